@@ -0,0 +1,45 @@
+package app
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/chiips/snippets/API/models"
+	uuid "github.com/satori/go.uuid"
+)
+
+//maxRequestID is the length past which the request IDs clients send are cut short in the audit log
+const maxRequestID = 64
+
+//audit records an event in the security audit log: the action, the user acting, the user acted on, and a short detail,
+//with the IP, user agent, and request ID of the request. Errors are logged rather than returned: the action happened either way.
+func (s *Server) audit(r *http.Request, action string, actor, target uuid.UUID, detail string) {
+
+	id, err := uuid.NewV4()
+	if err != nil {
+		s.Log.Errorln("error recording audit event:", err)
+		return
+	}
+
+	requestID := []rune(r.Header.Get("X-REQUEST-ID"))
+	if len(requestID) > maxRequestID {
+		requestID = requestID[:maxRequestID]
+	}
+
+	event := &models.AuditEvent{
+		ID:        id,
+		Action:    action,
+		Actor:     actor,
+		Target:    target,
+		IP:        s.clientIP(r),
+		UserAgent: userAgent(r),
+		RequestID: string(requestID),
+		Detail:    detail,
+		Created:   time.Now().UTC(),
+	}
+
+	err = s.DB.RecordAuditEvent(event)
+	if err != nil {
+		s.Log.Errorln("error recording audit event:", err, action, actor, target)
+	}
+}
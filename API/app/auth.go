@@ -1,6 +1,8 @@
 package app
 
 import (
+	"errors"
+	"net/http"
 	"os"
 	"strings"
 	"time"
@@ -12,19 +14,37 @@ import (
 //MyClaims struct defined for adding to jwt.StandardClaims as an embedded type.
 type MyClaims struct {
 	ID uuid.UUID `json:"id"`
+
+	//Roles are the user's roles when the JWT was created. A role change reaches the user when their JWT is next created.
+	Roles []string `json:"roles"`
+
+	//Session is the session the JWT was created for, at login or when the session was refreshed. Revoking the session revokes the JWT.
+	Session uuid.UUID `json:"sid"`
 	jwt.StandardClaims
 }
 
-//createJWT creates a new JWT for a user.
-func (s *Server) createJWT(id uuid.UUID) (string, string, error) {
+//jwtLifetime is how long JWTs are accepted after they are created
+const jwtLifetime = 5 * time.Minute
+
+//createJWT creates a new JWT for a user holding the given roles, in the session.
+func (s *Server) createJWT(id uuid.UUID, roles []string, session uuid.UUID) (string, string, error) {
 
 	//5 minute expiration time in unix milliseconds
-	expirationTime := time.Now().Add(5 * time.Minute).Unix()
+	expirationTime := time.Now().Add(jwtLifetime).Unix()
+
+	//a unique JWT ID lets this JWT be revoked without revoking the user's others
+	jti, err := uuid.NewV4()
+	if err != nil {
+		return "", "", err
+	}
 
-	//Create the JWT claims which include the user id, expiry time, and issuer
+	//Create the JWT claims which include the user id, roles, session, JWT ID, expiry time, and issuer
 	claims := &MyClaims{
-		ID: id,
+		ID:      id,
+		Roles:   roles,
+		Session: session,
 		StandardClaims: jwt.StandardClaims{
+			Id:        jti.String(),
 			ExpiresAt: expirationTime,
 			Issuer:    os.Getenv("jwt_issuer"),
 		},
@@ -49,3 +69,61 @@ func (s *Server) createJWT(id uuid.UUID) (string, string, error) {
 
 	return string(headerpaylod), string(signature), nil
 }
+
+//setJWTCookies creates a new JWT for a user holding the given roles, in the session, and sends it split across two cookies:
+//the header and payload in a non-HttpOnly cookie for the front-end client to read, and the signature in an HttpOnly cookie.
+func (s *Server) setJWTCookies(w http.ResponseWriter, id uuid.UUID, roles []string, session uuid.UUID) error {
+
+	headerpayload, signature, err := s.createJWT(id, roles, session)
+	if err != nil {
+		return err
+	}
+
+	//header and payload in non-HttpOnly cookie
+	c1 := &http.Cookie{
+		Name:     "token-hp",
+		Value:    headerpayload,
+		Secure:   true, //for testing over http, set Secure to false.
+		Path:     "/",
+		MaxAge:   0,
+		SameSite: http.SameSiteDefaultMode,
+	}
+	http.SetCookie(w, c1)
+
+	//signature in HttpOnly cookie
+	c2 := &http.Cookie{
+		Name:     "token-s",
+		Value:    signature,
+		Secure:   true,
+		HttpOnly: true,
+		Path:     "/",
+		MaxAge:   0,
+		SameSite: http.SameSiteDefaultMode,
+	}
+	http.SetCookie(w, c2)
+
+	return nil
+}
+
+//revokeJWT stops a JWT from being accepted before it expires, e.g. on logout.
+//The revocation is kept in the server's state store, shared by every instance using the same store, until the JWT would have expired anyway.
+func (s *Server) revokeJWT(claims *MyClaims) error {
+
+	if s.State == nil {
+		return errors.New("no state store to revoke JWTs in")
+	}
+
+	if claims.Id == "" {
+		return errors.New("JWT has no ID to revoke")
+	}
+
+	//JWTs are accepted through the second they expire in
+	ttl := time.Until(time.Unix(claims.ExpiresAt, 0)) + time.Second
+
+	return s.State.Set(revokedKey(claims.Id), ttl)
+}
+
+//revokedKey is the state store key marking the JWT with the given ID revoked.
+func revokedKey(jti string) string {
+	return "revoked:" + jti
+}
@@ -0,0 +1,186 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+//clientIPContextKey holds the client IP resolved by ResolveClientIP
+const clientIPContextKey contextKey = "clientIP"
+
+//ParseTrustedProxies reads a comma separated list of the CIDRs of the reverse proxies in front of the API, e.g. "127.0.0.1/32,10.0.0.0/8".
+//A single address is taken as a one address CIDR.
+func ParseTrustedProxies(spec string) ([]*net.IPNet, error) {
+
+	proxies := []*net.IPNet{}
+
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		if !strings.Contains(part, "/") {
+			ip := net.ParseIP(part)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", part)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, cidr, err := net.ParseCIDR(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", part)
+		}
+		proxies = append(proxies, cidr)
+	}
+
+	return proxies, nil
+}
+
+//ParseForwardedHeader reads the name of the header the reverse proxies in front of the API record client IPs in: X-Forwarded-For,
+//the default, or Forwarded (RFC 7239). Only that header is read, so a client cannot pick its IP with the other one, which the proxies pass through.
+func ParseForwardedHeader(name string) (string, error) {
+
+	switch {
+	case name == "" || strings.EqualFold(name, "X-Forwarded-For"):
+		return "X-Forwarded-For", nil
+	case strings.EqualFold(name, "Forwarded"):
+		return "Forwarded", nil
+	}
+
+	return "", fmt.Errorf("invalid forwarded header %q: must be X-Forwarded-For or Forwarded", name)
+}
+
+//ResolveClientIP puts the client IP in context for the rate limiter, request logs, and audit records.
+//It goes outermost so everything after it sees the same IP.
+func (s *Server) ResolveClientIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		ctx := context.WithValue(r.Context(), clientIPContextKey, s.resolveClientIP(r))
+		r = r.WithContext(ctx)
+
+		next.ServeHTTP(w, r)
+
+	})
+
+}
+
+//clientIP returns the client IP ResolveClientIP put in context, resolving it if the request did not come through ResolveClientIP.
+func (s *Server) clientIP(r *http.Request) string {
+
+	if ip, ok := r.Context().Value(clientIPContextKey).(string); ok {
+		return ip
+	}
+
+	return s.resolveClientIP(r)
+}
+
+//resolveClientIP finds the client IP by walking the hops the proxies recorded, from the API back towards the client, only as far as the hops are trusted.
+//The connection's peer is the first hop. While a hop is a trusted proxy, the address it recorded as its own client is believed,
+//so a client cannot pick its IP by sending its own X-Forwarded-For or Forwarded header. The first untrusted hop is the client.
+//Only the Server's ForwardedHeader is read, X-Forwarded-For if unset.
+func (s *Server) resolveClientIP(r *http.Request) string {
+
+	peer := remoteIP(r.RemoteAddr)
+	if peer == nil {
+		return r.RemoteAddr
+	}
+
+	if !s.trustedProxy(peer) {
+		return peer.String()
+	}
+
+	var hops []string
+	if s.ForwardedHeader == "Forwarded" {
+		hops = forwardedFor(r.Header.Values("Forwarded"))
+	} else {
+		for _, xff := range r.Header.Values("X-Forwarded-For") {
+			hops = append(hops, strings.Split(xff, ",")...)
+		}
+	}
+
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := parseHop(hops[i])
+		if hop == nil {
+			//a trusted proxy recorded an address we cannot read, so the proxy itself is the last address known
+			break
+		}
+		client = hop
+		if !s.trustedProxy(hop) {
+			break
+		}
+	}
+
+	return client.String()
+}
+
+//trustedProxy reports whether the IP is in one of the Server's trusted proxy CIDRs.
+func (s *Server) trustedProxy(ip net.IP) bool {
+
+	for _, cidr := range s.TrustedProxies {
+		if cidr.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+//remoteIP parses the IP of a host:port address, or of a bare IP.
+func remoteIP(addr string) net.IP {
+
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+
+	return net.ParseIP(addr)
+}
+
+//forwardedFor returns the for= parameter of each element of the Forwarded headers, in order.
+//Elements without one are returned empty, so they are not mistaken for the hop before them.
+func forwardedFor(headers []string) []string {
+
+	hops := []string{}
+
+	for _, header := range headers {
+		for _, element := range strings.Split(header, ",") {
+			hop := ""
+			for _, pair := range strings.Split(element, ";") {
+				kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+				if len(kv) == 2 && strings.EqualFold(kv[0], "for") {
+					hop = strings.Trim(kv[1], `"`)
+				}
+			}
+			hops = append(hops, hop)
+		}
+	}
+
+	return hops
+}
+
+//parseHop parses one recorded hop: an IP, an IP and port, or a bracketed IPv6 address with or without a port.
+//RFC 7239's "unknown" and obfuscated identifiers are not IPs and return nil.
+func parseHop(hop string) net.IP {
+
+	hop = strings.TrimSpace(hop)
+
+	if ip := net.ParseIP(hop); ip != nil {
+		return ip
+	}
+
+	if host, _, err := net.SplitHostPort(hop); err == nil {
+		return net.ParseIP(host)
+	}
+
+	return net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(hop, "["), "]"))
+}
@@ -0,0 +1,123 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/chiips/snippets/API/models"
+	hr "github.com/julienschmidt/httprouter"
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestResolveClientIP(t *testing.T) {
+
+	proxies, err := ParseTrustedProxies("10.0.0.0/8, 127.0.0.1, ::1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	xff := Server{Log: testLog, TrustedProxies: proxies}
+	forwarded := Server{Log: testLog, TrustedProxies: proxies, ForwardedHeader: "Forwarded"}
+
+	for _, tc := range []struct {
+		name           string
+		s              *Server
+		remote         string
+		xff, forwarded []string
+		want           string
+	}{
+		{"no proxy", &xff, "192.0.2.1:4000", nil, nil, "192.0.2.1"},
+		{"spoofed by an untrusted client", &xff, "192.0.2.1:4000", []string{"198.51.100.7"}, nil, "192.0.2.1"},
+		{"one trusted proxy", &xff, "127.0.0.1:4000", []string{"198.51.100.7"}, nil, "198.51.100.7"},
+		{"spoof behind trusted proxies", &xff, "127.0.0.1:4000", []string{"203.0.113.9, 198.51.100.7, 10.1.2.3"}, nil, "198.51.100.7"},
+		{"repeated headers", &xff, "127.0.0.1:4000", []string{"203.0.113.9", "198.51.100.7"}, nil, "198.51.100.7"},
+		{"only trusted hops", &xff, "127.0.0.1:4000", []string{"10.0.0.2, 10.0.0.1"}, nil, "10.0.0.2"},
+		{"unreadable hop", &xff, "127.0.0.1:4000", []string{"198.51.100.7, garbage"}, nil, "127.0.0.1"},
+		{"trusted proxy without header", &xff, "[::1]:4000", nil, nil, "::1"},
+		{"client's forwarded passed through", &xff, "127.0.0.1:4000", []string{"203.0.113.7"}, []string{"for=9.9.9.9"}, "203.0.113.7"},
+		{"forwarded only without x-forwarded-for", &xff, "127.0.0.1:4000", nil, []string{"for=9.9.9.9"}, "127.0.0.1"},
+		{"forwarded", &forwarded, "127.0.0.1:4000", nil, []string{`for=203.0.113.9, for="[2001:db8:cafe::17]:4711";proto=https`}, "2001:db8:cafe::17"},
+		{"forwarded with port", &forwarded, "127.0.0.1:4000", nil, []string{"for=192.0.2.60;by=10.0.0.1", `for="198.51.100.7:4711"`}, "198.51.100.7"},
+		{"forwarded unknown", &forwarded, "127.0.0.1:4000", nil, []string{"for=unknown"}, "127.0.0.1"},
+		{"forwarded without for", &forwarded, "127.0.0.1:4000", nil, []string{"for=198.51.100.7, proto=http"}, "127.0.0.1"},
+		{"client's x-forwarded-for passed through", &forwarded, "127.0.0.1:4000", []string{"9.9.9.9"}, []string{"for=198.51.100.7"}, "198.51.100.7"},
+	} {
+		req, err := http.NewRequest("GET", "/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.RemoteAddr = tc.remote
+		for _, v := range tc.xff {
+			req.Header.Add("X-Forwarded-For", v)
+		}
+		for _, v := range tc.forwarded {
+			req.Header.Add("Forwarded", v)
+		}
+
+		if got := tc.s.clientIP(req); got != tc.want {
+			t.Errorf("%v: resolved wrong client IP:\ngot: %v\nwant: %v", tc.name, got, tc.want)
+		}
+	}
+
+	for name, want := range map[string]string{"": "X-Forwarded-For", "x-forwarded-for": "X-Forwarded-For", "forwarded": "Forwarded"} {
+		if got, err := ParseForwardedHeader(name); err != nil || got != want {
+			t.Errorf("ParseForwardedHeader(%q) returned wrongly:\ngot: %v %v\nwant: %v", name, got, err, want)
+		}
+	}
+	if _, err := ParseForwardedHeader("X-Real-IP"); err == nil {
+		t.Error("ParseForwardedHeader accepted an unknown header")
+	}
+
+	for _, spec := range []string{"10.0.0.0/33", "localhost", "10.0.0"} {
+		if _, err := ParseTrustedProxies(spec); err == nil {
+			t.Errorf("ParseTrustedProxies(%q) returned no error", spec)
+		}
+	}
+}
+
+func TestClientIPInContext(t *testing.T) {
+
+	router := hr.New()
+	mdb := &mockDB{roles: map[uuid.UUID][]string{thirdUserID: {models.RoleAdmin}}}
+	s := Server{DB: mdb, Router: router, Log: testLog}
+	s.Limiter = NewRateLimiter(map[string]RatePolicy{
+		PolicyRead:  {Name: PolicyRead, Limit: 2, Window: time.Minute},
+		PolicyWrite: {Name: PolicyWrite, Limit: 10, Window: time.Minute},
+	}, NewMemoryStore())
+	s.Routes()
+	handler := s.ResolveClientIP(s.Router)
+
+	//send runs a request from 192.0.2.1 claiming to forward for the given address
+	send := func(method, url, forwardedFor string, user uuid.UUID) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.RemoteAddr = "192.0.2.1:4000"
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		if !uuid.Equal(user, uuid.Nil) {
+			authenticate(t, &s, req, user)
+		}
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	//a client cannot dodge the limiter by making up X-Forwarded-For addresses
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		if rr := send("GET", "/api/posts", fmt.Sprintf("198.51.100.%d", i), uuid.Nil); rr.Code != want {
+			t.Errorf("request %v returned wrong status code:\ngot: %v\nwant: %v", i, rr.Code, want)
+		}
+	}
+
+	//audit records carry the resolved IP
+	if rr := send("PUT", fmt.Sprintf("/api/admin/users/%s/roles/%s", userID, models.RoleModerator), "198.51.100.1", thirdUserID); rr.Code != http.StatusOK {
+		t.Fatalf("grant returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusOK)
+	}
+	if len(mdb.roleChanges) != 1 || mdb.roleChanges[0].IP != "192.0.2.1" {
+		t.Errorf("role change recorded wrong IP: %+v", mdb.roleChanges)
+	}
+}
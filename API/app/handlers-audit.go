@@ -0,0 +1,234 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/chiips/snippets/API/models"
+	hr "github.com/julienschmidt/httprouter"
+	uuid "github.com/satori/go.uuid"
+)
+
+//auditExportBatch is how many audit events an export reads from the database at a time
+const auditExportBatch = 500
+
+//errors returned to the client when the audit filters are unusable
+var (
+	errInvalidAuditAction = errors.New("invalid action")
+	errInvalidAuditUser   = errors.New("invalid actor or target: must be a user id")
+	errInvalidAuditTime   = errors.New("invalid since or until: must be an RFC 3339 time")
+)
+
+//parseAuditFilter reads the filters of an audit request from its query parameters: action, actor, target, ip, since, and until.
+func parseAuditFilter(r *http.Request) (models.AuditFilter, error) {
+
+	filter := models.AuditFilter{}
+	query := r.URL.Query()
+
+	filter.Action = strings.TrimSpace(query.Get("action"))
+	if filter.Action != "" && !models.ValidAuditAction(filter.Action) {
+		return filter, errInvalidAuditAction
+	}
+
+	for param, id := range map[string]*uuid.UUID{"actor": &filter.Actor, "target": &filter.Target} {
+		if v := strings.TrimSpace(query.Get(param)); v != "" {
+			parsed, err := uuid.FromString(v)
+			if err != nil {
+				return filter, errInvalidAuditUser
+			}
+			*id = parsed
+		}
+	}
+
+	filter.IP = strings.TrimSpace(query.Get("ip"))
+
+	for param, at := range map[string]**time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if v := strings.TrimSpace(query.Get(param)); v != "" {
+			parsed, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return filter, errInvalidAuditTime
+			}
+			parsed = parsed.UTC()
+			*at = &parsed
+		}
+	}
+
+	return filter, nil
+}
+
+//auditEvents retrieves one page of the security audit log, newest first, narrowed by the filters parseAuditFilter reads.
+func (s *Server) auditEvents() hr.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ hr.Params) {
+
+		ctx := r.Context()
+
+		filter, err := parseAuditFilter(r)
+		if err != nil {
+			s.Log.Errorln(err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		before, limit, err := s.parsePagination(r)
+		if err != nil {
+			s.Log.Errorln(err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		eventsCh := make(chan []*models.AuditEvent)
+		errCh := make(chan error)
+
+		go func() {
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			events, err := s.DB.AuditEvents(filter, before, limit+1)
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			eventsCh <- events
+			return
+
+		}()
+
+		select {
+		case <-ctx.Done():
+			s.Log.Errorln(ctx.Err())
+			http.Error(w, "We could not process your request at this time. Please try again later.", http.StatusRequestTimeout)
+			return
+		case err := <-errCh:
+			s.Log.Errorln(err)
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		case events := <-eventsCh:
+			var next *models.Cursor
+			if len(events) > limit {
+				events = events[:limit]
+				last := events[limit-1]
+				next = &models.Cursor{Created: last.Created, ID: last.ID}
+			}
+
+			w.Header().Set("Cache-Control", "no-store")
+			err = s.writePage(w, r, events, limit, next)
+			if err != nil {
+				s.Log.Errorln(err)
+				http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+				return
+			}
+			return
+		}
+	}
+}
+
+//exportAuditEvents sends every audit event matching the filters parseAuditFilter reads as JSON Lines, one event per line, newest first.
+//Events are read and sent a batch at a time, so exports of any size are streamed; an export cut short by an error
+//after the first batch has been sent ends without its last lines.
+func (s *Server) exportAuditEvents() hr.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ hr.Params) {
+
+		//cancel the reads below if the export stops before they are done
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+
+		filter, err := parseAuditFilter(r)
+		if err != nil {
+			s.Log.Errorln(err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		batchCh := make(chan []*models.AuditEvent)
+		errCh := make(chan error)
+
+		go func() {
+
+			before := models.Cursor{Created: time.Now().UTC()}
+
+			for {
+
+				if ctx.Err() != nil {
+					return
+				}
+
+				events, err := s.DB.AuditEvents(filter, before, auditExportBatch)
+
+				if ctx.Err() != nil {
+					return
+				}
+
+				if err != nil {
+					errCh <- err
+					return
+				}
+
+				select {
+				case <-ctx.Done():
+					return
+				case batchCh <- events:
+				}
+
+				if len(events) < auditExportBatch {
+					return
+				}
+
+				last := events[len(events)-1]
+				before = models.Cursor{Created: last.Created, ID: last.ID}
+			}
+
+		}()
+
+		started := false
+		enc := json.NewEncoder(w)
+
+		for {
+			select {
+			case <-ctx.Done():
+				s.Log.Errorln(ctx.Err())
+				if !started {
+					http.Error(w, "We could not process your request at this time. Please try again later.", http.StatusRequestTimeout)
+				}
+				return
+			case err := <-errCh:
+				s.Log.Errorln("error exporting audit events:", err)
+				if !started {
+					http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+				}
+				return
+			case events := <-batchCh:
+				if !started {
+					w.Header().Set("Content-Type", "application/x-ndjson")
+					w.Header().Set("Content-Disposition", `attachment; filename="audit.jsonl"`)
+					w.Header().Set("Cache-Control", "no-store")
+					started = true
+				}
+
+				//Encode ends each event with a newline
+				for _, event := range events {
+					err := enc.Encode(event)
+					if err != nil {
+						s.Log.Errorln(err)
+						return
+					}
+				}
+
+				if len(events) < auditExportBatch {
+					return
+				}
+			}
+		}
+	}
+}
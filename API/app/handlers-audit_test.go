@@ -0,0 +1,182 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/chiips/snippets/API/models"
+	hr "github.com/julienschmidt/httprouter"
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestAudit(t *testing.T) {
+
+	os.Setenv("jwt_key", "test-key")
+	os.Setenv("jwt_issuer", "test-issuer")
+
+	router := hr.New()
+	mdb := &mockDB{roles: map[uuid.UUID][]string{thirdUserID: {models.RoleAdmin}}}
+	s := Server{DB: mdb, Router: router, Log: testLog, State: NewMemoryStore(), Passwords: testHasher}
+	s.Routes()
+
+	//send sends the body to the url from a browser, as the given user unless nil
+	send := func(method, url string, id uuid.UUID, body interface{}) *httptest.ResponseRecorder {
+		b, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest(method, url, bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("User-Agent", "Test Browser")
+		req.Header.Set("X-REQUEST-ID", "request-1")
+		req.RemoteAddr = "192.0.2.1:4000"
+		if !uuid.Equal(id, uuid.Nil) {
+			authenticate(t, &s, req, id)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	//failed and successful logins are recorded with the request they came in
+	if rr := send("POST", "/api/login", uuid.Nil, &models.User{Email: "user-1@example.com", Password: "Wrong1!"}); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("failed login returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusUnauthorized)
+	}
+	if rr := send("POST", "/api/login", uuid.Nil, &models.User{Email: "user-1@example.com", Password: testPassword}); rr.Code != http.StatusOK {
+		t.Fatalf("login returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusOK)
+	}
+
+	if len(mdb.auditEvents) != 2 {
+		t.Fatalf("logins recorded wrongly: %v events", len(mdb.auditEvents))
+	}
+	failed, login := mdb.auditEvents[0], mdb.auditEvents[1]
+	if failed.Action != models.AuditLoginFailed || !uuid.Equal(failed.Actor, uuid.Nil) || !uuid.Equal(failed.Target, userID) || failed.Detail != "password" {
+		t.Errorf("failed login recorded wrongly: %+v", failed)
+	}
+	if login.Action != models.AuditLogin || !uuid.Equal(login.Actor, userID) || !uuid.Equal(login.Target, userID) {
+		t.Errorf("login recorded wrongly: %+v", login)
+	}
+	for _, event := range mdb.auditEvents {
+		if event.IP != "192.0.2.1" || event.UserAgent != "Test Browser" || event.RequestID != "request-1" || event.Created.IsZero() {
+			t.Errorf("event recorded without its request: %+v", event)
+		}
+	}
+
+	//account changes are recorded as made by the user
+	if rr := send("POST", "/api/sessions/refresh", userID, nil); rr.Code != http.StatusOK {
+		t.Fatalf("refresh returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusOK)
+	}
+	if rr := send("PUT", "/api/profile/"+userID.String()+"/password", userID, passwordChange{Current: testPassword, Password: "NewPassword1!"}); rr.Code != http.StatusOK {
+		t.Fatalf("password change returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusOK)
+	}
+	if rr := send("PUT", "/api/admin/users/"+otherUserID.String()+"/roles/"+models.RoleModerator, thirdUserID, nil); rr.Code != http.StatusOK {
+		t.Fatalf("grant returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusOK)
+	}
+
+	want := []struct {
+		action        string
+		actor, target uuid.UUID
+		detail        string
+	}{
+		{models.AuditSessionRefresh, userID, userID, ""},
+		{models.AuditPasswordChange, userID, userID, ""},
+		{models.AuditRoleChange, thirdUserID, otherUserID, "grant moderator"},
+	}
+	if len(mdb.auditEvents) != 5 {
+		t.Fatalf("account changes recorded wrongly: %v events", len(mdb.auditEvents))
+	}
+	for i, w := range want {
+		got := mdb.auditEvents[2+i]
+		if got.Action != w.action || !uuid.Equal(got.Actor, w.actor) || !uuid.Equal(got.Target, w.target) || (w.detail != "" && got.Detail != w.detail) {
+			t.Errorf("%v recorded wrongly: %+v", w.action, got)
+		}
+	}
+
+	//granting a role the user already holds records nothing
+	if rr := send("PUT", "/api/admin/users/"+otherUserID.String()+"/roles/"+models.RoleModerator, thirdUserID, nil); rr.Code != http.StatusOK {
+		t.Fatalf("repeated grant returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusOK)
+	}
+	if len(mdb.auditEvents) != 5 {
+		t.Errorf("repeated grant recorded wrongly: %v events", len(mdb.auditEvents))
+	}
+
+	//only admins read the log
+	for _, path := range []string{"/api/admin/audit", "/api/admin/audit/export"} {
+		if rr := send("GET", path, userID, nil); rr.Code != http.StatusForbidden {
+			t.Errorf("%v returned wrong status code to a user:\ngot: %v\nwant: %v", path, rr.Code, http.StatusForbidden)
+		}
+	}
+
+	//list reads one filtered page of the log as the admin
+	list := func(query url.Values) ([]*models.AuditEvent, *httptest.ResponseRecorder) {
+		rr := send("GET", "/api/admin/audit?"+query.Encode(), thirdUserID, nil)
+		events := []*models.AuditEvent{}
+		if rr.Code == http.StatusOK {
+			if err := json.NewDecoder(rr.Body).Decode(&page{Data: &events}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return events, rr
+	}
+
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	tests := []struct {
+		name  string
+		query url.Values
+		count int
+	}{
+		{"every event", url.Values{}, 5},
+		{"by action", url.Values{"action": {models.AuditLoginFailed}}, 1},
+		{"by actor", url.Values{"actor": {userID.String()}}, 3},
+		{"by target", url.Values{"target": {otherUserID.String()}}, 1},
+		{"by ip", url.Values{"ip": {"192.0.2.9"}}, 0},
+		{"since", url.Values{"since": {future}}, 0},
+		{"until", url.Values{"until": {future}, "action": {models.AuditLogin}}, 1},
+		{"paged", url.Values{"limit": {"4"}}, 4},
+	}
+	for _, test := range tests {
+		events, rr := list(test.query)
+		if rr.Code != http.StatusOK || len(events) != test.count {
+			t.Errorf("%v returned wrong events: %v %v, want %v", test.name, rr.Code, len(events), test.count)
+		}
+	}
+	if events, _ := list(url.Values{}); len(events) > 0 && events[0].Action != models.AuditRoleChange {
+		t.Errorf("log not listed newest first: %+v", events[0])
+	}
+
+	for _, query := range []url.Values{{"action": {"nothing"}}, {"actor": {"someone"}}, {"since": {"yesterday"}}} {
+		if _, rr := list(query); rr.Code != http.StatusBadRequest {
+			t.Errorf("invalid filter %v returned wrong status code:\ngot: %v\nwant: %v", query, rr.Code, http.StatusBadRequest)
+		}
+	}
+
+	//the export sends every matching event, one JSON object per line
+	rr := send("GET", "/api/admin/audit/export?actor="+userID.String(), thirdUserID, nil)
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("export returned wrong response: %v %v", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	lines := bufio.NewScanner(rr.Body)
+	exported := 0
+	for lines.Scan() {
+		event := &models.AuditEvent{}
+		if err := json.Unmarshal(lines.Bytes(), event); err != nil {
+			t.Fatal(err)
+		}
+		if !uuid.Equal(event.Actor, userID) {
+			t.Errorf("export sent an unmatched event: %+v", event)
+		}
+		exported++
+	}
+	if exported != 3 {
+		t.Errorf("export sent wrong number of events:\ngot: %v\nwant: %v", exported, 3)
+	}
+}
@@ -0,0 +1,482 @@
+package app
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/chiips/snippets/API/models"
+	hr "github.com/julienschmidt/httprouter"
+	uuid "github.com/satori/go.uuid"
+)
+
+//default and maximum number of reply levels nested under each listed comment, counting the listed comments as level 1
+const (
+	defaultCommentDepth = 3
+	maxCommentDepth     = 5
+)
+
+//maximum number of characters in a comment
+const maxCommentLength = 1000
+
+//postComments retrieves one page of a post's comments with their replies nested to the requested depth.
+//With a parent query parameter it retrieves the replies to that comment instead, for threads deeper than one response.
+//Only viewers who may see the post see its comments.
+func (s *Server) postComments() hr.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps hr.Params) {
+
+		ctx := r.Context()
+
+		viewer, _ := ctx.Value(userContextKey).(uuid.UUID)
+		roles, _ := ctx.Value(rolesContextKey).([]string)
+
+		postID, err := uuid.FromString(ps.ByName("postid"))
+		if err != nil {
+			s.Log.Errorln(err)
+			http.Error(w, http.StatusText(400), http.StatusBadRequest)
+			return
+		}
+
+		//read the cursor and limit. without a cursor start at the most recent comment.
+		before, limit, err := s.parsePagination(r)
+		if err != nil {
+			s.Log.Errorln(err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		//read the depth of nesting
+		depth := defaultCommentDepth
+		if d := strings.TrimSpace(r.URL.Query().Get("depth")); d != "" {
+			depth, err = strconv.Atoi(d)
+			if err != nil || depth < 1 || depth > maxCommentDepth {
+				s.Log.Errorln("invalid depth")
+				http.Error(w, fmt.Sprintf("invalid depth: must be a number from 1 to %d", maxCommentDepth), http.StatusBadRequest)
+				return
+			}
+		}
+
+		//read the parent comment, if any
+		parentID := uuid.Nil
+		if p := strings.TrimSpace(r.URL.Query().Get("parent")); p != "" {
+			parentID, err = uuid.FromString(p)
+			if err != nil {
+				s.Log.Errorln(err)
+				http.Error(w, "invalid parent", http.StatusBadRequest)
+				return
+			}
+		}
+
+		//confirm the post exists and may be seen
+		_, status := s.authorizeViewPost(viewer, roles, postID)
+		if status != http.StatusOK {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+
+		commentsCh := make(chan []*models.Comment)
+		errCh := make(chan error)
+
+		go func() {
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			//ask for one extra comment to learn whether another page follows.
+			comments, err := s.DB.PostComments(postID, parentID, before, limit+1)
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			//fetch the replies to the comments on this page in one call and nest them under their parents.
+			parentIDs := []uuid.UUID{}
+			for i, comment := range comments {
+				if i == limit {
+					break
+				}
+				parentIDs = append(parentIDs, comment.ID)
+			}
+
+			replies, err := s.DB.CommentReplies(parentIDs, depth-1)
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			nestComments(comments, replies)
+
+			commentsCh <- comments
+			return
+
+		}()
+
+		select {
+		case <-ctx.Done():
+			s.Log.Errorln(ctx.Err())
+			http.Error(w, "We could not process your request at this time. Please try again later.", http.StatusRequestTimeout)
+			return
+		case err := <-errCh:
+			s.Log.Errorln(err)
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		case comments := <-commentsCh:
+			//if there is another page then point the next cursor at the last comment sent.
+			var next *models.Cursor
+			if len(comments) > limit {
+				comments = comments[:limit]
+				last := comments[limit-1]
+				next = &models.Cursor{Created: last.Created, ID: last.ID}
+			}
+
+			err = s.writePage(w, r, comments, limit, next)
+			if err != nil {
+				s.Log.Errorln(err)
+				http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+				return
+			}
+			return
+		}
+	}
+}
+
+//nestComments attaches each reply to its parent among the comments and replies given.
+//Replies keep the order in which they are given.
+func nestComments(comments, replies []*models.Comment) {
+
+	byID := make(map[uuid.UUID]*models.Comment, len(comments)+len(replies))
+	for _, comment := range comments {
+		byID[comment.ID] = comment
+	}
+	for _, reply := range replies {
+		byID[reply.ID] = reply
+	}
+
+	for _, reply := range replies {
+		if parent, ok := byID[reply.ParentID]; ok {
+			parent.Replies = append(parent.Replies, reply)
+		}
+	}
+}
+
+//submitComment handles users commenting on a post or replying to a comment
+func (s *Server) submitComment() hr.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps hr.Params) {
+
+		ctx := r.Context()
+
+		//confirm that the user id is present.
+		//the user id should be passed into the context in the authenticateJWT middleware.
+		currentUser, ok := ctx.Value(userContextKey).(uuid.UUID)
+		if !ok {
+			s.Log.Errorln("no userID in context")
+			http.Error(w, http.StatusText(500), http.StatusForbidden)
+			return
+		}
+
+		if uuid.Equal(currentUser, uuid.Nil) {
+			s.Log.Errorln("userID came in with nil value.")
+			http.Error(w, http.StatusText(400), http.StatusBadRequest)
+			return
+		}
+
+		postID, err := uuid.FromString(ps.ByName("postid"))
+		if err != nil {
+			s.Log.Errorln(err)
+			http.Error(w, http.StatusText(400), http.StatusBadRequest)
+			return
+		}
+
+		//get the information of the new comment
+		submission := models.Comment{}
+		err = json.NewDecoder(r.Body).Decode(&submission)
+		if err != nil {
+			s.Log.Errorln(err)
+			http.Error(w, http.StatusText(400), http.StatusBadRequest)
+			return
+		}
+
+		//check that the body is present and not too many characters
+		if strings.TrimSpace(submission.Body) == "" || utf8.RuneCountInString(submission.Body) > maxCommentLength {
+			s.Log.Errorln("invalid body")
+			http.Error(w, "invalid body", http.StatusBadRequest)
+			return
+		}
+
+		//confirm the post exists and may be seen
+		roles, _ := ctx.Value(rolesContextKey).([]string)
+		_, status := s.authorizeViewPost(currentUser, roles, postID)
+		if status != http.StatusOK {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+
+		//a reply must answer a comment on the same post
+		if !uuid.Equal(submission.ParentID, uuid.Nil) {
+			parent, err := s.DB.OneComment(submission.ParentID)
+			switch {
+			case err == sql.ErrNoRows:
+				s.Log.Errorln(err)
+				http.Error(w, "parent comment not found", http.StatusBadRequest)
+				return
+			case err != nil:
+				s.Log.Errorln(err)
+				http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+				return
+			}
+
+			if !uuid.Equal(parent.PostID, postID) {
+				s.Log.Errorln("parent comment belongs to another post")
+				http.Error(w, "parent comment not found", http.StatusBadRequest)
+				return
+			}
+		}
+
+		//create uuid for comment
+		id, err := uuid.NewV4()
+		if err != nil {
+			s.Log.Errorln(err)
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		}
+
+		//build comment
+		comment := &models.Comment{}
+		comment.ID = id
+		comment.PostID = postID
+		comment.ParentID = submission.ParentID
+		comment.Body = submission.Body
+		comment.Created = time.Now().UTC()
+		comment.Updated = comment.Created
+		comment.Author.ID = currentUser
+
+		okCh := make(chan bool)
+		errCh := make(chan error)
+
+		go func() {
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			err := s.DB.CreateComment(comment)
+
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			okCh <- true
+			return
+
+		}()
+
+		select {
+		case <-ctx.Done():
+			s.Log.Errorln(ctx.Err())
+			http.Error(w, "We could not process your request at this time. Please try again later.", http.StatusRequestTimeout)
+			return
+		case err := <-errCh:
+			s.Log.Errorln("error submitting comment:", err)
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		case <-okCh:
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			err = json.NewEncoder(w).Encode(comment)
+			if err != nil {
+				s.Log.Errorln(err)
+			}
+			return
+		}
+	}
+}
+
+//editComment handles when users modify their comments
+func (s *Server) editComment() hr.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps hr.Params) {
+
+		ctx := r.Context()
+
+		currentUser, ok := ctx.Value(userContextKey).(uuid.UUID)
+		if !ok {
+			s.Log.Errorln("no userID in context")
+			http.Error(w, http.StatusText(500), http.StatusForbidden)
+			return
+		}
+
+		if uuid.Equal(currentUser, uuid.Nil) {
+			s.Log.Errorln("userID came in with nil value.")
+			http.Error(w, http.StatusText(400), http.StatusBadRequest)
+			return
+		}
+
+		//load the stored comment and confirm the current user may edit it
+		comment, status := s.authorizeComment(currentUser, ps, CanEditComment)
+		if status != http.StatusOK {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+
+		submission := models.Comment{}
+		err := json.NewDecoder(r.Body).Decode(&submission)
+		if err != nil {
+			s.Log.Errorln(err)
+			http.Error(w, http.StatusText(400), http.StatusBadRequest)
+			return
+		}
+
+		if strings.TrimSpace(submission.Body) == "" || utf8.RuneCountInString(submission.Body) > maxCommentLength {
+			s.Log.Errorln("invalid body")
+			http.Error(w, "invalid body", http.StatusBadRequest)
+			return
+		}
+
+		comment.Body = submission.Body
+		comment.Updated = time.Now().UTC()
+
+		okCh := make(chan bool)
+		errCh := make(chan error)
+
+		go func() {
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			err := s.DB.UpdateComment(comment)
+
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			okCh <- true
+			return
+
+		}()
+
+		select {
+		case <-ctx.Done():
+			s.Log.Errorln(ctx.Err())
+			http.Error(w, "We could not process your request at this time. Please try again later.", http.StatusRequestTimeout)
+			return
+		case err := <-errCh:
+			s.Log.Errorln("error editing comment:", err)
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		case <-okCh:
+			fmt.Fprint(w, "comment edited!")
+			return
+		}
+	}
+}
+
+//deleteComment handles requests to remove one specific comment and its replies
+func (s *Server) deleteComment() hr.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps hr.Params) {
+
+		ctx := r.Context()
+
+		currentUser, ok := ctx.Value(userContextKey).(uuid.UUID)
+		if !ok {
+			s.Log.Errorln("no userID in context")
+			http.Error(w, http.StatusText(500), http.StatusForbidden)
+			return
+		}
+
+		if uuid.Equal(currentUser, uuid.Nil) {
+			s.Log.Errorln("userID came in with nil value.")
+			http.Error(w, http.StatusText(400), http.StatusBadRequest)
+			return
+		}
+
+		//load the stored comment and confirm the current user may delete it
+		comment, status := s.authorizeComment(currentUser, ps, CanDeleteComment)
+		if status != http.StatusOK {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+
+		okCh := make(chan bool)
+		errCh := make(chan error)
+
+		go func() {
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			err := s.DB.DeleteComment(comment)
+
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			okCh <- true
+			return
+
+		}()
+
+		select {
+		case <-ctx.Done():
+			s.Log.Errorln(ctx.Err())
+			http.Error(w, "We could not process your request at this time. Please try again later.", http.StatusRequestTimeout)
+			return
+		case err := <-errCh:
+			s.Log.Errorln("error deleting comment:", err)
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		case <-okCh:
+			fmt.Fprint(w, "comment deleted!")
+			return
+		}
+	}
+}
+
+//commentFromParams loads the comment named by the postid and commentid url parameters.
+//It returns the status to send the client if the comment cannot be loaded, or http.StatusOK.
+func (s *Server) commentFromParams(ps hr.Params) (*models.Comment, int) {
+
+	postID, err := uuid.FromString(ps.ByName("postid"))
+	if err != nil {
+		s.Log.Errorln(err)
+		return nil, http.StatusBadRequest
+	}
+
+	commentID, err := uuid.FromString(ps.ByName("commentid"))
+	if err != nil {
+		s.Log.Errorln(err)
+		return nil, http.StatusBadRequest
+	}
+
+	comment, err := s.DB.OneComment(commentID)
+	switch {
+	case err == sql.ErrNoRows:
+		s.Log.Errorln(err)
+		return nil, http.StatusNotFound
+	case err != nil:
+		s.Log.Errorln(err)
+		return nil, http.StatusInternalServerError
+	}
+
+	//the comment must sit under the post in the url
+	if !uuid.Equal(comment.PostID, postID) {
+		s.Log.Errorln("comment does not belong to post")
+		return nil, http.StatusNotFound
+	}
+
+	return comment, http.StatusOK
+}
@@ -0,0 +1,97 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/chiips/snippets/API/models"
+	hr "github.com/julienschmidt/httprouter"
+)
+
+func TestPostComments(t *testing.T) {
+
+	router := hr.New()
+	s := Server{DB: &mockDB{}, Router: router, Log: testLog}
+	s.Routes()
+
+	//nest two levels: the comments and their direct replies
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", fmt.Sprintf("/api/post/%s/comments?depth=2", postID1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code:\ngot: %v\nwant: %v", status, http.StatusOK)
+	}
+
+	got := []*models.Comment{}
+	if err := json.NewDecoder(rr.Body).Decode(&page{Data: &got}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != 2 || got[0].ID != commentID1 || got[1].ID != commentID2 {
+		t.Fatalf("handler returned wrong comments:\ngot: %v\nwant: [%v %v]", got, commentID1, commentID2)
+	}
+
+	//the first comment carries its reply, but the reply to that reply is beyond the requested depth
+	if len(got[0].Replies) != 1 || got[0].Replies[0].ID != replyID1 {
+		t.Fatalf("handler returned wrong replies:\ngot: %v\nwant: [%v]", got[0].Replies, replyID1)
+	}
+
+	if len(got[0].Replies[0].Replies) != 0 || got[0].Replies[0].ReplyCount != 1 {
+		t.Errorf("handler nested replies past the requested depth: %v", got[0].Replies[0].Replies)
+	}
+
+	if len(got[1].Replies) != 0 {
+		t.Errorf("handler returned replies for a comment without any: %v", got[1].Replies)
+	}
+
+}
+
+func TestEditCommentForbidden(t *testing.T) {
+
+	router := hr.New()
+	s := Server{DB: &mockDB{}, Router: router, Log: testLog}
+	s.Routes()
+
+	body, err := json.Marshal(&models.Comment{Body: "edited"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//the comment exists and the request is well formed, but its author is another user
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("PUT", fmt.Sprintf("/api/post/%s/comments/%s", postID1, commentID1), bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticate(t, &s, req, otherUserID)
+
+	router.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusForbidden {
+		t.Errorf("handler returned wrong status code:\ngot: %v\nwant: %v", status, http.StatusForbidden)
+	}
+
+	//the author may edit the comment
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("PUT", fmt.Sprintf("/api/post/%s/comments/%s", postID1, commentID1), bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticate(t, &s, req, userID)
+
+	router.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code:\ngot: %v\nwant: %v", status, http.StatusOK)
+	}
+
+}
@@ -0,0 +1,362 @@
+package app
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/chiips/snippets/API/models"
+	hr "github.com/julienschmidt/httprouter"
+	uuid "github.com/satori/go.uuid"
+)
+
+//userProfile retrieves one user's profile with their follower and following counts.
+func (s *Server) userProfile() hr.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps hr.Params) {
+
+		ctx := r.Context()
+
+		//the viewer is set by the identifyJWT middleware when logged in and is otherwise uuid.Nil.
+		viewer, _ := ctx.Value(userContextKey).(uuid.UUID)
+
+		id, err := uuid.FromString(ps.ByName("userid"))
+		if err != nil {
+			s.Log.Errorln(err)
+			http.Error(w, http.StatusText(400), http.StatusBadRequest)
+			return
+		}
+
+		profileCh := make(chan *models.Profile)
+		errCh := make(chan error)
+
+		go func() {
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			profile, err := s.DB.UserProfile(id, viewer)
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			profileCh <- profile
+			return
+
+		}()
+
+		select {
+		case <-ctx.Done():
+			s.Log.Errorln(ctx.Err())
+			http.Error(w, "We could not process your request at this time. Please try again later.", http.StatusRequestTimeout)
+			return
+		case err := <-errCh:
+			s.Log.Errorln(err)
+			if err == sql.ErrNoRows {
+				http.Error(w, http.StatusText(404), http.StatusNotFound)
+				return
+			}
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		case profile := <-profileCh:
+			//the ETag names the version profile edits must be made to
+			w.Header().Set("ETag", versionETag(profile.Version))
+			w.Header().Set("Content-Type", "application/json")
+			err = json.NewEncoder(w).Encode(profile)
+			if err != nil {
+				s.Log.Errorln(err)
+				http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+				return
+			}
+			return
+		}
+	}
+}
+
+//follow handles users following another user. Following a user again has no further effect.
+func (s *Server) follow() hr.Handle {
+	return s.changeFollow(true)
+}
+
+//unfollow handles users unfollowing another user. Unfollowing a user not followed has no effect.
+func (s *Server) unfollow() hr.Handle {
+	return s.changeFollow(false)
+}
+
+//changeFollow makes the current user follow or unfollow the user in the url and responds with that user's updated profile.
+func (s *Server) changeFollow(add bool) hr.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps hr.Params) {
+
+		ctx := r.Context()
+
+		currentUser, ok := ctx.Value(userContextKey).(uuid.UUID)
+		if !ok {
+			s.Log.Errorln("no userID in context")
+			http.Error(w, http.StatusText(500), http.StatusForbidden)
+			return
+		}
+
+		if uuid.Equal(currentUser, uuid.Nil) {
+			s.Log.Errorln("userID came in with nil value.")
+			http.Error(w, http.StatusText(400), http.StatusBadRequest)
+			return
+		}
+
+		id, err := uuid.FromString(ps.ByName("userid"))
+		if err != nil {
+			s.Log.Errorln(err)
+			http.Error(w, http.StatusText(400), http.StatusBadRequest)
+			return
+		}
+
+		//users cannot follow themselves
+		if uuid.Equal(currentUser, id) {
+			s.Log.Errorln("user tried to follow themselves")
+			http.Error(w, "cannot follow yourself", http.StatusBadRequest)
+			return
+		}
+
+		profileCh := make(chan *models.Profile)
+		errCh := make(chan error)
+
+		go func() {
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			//confirm the user exists before following them
+			_, err := s.DB.UserProfile(id, currentUser)
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			if add {
+				err = s.DB.Follow(currentUser, id, time.Now().UTC())
+			} else {
+				err = s.DB.Unfollow(currentUser, id)
+			}
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			//reload the profile for its updated counts
+			profile, err := s.DB.UserProfile(id, currentUser)
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			profileCh <- profile
+			return
+
+		}()
+
+		select {
+		case <-ctx.Done():
+			s.Log.Errorln(ctx.Err())
+			http.Error(w, "We could not process your request at this time. Please try again later.", http.StatusRequestTimeout)
+			return
+		case err := <-errCh:
+			s.Log.Errorln("error changing follow:", err)
+			if err == sql.ErrNoRows {
+				http.Error(w, http.StatusText(404), http.StatusNotFound)
+				return
+			}
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		case profile := <-profileCh:
+			w.Header().Set("Content-Type", "application/json")
+			err = json.NewEncoder(w).Encode(profile)
+			if err != nil {
+				s.Log.Errorln(err)
+				http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+				return
+			}
+			return
+		}
+	}
+}
+
+//followers retrieves one page of the users following the user in the url.
+func (s *Server) followers() hr.Handle {
+	return s.followList(false)
+}
+
+//following retrieves one page of the users followed by the user in the url.
+func (s *Server) following() hr.Handle {
+	return s.followList(true)
+}
+
+//followList sends one page of either side of a user's follow graph, most recent follow first.
+func (s *Server) followList(following bool) hr.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps hr.Params) {
+
+		ctx := r.Context()
+
+		id, err := uuid.FromString(ps.ByName("userid"))
+		if err != nil {
+			s.Log.Errorln(err)
+			http.Error(w, http.StatusText(400), http.StatusBadRequest)
+			return
+		}
+
+		before, limit, err := s.parsePagination(r)
+		if err != nil {
+			s.Log.Errorln(err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		followsCh := make(chan []*models.Follow)
+		errCh := make(chan error)
+
+		go func() {
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			var follows []*models.Follow
+			var err error
+			if following {
+				follows, err = s.DB.Following(id, before, limit+1)
+			} else {
+				follows, err = s.DB.Followers(id, before, limit+1)
+			}
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			followsCh <- follows
+			return
+
+		}()
+
+		select {
+		case <-ctx.Done():
+			s.Log.Errorln(ctx.Err())
+			http.Error(w, "We could not process your request at this time. Please try again later.", http.StatusRequestTimeout)
+			return
+		case err := <-errCh:
+			s.Log.Errorln(err)
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		case follows := <-followsCh:
+			//if there is another page then point the next cursor at the last follow sent.
+			var next *models.Cursor
+			if len(follows) > limit {
+				follows = follows[:limit]
+				last := follows[limit-1]
+				next = &models.Cursor{Created: last.Created, ID: last.User.ID}
+			}
+
+			err = s.writePage(w, r, follows, limit, next)
+			if err != nil {
+				s.Log.Errorln(err)
+				http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+				return
+			}
+			return
+		}
+	}
+}
+
+//timeline retrieves one page of the posts of the current user and the users they follow.
+func (s *Server) timeline() hr.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ hr.Params) {
+
+		ctx := r.Context()
+
+		currentUser, ok := ctx.Value(userContextKey).(uuid.UUID)
+		if !ok {
+			s.Log.Errorln("no userID in context")
+			http.Error(w, http.StatusText(500), http.StatusForbidden)
+			return
+		}
+
+		if uuid.Equal(currentUser, uuid.Nil) {
+			s.Log.Errorln("userID came in with nil value.")
+			http.Error(w, http.StatusText(400), http.StatusBadRequest)
+			return
+		}
+
+		before, limit, err := s.parsePagination(r)
+		if err != nil {
+			s.Log.Errorln(err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		postsCh := make(chan []*models.Post)
+		errCh := make(chan error)
+
+		go func() {
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			posts, err := s.DB.Timeline(currentUser, before, limit+1)
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			postsCh <- posts
+			return
+
+		}()
+
+		select {
+		case <-ctx.Done():
+			s.Log.Errorln(ctx.Err())
+			http.Error(w, "We could not process your request at this time. Please try again later.", http.StatusRequestTimeout)
+			return
+		case err := <-errCh:
+			s.Log.Errorln(err)
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		case posts := <-postsCh:
+			var next *models.Cursor
+			if len(posts) > limit {
+				posts = posts[:limit]
+				last := posts[limit-1]
+				next = &models.Cursor{Created: last.Created, ID: last.ID}
+			}
+
+			err = s.writePage(w, r, posts, limit, next)
+			if err != nil {
+				s.Log.Errorln(err)
+				http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+				return
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/chiips/snippets/API/models"
+	hr "github.com/julienschmidt/httprouter"
+)
+
+func TestFollowAndTimeline(t *testing.T) {
+
+	router := hr.New()
+	s := Server{DB: &mockDB{}, Router: router, Log: testLog}
+	s.Routes()
+
+	//getTimeline returns the timeline of otherUserID
+	getTimeline := func() []*models.Post {
+		rr := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/api/timeline", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		authenticate(t, &s, req, otherUserID)
+
+		router.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code:\ngot: %v\nwant: %v", status, http.StatusOK)
+		}
+
+		posts := []*models.Post{}
+		if err := json.NewDecoder(rr.Body).Decode(&page{Data: &posts}); err != nil {
+			t.Fatal(err)
+		}
+		return posts
+	}
+
+	if posts := getTimeline(); len(posts) != 0 {
+		t.Fatalf("timeline returned posts of an unfollowed user: %v", posts)
+	}
+
+	//following twice counts once
+	for i := 0; i < 2; i++ {
+		rr := httptest.NewRecorder()
+		req, err := http.NewRequest("PUT", fmt.Sprintf("/api/profile/%s/follow", userID), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		authenticate(t, &s, req, otherUserID)
+
+		router.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code:\ngot: %v\nwant: %v", status, http.StatusOK)
+		}
+
+		got := models.Profile{}
+		if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+
+		if got.ID != userID || got.Followers != 1 || !got.Followed {
+			t.Fatalf("handler returned wrong profile:\ngot: %+v", got)
+		}
+	}
+
+	if posts := getTimeline(); len(posts) != 2 || posts[0].ID != postID1 || posts[1].ID != postID2 {
+		t.Fatalf("timeline returned wrong posts of a followed user: %v", posts)
+	}
+
+	//users cannot follow themselves
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("PUT", fmt.Sprintf("/api/profile/%s/follow", userID), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticate(t, &s, req, userID)
+
+	router.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code:\ngot: %v\nwant: %v", status, http.StatusBadRequest)
+	}
+
+}
@@ -0,0 +1,554 @@
+package app
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chiips/snippets/API/models"
+	hr "github.com/julienschmidt/httprouter"
+	uuid "github.com/satori/go.uuid"
+)
+
+//the messages sent for failed and refused logins, the same whether or not an account has the email
+const (
+	msgInvalidLogin   = "invalid email or password"
+	msgThrottledLogin = "too many failed logins. Please try again later, or unlock your account with the link emailed to you."
+)
+
+//the messages sent during two-factor logins
+const (
+	msgMFARequired = "authentication code required"
+	msgMFAExpired  = "login expired. Please log in again."
+	msgInvalidCode = "invalid authentication code"
+)
+
+//unlockRequest is the body of a request to unlock an account, from the link emailed when it was locked
+type unlockRequest struct {
+	Email string `json:"email"`
+	Token string `json:"token"`
+}
+
+//login checks a user's email and password and logs the user in, sending the JWT in cookies as signup does.
+//Failed logins are counted per account and per IP: further attempts must wait longer after each failure,
+//and enough failures lock the account and email the user a link to unlock it. See loginguard.go.
+//Passwords hashed with another algorithm or cost than the server's are rehashed once they are verified.
+//Users with two-factor authentication get a pending token in place of the JWT, to finish logging in with loginMFA.
+func (s *Server) login() hr.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ hr.Params) {
+
+		ctx := r.Context()
+
+		credentials := models.User{}
+		err := json.NewDecoder(r.Body).Decode(&credentials)
+		if err != nil {
+			s.Log.Errorln(err)
+			http.Error(w, http.StatusText(400), http.StatusBadRequest)
+			return
+		}
+
+		email := strings.TrimSpace(credentials.Email)
+		password := credentials.Password
+
+		if email == "" || password == "" {
+			s.Log.Errorln("bad form request")
+			http.Error(w, "invalid email and/or password", http.StatusBadRequest)
+			return
+		}
+
+		ip := s.clientIP(r)
+
+		attempt, wait, err := s.loginReserve(email, ip)
+		if err != nil {
+			s.Log.Errorln(err)
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		}
+
+		if wait > 0 {
+			s.Log.Errorln("login attempt too soon after failed logins from:", ip)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, msgThrottledLogin, http.StatusTooManyRequests)
+			return
+		}
+
+		userCh := make(chan *models.User)
+		mfaCh := make(chan string)
+		failCh := make(chan bool)
+		errCh := make(chan error)
+
+		go func() {
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			user, err := s.DB.UserByEmail(email)
+			if err != nil && err != sql.ErrNoRows {
+				errCh <- err
+				return
+			}
+
+			//unknown emails take as long to refuse as wrong passwords
+			var valid bool
+			if err == nil {
+				valid, err = s.hasher().Verify(password, user.Password)
+				if err != nil {
+					s.Log.Errorln("unreadable password hash for:", user.ID)
+				}
+			} else {
+				s.hasher().VerifyNone(password)
+				user = nil
+			}
+
+			if valid {
+
+				s.rehash(user, password)
+
+				err = s.loginPassed(attempt)
+				if err != nil {
+					errCh <- err
+					return
+				}
+
+				//accounts with two-factor authentication get a pending token instead of the JWT,
+				//and their failed logins are only cleared once the second factor is checked too
+				enabled, err := s.mfaEnabled(user.ID)
+				if err != nil {
+					errCh <- err
+					return
+				}
+
+				if enabled {
+					token, err := s.startMFA(user.ID)
+					if err != nil {
+						errCh <- err
+						return
+					}
+					if ctx.Err() != nil {
+						return
+					}
+					mfaCh <- token
+					return
+				}
+
+				err = s.loginSucceeded(email)
+				if err != nil {
+					errCh <- err
+					return
+				}
+
+				if ctx.Err() != nil {
+					return
+				}
+
+				userCh <- user
+				return
+			}
+
+			//count the failure even if the request has timed out
+			failures, locked, lockErr := s.loginFailed(attempt)
+			if lockErr != nil {
+				errCh <- lockErr
+				return
+			}
+
+			if locked {
+				s.Log.Errorln("login locked after failed logins from:", ip)
+				if user != nil {
+					s.lockedOut(user, ip, failures)
+				}
+			}
+
+			target := uuid.Nil
+			if user != nil {
+				target = user.ID
+			}
+			s.audit(r, models.AuditLoginFailed, uuid.Nil, target, "password")
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			failCh <- true
+			return
+
+		}()
+
+		select {
+		case <-ctx.Done():
+			s.Log.Errorln(ctx.Err())
+			http.Error(w, "We could not process your request at this time. Please try again later.", http.StatusRequestTimeout)
+			return
+		case err := <-errCh:
+			s.Log.Errorln("error logging in:", err)
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		case <-failCh:
+			s.Log.Errorln("failed login from:", ip)
+			http.Error(w, msgInvalidLogin, http.StatusUnauthorized)
+			return
+		case token := <-mfaCh:
+			setMFACookie(w, token)
+			fmt.Fprint(w, msgMFARequired)
+			return
+		case user := <-userCh:
+			//start a session and create its JWT, split into headerpaylod and signature, and put each into cookies.
+			err := s.startSession(w, r, user.ID, user.Roles)
+			if err != nil {
+				s.Log.Errorln(err)
+				http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+				return
+			}
+			s.audit(r, models.AuditLogin, user.ID, user.ID, "password")
+			fmt.Fprint(w, "logged in!")
+			return
+		}
+
+	}
+}
+
+//mfaRequest is the body of requests proving a second factor: a code from the user's authenticator app or a recovery code,
+//and the user's password where the request needs them to log in again
+type mfaRequest struct {
+	Password string `json:"password,omitempty"`
+	Code     string `json:"code"`
+}
+
+//loginMFA finishes a two-factor login with the pending token login sent and the user's authentication or recovery code,
+//sending the JWT in cookies as login does. A pending token allows mfaAttempts codes before the user must log in again.
+//Wrong codes count as failed logins to the account, as wrong passwords do, so they wait and lock the account alike.
+func (s *Server) loginMFA() hr.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ hr.Params) {
+
+		ctx := r.Context()
+
+		cookie, err := r.Cookie("token-mfa")
+		if err != nil {
+			s.Log.Errorln("no pending two-factor login")
+			http.Error(w, msgMFAExpired, http.StatusUnauthorized)
+			return
+		}
+
+		submission := mfaRequest{}
+		err = json.NewDecoder(r.Body).Decode(&submission)
+		if err != nil {
+			s.Log.Errorln(err)
+			http.Error(w, http.StatusText(400), http.StatusBadRequest)
+			return
+		}
+
+		ip := s.clientIP(r)
+
+		userCh := make(chan *models.User)
+		expiredCh := make(chan bool)
+		waitCh := make(chan time.Duration)
+		failCh := make(chan bool)
+		errCh := make(chan error)
+
+		go func() {
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			id, pending, err := s.pendingMFA(cookie.Value)
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			if !pending {
+				if ctx.Err() != nil {
+					return
+				}
+				expiredCh <- true
+				return
+			}
+
+			user, err := s.DB.UserByID(id)
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			attempt, wait, err := s.loginReserve(user.Email, ip)
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			if wait > 0 {
+				if ctx.Err() != nil {
+					return
+				}
+				waitCh <- wait
+				return
+			}
+
+			valid, err := s.secondFactor(id, submission.Code)
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			if !valid {
+				//count the failure even if the request has timed out
+				failures, locked, err := s.loginFailed(attempt)
+				if err != nil {
+					errCh <- err
+					return
+				}
+
+				if locked {
+					s.Log.Errorln("login locked after failed authentication codes from:", ip)
+					s.lockedOut(user, ip, failures)
+				}
+
+				s.audit(r, models.AuditLoginFailed, uuid.Nil, id, "mfa")
+
+				if ctx.Err() != nil {
+					return
+				}
+				failCh <- true
+				return
+			}
+
+			//the code is used up, so finish the login even if the request has timed out
+			err = s.finishMFA(cookie.Value)
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			err = s.loginPassed(attempt)
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			err = s.loginSucceeded(user.Email)
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			userCh <- user
+			return
+
+		}()
+
+		select {
+		case <-ctx.Done():
+			s.Log.Errorln(ctx.Err())
+			http.Error(w, "We could not process your request at this time. Please try again later.", http.StatusRequestTimeout)
+			return
+		case err := <-errCh:
+			s.Log.Errorln("error finishing two-factor login:", err)
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		case <-expiredCh:
+			s.Log.Errorln("expired two-factor login from:", ip)
+			setMFACookie(w, "")
+			http.Error(w, msgMFAExpired, http.StatusUnauthorized)
+			return
+		case wait := <-waitCh:
+			s.Log.Errorln("authentication code too soon after failed logins from:", ip)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, msgThrottledLogin, http.StatusTooManyRequests)
+			return
+		case <-failCh:
+			s.Log.Errorln("invalid authentication code from:", ip)
+			http.Error(w, msgInvalidCode, http.StatusUnauthorized)
+			return
+		case user := <-userCh:
+			setMFACookie(w, "")
+			err := s.startSession(w, r, user.ID, user.Roles)
+			if err != nil {
+				s.Log.Errorln(err)
+				http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+				return
+			}
+			s.audit(r, models.AuditLogin, user.ID, user.ID, "mfa")
+			fmt.Fprint(w, "logged in!")
+			return
+		}
+
+	}
+}
+
+//rehash replaces the user's password hash with one made by the server's Hasher if it was made with another algorithm or cost,
+//e.g. bcrypt hashes from before argon2id. Errors are logged: the user logs in either way, and the hash is replaced at a later login.
+func (s *Server) rehash(user *models.User, password string) {
+
+	h := s.hasher()
+	if !h.NeedsRehash(user.Password) {
+		return
+	}
+
+	hash, err := h.Hash(password)
+	if err != nil {
+		s.Log.Errorln("error rehashing password:", err)
+		return
+	}
+
+	err = s.DB.UpdatePassword(user.ID, hash)
+	if err != nil {
+		s.Log.Errorln("error rehashing password:", err)
+		return
+	}
+
+	user.Password = hash
+}
+
+//lockedOut records a user's account being locked from the IP after the given failed logins, and emails the user a link to unlock it.
+//Errors are logged rather than returned: the account is locked either way, and unlocks when the lockout ends.
+func (s *Server) lockedOut(user *models.User, ip string, failures int) {
+
+	created := time.Now().UTC()
+	until := created.Add(loginLockout)
+
+	id, err := uuid.NewV4()
+	if err != nil {
+		s.Log.Errorln(err)
+		return
+	}
+
+	event := &models.LockoutEvent{ID: id, User: user.ID, Event: models.LockoutLocked, IP: ip, Failures: failures, Until: &until, Created: created}
+	err = s.DB.RecordLockout(event)
+	if err != nil {
+		s.Log.Errorln("error recording lockout:", err)
+	}
+
+	s.Log.WithField("ip", ip).Infoln("account locked:", user.ID, failures)
+
+	if s.Mail == nil {
+		s.Log.Errorln("no mailer to send unlock link")
+		return
+	}
+
+	token, err := s.unlockToken(user.Email)
+	if err != nil {
+		s.Log.Errorln("error creating unlock token:", err)
+		return
+	}
+
+	link := fmt.Sprintf("%s/unlock?email=%s&token=%s", os.Getenv("app_url"), url.QueryEscape(user.Email), token)
+	body := fmt.Sprintf("Hi %s,\n\nThere have been %d failed attempts to log in to your account, so logging in is locked until %s.\n\n"+
+		"If these were you, you can unlock your account now by following this link:\n\n%s\n\n"+
+		"If they were not, your password may be under attack. Consider changing it once you can log in.\n",
+		user.Name, failures, until.Format(time.RFC1123), link)
+
+	err = s.Mail.Send(user.Email, "Your account has been locked", body)
+	if err != nil {
+		s.Log.Errorln("error sending unlock link:", err)
+	}
+}
+
+//unlock handles users unlocking their account with the link emailed when it was locked, clearing its failed logins.
+//An invalid or used link gets the same response whether or not an account has the email.
+func (s *Server) unlock() hr.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ hr.Params) {
+
+		ctx := r.Context()
+
+		submission := unlockRequest{}
+		err := json.NewDecoder(r.Body).Decode(&submission)
+		if err != nil {
+			s.Log.Errorln(err)
+			http.Error(w, http.StatusText(400), http.StatusBadRequest)
+			return
+		}
+
+		email := strings.TrimSpace(submission.Email)
+
+		if email == "" || submission.Token == "" {
+			s.Log.Errorln("bad form request")
+			http.Error(w, "invalid or expired unlock link", http.StatusBadRequest)
+			return
+		}
+
+		okCh := make(chan bool)
+		errCh := make(chan error)
+
+		go func() {
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			unlocked, err := s.unlockAccount(email, submission.Token)
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			if unlocked {
+				s.unlocked(email, s.clientIP(r))
+			}
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			okCh <- unlocked
+			return
+
+		}()
+
+		select {
+		case <-ctx.Done():
+			s.Log.Errorln(ctx.Err())
+			http.Error(w, "We could not process your request at this time. Please try again later.", http.StatusRequestTimeout)
+			return
+		case err := <-errCh:
+			s.Log.Errorln("error unlocking account:", err)
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		case unlocked := <-okCh:
+			if !unlocked {
+				s.Log.Errorln("invalid unlock token")
+				http.Error(w, "invalid or expired unlock link", http.StatusBadRequest)
+				return
+			}
+			fmt.Fprint(w, "account unlocked.")
+			return
+		}
+
+	}
+}
+
+//unlocked records the account with the email being unlocked from the IP. Errors are logged: the account is unlocked either way.
+func (s *Server) unlocked(email, ip string) {
+
+	user, err := s.DB.UserByEmail(email)
+	if err != nil {
+		s.Log.Errorln("error recording unlock:", err)
+		return
+	}
+
+	id, err := uuid.NewV4()
+	if err != nil {
+		s.Log.Errorln(err)
+		return
+	}
+
+	event := &models.LockoutEvent{ID: id, User: user.ID, Event: models.LockoutUnlocked, IP: ip, Created: time.Now().UTC()}
+	err = s.DB.RecordLockout(event)
+	if err != nil {
+		s.Log.Errorln("error recording unlock:", err)
+	}
+
+	s.Log.WithField("ip", ip).Infoln("account unlocked:", user.ID)
+}
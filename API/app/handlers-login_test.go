@@ -0,0 +1,198 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chiips/snippets/API/models"
+	hr "github.com/julienschmidt/httprouter"
+)
+
+func TestLogin(t *testing.T) {
+
+	router := hr.New()
+	mdb := &mockDB{}
+	mailer := &mockMailer{}
+	s := Server{DB: mdb, Router: router, Log: testLog, State: NewMemoryStore(), Mail: mailer, Passwords: testHasher}
+	s.Routes()
+
+	//send posts the body to the url from the given address and returns the response
+	send := func(url, addr string, body interface{}) *httptest.ResponseRecorder {
+		b, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req, err := http.NewRequest("POST", url, bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.RemoteAddr = addr + ":4000"
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	login := func(email, password, addr string) *httptest.ResponseRecorder {
+		return send("/api/login", addr, &models.User{Email: email, Password: password})
+	}
+
+	//skip lets the backoff from earlier failures pass without waiting it out
+	skip := func(email, addr string) {
+		if err := s.State.Delete(loginAccountKey(email)+":wait", loginAccountKey(email)+":turn", loginIPKey(addr)+":wait", loginIPKey(addr)+":turn"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	//a user logs in with the right password and gets a working JWT
+	rr := login("user-1@example.com", testPassword, "192.0.2.1")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("login returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusOK)
+	}
+	req, err := http.NewRequest("GET", "/api/timeline", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range rr.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	timeline := httptest.NewRecorder()
+	router.ServeHTTP(timeline, req)
+	if timeline.Code != http.StatusOK {
+		t.Errorf("JWT from login refused:\ngot: %v\nwant: %v", timeline.Code, http.StatusOK)
+	}
+
+	//the legacy bcrypt hash was replaced with an argon2id one, which the next login verifies
+	rehashed := mdb.passwords[userID]
+	if !strings.HasPrefix(rehashed, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Fatalf("password not rehashed on login: %q", rehashed)
+	}
+	if rr := login("user-1@example.com", testPassword, "192.0.2.1"); rr.Code != http.StatusOK || mdb.passwords[userID] != rehashed {
+		t.Errorf("login with rehashed password returned wrong status code or rehashed again: %v", rr.Code)
+	}
+
+	//a wrong password and an unknown email get the same response
+	wrong := login("user-1@example.com", "Wrong1!", "192.0.2.1")
+	unknown := login("nobody@example.com", "Wrong1!", "192.0.2.1")
+	if wrong.Code != http.StatusUnauthorized || wrong.Code != unknown.Code || wrong.Body.String() != unknown.Body.String() {
+		t.Errorf("failed logins answered differently:\nwrong password: %v %q\nunknown email: %v %q", wrong.Code, wrong.Body, unknown.Code, unknown.Body)
+	}
+
+	//after the free failures, each attempt must wait, even with the right password
+	for i := 0; i < accountFreeFailures; i++ {
+		login("user-2@example.com", "Wrong1!", "192.0.2.2")
+	}
+	if rr := login("user-2@example.com", "Wrong1!", "192.0.2.3"); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("failure within the free failures returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusUnauthorized)
+	}
+	rr = login("user-2@example.com", testPassword, "192.0.2.4")
+	if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") != "1" {
+		t.Errorf("login during backoff returned wrong status code or Retry-After: %v %q", rr.Code, rr.Header().Get("Retry-After"))
+	}
+
+	//concurrent attempts get no more tries than attempts one after another
+	results := make(chan int)
+	for i := 0; i < 2*accountFreeFailures; i++ {
+		go func(i int) {
+			results <- login("rush@example.com", "Wrong1!", "198.51.100."+strconv.Itoa(i)).Code
+		}(i)
+	}
+	tried := 0
+	for i := 0; i < 2*accountFreeFailures; i++ {
+		if code := <-results; code == http.StatusUnauthorized {
+			tried++
+		} else if code != http.StatusTooManyRequests {
+			t.Errorf("concurrent attempt returned wrong status code:\ngot: %v\nwant: %v or %v", code, http.StatusUnauthorized, http.StatusTooManyRequests)
+		}
+	}
+	if tried != accountFreeFailures+1 {
+		t.Errorf("concurrent attempts tried wrongly:\ngot: %v\nwant: %v", tried, accountFreeFailures+1)
+	}
+
+	//enough failures lock the account, the user is emailed a link to unlock it, and the lockout is recorded
+	for _, email := range []string{"user-3@example.com", "stranger@example.com"} {
+		for i := 1; i <= loginLockoutFailures; i++ {
+			skip(email, "192.0.2.5")
+			if rr := login(email, "Wrong1!", "192.0.2.5"); rr.Code != http.StatusUnauthorized {
+				t.Fatalf("failure %v for %v returned wrong status code:\ngot: %v\nwant: %v", i, email, rr.Code, http.StatusUnauthorized)
+			}
+		}
+		skip(email, "192.0.2.5")
+		rr := login(email, testPassword, "192.0.2.5")
+		if rr.Code != http.StatusTooManyRequests || !strings.HasPrefix(rr.Body.String(), msgThrottledLogin) {
+			t.Errorf("login to locked %v returned wrong status code or message: %v %q", email, rr.Code, rr.Body)
+		}
+		if wait, err := strconv.Atoi(rr.Header().Get("Retry-After")); err != nil || time.Duration(wait)*time.Second < loginLockout-time.Minute {
+			t.Errorf("login to locked %v returned wrong Retry-After: %q", email, rr.Header().Get("Retry-After"))
+		}
+	}
+
+	if len(mailer.sent) != 1 || mailer.sent[0].to != "user-3@example.com" {
+		t.Fatalf("unlock links sent wrongly: %+v", mailer.sent)
+	}
+	if len(mdb.lockouts) != 1 || mdb.lockouts[0].User != thirdUserID || mdb.lockouts[0].IP != "192.0.2.5" || mdb.lockouts[0].Event != models.LockoutLocked {
+		t.Errorf("lockout recorded wrongly: %+v", mdb.lockouts)
+	}
+
+	//the emailed link unlocks the account once
+	match := regexp.MustCompile(`token=([A-Za-z0-9_-]+)`).FindStringSubmatch(mailer.sent[0].body)
+	if match == nil {
+		t.Fatalf("unlock email has no token: %v", mailer.sent[0].body)
+	}
+	unlock := &unlockRequest{Email: "user-3@example.com", Token: match[1]}
+
+	for _, bad := range []*unlockRequest{{Email: "user-3@example.com", Token: "guess"}, {Email: "user-1@example.com", Token: match[1]}} {
+		if rr := send("/api/login/unlock", "192.0.2.5", bad); rr.Code != http.StatusBadRequest {
+			t.Errorf("unlock with %+v returned wrong status code:\ngot: %v\nwant: %v", bad, rr.Code, http.StatusBadRequest)
+		}
+	}
+
+	if rr := send("/api/login/unlock", "192.0.2.6", unlock); rr.Code != http.StatusOK {
+		t.Fatalf("unlock returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusOK)
+	}
+	if rr := send("/api/login/unlock", "192.0.2.6", unlock); rr.Code != http.StatusBadRequest {
+		t.Errorf("second unlock returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusBadRequest)
+	}
+	if len(mdb.lockouts) != 2 || mdb.lockouts[1].Event != models.LockoutUnlocked || mdb.lockouts[1].IP != "192.0.2.6" {
+		t.Errorf("unlock recorded wrongly: %+v", mdb.lockouts)
+	}
+
+	if rr := login("user-3@example.com", testPassword, "192.0.2.6"); rr.Code != http.StatusOK {
+		t.Errorf("login after unlock returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusOK)
+	}
+
+	//guessing across many accounts from one IP makes the IP wait too
+	for i := 0; i <= ipFreeFailures; i++ {
+		login(strings.Repeat("x", i+1)+"@example.com", "Wrong1!", "192.0.2.7")
+	}
+	if rr := login("user-1@example.com", testPassword, "192.0.2.7"); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("login from a guessing IP returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusTooManyRequests)
+	}
+	if rr := login("user-1@example.com", testPassword, "192.0.2.8"); rr.Code != http.StatusOK {
+		t.Errorf("login from another IP returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusOK)
+	}
+}
+
+func TestLoginBackoff(t *testing.T) {
+
+	for failures, want := range map[int]time.Duration{
+		0:  0,
+		3:  0,
+		4:  time.Second,
+		5:  2 * time.Second,
+		8:  16 * time.Second,
+		20: loginMaxDelay,
+	} {
+		if got := loginBackoff(failures, 3); got != want {
+			t.Errorf("loginBackoff(%v, 3) returned wrong delay:\ngot: %v\nwant: %v", failures, got, want)
+		}
+	}
+}
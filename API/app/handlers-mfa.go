@@ -0,0 +1,361 @@
+package app
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/chiips/snippets/API/models"
+	"github.com/chiips/snippets/API/qrcode"
+	"github.com/chiips/snippets/API/totp"
+	hr "github.com/julienschmidt/httprouter"
+	uuid "github.com/satori/go.uuid"
+)
+
+//totpEnrollment is sent to users enrolling an authenticator app: the secret to type in,
+//and the otpauth URI to scan, also rendered as a QR code PNG in a data URI.
+type totpEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+	QR     string `json:"qr"`
+}
+
+//recoveryCodeList is sent once when two-factor authentication is turned on, for the user to keep
+type recoveryCodeList struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+//msgMFANotEnabled refuses turning off two-factor authentication that is not on
+const msgMFANotEnabled = "two-factor authentication is not enabled"
+
+//totpIssuer names the app in users' authenticator apps, from the totp_issuer environment variable
+func totpIssuer() string {
+	if issuer := os.Getenv("totp_issuer"); issuer != "" {
+		return issuer
+	}
+	return "Snippets"
+}
+
+//enrollTOTP starts turning on two-factor authentication for the current user with a new authenticator app secret.
+//The enrollment protects nothing until confirmTOTP confirms it with a first code; enrolling again before then replaces it.
+func (s *Server) enrollTOTP() hr.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ hr.Params) {
+
+		ctx := r.Context()
+
+		currentUser, ok := ctx.Value(userContextKey).(uuid.UUID)
+		if !ok {
+			s.Log.Errorln("no userID in context")
+			http.Error(w, http.StatusText(500), http.StatusForbidden)
+			return
+		}
+
+		secret, err := totp.NewSecret()
+		if err != nil {
+			s.Log.Errorln(err)
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		}
+
+		enrollmentCh := make(chan *totpEnrollment)
+		conflictCh := make(chan bool)
+		errCh := make(chan error)
+
+		go func() {
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			profile, err := s.DB.UserProfile(currentUser, currentUser)
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			err = s.DB.EnrollTOTP(&models.TOTP{User: currentUser, Secret: secret, Created: time.Now().UTC()})
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err == models.ErrTOTPConfirmed {
+				conflictCh <- true
+				return
+			}
+
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			uri := totp.URI(totpIssuer(), profile.Name, secret)
+
+			code, err := qrcode.Encode(uri)
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			png, err := code.PNG(6)
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			enrollmentCh <- &totpEnrollment{Secret: secret, URI: uri, QR: "data:image/png;base64," + base64.StdEncoding.EncodeToString(png)}
+			return
+
+		}()
+
+		select {
+		case <-ctx.Done():
+			s.Log.Errorln(ctx.Err())
+			http.Error(w, "We could not process your request at this time. Please try again later.", http.StatusRequestTimeout)
+			return
+		case err := <-errCh:
+			s.Log.Errorln("error enrolling TOTP:", err)
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		case <-conflictCh:
+			s.Log.Errorln("two-factor authentication already enabled")
+			http.Error(w, "two-factor authentication already enabled", http.StatusConflict)
+			return
+		case enrollment := <-enrollmentCh:
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Cache-Control", "no-store")
+			err = json.NewEncoder(w).Encode(enrollment)
+			if err != nil {
+				s.Log.Errorln(err)
+			}
+			return
+		}
+	}
+}
+
+//confirmTOTP turns on two-factor authentication for the current user once they send a first code from the authenticator app they enrolled,
+//and sends them their recovery codes. Only hashes of the codes are kept, so this is the only time they are shown.
+func (s *Server) confirmTOTP() hr.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ hr.Params) {
+
+		ctx := r.Context()
+
+		currentUser, ok := ctx.Value(userContextKey).(uuid.UUID)
+		if !ok {
+			s.Log.Errorln("no userID in context")
+			http.Error(w, http.StatusText(500), http.StatusForbidden)
+			return
+		}
+
+		submission := mfaRequest{}
+		err := json.NewDecoder(r.Body).Decode(&submission)
+		if err != nil {
+			s.Log.Errorln(err)
+			http.Error(w, http.StatusText(400), http.StatusBadRequest)
+			return
+		}
+
+		codes, hashes, err := newRecoveryCodes()
+		if err != nil {
+			s.Log.Errorln(err)
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		}
+
+		//the response for a missing or already confirmed enrollment, or an invalid code, if not confirmed
+		statusCh := make(chan int)
+		errCh := make(chan error)
+
+		go func() {
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			t, err := s.DB.TOTP(currentUser)
+			if err != nil && err != sql.ErrNoRows {
+				errCh <- err
+				return
+			}
+
+			status := http.StatusOK
+
+			switch {
+			case err == sql.ErrNoRows:
+				status = http.StatusNotFound
+			case t.Confirmed:
+				status = http.StatusConflict
+			default:
+				step, valid, err := totp.Validate(t.Secret, submission.Code, time.Now())
+				if err != nil {
+					errCh <- err
+					return
+				}
+				if !valid {
+					status = http.StatusBadRequest
+					break
+				}
+				err = s.DB.ConfirmTOTP(currentUser, step, hashes)
+				if err != nil {
+					errCh <- err
+					return
+				}
+			}
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			statusCh <- status
+			return
+
+		}()
+
+		select {
+		case <-ctx.Done():
+			s.Log.Errorln(ctx.Err())
+			http.Error(w, "We could not process your request at this time. Please try again later.", http.StatusRequestTimeout)
+			return
+		case err := <-errCh:
+			s.Log.Errorln("error confirming TOTP:", err)
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		case status := <-statusCh:
+			switch status {
+			case http.StatusNotFound:
+				s.Log.Errorln("no TOTP enrollment to confirm")
+				http.Error(w, "no two-factor authentication enrollment to confirm", http.StatusNotFound)
+			case http.StatusConflict:
+				s.Log.Errorln("two-factor authentication already enabled")
+				http.Error(w, "two-factor authentication already enabled", http.StatusConflict)
+			case http.StatusBadRequest:
+				s.Log.Errorln("invalid code confirming TOTP")
+				http.Error(w, msgInvalidCode, http.StatusBadRequest)
+			default:
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set("Cache-Control", "no-store")
+				err = json.NewEncoder(w).Encode(&recoveryCodeList{RecoveryCodes: codes})
+				if err != nil {
+					s.Log.Errorln(err)
+				}
+			}
+			return
+		}
+	}
+}
+
+//disableTOTP turns off two-factor authentication for the current user, deleting their authenticator app secret and recovery codes.
+//A stolen session should not be enough to do so, so the user must log in again in the request: their password and a code.
+func (s *Server) disableTOTP() hr.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ hr.Params) {
+
+		ctx := r.Context()
+
+		currentUser, ok := ctx.Value(userContextKey).(uuid.UUID)
+		if !ok {
+			s.Log.Errorln("no userID in context")
+			http.Error(w, http.StatusText(500), http.StatusForbidden)
+			return
+		}
+
+		submission := mfaRequest{}
+		err := json.NewDecoder(r.Body).Decode(&submission)
+		if err != nil {
+			s.Log.Errorln(err)
+			http.Error(w, http.StatusText(400), http.StatusBadRequest)
+			return
+		}
+
+		//the message refusing the request, or empty once disabled
+		refusedCh := make(chan string)
+		errCh := make(chan error)
+
+		go func() {
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			enabled, err := s.mfaEnabled(currentUser)
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			refused := msgMFANotEnabled
+
+			if enabled {
+				hash, err := s.DB.UserPassword(currentUser)
+				if err != nil {
+					errCh <- err
+					return
+				}
+
+				valid, err := s.hasher().Verify(submission.Password, hash)
+				if err != nil {
+					errCh <- err
+					return
+				}
+
+				refused = "incorrect password"
+				if valid {
+					valid, err = s.secondFactor(currentUser, submission.Code)
+					if err != nil {
+						errCh <- err
+						return
+					}
+					refused = msgInvalidCode
+					if valid {
+						refused = ""
+					}
+				}
+			}
+
+			if refused == "" {
+				err = s.DB.DeleteTOTP(currentUser)
+
+				//no checking context again here: once the delete reached the database two-factor authentication is off.
+
+				if err != nil {
+					errCh <- err
+					return
+				}
+			} else if ctx.Err() != nil {
+				return
+			}
+
+			refusedCh <- refused
+			return
+
+		}()
+
+		select {
+		case <-ctx.Done():
+			s.Log.Errorln(ctx.Err())
+			http.Error(w, "We could not process your request at this time. Please try again later.", http.StatusRequestTimeout)
+			return
+		case err := <-errCh:
+			s.Log.Errorln("error disabling TOTP:", err)
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		case refused := <-refusedCh:
+			if refused == msgMFANotEnabled {
+				s.Log.Errorln("refused disabling TOTP:", refused)
+				http.Error(w, refused, http.StatusBadRequest)
+				return
+			}
+			if refused != "" {
+				s.Log.Errorln("refused disabling TOTP:", refused)
+				http.Error(w, refused, http.StatusForbidden)
+				return
+			}
+			s.Log.WithField("ip", s.clientIP(r)).Infoln("two-factor authentication disabled:", currentUser)
+			fmt.Fprint(w, "two-factor authentication disabled.")
+			return
+		}
+	}
+}
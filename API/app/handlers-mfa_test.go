@@ -0,0 +1,205 @@
+package app
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chiips/snippets/API/models"
+	"github.com/chiips/snippets/API/totp"
+	hr "github.com/julienschmidt/httprouter"
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestTOTP(t *testing.T) {
+
+	router := hr.New()
+	mdb := &mockDB{}
+	s := Server{DB: mdb, Router: router, Log: testLog, State: NewMemoryStore(), Passwords: testHasher}
+	s.Routes()
+
+	//send sends the body to the url as the user unless nil, with any cookies given
+	send := func(method, url string, user uuid.UUID, body interface{}, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		b, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest(method, url, bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !uuid.Equal(user, uuid.Nil) {
+			authenticate(t, &s, req, user)
+		}
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	login := func() *httptest.ResponseRecorder {
+		return send("POST", "/api/login", uuid.Nil, &models.User{Email: "user-1@example.com", Password: testPassword})
+	}
+
+	//cookie returns the response's cookie with the name, or nil
+	cookie := func(rr *httptest.ResponseRecorder, name string) *http.Cookie {
+		for _, c := range rr.Result().Cookies() {
+			if c.Name == name && c.MaxAge >= 0 {
+				return c
+			}
+		}
+		return nil
+	}
+
+	//enrolling sends the secret, its otpauth URI, and the URI as a QR code PNG
+	rr := send("POST", "/api/mfa/totp", userID, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("enroll returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusOK)
+	}
+	enrollment := totpEnrollment{}
+	if err := json.NewDecoder(rr.Body).Decode(&enrollment); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(enrollment.URI, "otpauth://totp/Snippets:User?secret="+enrollment.Secret) {
+		t.Errorf("enrollment URI wrong: %v", enrollment.URI)
+	}
+	qr, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(enrollment.QR, "data:image/png;base64,"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := png.Decode(bytes.NewReader(qr)); err != nil {
+		t.Errorf("enrollment QR code not a PNG: %v", err)
+	}
+
+	//an unconfirmed enrollment does not change logging in
+	if rr := login(); rr.Body.String() != "logged in!" {
+		t.Errorf("login with unconfirmed TOTP returned wrong body: %q", rr.Body)
+	}
+
+	//confirming takes a valid code and sends the recovery codes
+	step := totp.Step(time.Now())
+	code := func(step int64) string {
+		c, err := totp.Code(enrollment.Secret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	if rr := send("POST", "/api/mfa/totp/confirm", userID, &mfaRequest{Code: "000000"}); rr.Code != http.StatusBadRequest && code(step) != "000000" {
+		t.Errorf("confirm with wrong code returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusBadRequest)
+	}
+	rr = send("POST", "/api/mfa/totp/confirm", userID, &mfaRequest{Code: code(step)})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("confirm returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusOK)
+	}
+	recovery := recoveryCodeList{}
+	if err := json.NewDecoder(rr.Body).Decode(&recovery); err != nil {
+		t.Fatal(err)
+	}
+	if len(recovery.RecoveryCodes) != recoveryCodes || len(mdb.recoveryCodes[userID]) != recoveryCodes || !isRecoveryCode(recovery.RecoveryCodes[0]) {
+		t.Fatalf("recovery codes sent or stored wrongly: %v", recovery.RecoveryCodes)
+	}
+	if _, stored := mdb.recoveryCodes[userID][recovery.RecoveryCodes[0]]; stored {
+		t.Errorf("recovery code stored unhashed")
+	}
+
+	for _, url := range []string{"/api/mfa/totp", "/api/mfa/totp/confirm"} {
+		if rr := send("POST", url, userID, &mfaRequest{Code: code(step)}); rr.Code != http.StatusConflict {
+			t.Errorf("%v with confirmed TOTP returned wrong status code:\ngot: %v\nwant: %v", url, rr.Code, http.StatusConflict)
+		}
+	}
+
+	//logging in now sends a pending token instead of the JWT
+	rr = login()
+	pending := cookie(rr, "token-mfa")
+	if rr.Code != http.StatusOK || rr.Body.String() != msgMFARequired || pending == nil || !pending.HttpOnly || cookie(rr, "token-hp") != nil {
+		t.Fatalf("login with TOTP returned wrong response: %v %q %+v", rr.Code, rr.Body, rr.Result().Cookies())
+	}
+
+	if rr := send("POST", "/api/login/mfa", uuid.Nil, &mfaRequest{Code: code(step + 1)}); rr.Code != http.StatusUnauthorized || !strings.HasPrefix(rr.Body.String(), msgMFAExpired) {
+		t.Errorf("second factor without pending token returned wrong response: %v %q", rr.Code, rr.Body)
+	}
+
+	//the code used to confirm cannot be used again
+	if rr := send("POST", "/api/login/mfa", uuid.Nil, &mfaRequest{Code: code(step)}, pending); rr.Code != http.StatusUnauthorized || !strings.HasPrefix(rr.Body.String(), msgInvalidCode) {
+		t.Errorf("replayed code returned wrong response: %v %q", rr.Code, rr.Body)
+	}
+
+	//a later code finishes logging in with a working JWT, and spends the pending token
+	rr = send("POST", "/api/login/mfa", uuid.Nil, &mfaRequest{Code: code(step + 1)}, pending)
+	if rr.Code != http.StatusOK || cookie(rr, "token-hp") == nil || cookie(rr, "token-s") == nil {
+		t.Fatalf("second factor returned wrong response: %v %q", rr.Code, rr.Body)
+	}
+	req, err := http.NewRequest("GET", "/api/timeline", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(cookie(rr, "token-hp"))
+	req.AddCookie(cookie(rr, "token-s"))
+	timeline := httptest.NewRecorder()
+	router.ServeHTTP(timeline, req)
+	if timeline.Code != http.StatusOK {
+		t.Errorf("JWT from two-factor login refused:\ngot: %v\nwant: %v", timeline.Code, http.StatusOK)
+	}
+	if rr := send("POST", "/api/login/mfa", uuid.Nil, &mfaRequest{Code: code(step + 1)}, pending); rr.Code != http.StatusUnauthorized || !strings.HasPrefix(rr.Body.String(), msgMFAExpired) {
+		t.Errorf("spent pending token returned wrong response: %v %q", rr.Code, rr.Body)
+	}
+
+	//recovery codes log in once each, typed in any case
+	rr = send("POST", "/api/login/mfa", uuid.Nil, &mfaRequest{Code: strings.ToUpper(recovery.RecoveryCodes[0])}, cookie(login(), "token-mfa"))
+	if rr.Code != http.StatusOK {
+		t.Errorf("recovery code returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusOK)
+	}
+	rr = send("POST", "/api/login/mfa", uuid.Nil, &mfaRequest{Code: recovery.RecoveryCodes[0]}, cookie(login(), "token-mfa"))
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("used recovery code returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusUnauthorized)
+	}
+
+	//a pending token allows only so many guesses
+	pending = cookie(login(), "token-mfa")
+	for i := 0; i < mfaAttempts; i++ {
+		send("POST", "/api/login/mfa", uuid.Nil, &mfaRequest{Code: "guess"}, pending)
+	}
+	if rr := send("POST", "/api/login/mfa", uuid.Nil, &mfaRequest{Code: recovery.RecoveryCodes[1]}, pending); rr.Code != http.StatusUnauthorized || !strings.HasPrefix(rr.Body.String(), msgMFAExpired) {
+		t.Errorf("pending token past its attempts returned wrong response: %v %q", rr.Code, rr.Body)
+	}
+
+	//wrong codes count as failed logins, which the right password does not clear: the account must wait before logging in again
+	if rr := login(); rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") == "" {
+		t.Errorf("login after wrong codes returned wrong response: %v %q", rr.Code, rr.Body)
+	}
+	if failures, err := s.State.Exists(loginAccountKey("user-1@example.com") + ":failures"); err != nil || !failures {
+		t.Errorf("failed codes not counted: %v %v", failures, err)
+	}
+	if err := s.loginSucceeded("user-1@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	//turning two-factor authentication off takes the password and a code
+	for _, bad := range []*mfaRequest{{Password: "Wrong1!", Code: recovery.RecoveryCodes[1]}, {Password: testPassword, Code: "guess"}} {
+		if rr := send("DELETE", "/api/mfa/totp", userID, bad); rr.Code != http.StatusForbidden {
+			t.Errorf("disable with %+v returned wrong status code:\ngot: %v\nwant: %v", bad, rr.Code, http.StatusForbidden)
+		}
+	}
+	if rr := send("DELETE", "/api/mfa/totp", userID, &mfaRequest{Password: testPassword, Code: recovery.RecoveryCodes[1]}); rr.Code != http.StatusOK {
+		t.Fatalf("disable returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusOK)
+	}
+	if mdb.totp[userID] != nil || mdb.recoveryCodes[userID] != nil {
+		t.Errorf("TOTP not deleted on disable")
+	}
+	if rr := login(); rr.Body.String() != "logged in!" {
+		t.Errorf("login after disabling TOTP returned wrong body: %q", rr.Body)
+	}
+	if rr := send("DELETE", "/api/mfa/totp", userID, &mfaRequest{Password: testPassword, Code: recovery.RecoveryCodes[2]}); rr.Code != http.StatusBadRequest {
+		t.Errorf("disable without TOTP returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusBadRequest)
+	}
+}
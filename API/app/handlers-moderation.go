@@ -0,0 +1,801 @@
+package app
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/chiips/snippets/API/models"
+	hr "github.com/julienschmidt/httprouter"
+	uuid "github.com/satori/go.uuid"
+)
+
+//the most characters a report's details, a moderator's note, and an appeal may have
+const (
+	maxReportDetails = 500
+	maxModNote       = 500
+	maxAppealLength  = 1000
+)
+
+//report handles users reporting a post, comment, or user to the moderators.
+//The request names the kind of content, its id as target, a reason code, and optional details.
+func (s *Server) report() hr.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ hr.Params) {
+
+		ctx := r.Context()
+
+		currentUser, ok := ctx.Value(userContextKey).(uuid.UUID)
+		if !ok {
+			s.Log.Errorln("no userID in context")
+			http.Error(w, http.StatusText(500), http.StatusForbidden)
+			return
+		}
+
+		if uuid.Equal(currentUser, uuid.Nil) {
+			s.Log.Errorln("userID came in with nil value.")
+			http.Error(w, http.StatusText(400), http.StatusBadRequest)
+			return
+		}
+
+		submission := models.Report{}
+		err := json.NewDecoder(r.Body).Decode(&submission)
+		if err != nil {
+			s.Log.Errorln(err)
+			http.Error(w, http.StatusText(400), http.StatusBadRequest)
+			return
+		}
+
+		if !models.ValidKind(submission.Kind) {
+			s.Log.Errorln("invalid report kind")
+			http.Error(w, "invalid kind: must be post, comment, or user", http.StatusBadRequest)
+			return
+		}
+
+		if !models.ValidReason(submission.Reason) {
+			s.Log.Errorln("invalid report reason")
+			http.Error(w, "invalid reason: must be one of "+strings.Join(models.ReportReasons, ", "), http.StatusBadRequest)
+			return
+		}
+
+		if utf8.RuneCountInString(submission.Details) > maxReportDetails {
+			s.Log.Errorln("invalid details")
+			http.Error(w, "invalid details", http.StatusBadRequest)
+			return
+		}
+
+		//find who is responsible for the content, confirming it exists
+		subject, status := s.reportSubject(submission.Kind, submission.Target)
+		if status != http.StatusOK {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+
+		if !CanReport(currentUser, subject) {
+			s.Log.Errorln("user tried to report themselves")
+			http.Error(w, "cannot report yourself", http.StatusBadRequest)
+			return
+		}
+
+		id, err := uuid.NewV4()
+		if err != nil {
+			s.Log.Errorln(err)
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		}
+
+		report := &models.Report{ID: id, Reporter: currentUser, Kind: submission.Kind, Target: submission.Target, Subject: subject, Reason: submission.Reason, Details: strings.TrimSpace(submission.Details), Created: time.Now().UTC()}
+
+		okCh := make(chan bool)
+		errCh := make(chan error)
+
+		go func() {
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			err := s.DB.CreateReport(report)
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			okCh <- true
+			return
+
+		}()
+
+		select {
+		case <-ctx.Done():
+			s.Log.Errorln(ctx.Err())
+			http.Error(w, "We could not process your request at this time. Please try again later.", http.StatusRequestTimeout)
+			return
+		case err := <-errCh:
+			s.Log.Errorln("error reporting:", err)
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		case <-okCh:
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			err = json.NewEncoder(w).Encode(report)
+			if err != nil {
+				s.Log.Errorln(err)
+			}
+			return
+		}
+	}
+}
+
+//reportSubject loads the reported content and returns the user responsible for it: the author of a post or comment, or the reported user.
+//It returns the status to send the client if the content cannot be loaded, or http.StatusOK.
+func (s *Server) reportSubject(kind string, target uuid.UUID) (uuid.UUID, int) {
+
+	var subject uuid.UUID
+	var err error
+
+	switch kind {
+	case models.KindPost:
+		var post *models.Post
+		post, err = s.DB.OnePost(target, uuid.Nil)
+		if err == nil {
+			subject = post.Author.ID
+		}
+	case models.KindComment:
+		var comment *models.Comment
+		comment, err = s.DB.OneComment(target)
+		if err == nil {
+			subject = comment.Author.ID
+		}
+	case models.KindUser:
+		var profile *models.Profile
+		profile, err = s.DB.UserProfile(target, uuid.Nil)
+		if err == nil {
+			subject = profile.ID
+		}
+	}
+
+	switch {
+	case err == sql.ErrNoRows:
+		s.Log.Errorln(err)
+		return subject, http.StatusNotFound
+	case err != nil:
+		s.Log.Errorln(err)
+		return subject, http.StatusInternalServerError
+	}
+
+	return subject, http.StatusOK
+}
+
+//reportQueue retrieves one page of the moderation queue, newest first.
+//It lists open reports unless the status query parameter asks for resolved, dismissed, or all reports,
+//and the kind and reason parameters narrow it further.
+func (s *Server) reportQueue() hr.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ hr.Params) {
+
+		ctx := r.Context()
+
+		query := r.URL.Query()
+
+		filter := models.ReportFilter{Status: query.Get("status"), Kind: query.Get("kind"), Reason: query.Get("reason")}
+		switch filter.Status {
+		case "":
+			filter.Status = models.ReportOpen
+		case "all":
+			filter.Status = ""
+		case models.ReportOpen, models.ReportResolved, models.ReportDismissed:
+		default:
+			s.Log.Errorln("invalid report status")
+			http.Error(w, "invalid status: must be open, resolved, dismissed, or all", http.StatusBadRequest)
+			return
+		}
+
+		if (filter.Kind != "" && !models.ValidKind(filter.Kind)) || (filter.Reason != "" && !models.ValidReason(filter.Reason)) {
+			s.Log.Errorln("invalid report filter")
+			http.Error(w, "invalid kind or reason", http.StatusBadRequest)
+			return
+		}
+
+		before, limit, err := s.parsePagination(r)
+		if err != nil {
+			s.Log.Errorln(err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		reportsCh := make(chan []*models.Report)
+		errCh := make(chan error)
+
+		go func() {
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			reports, err := s.DB.Reports(filter, before, limit+1)
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			reportsCh <- reports
+			return
+
+		}()
+
+		select {
+		case <-ctx.Done():
+			s.Log.Errorln(ctx.Err())
+			http.Error(w, "We could not process your request at this time. Please try again later.", http.StatusRequestTimeout)
+			return
+		case err := <-errCh:
+			s.Log.Errorln(err)
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		case reports := <-reportsCh:
+			var next *models.Cursor
+			if len(reports) > limit {
+				reports = reports[:limit]
+				last := reports[limit-1]
+				next = &models.Cursor{Created: last.Created, ID: last.ID}
+			}
+
+			err = s.writePage(w, r, reports, limit, next)
+			if err != nil {
+				s.Log.Errorln(err)
+				http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+				return
+			}
+			return
+		}
+	}
+}
+
+//moderate lets a moderator act on the content of the report in the url: hide a post, delete a post or comment,
+//warn or suspend the user responsible, or dismiss the report. The action closes every open report on the same content.
+//A suspension needs an until time in the future.
+func (s *Server) moderate() hr.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps hr.Params) {
+
+		ctx := r.Context()
+
+		currentUser, ok := ctx.Value(userContextKey).(uuid.UUID)
+		if !ok {
+			s.Log.Errorln("no userID in context")
+			http.Error(w, http.StatusText(500), http.StatusForbidden)
+			return
+		}
+
+		if uuid.Equal(currentUser, uuid.Nil) {
+			s.Log.Errorln("userID came in with nil value.")
+			http.Error(w, http.StatusText(400), http.StatusBadRequest)
+			return
+		}
+
+		reportID, err := uuid.FromString(ps.ByName("reportid"))
+		if err != nil {
+			s.Log.Errorln(err)
+			http.Error(w, http.StatusText(400), http.StatusBadRequest)
+			return
+		}
+
+		submission := models.ModAction{}
+		err = json.NewDecoder(r.Body).Decode(&submission)
+		if err != nil {
+			s.Log.Errorln(err)
+			http.Error(w, http.StatusText(400), http.StatusBadRequest)
+			return
+		}
+
+		if utf8.RuneCountInString(submission.Note) > maxModNote {
+			s.Log.Errorln("invalid note")
+			http.Error(w, "invalid note", http.StatusBadRequest)
+			return
+		}
+
+		created := time.Now().UTC()
+
+		if submission.Action == models.ActionSuspend && (submission.Until == nil || !submission.Until.After(created)) {
+			s.Log.Errorln("invalid suspension")
+			http.Error(w, "invalid until: a suspension must end in the future", http.StatusBadRequest)
+			return
+		}
+		if submission.Action != models.ActionSuspend {
+			submission.Until = nil
+		}
+
+		report, err := s.DB.OneReport(reportID)
+		switch {
+		case err == sql.ErrNoRows:
+			s.Log.Errorln(err)
+			http.Error(w, http.StatusText(404), http.StatusNotFound)
+			return
+		case err != nil:
+			s.Log.Errorln(err)
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		}
+
+		if report.Status != models.ReportOpen {
+			s.Log.Errorln("report already closed")
+			http.Error(w, "report already closed", http.StatusConflict)
+			return
+		}
+
+		if !models.ValidAction(report.Kind, submission.Action) {
+			s.Log.Errorln("invalid moderator action")
+			http.Error(w, "invalid action for a reported "+report.Kind, http.StatusBadRequest)
+			return
+		}
+
+		id, err := uuid.NewV4()
+		if err != nil {
+			s.Log.Errorln(err)
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		}
+
+		action := &models.ModAction{ID: id, Moderator: currentUser, Kind: report.Kind, Target: report.Target, Subject: report.Subject, Action: submission.Action, Note: strings.TrimSpace(submission.Note), Until: submission.Until, Created: created}
+
+		okCh := make(chan bool)
+		errCh := make(chan error)
+
+		go func() {
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			err := s.DB.Moderate(action)
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			okCh <- true
+			return
+
+		}()
+
+		select {
+		case <-ctx.Done():
+			s.Log.Errorln(ctx.Err())
+			http.Error(w, "We could not process your request at this time. Please try again later.", http.StatusRequestTimeout)
+			return
+		case err := <-errCh:
+			s.Log.Errorln("error moderating:", err)
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		case <-okCh:
+			s.Log.WithField("ip", s.clientIP(r)).Infoln("moderator action:", action.Moderator, action.Action, action.Kind, action.Target)
+			s.audit(r, models.AuditModeration, action.Moderator, action.Subject, action.Action+" "+action.Kind+" "+action.Target.String())
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			err = json.NewEncoder(w).Encode(action)
+			if err != nil {
+				s.Log.Errorln(err)
+			}
+			return
+		}
+	}
+}
+
+//notices retrieves one page of the moderator actions aimed at the current user, newest first, for them to read and appeal.
+func (s *Server) notices() hr.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ hr.Params) {
+
+		ctx := r.Context()
+
+		currentUser, ok := ctx.Value(userContextKey).(uuid.UUID)
+		if !ok {
+			s.Log.Errorln("no userID in context")
+			http.Error(w, http.StatusText(500), http.StatusForbidden)
+			return
+		}
+
+		if uuid.Equal(currentUser, uuid.Nil) {
+			s.Log.Errorln("userID came in with nil value.")
+			http.Error(w, http.StatusText(400), http.StatusBadRequest)
+			return
+		}
+
+		before, limit, err := s.parsePagination(r)
+		if err != nil {
+			s.Log.Errorln(err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		actionsCh := make(chan []*models.ModAction)
+		errCh := make(chan error)
+
+		go func() {
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			actions, err := s.DB.ModActions(currentUser, before, limit+1)
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			actionsCh <- actions
+			return
+
+		}()
+
+		select {
+		case <-ctx.Done():
+			s.Log.Errorln(ctx.Err())
+			http.Error(w, "We could not process your request at this time. Please try again later.", http.StatusRequestTimeout)
+			return
+		case err := <-errCh:
+			s.Log.Errorln(err)
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		case actions := <-actionsCh:
+			var next *models.Cursor
+			if len(actions) > limit {
+				actions = actions[:limit]
+				last := actions[limit-1]
+				next = &models.Cursor{Created: last.Created, ID: last.ID}
+			}
+
+			err = s.writePage(w, r, actions, limit, next)
+			if err != nil {
+				s.Log.Errorln(err)
+				http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+				return
+			}
+			return
+		}
+	}
+}
+
+//appeal handles users appealing a moderator action aimed at them. Each action can be appealed once.
+func (s *Server) appeal() hr.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps hr.Params) {
+
+		ctx := r.Context()
+
+		currentUser, ok := ctx.Value(userContextKey).(uuid.UUID)
+		if !ok {
+			s.Log.Errorln("no userID in context")
+			http.Error(w, http.StatusText(500), http.StatusForbidden)
+			return
+		}
+
+		if uuid.Equal(currentUser, uuid.Nil) {
+			s.Log.Errorln("userID came in with nil value.")
+			http.Error(w, http.StatusText(400), http.StatusBadRequest)
+			return
+		}
+
+		actionID, err := uuid.FromString(ps.ByName("actionid"))
+		if err != nil {
+			s.Log.Errorln(err)
+			http.Error(w, http.StatusText(400), http.StatusBadRequest)
+			return
+		}
+
+		submission := models.Appeal{}
+		err = json.NewDecoder(r.Body).Decode(&submission)
+		if err != nil {
+			s.Log.Errorln(err)
+			http.Error(w, http.StatusText(400), http.StatusBadRequest)
+			return
+		}
+
+		if strings.TrimSpace(submission.Body) == "" || utf8.RuneCountInString(submission.Body) > maxAppealLength {
+			s.Log.Errorln("invalid body")
+			http.Error(w, "invalid body", http.StatusBadRequest)
+			return
+		}
+
+		action, err := s.DB.OneModAction(actionID)
+		switch {
+		case err == sql.ErrNoRows:
+			s.Log.Errorln(err)
+			http.Error(w, http.StatusText(404), http.StatusNotFound)
+			return
+		case err != nil:
+			s.Log.Errorln(err)
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		}
+
+		if !CanAppeal(currentUser, action) {
+			s.Log.Errorln("forbidden request")
+			http.Error(w, http.StatusText(403), http.StatusForbidden)
+			return
+		}
+
+		id, err := uuid.NewV4()
+		if err != nil {
+			s.Log.Errorln(err)
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		}
+
+		appeal := &models.Appeal{ID: id, Action: actionID, Subject: currentUser, Body: strings.TrimSpace(submission.Body), Created: time.Now().UTC()}
+
+		okCh := make(chan bool)
+		errCh := make(chan error)
+
+		go func() {
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			err := s.DB.CreateAppeal(appeal)
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			okCh <- true
+			return
+
+		}()
+
+		select {
+		case <-ctx.Done():
+			s.Log.Errorln(ctx.Err())
+			http.Error(w, "We could not process your request at this time. Please try again later.", http.StatusRequestTimeout)
+			return
+		case err := <-errCh:
+			s.Log.Errorln("error appealing:", err)
+			if err == models.ErrAppealExists {
+				http.Error(w, "action already appealed", http.StatusConflict)
+				return
+			}
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		case <-okCh:
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			err = json.NewEncoder(w).Encode(appeal)
+			if err != nil {
+				s.Log.Errorln(err)
+			}
+			return
+		}
+	}
+}
+
+//appeals retrieves one page of the appeals for moderators, newest first.
+//It lists open appeals unless the status query parameter asks for upheld, overturned, or all appeals.
+func (s *Server) appeals() hr.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ hr.Params) {
+
+		ctx := r.Context()
+
+		status := r.URL.Query().Get("status")
+		switch status {
+		case "":
+			status = models.AppealOpen
+		case "all":
+			status = ""
+		case models.AppealOpen, models.AppealUpheld, models.AppealOverturned:
+		default:
+			s.Log.Errorln("invalid appeal status")
+			http.Error(w, "invalid status: must be open, upheld, overturned, or all", http.StatusBadRequest)
+			return
+		}
+
+		before, limit, err := s.parsePagination(r)
+		if err != nil {
+			s.Log.Errorln(err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		appealsCh := make(chan []*models.Appeal)
+		errCh := make(chan error)
+
+		go func() {
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			appeals, err := s.DB.Appeals(status, before, limit+1)
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			appealsCh <- appeals
+			return
+
+		}()
+
+		select {
+		case <-ctx.Done():
+			s.Log.Errorln(ctx.Err())
+			http.Error(w, "We could not process your request at this time. Please try again later.", http.StatusRequestTimeout)
+			return
+		case err := <-errCh:
+			s.Log.Errorln(err)
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		case appeals := <-appealsCh:
+			var next *models.Cursor
+			if len(appeals) > limit {
+				appeals = appeals[:limit]
+				last := appeals[limit-1]
+				next = &models.Cursor{Created: last.Created, ID: last.ID}
+			}
+
+			err = s.writePage(w, r, appeals, limit, next)
+			if err != nil {
+				s.Log.Errorln(err)
+				http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+				return
+			}
+			return
+		}
+	}
+}
+
+//decideAppeal lets a moderator uphold or overturn the appeal in the url. Overturning reverses a hide or a suspension.
+//Moderators cannot decide appeals against their own actions.
+func (s *Server) decideAppeal() hr.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps hr.Params) {
+
+		ctx := r.Context()
+
+		currentUser, ok := ctx.Value(userContextKey).(uuid.UUID)
+		if !ok {
+			s.Log.Errorln("no userID in context")
+			http.Error(w, http.StatusText(500), http.StatusForbidden)
+			return
+		}
+
+		if uuid.Equal(currentUser, uuid.Nil) {
+			s.Log.Errorln("userID came in with nil value.")
+			http.Error(w, http.StatusText(400), http.StatusBadRequest)
+			return
+		}
+
+		appealID, err := uuid.FromString(ps.ByName("appealid"))
+		if err != nil {
+			s.Log.Errorln(err)
+			http.Error(w, http.StatusText(400), http.StatusBadRequest)
+			return
+		}
+
+		submission := models.Appeal{}
+		err = json.NewDecoder(r.Body).Decode(&submission)
+		if err != nil {
+			s.Log.Errorln(err)
+			http.Error(w, http.StatusText(400), http.StatusBadRequest)
+			return
+		}
+
+		if submission.Status != models.AppealUpheld && submission.Status != models.AppealOverturned {
+			s.Log.Errorln("invalid appeal decision")
+			http.Error(w, "invalid status: must be upheld or overturned", http.StatusBadRequest)
+			return
+		}
+
+		if utf8.RuneCountInString(submission.Note) > maxModNote {
+			s.Log.Errorln("invalid note")
+			http.Error(w, "invalid note", http.StatusBadRequest)
+			return
+		}
+
+		appeal, err := s.DB.OneAppeal(appealID)
+		if err == nil {
+			var action *models.ModAction
+			action, err = s.DB.OneModAction(appeal.Action)
+			if err == nil && !CanDecideAppeal(currentUser, action) {
+				s.Log.Errorln("moderator tried to decide an appeal of their own action")
+				http.Error(w, http.StatusText(403), http.StatusForbidden)
+				return
+			}
+		}
+		switch {
+		case err == sql.ErrNoRows:
+			s.Log.Errorln(err)
+			http.Error(w, http.StatusText(404), http.StatusNotFound)
+			return
+		case err != nil:
+			s.Log.Errorln(err)
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		}
+
+		decided := time.Now().UTC()
+		appeal.Status = submission.Status
+		appeal.Note = strings.TrimSpace(submission.Note)
+		appeal.DecidedBy = currentUser
+		appeal.Decided = &decided
+
+		okCh := make(chan bool)
+		errCh := make(chan error)
+
+		go func() {
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			err := s.DB.DecideAppeal(appeal)
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			okCh <- true
+			return
+
+		}()
+
+		select {
+		case <-ctx.Done():
+			s.Log.Errorln(ctx.Err())
+			http.Error(w, "We could not process your request at this time. Please try again later.", http.StatusRequestTimeout)
+			return
+		case err := <-errCh:
+			s.Log.Errorln("error deciding appeal:", err)
+			if err == models.ErrAppealDecided {
+				http.Error(w, "appeal already decided", http.StatusConflict)
+				return
+			}
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		case <-okCh:
+			s.Log.WithField("ip", s.clientIP(r)).Infoln("appeal decision:", appeal.DecidedBy, appeal.Status, appeal.ID)
+			s.audit(r, models.AuditModeration, appeal.DecidedBy, appeal.Subject, "appeal "+appeal.Status+" "+appeal.ID.String())
+			w.Header().Set("Content-Type", "application/json")
+			err = json.NewEncoder(w).Encode(appeal)
+			if err != nil {
+				s.Log.Errorln(err)
+				http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+				return
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/chiips/snippets/API/models"
+	hr "github.com/julienschmidt/httprouter"
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestModeration(t *testing.T) {
+
+	router := hr.New()
+	mdb := &mockDB{roles: map[uuid.UUID][]string{thirdUserID: {models.RoleModerator}}}
+	s := Server{DB: mdb, Router: router, Log: testLog}
+	s.Routes()
+
+	//send runs a request as the given user, anonymously for uuid.Nil, and returns the response
+	send := func(user uuid.UUID, method, url string, body interface{}) *httptest.ResponseRecorder {
+		b, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req, err := http.NewRequest(method, url, bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !uuid.Equal(user, uuid.Nil) {
+			authenticate(t, &s, req, user)
+		}
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	//decode reads a response body, failing the test if the status is not the one wanted
+	decode := func(rr *httptest.ResponseRecorder, want int, v interface{}) {
+		if rr.Code != want {
+			t.Fatalf("handler returned wrong status code:\ngot: %v\nwant: %v\n%v", rr.Code, want, rr.Body)
+		}
+		if err := json.NewDecoder(rr.Body).Decode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	//bad reports are refused
+	for _, tc := range []struct {
+		user   uuid.UUID
+		report *models.Report
+		want   int
+	}{
+		{otherUserID, &models.Report{Kind: models.KindPost, Target: postID1, Reason: "rude"}, http.StatusBadRequest},
+		{otherUserID, &models.Report{Kind: "page", Target: postID1, Reason: "spam"}, http.StatusBadRequest},
+		{otherUserID, &models.Report{Kind: models.KindComment, Target: postID1, Reason: "spam"}, http.StatusNotFound},
+		{userID, &models.Report{Kind: models.KindPost, Target: postID1, Reason: "spam"}, http.StatusBadRequest},
+	} {
+		if rr := send(tc.user, "POST", "/api/report", tc.report); rr.Code != tc.want {
+			t.Errorf("report %+v returned wrong status code:\ngot: %v\nwant: %v", tc.report, rr.Code, tc.want)
+		}
+	}
+
+	//a report on a post names its author as the subject
+	report := &models.Report{}
+	decode(send(otherUserID, "POST", "/api/report", &models.Report{Kind: models.KindPost, Target: postID1, Reason: "spam", Details: "ads"}), http.StatusCreated, report)
+	if report.Subject != userID || report.Status != models.ReportOpen {
+		t.Errorf("report stored wrong subject or status: %+v", report)
+	}
+
+	//only moderators see the queue
+	if rr := send(userID, "GET", "/api/moderation/reports", nil); rr.Code != http.StatusForbidden {
+		t.Errorf("queue returned wrong status code to a user:\ngot: %v\nwant: %v", rr.Code, http.StatusForbidden)
+	}
+
+	reports := []*models.Report{}
+	decode(send(thirdUserID, "GET", "/api/moderation/reports?kind=post&reason=spam", nil), http.StatusOK, &page{Data: &reports})
+	if len(reports) != 1 || reports[0].ID != report.ID {
+		t.Fatalf("queue returned wrong reports: %v", reports)
+	}
+
+	//actions must suit the content, and suspensions need an end
+	actionURL := fmt.Sprintf("/api/moderation/reports/%s/actions", report.ID)
+	for _, action := range []*models.ModAction{{Action: "ban"}, {Action: models.ActionSuspend}} {
+		if rr := send(thirdUserID, "POST", actionURL, action); rr.Code != http.StatusBadRequest {
+			t.Errorf("action %v returned wrong status code:\ngot: %v\nwant: %v", action.Action, rr.Code, http.StatusBadRequest)
+		}
+	}
+
+	//hiding the post closes the report and takes the post out of the feed
+	action := &models.ModAction{}
+	decode(send(thirdUserID, "POST", actionURL, &models.ModAction{Action: models.ActionHide, Note: "spam"}), http.StatusCreated, action)
+	if action.Subject != userID || action.Moderator != thirdUserID {
+		t.Errorf("action recorded wrong users: %+v", action)
+	}
+
+	if rr := send(thirdUserID, "POST", actionURL, &models.ModAction{Action: models.ActionDelete}); rr.Code != http.StatusConflict {
+		t.Errorf("action on a closed report returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusConflict)
+	}
+
+	visible := func() bool {
+		posts := []*models.Post{}
+		decode(send(uuid.Nil, "GET", "/api/posts", nil), http.StatusOK, &page{Data: &posts})
+		for _, post := range posts {
+			if post.ID == postID1 {
+				return true
+			}
+		}
+		return false
+	}
+
+	if visible() {
+		t.Error("hidden post listed in the feed")
+	}
+
+	postURL := fmt.Sprintf("/api/post/%s", postID1)
+	for user, want := range map[uuid.UUID]int{uuid.Nil: http.StatusNotFound, otherUserID: http.StatusNotFound, userID: http.StatusOK, thirdUserID: http.StatusOK} {
+		if rr := send(user, "GET", postURL, nil); rr.Code != want {
+			t.Errorf("hidden post returned wrong status code to %v:\ngot: %v\nwant: %v", user, rr.Code, want)
+		}
+	}
+
+	//nor are its revisions, diffs, and comments read, or comments added, by anyone else
+	mdb.revisions = map[uuid.UUID][]*models.Revision{postID1: {
+		{PostID: postID1, Number: 1, Title: "Post", Body: "Body"},
+		{PostID: postID1, Number: 2, Title: "Post", Body: "Edited body"},
+	}}
+	for _, path := range []string{"/revisions", "/diff?from=1&to=2", "/comments"} {
+		for user, want := range map[uuid.UUID]int{uuid.Nil: http.StatusNotFound, otherUserID: http.StatusNotFound, userID: http.StatusOK, thirdUserID: http.StatusOK} {
+			if rr := send(user, "GET", postURL+path, nil); rr.Code != want {
+				t.Errorf("%v of hidden post returned wrong status code to %v:\ngot: %v\nwant: %v", path, user, rr.Code, want)
+			}
+		}
+	}
+	if rr := send(otherUserID, "POST", postURL+"/comments", &models.Comment{Body: "Still here?"}); rr.Code != http.StatusNotFound {
+		t.Errorf("comment on hidden post returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusNotFound)
+	}
+
+	//the author sees the action and may appeal it once
+	notices := []*models.ModAction{}
+	decode(send(userID, "GET", "/api/moderation/notices", nil), http.StatusOK, &page{Data: &notices})
+	if len(notices) != 1 || notices[0].ID != action.ID {
+		t.Fatalf("notices returned wrong actions: %v", notices)
+	}
+
+	appealURL := fmt.Sprintf("/api/moderation/actions/%s/appeal", action.ID)
+	if rr := send(otherUserID, "POST", appealURL, &models.Appeal{Body: "Not mine to appeal"}); rr.Code != http.StatusForbidden {
+		t.Errorf("appeal by another user returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusForbidden)
+	}
+
+	appeal := &models.Appeal{}
+	decode(send(userID, "POST", appealURL, &models.Appeal{Body: "It was not spam"}), http.StatusCreated, appeal)
+
+	if rr := send(userID, "POST", appealURL, &models.Appeal{Body: "Again"}); rr.Code != http.StatusConflict {
+		t.Errorf("second appeal returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusConflict)
+	}
+
+	//the moderator who acted cannot decide the appeal; another moderator overturns it and the post returns
+	decideURL := fmt.Sprintf("/api/moderation/appeals/%s", appeal.ID)
+	if rr := send(thirdUserID, "PUT", decideURL, &models.Appeal{Status: models.AppealOverturned}); rr.Code != http.StatusForbidden {
+		t.Errorf("own appeal decision returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusForbidden)
+	}
+
+	mdb.roles[otherUserID] = []string{models.RoleModerator}
+
+	appeals := []*models.Appeal{}
+	decode(send(otherUserID, "GET", "/api/moderation/appeals", nil), http.StatusOK, &page{Data: &appeals})
+	if len(appeals) != 1 || appeals[0].ID != appeal.ID {
+		t.Fatalf("appeals returned wrong appeals: %v", appeals)
+	}
+
+	decided := &models.Appeal{}
+	decode(send(otherUserID, "PUT", decideURL, &models.Appeal{Status: models.AppealOverturned, Note: "Not spam"}), http.StatusOK, decided)
+	if decided.Status != models.AppealOverturned || decided.DecidedBy != otherUserID || decided.Decided == nil {
+		t.Errorf("appeal decided wrongly: %+v", decided)
+	}
+
+	if rr := send(otherUserID, "PUT", decideURL, &models.Appeal{Status: models.AppealUpheld}); rr.Code != http.StatusConflict {
+		t.Errorf("second decision returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusConflict)
+	}
+
+	if !visible() {
+		t.Error("post still hidden after its appeal was overturned")
+	}
+
+	//a user report can lead to a suspension
+	decode(send(otherUserID, "POST", "/api/report", &models.Report{Kind: models.KindUser, Target: userID, Reason: "harassment"}), http.StatusCreated, report)
+
+	until := time.Now().Add(24 * time.Hour)
+	decode(send(thirdUserID, "POST", fmt.Sprintf("/api/moderation/reports/%s/actions", report.ID), &models.ModAction{Action: models.ActionSuspend, Until: &until}), http.StatusCreated, action)
+	if action.Until == nil || !action.Until.Equal(until) || action.Subject != userID {
+		t.Errorf("suspension recorded wrongly: %+v", action)
+	}
+}
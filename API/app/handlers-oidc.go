@@ -0,0 +1,393 @@
+package app
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/chiips/snippets/API/models"
+	hr "github.com/julienschmidt/httprouter"
+	uuid "github.com/satori/go.uuid"
+)
+
+//oidcStart is the response starting an external login or link: the provider's URL to send the user to
+type oidcStart struct {
+	URL string `json:"url"`
+}
+
+//oidcProviders lists the names of the providers users may log in with, for the app to offer
+func (s *Server) oidcProviders() hr.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ hr.Params) {
+
+		names := []string{}
+		for name := range s.Providers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		w.Header().Set("Content-Type", "application/json")
+		err := json.NewEncoder(w).Encode(names)
+		if err != nil {
+			s.Log.Errorln(err)
+		}
+	}
+}
+
+//oidcLogin starts logging in with the provider, sending the URL of the provider to send the user to.
+//The provider sends them back to oidcCallback.
+func (s *Server) oidcLogin() hr.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps hr.Params) {
+		s.startOIDCFlow(w, ps.ByName("provider"), flowLogin, uuid.Nil)
+	}
+}
+
+//oidcLink starts linking an account at the provider to the current user, sending the URL of the provider to send them to.
+//The provider sends them back to oidcCallback.
+func (s *Server) oidcLink() hr.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps hr.Params) {
+
+		currentUser, ok := r.Context().Value(userContextKey).(uuid.UUID)
+		if !ok {
+			s.Log.Errorln("no userID in context")
+			http.Error(w, http.StatusText(500), http.StatusForbidden)
+			return
+		}
+
+		s.startOIDCFlow(w, ps.ByName("provider"), flowLink, currentUser)
+	}
+}
+
+//startOIDCFlow starts the flow with the provider for oidcLogin and oidcLink
+func (s *Server) startOIDCFlow(w http.ResponseWriter, name, flow string, user uuid.UUID) {
+
+	p, ok := s.Providers[name]
+	if !ok {
+		s.Log.Errorln("no provider:", name)
+		http.Error(w, msgUnknownProvider, http.StatusNotFound)
+		return
+	}
+
+	authURL, err := s.startOIDC(w, p, flow, user)
+	if err != nil {
+		s.Log.Errorln("error starting external login:", err)
+		http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	err = json.NewEncoder(w).Encode(&oidcStart{URL: authURL})
+	if err != nil {
+		s.Log.Errorln(err)
+	}
+}
+
+//oidcCallback finishes a flow the provider sent the user back from with a code and the state, and sends them on to the app.
+//Logging in, the user whose linked account the provider's ID token names gets the JWT in cookies as login does,
+//or a pending token to finish logging in with loginMFA if they have two-factor authentication. No account is ever created or
+//matched by email here. Linking, the account is linked to the user who started the flow.
+//Refused flows send the user back with the message in the error parameter.
+func (s *Server) oidcCallback() hr.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps hr.Params) {
+
+		ctx := r.Context()
+
+		name := ps.ByName("provider")
+		query := r.URL.Query()
+		ip := s.clientIP(r)
+
+		cookie, err := r.Cookie("token-oidc")
+		if err != nil {
+			s.Log.Errorln("no external login in progress from:", ip)
+			http.Redirect(w, r, oidcPage(flowLogin, url.Values{"error": {msgOIDCExpired}}), http.StatusSeeOther)
+			return
+		}
+		setOIDCCookie(w, "")
+
+		p, ok := s.Providers[name]
+		if !ok {
+			s.Log.Errorln("no provider:", name)
+			http.Redirect(w, r, oidcPage(flowLogin, url.Values{"error": {msgUnknownProvider}}), http.StatusSeeOther)
+			return
+		}
+
+		userCh := make(chan *models.User)
+		mfaCh := make(chan string)
+		linkedCh := make(chan *models.Identity)
+		refusedCh := make(chan oidcRefusal)
+		errCh := make(chan error)
+
+		go func() {
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			f, started, err := s.spendOIDC(p, cookie.Value, query.Get("state"))
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			if !started {
+				if ctx.Err() != nil {
+					return
+				}
+				refusedCh <- oidcRefusal{flowLogin, msgOIDCExpired}
+				return
+			}
+
+			//the user declined at the provider, or it refused the request
+			if query.Get("error") != "" {
+				s.Log.Errorln("provider refused external login:", name, query.Get("error"))
+				if ctx.Err() != nil {
+					return
+				}
+				refusedCh <- oidcRefusal{f.Flow, msgOIDCCancelled}
+				return
+			}
+
+			claims, err := p.Exchange(ctx, query.Get("code"), f.Verifier, f.Nonce)
+			if err != nil {
+				s.Log.Errorln("invalid external login:", name, err)
+				if ctx.Err() != nil {
+					return
+				}
+				refusedCh <- oidcRefusal{f.Flow, msgOIDCFailed}
+				return
+			}
+
+			if f.Flow == flowLink {
+
+				identity := &models.Identity{Provider: name, Subject: claims.Subject, User: f.User, Email: claims.Email, Created: time.Now().UTC()}
+
+				err := s.DB.LinkIdentity(identity)
+
+				if ctx.Err() != nil {
+					return
+				}
+
+				if err == models.ErrIdentityLinked {
+					refusedCh <- oidcRefusal{flowLink, msgIdentityLinked}
+					return
+				}
+
+				if err != nil {
+					errCh <- err
+					return
+				}
+
+				linkedCh <- identity
+				return
+			}
+
+			identity, err := s.DB.Identity(name, claims.Subject)
+			if err == sql.ErrNoRows {
+				if ctx.Err() != nil {
+					return
+				}
+				refusedCh <- oidcRefusal{flowLogin, msgIdentityUnknown}
+				return
+			}
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			//accounts with two-factor authentication get a pending token instead of the JWT
+			enabled, err := s.mfaEnabled(identity.User)
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			if enabled {
+				token, err := s.startMFA(identity.User)
+				if err != nil {
+					errCh <- err
+					return
+				}
+				if ctx.Err() != nil {
+					return
+				}
+				mfaCh <- token
+				return
+			}
+
+			roles, err := s.DB.UserRoles(identity.User)
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			userCh <- &models.User{ID: identity.User, Roles: roles}
+			return
+
+		}()
+
+		select {
+		case <-ctx.Done():
+			s.Log.Errorln(ctx.Err())
+			http.Error(w, "We could not process your request at this time. Please try again later.", http.StatusRequestTimeout)
+			return
+		case err := <-errCh:
+			s.Log.Errorln("error finishing external login:", err)
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		case refused := <-refusedCh:
+			s.Log.Errorln("refused external login from:", ip, name, refused.Message)
+			if refused.Flow == flowLogin {
+				s.audit(r, models.AuditLoginFailed, uuid.Nil, uuid.Nil, "oidc:"+name)
+			}
+			http.Redirect(w, r, oidcPage(refused.Flow, url.Values{"error": {refused.Message}}), http.StatusSeeOther)
+			return
+		case identity := <-linkedCh:
+			s.Log.WithField("ip", ip).Infoln("identity linked:", identity.User, name)
+			http.Redirect(w, r, oidcPage(flowLink, url.Values{"linked": {name}}), http.StatusSeeOther)
+			return
+		case token := <-mfaCh:
+			setMFACookie(w, token)
+			http.Redirect(w, r, os.Getenv("app_url")+"/login/mfa", http.StatusSeeOther)
+			return
+		case user := <-userCh:
+			err := s.startSession(w, r, user.ID, user.Roles)
+			if err != nil {
+				s.Log.Errorln(err)
+				http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+				return
+			}
+			s.Log.WithField("ip", ip).Infoln("external login:", user.ID, name)
+			s.audit(r, models.AuditLogin, user.ID, user.ID, "oidc:"+name)
+			http.Redirect(w, r, os.Getenv("app_url")+"/", http.StatusSeeOther)
+			return
+		}
+	}
+}
+
+//identities lists the accounts at providers the current user linked, oldest first
+func (s *Server) identities() hr.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ hr.Params) {
+
+		ctx := r.Context()
+
+		currentUser, ok := ctx.Value(userContextKey).(uuid.UUID)
+		if !ok {
+			s.Log.Errorln("no userID in context")
+			http.Error(w, http.StatusText(500), http.StatusForbidden)
+			return
+		}
+
+		identitiesCh := make(chan []*models.Identity)
+		errCh := make(chan error)
+
+		go func() {
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			identities, err := s.DB.UserIdentities(currentUser)
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			identitiesCh <- identities
+			return
+
+		}()
+
+		select {
+		case <-ctx.Done():
+			s.Log.Errorln(ctx.Err())
+			http.Error(w, "We could not process your request at this time. Please try again later.", http.StatusRequestTimeout)
+			return
+		case err := <-errCh:
+			s.Log.Errorln("error listing identities:", err)
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		case identities := <-identitiesCh:
+			w.Header().Set("Content-Type", "application/json")
+			err := json.NewEncoder(w).Encode(identities)
+			if err != nil {
+				s.Log.Errorln(err)
+			}
+			return
+		}
+	}
+}
+
+//unlinkIdentity removes the account at the provider the current user linked, so it no longer logs in
+func (s *Server) unlinkIdentity() hr.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps hr.Params) {
+
+		ctx := r.Context()
+
+		currentUser, ok := ctx.Value(userContextKey).(uuid.UUID)
+		if !ok {
+			s.Log.Errorln("no userID in context")
+			http.Error(w, http.StatusText(500), http.StatusForbidden)
+			return
+		}
+
+		name := ps.ByName("provider")
+
+		okCh := make(chan bool)
+		errCh := make(chan error)
+
+		go func() {
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			err := s.DB.UnlinkIdentity(currentUser, name)
+			if err != nil && err != sql.ErrNoRows {
+				errCh <- err
+				return
+			}
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			okCh <- err == nil
+			return
+
+		}()
+
+		select {
+		case <-ctx.Done():
+			s.Log.Errorln(ctx.Err())
+			http.Error(w, "We could not process your request at this time. Please try again later.", http.StatusRequestTimeout)
+			return
+		case err := <-errCh:
+			s.Log.Errorln("error unlinking identity:", err)
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		case unlinked := <-okCh:
+			if !unlinked {
+				s.Log.Errorln("no identity to unlink:", currentUser, name)
+				http.Error(w, http.StatusText(404), http.StatusNotFound)
+				return
+			}
+			s.Log.WithField("ip", s.clientIP(r)).Infoln("identity unlinked:", currentUser, name)
+			fmt.Fprint(w, "login unlinked.")
+			return
+		}
+	}
+}
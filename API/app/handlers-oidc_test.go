@@ -0,0 +1,209 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/chiips/snippets/API/models"
+	"github.com/chiips/snippets/API/oidc"
+	"github.com/chiips/snippets/API/oidc/oidctest"
+	hr "github.com/julienschmidt/httprouter"
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestOIDC(t *testing.T) {
+
+	os.Setenv("app_url", "https://example.com")
+
+	mock := oidctest.NewServer("client", "secret")
+	defer mock.Close()
+
+	p, err := oidc.NewProvider(context.Background(), "mock", mock.URL, "client", "secret", "https://example.com/api/oidc/mock/callback")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := hr.New()
+	mdb := &mockDB{}
+	s := Server{DB: mdb, Router: router, Log: testLog, State: NewMemoryStore(), Providers: map[string]*oidc.Provider{"mock": p}}
+	s.Routes()
+
+	//send sends a request to the url as the user unless nil, with any cookies given
+	send := func(method, url string, user uuid.UUID, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !uuid.Equal(user, uuid.Nil) {
+			authenticate(t, &s, req, user)
+		}
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	//cookie returns the response's cookie with the name, or nil
+	cookie := func(rr *httptest.ResponseRecorder, name string) *http.Cookie {
+		for _, c := range rr.Result().Cookies() {
+			if c.Name == name && c.MaxAge >= 0 {
+				return c
+			}
+		}
+		return nil
+	}
+
+	//start starts the flow at the path as the user, returning the query the provider sends them back with and the flow's cookie
+	start := func(path string, user uuid.UUID) (string, *http.Cookie) {
+		rr := send("POST", path, user)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%v returned wrong status code:\ngot: %v\nwant: %v", path, rr.Code, http.StatusOK)
+		}
+		started := oidcStart{}
+		if err := json.NewDecoder(rr.Body).Decode(&started); err != nil {
+			t.Fatal(err)
+		}
+		back, err := mock.Authorize(started.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := cookie(rr, "token-oidc")
+		if c == nil || !c.HttpOnly || c.SameSite != http.SameSiteLaxMode {
+			t.Fatalf("flow cookie wrong: %+v", c)
+		}
+		return back.RawQuery, c
+	}
+
+	//redirect returns where a callback sent the user
+	redirect := func(rr *httptest.ResponseRecorder) string {
+		if rr.Code != http.StatusSeeOther {
+			t.Fatalf("callback returned wrong status code:\ngot: %v\nwant: %v %q", rr.Code, http.StatusSeeOther, rr.Body)
+		}
+		return rr.Header().Get("Location")
+	}
+
+	//finish sends the user back to the callback from a flow started at the path, returning where it sent them
+	finish := func(path string, user uuid.UUID) (string, *httptest.ResponseRecorder) {
+		query, c := start(path, user)
+		rr := send("GET", "/api/oidc/mock/callback?"+query, uuid.Nil, c)
+		return redirect(rr), rr
+	}
+
+	refused := func(flow, msg string) string {
+		return oidcPage(flow, url.Values{"error": {msg}})
+	}
+
+	rr := send("GET", "/api/oidc", uuid.Nil)
+	if rr.Body.String() != "[\"mock\"]\n" {
+		t.Errorf("provider list wrong: %q", rr.Body)
+	}
+
+	//accounts at providers log in no one until linked, even with a user's email
+	mock.Email = "user-1@example.com"
+	if to, _ := finish("/api/oidc/mock/login", uuid.Nil); to != refused(flowLogin, msgIdentityUnknown) {
+		t.Errorf("login with unlinked account sent to wrong page:\ngot: %v\nwant: %v", to, refused(flowLogin, msgIdentityUnknown))
+	}
+
+	if to, _ := finish("/api/oidc/mock/link", userID); to != "https://example.com/settings/identities?linked=mock" {
+		t.Fatalf("link sent to wrong page: %v", to)
+	}
+	if len(mdb.identities) != 1 || !uuid.Equal(mdb.identities[0].User, userID) || mdb.identities[0].Subject != "subject-1" {
+		t.Fatalf("identity linked wrongly: %+v", mdb.identities)
+	}
+
+	rr = send("GET", "/api/identities", userID)
+	list := []*models.Identity{}
+	if err := json.NewDecoder(rr.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Provider != "mock" || list[0].Email != "user-1@example.com" {
+		t.Errorf("identity list wrong: %+v", list)
+	}
+
+	//the linked account logs in, with a working JWT
+	to, rr := finish("/api/oidc/mock/login", uuid.Nil)
+	if to != "https://example.com/" {
+		t.Fatalf("login sent to wrong page: %v", to)
+	}
+	timeline := send("GET", "/api/timeline", uuid.Nil, rr.Result().Cookies()...)
+	if timeline.Code != http.StatusOK {
+		t.Errorf("JWT from external login refused:\ngot: %v\nwant: %v", timeline.Code, http.StatusOK)
+	}
+
+	//each flow finishes once, in the browser that started it, with the state it was started with
+	query, c := start("/api/oidc/mock/login", uuid.Nil)
+	if to := redirect(send("GET", "/api/oidc/mock/callback?"+query, uuid.Nil)); to != refused(flowLogin, msgOIDCExpired) {
+		t.Errorf("callback without cookie sent to wrong page: %v", to)
+	}
+	_, other := start("/api/oidc/mock/login", uuid.Nil)
+	if to := redirect(send("GET", "/api/oidc/mock/callback?"+query, uuid.Nil, other)); to != refused(flowLogin, msgOIDCExpired) {
+		t.Errorf("callback with another flow's cookie sent to wrong page: %v", to)
+	}
+	if to := redirect(send("GET", "/api/oidc/mock/callback?"+query, uuid.Nil, c)); to != "https://example.com/" {
+		t.Errorf("callback sent to wrong page: %v", to)
+	}
+	if to := redirect(send("GET", "/api/oidc/mock/callback?"+query, uuid.Nil, c)); to != refused(flowLogin, msgOIDCExpired) {
+		t.Errorf("replayed callback sent to wrong page: %v", to)
+	}
+
+	//users who decline at the provider are sent back, once the state is checked
+	_, c = start("/api/oidc/mock/link", userID)
+	state, _ := url.ParseQuery(query)
+	declined := url.Values{"error": {"access_denied"}, "state": {state.Get("state")}}
+	if to := redirect(send("GET", "/api/oidc/mock/callback?"+declined.Encode(), uuid.Nil, c)); to != refused(flowLogin, msgOIDCExpired) {
+		t.Errorf("callback with wrong state sent to wrong page: %v", to)
+	}
+	query, c = start("/api/oidc/mock/link", userID)
+	state, _ = url.ParseQuery(query)
+	declined.Set("state", state.Get("state"))
+	if to := redirect(send("GET", "/api/oidc/mock/callback?"+declined.Encode(), uuid.Nil, c)); to != refused(flowLink, msgOIDCCancelled) {
+		t.Errorf("declined link sent to wrong page: %v", to)
+	}
+
+	//ID tokens for another login are refused
+	mock.Claims = map[string]interface{}{"nonce": "other"}
+	if to, _ := finish("/api/oidc/mock/login", uuid.Nil); to != refused(flowLogin, msgOIDCFailed) {
+		t.Errorf("login with wrong nonce sent to wrong page: %v", to)
+	}
+	mock.Claims = nil
+
+	//an account links to one user, and a user links one account per provider
+	if to, _ := finish("/api/oidc/mock/link", otherUserID); to != refused(flowLink, msgIdentityLinked) {
+		t.Errorf("linking another user's account sent to wrong page: %v", to)
+	}
+	mock.Subject = "subject-2"
+	if to, _ := finish("/api/oidc/mock/link", userID); to != refused(flowLink, msgIdentityLinked) {
+		t.Errorf("linking a second account at a provider sent to wrong page: %v", to)
+	}
+	mock.Subject = "subject-1"
+
+	//users with two-factor authentication finish logging in with their second factor
+	mdb.totp = map[uuid.UUID]*models.TOTP{userID: {User: userID, Confirmed: true}}
+	to, rr = finish("/api/oidc/mock/login", uuid.Nil)
+	if to != "https://example.com/login/mfa" || cookie(rr, "token-mfa") == nil || cookie(rr, "token-s") != nil {
+		t.Errorf("login with two-factor authentication sent to wrong page: %v %v", to, rr.Result().Cookies())
+	}
+	mdb.totp = nil
+
+	//users unlink their own accounts only
+	if rr := send("DELETE", "/api/identities/mock", otherUserID); rr.Code != http.StatusNotFound {
+		t.Errorf("unlinking another user's account returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusNotFound)
+	}
+	if rr := send("DELETE", "/api/identities/mock", userID); rr.Code != http.StatusOK {
+		t.Errorf("unlinking returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusOK)
+	}
+	if to, _ := finish("/api/oidc/mock/login", uuid.Nil); to != refused(flowLogin, msgIdentityUnknown) {
+		t.Errorf("login with unlinked account sent to wrong page: %v", to)
+	}
+
+	if rr := send("POST", "/api/oidc/other/login", uuid.Nil); rr.Code != http.StatusNotFound {
+		t.Errorf("login with unknown provider returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusNotFound)
+	}
+}
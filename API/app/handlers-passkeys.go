@@ -0,0 +1,565 @@
+package app
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/chiips/snippets/API/models"
+	"github.com/chiips/snippets/API/webauthn"
+	hr "github.com/julienschmidt/httprouter"
+	uuid "github.com/satori/go.uuid"
+)
+
+//passkeyRegistration is the body of a request to register a passkey: the name to show it by and the new credential
+type passkeyRegistration struct {
+	Name       string                `json:"name"`
+	Credential webauthn.Registration `json:"credential"`
+}
+
+//passkeyOptions starts registering a passkey for the current user, sending the options for navigator.credentials.create.
+//The user's other passkeys are excluded, so the browser does not register a second one on the same authenticator.
+func (s *Server) passkeyOptions() hr.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ hr.Params) {
+
+		ctx := r.Context()
+
+		currentUser, ok := ctx.Value(userContextKey).(uuid.UUID)
+		if !ok {
+			s.Log.Errorln("no userID in context")
+			http.Error(w, http.StatusText(500), http.StatusForbidden)
+			return
+		}
+
+		if s.WebAuthn == nil {
+			s.Log.Errorln("no relying party for passkeys")
+			http.Error(w, msgPasskeysDisabled, http.StatusNotFound)
+			return
+		}
+
+		optionsCh := make(chan *webauthn.CreationOptions)
+		fullCh := make(chan bool)
+		errCh := make(chan error)
+
+		go func() {
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			profile, err := s.DB.UserProfile(currentUser, currentUser)
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			passkeys, err := s.DB.UserPasskeys(currentUser)
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			if len(passkeys) >= maxPasskeys {
+				if ctx.Err() != nil {
+					return
+				}
+				fullCh <- true
+				return
+			}
+
+			exclude := [][]byte{}
+			for _, p := range passkeys {
+				c, err := passkeyCredential(p)
+				if err != nil {
+					errCh <- err
+					return
+				}
+				exclude = append(exclude, c.ID)
+			}
+
+			challenge, err := s.newPasskeyChallenge(ceremonyRegister, currentUser)
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			optionsCh <- s.WebAuthn.CreationOptions(challenge, currentUser.Bytes(), profile.Name, exclude)
+			return
+
+		}()
+
+		select {
+		case <-ctx.Done():
+			s.Log.Errorln(ctx.Err())
+			http.Error(w, "We could not process your request at this time. Please try again later.", http.StatusRequestTimeout)
+			return
+		case err := <-errCh:
+			s.Log.Errorln("error starting passkey registration:", err)
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		case <-fullCh:
+			s.Log.Errorln("too many passkeys:", currentUser)
+			http.Error(w, fmt.Sprintf("you can register at most %d passkeys. Please remove one first.", maxPasskeys), http.StatusConflict)
+			return
+		case options := <-optionsCh:
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Cache-Control", "no-store")
+			err := json.NewEncoder(w).Encode(options)
+			if err != nil {
+				s.Log.Errorln(err)
+			}
+			return
+		}
+	}
+}
+
+//registerPasskey finishes registering a passkey for the current user with the credential their browser created from passkeyOptions.
+func (s *Server) registerPasskey() hr.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ hr.Params) {
+
+		ctx := r.Context()
+
+		currentUser, ok := ctx.Value(userContextKey).(uuid.UUID)
+		if !ok {
+			s.Log.Errorln("no userID in context")
+			http.Error(w, http.StatusText(500), http.StatusForbidden)
+			return
+		}
+
+		if s.WebAuthn == nil {
+			s.Log.Errorln("no relying party for passkeys")
+			http.Error(w, msgPasskeysDisabled, http.StatusNotFound)
+			return
+		}
+
+		submission := passkeyRegistration{}
+		err := json.NewDecoder(r.Body).Decode(&submission)
+		if err != nil {
+			s.Log.Errorln(err)
+			http.Error(w, http.StatusText(400), http.StatusBadRequest)
+			return
+		}
+
+		name, ok := passkeyName(submission.Name)
+		if !ok {
+			s.Log.Errorln("bad form request")
+			http.Error(w, "invalid passkey name", http.StatusBadRequest)
+			return
+		}
+
+		passkeyCh := make(chan *models.Passkey)
+		//the messages refusing the registration: a bad request, or a conflict with the passkeys already registered
+		refusedCh := make(chan string)
+		conflictCh := make(chan string)
+		errCh := make(chan error)
+
+		go func() {
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			challenge, issued, err := s.spendPasskeyChallenge(ceremonyRegister, currentUser, submission.Credential.Response.ClientDataJSON)
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			if !issued {
+				if ctx.Err() != nil {
+					return
+				}
+				refusedCh <- msgPasskeyExpired
+				return
+			}
+
+			credential, err := s.WebAuthn.Register(&submission.Credential, challenge)
+			if err != nil {
+				s.Log.Errorln("invalid passkey registration:", err)
+				if ctx.Err() != nil {
+					return
+				}
+				refusedCh <- "invalid passkey"
+				return
+			}
+
+			passkeys, err := s.DB.UserPasskeys(currentUser)
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			if len(passkeys) >= maxPasskeys {
+				if ctx.Err() != nil {
+					return
+				}
+				conflictCh <- fmt.Sprintf("you can register at most %d passkeys. Please remove one first.", maxPasskeys)
+				return
+			}
+
+			passkey := &models.Passkey{
+				ID:        webauthn.EncodeID(credential.ID),
+				User:      currentUser,
+				Name:      name,
+				PublicKey: credential.PublicKey,
+				SignCount: int64(credential.SignCount),
+				Created:   time.Now().UTC(),
+			}
+
+			err = s.DB.CreatePasskey(passkey)
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err == models.ErrPasskeyExists {
+				conflictCh <- "passkey already registered"
+				return
+			}
+
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			passkeyCh <- passkey
+			return
+
+		}()
+
+		select {
+		case <-ctx.Done():
+			s.Log.Errorln(ctx.Err())
+			http.Error(w, "We could not process your request at this time. Please try again later.", http.StatusRequestTimeout)
+			return
+		case err := <-errCh:
+			s.Log.Errorln("error registering passkey:", err)
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		case refused := <-refusedCh:
+			s.Log.Errorln("refused registering passkey:", refused)
+			http.Error(w, refused, http.StatusBadRequest)
+			return
+		case conflict := <-conflictCh:
+			s.Log.Errorln("refused registering passkey:", conflict)
+			http.Error(w, conflict, http.StatusConflict)
+			return
+		case passkey := <-passkeyCh:
+			s.Log.WithField("ip", s.clientIP(r)).Infoln("passkey registered:", currentUser, passkey.ID)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			err = json.NewEncoder(w).Encode(passkey)
+			if err != nil {
+				s.Log.Errorln(err)
+			}
+			return
+		}
+	}
+}
+
+//passkeys lists the current user's passkeys, oldest first, with those flagged as possibly cloned marked
+func (s *Server) passkeys() hr.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ hr.Params) {
+
+		ctx := r.Context()
+
+		currentUser, ok := ctx.Value(userContextKey).(uuid.UUID)
+		if !ok {
+			s.Log.Errorln("no userID in context")
+			http.Error(w, http.StatusText(500), http.StatusForbidden)
+			return
+		}
+
+		passkeysCh := make(chan []*models.Passkey)
+		errCh := make(chan error)
+
+		go func() {
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			passkeys, err := s.DB.UserPasskeys(currentUser)
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			passkeysCh <- passkeys
+			return
+
+		}()
+
+		select {
+		case <-ctx.Done():
+			s.Log.Errorln(ctx.Err())
+			http.Error(w, "We could not process your request at this time. Please try again later.", http.StatusRequestTimeout)
+			return
+		case err := <-errCh:
+			s.Log.Errorln("error listing passkeys:", err)
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		case passkeys := <-passkeysCh:
+			w.Header().Set("Content-Type", "application/json")
+			err := json.NewEncoder(w).Encode(passkeys)
+			if err != nil {
+				s.Log.Errorln(err)
+			}
+			return
+		}
+	}
+}
+
+//deletePasskey removes one of the current user's passkeys, so it no longer logs in
+func (s *Server) deletePasskey() hr.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps hr.Params) {
+
+		ctx := r.Context()
+
+		currentUser, ok := ctx.Value(userContextKey).(uuid.UUID)
+		if !ok {
+			s.Log.Errorln("no userID in context")
+			http.Error(w, http.StatusText(500), http.StatusForbidden)
+			return
+		}
+
+		id := ps.ByName("passkeyid")
+
+		okCh := make(chan bool)
+		errCh := make(chan error)
+
+		go func() {
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			err := s.DB.DeletePasskey(currentUser, id)
+			if err != nil && err != sql.ErrNoRows {
+				errCh <- err
+				return
+			}
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			okCh <- err == nil
+			return
+
+		}()
+
+		select {
+		case <-ctx.Done():
+			s.Log.Errorln(ctx.Err())
+			http.Error(w, "We could not process your request at this time. Please try again later.", http.StatusRequestTimeout)
+			return
+		case err := <-errCh:
+			s.Log.Errorln("error deleting passkey:", err)
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		case deleted := <-okCh:
+			if !deleted {
+				s.Log.Errorln("no passkey to delete:", id)
+				http.Error(w, http.StatusText(404), http.StatusNotFound)
+				return
+			}
+			s.Log.WithField("ip", s.clientIP(r)).Infoln("passkey deleted:", currentUser, id)
+			fmt.Fprint(w, "passkey deleted.")
+			return
+		}
+	}
+}
+
+//passkeyLoginOptions starts a passkey login, sending the options for navigator.credentials.get.
+//No credentials are named, so the browser offers the user the passkeys they have for the site and no email is needed.
+func (s *Server) passkeyLoginOptions() hr.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ hr.Params) {
+
+		if s.WebAuthn == nil {
+			s.Log.Errorln("no relying party for passkeys")
+			http.Error(w, msgPasskeysDisabled, http.StatusNotFound)
+			return
+		}
+
+		challenge, err := s.newPasskeyChallenge(ceremonyLogin, uuid.Nil)
+		if err != nil {
+			s.Log.Errorln("error starting passkey login:", err)
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		err = json.NewEncoder(w).Encode(s.WebAuthn.RequestOptions(challenge, nil))
+		if err != nil {
+			s.Log.Errorln(err)
+		}
+	}
+}
+
+//loginPasskey logs a user in with the assertion their browser made from passkeyLoginOptions, sending the JWT in cookies as login does.
+//An assertion whose signature counter is no higher than the stored one means the passkey may have been cloned: it is flagged,
+//and neither copy logs in again.
+func (s *Server) loginPasskey() hr.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ hr.Params) {
+
+		ctx := r.Context()
+
+		if s.WebAuthn == nil {
+			s.Log.Errorln("no relying party for passkeys")
+			http.Error(w, msgPasskeysDisabled, http.StatusNotFound)
+			return
+		}
+
+		assertion := webauthn.Assertion{}
+		err := json.NewDecoder(r.Body).Decode(&assertion)
+		if err != nil {
+			s.Log.Errorln(err)
+			http.Error(w, http.StatusText(400), http.StatusBadRequest)
+			return
+		}
+
+		ip := s.clientIP(r)
+
+		userCh := make(chan *models.User)
+		//the message refusing the login
+		refusedCh := make(chan string)
+		errCh := make(chan error)
+
+		go func() {
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			refused, user, err := s.assertPasskey(&assertion, ip)
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			if refused != "" {
+				refusedCh <- refused
+				return
+			}
+
+			userCh <- user
+			return
+
+		}()
+
+		select {
+		case <-ctx.Done():
+			s.Log.Errorln(ctx.Err())
+			http.Error(w, "We could not process your request at this time. Please try again later.", http.StatusRequestTimeout)
+			return
+		case err := <-errCh:
+			s.Log.Errorln("error logging in with passkey:", err)
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		case refused := <-refusedCh:
+			s.Log.Errorln("refused passkey login from:", ip, refused)
+			s.audit(r, models.AuditLoginFailed, uuid.Nil, uuid.Nil, "passkey")
+			http.Error(w, refused, http.StatusUnauthorized)
+			return
+		case user := <-userCh:
+			err := s.startSession(w, r, user.ID, user.Roles)
+			if err != nil {
+				s.Log.Errorln(err)
+				http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+				return
+			}
+			s.audit(r, models.AuditLogin, user.ID, user.ID, "passkey")
+			fmt.Fprint(w, "logged in!")
+			return
+		}
+
+	}
+}
+
+//assertPasskey checks a passkey login's assertion from the IP and records the passkey's use, returning the user logged in,
+//or the message refusing the login.
+func (s *Server) assertPasskey(assertion *webauthn.Assertion, ip string) (string, *models.User, error) {
+
+	challenge, issued, err := s.spendPasskeyChallenge(ceremonyLogin, uuid.Nil, assertion.Response.ClientDataJSON)
+	if err != nil || !issued {
+		return msgPasskeyExpired, nil, err
+	}
+
+	id, err := assertion.CredentialID()
+	if err != nil {
+		return msgInvalidPasskey, nil, nil
+	}
+
+	passkey, err := s.DB.Passkey(webauthn.EncodeID(id))
+	if err == sql.ErrNoRows {
+		return msgInvalidPasskey, nil, nil
+	}
+	if err != nil {
+		return "", nil, err
+	}
+
+	//discoverable credentials name the user they were registered for
+	handle, err := assertion.UserHandle()
+	if err != nil || (handle != nil && !uuid.Equal(uuid.FromBytesOrNil(handle), passkey.User)) {
+		return msgInvalidPasskey, nil, nil
+	}
+
+	if passkey.Cloned {
+		return msgClonedPasskey, nil, nil
+	}
+
+	credential, err := passkeyCredential(passkey)
+	if err != nil {
+		return "", nil, err
+	}
+
+	count, err := s.WebAuthn.Verify(assertion, challenge, credential)
+	if err != nil && err != webauthn.ErrCloned {
+		s.Log.Errorln("invalid passkey assertion:", err)
+		return msgInvalidPasskey, nil, nil
+	}
+
+	//a verified signature with a stale counter, or one another login with the same counter got to first
+	accepted := false
+	if err == nil {
+		accepted, err = s.DB.UsePasskey(passkey.ID, int64(count), time.Now().UTC())
+		if err != nil {
+			return "", nil, err
+		}
+	}
+
+	if !accepted {
+		s.Log.WithField("ip", ip).Warnln("passkey may have been cloned, flagging it:", passkey.User, passkey.ID, count, credential.SignCount)
+		err = s.DB.FlagClonedPasskey(passkey.ID)
+		if err != nil {
+			return "", nil, err
+		}
+		return msgClonedPasskey, nil, nil
+	}
+
+	roles, err := s.DB.UserRoles(passkey.User)
+	if err != nil {
+		return "", nil, err
+	}
+
+	s.Log.WithField("ip", ip).Infoln("passkey login:", passkey.User, passkey.ID)
+
+	return "", &models.User{ID: passkey.User, Roles: roles}, nil
+}
@@ -0,0 +1,249 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/chiips/snippets/API/models"
+	"github.com/chiips/snippets/API/webauthn"
+	"github.com/chiips/snippets/API/webauthn/webauthntest"
+	hr "github.com/julienschmidt/httprouter"
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestPasskeys(t *testing.T) {
+
+	router := hr.New()
+	mdb := &mockDB{}
+	rp := &webauthn.RelyingParty{ID: "example.com", Name: "Snippets", Origins: []string{"https://example.com"}}
+	s := Server{DB: mdb, Router: router, Log: testLog, State: NewMemoryStore(), WebAuthn: rp}
+	s.Routes()
+
+	//send sends the body to the url as the user unless nil
+	send := func(method, url string, user uuid.UUID, body interface{}) *httptest.ResponseRecorder {
+		b, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest(method, url, bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !uuid.Equal(user, uuid.Nil) {
+			authenticate(t, &s, req, user)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	//creationOptions starts registering a passkey as the user
+	creationOptions := func(user uuid.UUID) *webauthn.CreationOptions {
+		rr := send("POST", "/api/passkeys/options", user, nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("passkey options returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusOK)
+		}
+		options := &webauthn.CreationOptions{}
+		if err := json.NewDecoder(rr.Body).Decode(options); err != nil {
+			t.Fatal(err)
+		}
+		return options
+	}
+
+	//register registers a passkey on the authenticator as the user
+	register := func(a *webauthntest.Authenticator, user uuid.UUID, name string) *httptest.ResponseRecorder {
+		reg, err := a.Create(creationOptions(user))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return send("POST", "/api/passkeys", user, &passkeyRegistration{Name: name, Credential: *reg})
+	}
+
+	//assert asserts a passkey on the authenticator for a login
+	assert := func(a *webauthntest.Authenticator) *webauthn.Assertion {
+		rr := send("POST", "/api/login/passkey/options", uuid.Nil, nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("passkey login options returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusOK)
+		}
+		options := &webauthn.RequestOptions{}
+		if err := json.NewDecoder(rr.Body).Decode(options); err != nil {
+			t.Fatal(err)
+		}
+		assertion, err := a.Get(options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return assertion
+	}
+
+	login := func(a *webauthntest.Authenticator) *httptest.ResponseRecorder {
+		return send("POST", "/api/login/passkey", uuid.Nil, assert(a))
+	}
+
+	//registering sends options naming the user, and stores the passkey
+	options := creationOptions(userID)
+	if options.User.ID != webauthn.EncodeID(userID.Bytes()) || options.User.Name != "User" || options.RP.ID != "example.com" {
+		t.Errorf("passkey options wrong: %+v", options)
+	}
+
+	laptop := webauthntest.New("https://example.com")
+	reg, err := laptop.Create(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := send("POST", "/api/passkeys", userID, &passkeyRegistration{Name: " Laptop ", Credential: *reg})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("passkey registration returned wrong status code:\ngot: %v\nwant: %v %q", rr.Code, http.StatusCreated, rr.Body)
+	}
+	passkey := models.Passkey{}
+	if err := json.NewDecoder(rr.Body).Decode(&passkey); err != nil {
+		t.Fatal(err)
+	}
+	if passkey.ID != reg.ID || passkey.Name != "Laptop" || mdb.passkeys[reg.ID] == nil || !uuid.Equal(mdb.passkeys[reg.ID].User, userID) {
+		t.Fatalf("passkey registered wrongly: %+v", passkey)
+	}
+
+	//each challenge registers once
+	if rr := send("POST", "/api/passkeys", userID, &passkeyRegistration{Credential: *reg}); rr.Code != http.StatusBadRequest || !strings.HasPrefix(rr.Body.String(), msgPasskeyExpired) {
+		t.Errorf("replayed registration returned wrong response: %v %q", rr.Code, rr.Body)
+	}
+
+	//a challenge issued to one user does not register a passkey for another
+	reg, err = webauthntest.New("https://example.com").Create(creationOptions(userID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rr := send("POST", "/api/passkeys", otherUserID, &passkeyRegistration{Credential: *reg}); rr.Code != http.StatusBadRequest || !strings.HasPrefix(rr.Body.String(), msgPasskeyExpired) {
+		t.Errorf("registration with another user's challenge returned wrong response: %v %q", rr.Code, rr.Body)
+	}
+
+	//the relying party's checks refuse credentials made for another origin
+	if rr := register(webauthntest.New("https://evil.example"), userID, ""); rr.Code != http.StatusBadRequest {
+		t.Errorf("registration from another origin returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusBadRequest)
+	}
+
+	if rr := register(webauthntest.New("https://example.com"), userID, strings.Repeat("x", maxPasskeyName+1)); rr.Code != http.StatusBadRequest {
+		t.Errorf("registration with too long a name returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusBadRequest)
+	}
+
+	//users may register several passkeys, but not two on one authenticator
+	options = creationOptions(userID)
+	if len(options.ExcludeCredentials) != 1 || options.ExcludeCredentials[0].ID != passkey.ID {
+		t.Errorf("passkey options do not exclude registered passkeys: %+v", options.ExcludeCredentials)
+	}
+	if _, err := laptop.Create(options); err == nil {
+		t.Errorf("authenticator registered a second passkey")
+	}
+
+	phone := webauthntest.New("https://example.com")
+	phone.Algorithm = webauthn.EdDSA
+	phone.NoCounter = true
+	if rr := register(phone, userID, "Phone"); rr.Code != http.StatusCreated {
+		t.Fatalf("second passkey registration returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusCreated)
+	}
+
+	rr = send("GET", "/api/passkeys", userID, nil)
+	list := []*models.Passkey{}
+	if err := json.NewDecoder(rr.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].Name != "Laptop" || list[1].Name != "Phone" {
+		t.Fatalf("passkey list wrong: %+v", list)
+	}
+	if rr := send("GET", "/api/passkeys", otherUserID, nil); rr.Body.String() != "[]\n" {
+		t.Errorf("another user's passkey list wrong: %q", rr.Body)
+	}
+
+	//logging in with a passkey needs no email or password, and sends a working JWT
+	rr = login(laptop)
+	if rr.Code != http.StatusOK || rr.Body.String() != "logged in!" {
+		t.Fatalf("passkey login returned wrong response: %v %q", rr.Code, rr.Body)
+	}
+	req, err := http.NewRequest("GET", "/api/timeline", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range rr.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	timeline := httptest.NewRecorder()
+	router.ServeHTTP(timeline, req)
+	if timeline.Code != http.StatusOK {
+		t.Errorf("JWT from passkey login refused:\ngot: %v\nwant: %v", timeline.Code, http.StatusOK)
+	}
+	if p := mdb.passkeys[passkey.ID]; p.SignCount != 1 || p.LastUsed == nil {
+		t.Errorf("passkey use not recorded: %+v", p)
+	}
+
+	//each login challenge is spent once
+	assertion := assert(laptop)
+	if rr := send("POST", "/api/login/passkey", uuid.Nil, assertion); rr.Code != http.StatusOK {
+		t.Fatalf("passkey login returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusOK)
+	}
+	if rr := send("POST", "/api/login/passkey", uuid.Nil, assertion); rr.Code != http.StatusUnauthorized || !strings.HasPrefix(rr.Body.String(), msgPasskeyExpired) {
+		t.Errorf("replayed assertion returned wrong response: %v %q", rr.Code, rr.Body)
+	}
+
+	//the user handle must name the passkey's user
+	assertion = assert(laptop)
+	assertion.Response.UserHandle = webauthn.EncodeID(otherUserID.Bytes())
+	if rr := send("POST", "/api/login/passkey", uuid.Nil, assertion); rr.Code != http.StatusUnauthorized || !strings.HasPrefix(rr.Body.String(), msgInvalidPasskey) {
+		t.Errorf("assertion for another user returned wrong response: %v %q", rr.Code, rr.Body)
+	}
+
+	//passkeys that were never registered do not log in
+	stranger := webauthntest.New("https://example.com")
+	if _, err := stranger.Create(creationOptions(otherUserID)); err != nil {
+		t.Fatal(err)
+	}
+	if rr := login(stranger); rr.Code != http.StatusUnauthorized || !strings.HasPrefix(rr.Body.String(), msgInvalidPasskey) {
+		t.Errorf("unregistered passkey returned wrong response: %v %q", rr.Code, rr.Body)
+	}
+
+	//passkeys without signature counters log in again and again
+	for i := 0; i < 2; i++ {
+		if rr := login(phone); rr.Code != http.StatusOK {
+			t.Errorf("passkey without counter returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusOK)
+		}
+	}
+
+	//once a clone signs, the counter of the other copy is stale: the passkey is flagged and neither copy logs in
+	clone := laptop.Clone()
+	if rr := login(clone); rr.Code != http.StatusOK {
+		t.Fatalf("first login by clone returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusOK)
+	}
+	for _, a := range []*webauthntest.Authenticator{laptop, clone} {
+		if rr := login(a); rr.Code != http.StatusUnauthorized || !strings.HasPrefix(rr.Body.String(), msgClonedPasskey) {
+			t.Errorf("cloned passkey returned wrong response: %v %q", rr.Code, rr.Body)
+		}
+	}
+	if !mdb.passkeys[passkey.ID].Cloned {
+		t.Errorf("cloned passkey not flagged")
+	}
+	if rr := login(phone); rr.Code != http.StatusOK {
+		t.Errorf("other passkey refused after clone flagged:\ngot: %v\nwant: %v", rr.Code, http.StatusOK)
+	}
+
+	//users delete their own passkeys only
+	if rr := send("DELETE", "/api/passkeys/"+passkey.ID, otherUserID, nil); rr.Code != http.StatusNotFound {
+		t.Errorf("deleting another user's passkey returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusNotFound)
+	}
+	if rr := send("DELETE", "/api/passkeys/"+passkey.ID, userID, nil); rr.Code != http.StatusOK {
+		t.Errorf("deleting passkey returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusOK)
+	}
+	if _, ok := mdb.passkeys[passkey.ID]; ok {
+		t.Errorf("passkey not deleted")
+	}
+
+	//without a relying party passkeys are off
+	s.WebAuthn = nil
+	for _, url := range []string{"/api/passkeys/options", "/api/login/passkey/options"} {
+		if rr := send("POST", url, userID, nil); rr.Code != http.StatusNotFound {
+			t.Errorf("%v without relying party returned wrong status code:\ngot: %v\nwant: %v", url, rr.Code, http.StatusNotFound)
+		}
+	}
+}
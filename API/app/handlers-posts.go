@@ -20,17 +20,15 @@ func (s *Server) allPosts() hr.Handle {
 
 		ctx := r.Context()
 
-		//set limit of 10 results
-		limit := 10
+		//the viewer is set by the identifyJWT middleware when logged in and is otherwise uuid.Nil.
+		viewer, _ := ctx.Value(userContextKey).(uuid.UUID)
 
-		//default prevDate is now to start at most recent result.
-		prevDate := time.Now().UTC().Format(time.RFC3339)
-
-		//if previous date is in the query then update prevDate to get results from that point in time back.
-		prevDateQuery, ok := r.URL.Query()["prev"]
-
-		if ok && len(prevDateQuery[0]) >= 1 && strings.TrimSpace(prevDateQuery[0]) != "" {
-			prevDate = prevDateQuery[0]
+		//read the cursor and limit. without a cursor start at the most recent result.
+		before, limit, err := s.parsePagination(r)
+		if err != nil {
+			s.Log.Errorln(err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
 
 		////create a postsCh to communicate results and an error channe to communicate errors
@@ -45,8 +43,8 @@ func (s *Server) allPosts() hr.Handle {
 				return
 			}
 
-			//call the database
-			posts, err := s.DB.AllPosts(prevDate, limit)
+			//call the database, asking for one extra post to learn whether another page follows.
+			posts, err := s.DB.AllPosts(before, limit+1, viewer)
 
 			//check if the request context is cancelled by the time we're done searching the database.
 			if ctx.Err() != nil {
@@ -79,9 +77,92 @@ func (s *Server) allPosts() hr.Handle {
 			return
 		//3. success
 		case posts := <-postsCh:
+			//if there is another page then point the next cursor at the last post sent.
+			var next *models.Cursor
+			if len(posts) > limit {
+				posts = posts[:limit]
+				last := posts[limit-1]
+				next = &models.Cursor{Created: last.Created, ID: last.ID}
+			}
+
 			//send the results as JSON data to the client
+			err = s.writePage(w, r, posts, limit, next)
+			if err != nil {
+				s.Log.Errorln(err)
+				http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+				return
+			}
+			return
+		}
+	}
+}
+
+//onePost retrieves one specific post from the database.
+func (s *Server) onePost() hr.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps hr.Params) {
+
+		ctx := r.Context()
+
+		viewer, _ := ctx.Value(userContextKey).(uuid.UUID)
+		roles, _ := ctx.Value(rolesContextKey).([]string)
+
+		id, err := uuid.FromString(ps.ByName("postid"))
+		if err != nil {
+			s.Log.Errorln(err)
+			http.Error(w, http.StatusText(400), http.StatusBadRequest)
+			return
+		}
+
+		postCh := make(chan *models.Post)
+		errCh := make(chan error)
+
+		go func() {
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			post, err := s.DB.OnePost(id, viewer)
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			postCh <- post
+			return
+
+		}()
+
+		select {
+		case <-ctx.Done():
+			s.Log.Errorln(ctx.Err())
+			http.Error(w, "We could not process your request at this time. Please try again later.", http.StatusRequestTimeout)
+			return
+		case err := <-errCh:
+			s.Log.Errorln(err)
+			if err == sql.ErrNoRows {
+				http.Error(w, http.StatusText(404), http.StatusNotFound)
+				return
+			}
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		case post := <-postCh:
+			//hidden posts are only shown to their author and moderators
+			if !CanViewPost(viewer, roles, post) {
+				s.Log.Errorln("hidden post requested")
+				http.Error(w, http.StatusText(404), http.StatusNotFound)
+				return
+			}
+
+			//the ETag names the version edits must be made to
+			w.Header().Set("ETag", versionETag(post.Version))
 			w.Header().Set("Content-Type", "application/json")
-			err = json.NewEncoder(w).Encode(posts)
+			err = json.NewEncoder(w).Encode(post)
 			if err != nil {
 				s.Log.Errorln(err)
 				http.Error(w, http.StatusText(500), http.StatusInternalServerError)
@@ -156,6 +237,7 @@ func (s *Server) submitPost() hr.Handle {
 		post.Created = time.Now().UTC()
 		post.Updated = time.Now().UTC()
 		post.Author.ID = currentUser
+		post.Tags = models.ExtractTags(body)
 
 		//create success channel and error channel.
 		okCh := make(chan bool)
@@ -237,17 +319,34 @@ func (s *Server) editPost() hr.Handle {
 			return
 		}
 
-		//confirm the current user is the author of the submission
-		if submission.Author.ID != currentUser {
-			s.Log.Errorln("forbidden request")
-			http.Error(w, http.StatusText(403), http.StatusForbidden)
+		//load the stored post and confirm the current user may edit it. the author sent with the submission is never trusted.
+		post, status := s.authorizePost(currentUser, submission.ID, CanEditPost)
+		if status != http.StatusOK {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+
+		//the edit must name the version it was made to so it cannot overwrite an edit saved since
+		version, err := ifMatchVersion(r, post.Version)
+		if err != nil {
+			s.Log.Errorln(err)
+			http.Error(w, err.Error(), http.StatusPreconditionRequired)
 			return
 		}
 
+		//apply only the editable fields to the stored post
+		post.Title = submission.Title
+		post.Body = submission.Body
+		post.Version = version
+
 		//change last updated to now
-		submission.Updated = time.Now().UTC()
+		post.Updated = time.Now().UTC()
+
+		//re-extract the tags from the edited body rather than trusting any sent by the client
+		post.Tags = models.ExtractTags(post.Body)
 
 		okCh := make(chan bool)
+		staleCh := make(chan *models.Post)
 		errCh := make(chan error)
 
 		go func() {
@@ -256,7 +355,18 @@ func (s *Server) editPost() hr.Handle {
 				return
 			}
 
-			err = s.DB.UpdatePost(submission)
+			err = s.DB.UpdatePost(post)
+
+			//a stale edit gets the current post to send back instead
+			if err == models.ErrVersionConflict {
+				current, err := s.DB.OnePost(post.ID, currentUser)
+				if err != nil {
+					errCh <- err
+					return
+				}
+				staleCh <- current
+				return
+			}
 
 			if err != nil {
 				errCh <- err
@@ -275,9 +385,21 @@ func (s *Server) editPost() hr.Handle {
 			return
 		case err := <-errCh:
 			s.Log.Errorln("error editing post:", err)
+			if err == sql.ErrNoRows {
+				http.Error(w, http.StatusText(404), http.StatusNotFound)
+				return
+			}
 			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
 			return
+		case current := <-staleCh:
+			s.Log.Errorln("stale post edit")
+			err = writeStale(w, current.Version, current)
+			if err != nil {
+				s.Log.Errorln(err)
+			}
+			return
 		case <-okCh:
+			w.Header().Set("ETag", versionETag(post.Version))
 			fmt.Fprint(w, "post edited!")
 			return
 		}
@@ -320,23 +442,10 @@ func (s *Server) deletePost() hr.Handle {
 			return
 		}
 
-		//query the database for the full post information.
-		post, err := s.DB.OnePost(id)
-		switch {
-		case err == sql.ErrNoRows:
-			s.Log.Errorln(err)
-			http.Error(w, http.StatusText(404), http.StatusNotFound)
-			return
-		case err != nil:
-			s.Log.Errorln(err)
-			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
-			return
-		}
-
-		//confirm post belongs to the current user
-		if post.Author.ID != currentUser {
-			s.Log.Errorln("forbidden request")
-			http.Error(w, http.StatusText(500), http.StatusForbidden)
+		//load the stored post and confirm the current user may delete it.
+		post, status := s.authorizePost(currentUser, id, CanDeletePost)
+		if status != http.StatusOK {
+			http.Error(w, http.StatusText(status), status)
 			return
 		}
 
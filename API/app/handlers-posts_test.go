@@ -1,10 +1,12 @@
 package app
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/chiips/snippets/API/models"
@@ -49,7 +51,7 @@ func TestAllPosts(t *testing.T) {
 
 	//check what is actually returned
 	got := []*models.Post{}
-	if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+	if err := json.NewDecoder(rr.Body).Decode(&page{Data: &got}); err != nil {
 		t.Fatal(err)
 	}
 
@@ -62,6 +64,115 @@ func TestAllPosts(t *testing.T) {
 
 }
 
+func TestAllPostsPagination(t *testing.T) {
+
+	router := hr.New()
+	s := Server{DB: &mockDB{}, Router: router}
+	s.Routes()
+
+	//request the first page of one post
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "/api/posts?limit=1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code:\ngot:\n%v\n want:\n%v", status, http.StatusOK)
+	}
+
+	got := []*models.Post{}
+	first := page{Data: &got}
+	if err := json.NewDecoder(rr.Body).Decode(&first); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != 1 || got[0].ID != postID1 {
+		t.Fatalf("handler returned wrong first page:\ngot: %v\nwant: [%v]", got, postID1)
+	}
+
+	if first.NextCursor == "" {
+		t.Fatal("handler returned no next cursor for the first page")
+	}
+
+	//the Link header should point to the next page
+	wantLink := fmt.Sprintf("</api/posts?cursor=%s&limit=1>; rel=\"next\"", first.NextCursor)
+	if link := rr.Header().Get("Link"); link != wantLink {
+		t.Errorf("link header does not match:\ngot: %v\nwant: %v", link, wantLink)
+	}
+
+	//follow the cursor to the second page. both posts share a created time so the id must break the tie.
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "/api/posts?limit=1&cursor="+first.NextCursor, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code:\ngot:\n%v\n want:\n%v", status, http.StatusOK)
+	}
+
+	got = []*models.Post{}
+	second := page{Data: &got}
+	if err := json.NewDecoder(rr.Body).Decode(&second); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != 1 || got[0].ID != postID2 {
+		t.Fatalf("handler returned wrong second page:\ngot: %v\nwant: [%v]", got, postID2)
+	}
+
+	if second.NextCursor != "" || rr.Header().Get("Link") != "" {
+		t.Errorf("handler returned a next page after the last post: %v", second.NextCursor)
+	}
+
+}
+
+func TestAllPostsBadPagination(t *testing.T) {
+
+	key, err := ParseCursorKey(strings.Repeat("k", minCursorKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseCursorKey(strings.Repeat("k", minCursorKey-1)); err == nil {
+		t.Error("ParseCursorKey accepted a short key")
+	}
+
+	router := hr.New()
+	s := Server{DB: &mockDB{}, Router: router, Log: testLog, CursorKey: key}
+	s.Routes()
+
+	//a tampered cursor fails its signature check, as does one signed with another key
+	tampered := []byte(s.encodeCursor(models.Cursor{Created: now, ID: postID1}))
+	tampered[0] ^= 1
+	other := Server{CursorKey: []byte(strings.Repeat("o", minCursorKey))}
+
+	urls := []string{
+		"/api/posts?cursor=not-a-cursor",
+		"/api/posts?cursor=" + string(tampered),
+		"/api/posts?cursor=" + other.encodeCursor(models.Cursor{Created: now, ID: postID1}),
+		"/api/posts?limit=0",
+		"/api/posts?limit=1000",
+		"/api/posts?limit=ten",
+	}
+
+	for _, url := range urls {
+		rr := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusBadRequest {
+			t.Errorf("%s: handler returned wrong status code:\ngot: %v\nwant: %v", url, status, http.StatusBadRequest)
+		}
+	}
+
+}
+
 //comparePostList compares got vs want for a collection of posts
 func comparePostList(got, want []*models.Post) (bool, string) {
 	for key, PostGot := range got {
@@ -100,3 +211,83 @@ func comparePostList(got, want []*models.Post) (bool, string) {
 	}
 	return true, ""
 }
+
+func TestEditPostPreconditions(t *testing.T) {
+
+	router := hr.New()
+	s := Server{DB: &mockDB{}, Router: router, Log: testLog}
+	s.Routes()
+
+	//reads carry the ETag of the post's version
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", fmt.Sprintf("/api/post/%s", postID1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router.ServeHTTP(rr, req)
+
+	etag := rr.Header().Get("ETag")
+	if etag != `"1"` {
+		t.Fatalf("handler returned wrong ETag:\ngot: %v\nwant: %v", etag, `"1"`)
+	}
+
+	//edit sends an edit of postID1 made to the version in ifMatch
+	edit := func(ifMatch, body string) *httptest.ResponseRecorder {
+		submission, err := json.Marshal(&models.Post{ID: postID1, Title: "Post", Body: body, Author: models.User{ID: userID}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		req, err := http.NewRequest("PUT", "/api/post", bytes.NewReader(submission))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		authenticate(t, &s, req, userID)
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	//edits must say which version they were made to
+	if rr := edit("", "From nowhere"); rr.Code != http.StatusPreconditionRequired {
+		t.Errorf("handler returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusPreconditionRequired)
+	}
+
+	//the first tab's edit applies and moves the post to the next version
+	rr = edit(etag, "From the first tab")
+	if rr.Code != http.StatusOK || rr.Header().Get("ETag") != `"2"` {
+		t.Fatalf("handler did not apply the edit: %v %v", rr.Code, rr.Header().Get("ETag"))
+	}
+
+	//the second tab's edit of the same version is refused with the current copy
+	rr = edit(etag, "From the second tab")
+	if rr.Code != http.StatusPreconditionFailed {
+		t.Fatalf("handler returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusPreconditionFailed)
+	}
+
+	current := models.Post{}
+	if err := json.NewDecoder(rr.Body).Decode(&current); err != nil {
+		t.Fatal(err)
+	}
+	if current.Body != "From the first tab" || current.Version != 2 || rr.Header().Get("ETag") != `"2"` {
+		t.Errorf("handler returned wrong current copy: %v version %v", current.Body, current.Version)
+	}
+
+	//weak or malformed tags never match, nor lists without the current version
+	for _, ifMatch := range []string{`W/"2"`, "2", `"x"`, `"1", W/"2"`} {
+		if rr := edit(ifMatch, "From elsewhere"); rr.Code != http.StatusPreconditionFailed {
+			t.Errorf("handler returned wrong status code for %v:\ngot: %v\nwant: %v", ifMatch, rr.Code, http.StatusPreconditionFailed)
+		}
+	}
+
+	//* matches the current version, as does a list naming it
+	for i, ifMatch := range []string{"*", `"1", "3"`} {
+		want := versionETag(i + 3)
+		if rr := edit(ifMatch, "From elsewhere"); rr.Code != http.StatusOK || rr.Header().Get("ETag") != want {
+			t.Errorf("handler did not apply the edit for %v: %v %v", ifMatch, rr.Code, rr.Header().Get("ETag"))
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package app
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/chiips/snippets/API/models"
+	hr "github.com/julienschmidt/httprouter"
+	uuid "github.com/satori/go.uuid"
+)
+
+//reactionSet sends the reactions users may add to posts along with the emoji displayed for each.
+func (s *Server) reactionSet() hr.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ hr.Params) {
+
+		//the set is fixed so clients may cache it
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+		w.Header().Set("Content-Type", "application/json")
+		err := json.NewEncoder(w).Encode(models.ReactionEmoji)
+		if err != nil {
+			s.Log.Errorln(err)
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+//addReaction handles users reacting to a post. Repeating a reaction has no further effect.
+func (s *Server) addReaction() hr.Handle {
+	return s.changeReaction(true)
+}
+
+//removeReaction handles users taking back their reaction to a post. Removing a reaction not made has no effect.
+func (s *Server) removeReaction() hr.Handle {
+	return s.changeReaction(false)
+}
+
+//changeReaction adds or removes the current user's reaction named in the url and responds with the post's updated reactions.
+func (s *Server) changeReaction(add bool) hr.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps hr.Params) {
+
+		ctx := r.Context()
+
+		currentUser, ok := ctx.Value(userContextKey).(uuid.UUID)
+		if !ok {
+			s.Log.Errorln("no userID in context")
+			http.Error(w, http.StatusText(500), http.StatusForbidden)
+			return
+		}
+
+		if uuid.Equal(currentUser, uuid.Nil) {
+			s.Log.Errorln("userID came in with nil value.")
+			http.Error(w, http.StatusText(400), http.StatusBadRequest)
+			return
+		}
+
+		postID, err := uuid.FromString(ps.ByName("postid"))
+		if err != nil {
+			s.Log.Errorln(err)
+			http.Error(w, http.StatusText(400), http.StatusBadRequest)
+			return
+		}
+
+		//only reactions from the fixed set are allowed
+		reaction := ps.ByName("reaction")
+		if _, ok := models.ReactionEmoji[reaction]; !ok {
+			s.Log.Errorln("invalid reaction")
+			http.Error(w, "invalid reaction", http.StatusBadRequest)
+			return
+		}
+
+		postCh := make(chan *models.Post)
+		errCh := make(chan error)
+
+		go func() {
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			//confirm the post exists before reacting to it
+			_, err := s.DB.OnePost(postID, currentUser)
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			if add {
+				err = s.DB.AddReaction(postID, currentUser, reaction, time.Now().UTC())
+			} else {
+				err = s.DB.RemoveReaction(postID, currentUser, reaction)
+			}
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			//reload the post for its updated counts
+			post, err := s.DB.OnePost(postID, currentUser)
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			postCh <- post
+			return
+
+		}()
+
+		select {
+		case <-ctx.Done():
+			s.Log.Errorln(ctx.Err())
+			http.Error(w, "We could not process your request at this time. Please try again later.", http.StatusRequestTimeout)
+			return
+		case err := <-errCh:
+			s.Log.Errorln("error changing reaction:", err)
+			if err == sql.ErrNoRows {
+				http.Error(w, http.StatusText(404), http.StatusNotFound)
+				return
+			}
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		case post := <-postCh:
+			w.Header().Set("Content-Type", "application/json")
+			err = json.NewEncoder(w).Encode(struct {
+				Reactions map[string]int `json:"reactions"`
+				Reacted   []string       `json:"reacted"`
+			}{post.Reactions, post.Reacted})
+			if err != nil {
+				s.Log.Errorln(err)
+				http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+				return
+			}
+			return
+		}
+	}
+}
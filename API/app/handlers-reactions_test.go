@@ -0,0 +1,105 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/chiips/snippets/API/models"
+	hr "github.com/julienschmidt/httprouter"
+)
+
+func TestChangeReaction(t *testing.T) {
+
+	router := hr.New()
+	s := Server{DB: &mockDB{}, Router: router, Log: testLog}
+	s.Routes()
+
+	url := fmt.Sprintf("/api/post/%s/reactions/like", postID1)
+
+	//adding the same reaction twice counts it once
+	for i := 0; i < 2; i++ {
+		rr := httptest.NewRecorder()
+		req, err := http.NewRequest("PUT", url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		authenticate(t, &s, req, userID)
+
+		router.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code:\ngot: %v\nwant: %v", status, http.StatusOK)
+		}
+
+		got := models.Post{}
+		if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+
+		if got.Reactions["like"] != 1 || len(got.Reacted) != 1 || got.Reacted[0] != "like" {
+			t.Fatalf("handler returned wrong reactions:\ngot: %v %v\nwant: map[like:1] [like]", got.Reactions, got.Reacted)
+		}
+	}
+
+	//the reaction shows in the feed for the viewer who made it, and only in the counts for others
+	posts := []*models.Post{}
+	for _, viewer := range []bool{true, false} {
+		rr := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", "/api/posts", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if viewer {
+			authenticate(t, &s, req, userID)
+		}
+
+		router.ServeHTTP(rr, req)
+
+		if err := json.NewDecoder(rr.Body).Decode(&page{Data: &posts}); err != nil {
+			t.Fatal(err)
+		}
+
+		if posts[0].ID != postID1 || posts[0].Reactions["like"] != 1 || (len(posts[0].Reacted) == 1) != viewer {
+			t.Errorf("feed returned wrong reactions for viewer %v:\ngot: %v %v", viewer, posts[0].Reactions, posts[0].Reacted)
+		}
+	}
+
+	//removing the reaction, even twice, leaves no count
+	for i := 0; i < 2; i++ {
+		rr := httptest.NewRecorder()
+		req, err := http.NewRequest("DELETE", url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		authenticate(t, &s, req, userID)
+
+		router.ServeHTTP(rr, req)
+
+		got := models.Post{}
+		if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+
+		if got.Reactions["like"] != 0 || len(got.Reacted) != 0 {
+			t.Fatalf("handler returned wrong reactions:\ngot: %v %v\nwant: map[] []", got.Reactions, got.Reacted)
+		}
+	}
+
+	//reactions outside the fixed set are rejected
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("PUT", fmt.Sprintf("/api/post/%s/reactions/shrug", postID1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticate(t, &s, req, userID)
+
+	router.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code:\ngot: %v\nwant: %v", status, http.StatusBadRequest)
+	}
+
+}
@@ -0,0 +1,360 @@
+package app
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/chiips/snippets/API/models"
+	hr "github.com/julienschmidt/httprouter"
+	uuid "github.com/satori/go.uuid"
+)
+
+//revisionDiff is the response of the diff endpoint: the runs turning one revision's title and body into another's.
+type revisionDiff struct {
+	From  int             `json:"from"`
+	To    int             `json:"to"`
+	By    string          `json:"by"`
+	Title []models.DiffOp `json:"title"`
+	Body  []models.DiffOp `json:"body"`
+}
+
+//errInvalidRevision is returned to the client when a revision number is not a positive number
+var errInvalidRevision = errors.New("invalid revision: must be a revision number")
+
+//postRevisions retrieves one page of a post's revisions, newest first, if the viewer may see the post.
+func (s *Server) postRevisions() hr.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps hr.Params) {
+
+		ctx := r.Context()
+
+		viewer, _ := ctx.Value(userContextKey).(uuid.UUID)
+		roles, _ := ctx.Value(rolesContextKey).([]string)
+
+		postID, err := uuid.FromString(ps.ByName("postid"))
+		if err != nil {
+			s.Log.Errorln(err)
+			http.Error(w, http.StatusText(400), http.StatusBadRequest)
+			return
+		}
+
+		before, limit, err := s.parsePagination(r)
+		if err != nil {
+			s.Log.Errorln(err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		//confirm the post exists and may be seen, so a missing post is not mistaken for one without revisions
+		_, status := s.authorizeViewPost(viewer, roles, postID)
+		if status != http.StatusOK {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+
+		revisionsCh := make(chan []*models.Revision)
+		errCh := make(chan error)
+
+		go func() {
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			revisions, err := s.DB.PostRevisions(postID, before, limit+1)
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			revisionsCh <- revisions
+			return
+
+		}()
+
+		select {
+		case <-ctx.Done():
+			s.Log.Errorln(ctx.Err())
+			http.Error(w, "We could not process your request at this time. Please try again later.", http.StatusRequestTimeout)
+			return
+		case err := <-errCh:
+			s.Log.Errorln(err)
+			if err == sql.ErrNoRows {
+				http.Error(w, http.StatusText(404), http.StatusNotFound)
+				return
+			}
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		case revisions := <-revisionsCh:
+			var next *models.Cursor
+			if len(revisions) > limit {
+				revisions = revisions[:limit]
+				last := revisions[limit-1]
+				next = &models.Cursor{Created: last.Created, ID: last.ID}
+			}
+
+			err = s.writePage(w, r, revisions, limit, next)
+			if err != nil {
+				s.Log.Errorln(err)
+				http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+				return
+			}
+			return
+		}
+	}
+}
+
+//diffRevisions compares two revisions of a post given by the from and to query parameters, if the viewer may see the post.
+//The by parameter chooses a "word" diff, the default, or a "line" diff.
+func (s *Server) diffRevisions() hr.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps hr.Params) {
+
+		ctx := r.Context()
+
+		viewer, _ := ctx.Value(userContextKey).(uuid.UUID)
+		roles, _ := ctx.Value(rolesContextKey).([]string)
+
+		postID, err := uuid.FromString(ps.ByName("postid"))
+		if err != nil {
+			s.Log.Errorln(err)
+			http.Error(w, http.StatusText(400), http.StatusBadRequest)
+			return
+		}
+
+		query := r.URL.Query()
+
+		from, err := revisionNumber(query.Get("from"))
+		if err != nil {
+			s.Log.Errorln(err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		to, err := revisionNumber(query.Get("to"))
+		if err != nil {
+			s.Log.Errorln(err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		by := query.Get("by")
+		diff := models.DiffWords
+		switch by {
+		case "", "word":
+			by = "word"
+		case "line":
+			diff = models.DiffLines
+		default:
+			s.Log.Errorln("invalid diff type")
+			http.Error(w, "invalid diff type: must be word or line", http.StatusBadRequest)
+			return
+		}
+
+		_, status := s.authorizeViewPost(viewer, roles, postID)
+		if status != http.StatusOK {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+
+		diffCh := make(chan *revisionDiff)
+		errCh := make(chan error)
+
+		go func() {
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			older, err := s.DB.OneRevision(postID, from)
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			newer, err := s.DB.OneRevision(postID, to)
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			diffCh <- &revisionDiff{From: from, To: to, By: by, Title: diff(older.Title, newer.Title), Body: diff(older.Body, newer.Body)}
+			return
+
+		}()
+
+		select {
+		case <-ctx.Done():
+			s.Log.Errorln(ctx.Err())
+			http.Error(w, "We could not process your request at this time. Please try again later.", http.StatusRequestTimeout)
+			return
+		case err := <-errCh:
+			s.Log.Errorln(err)
+			if err == sql.ErrNoRows {
+				http.Error(w, http.StatusText(404), http.StatusNotFound)
+				return
+			}
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		case diff := <-diffCh:
+			w.Header().Set("Content-Type", "application/json")
+			err = json.NewEncoder(w).Encode(diff)
+			if err != nil {
+				s.Log.Errorln(err)
+				http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+				return
+			}
+			return
+		}
+	}
+}
+
+//restoreRevision lets a post's author put an older revision's title and body back.
+//The restore is saved as a new revision so the history keeps every version, and the restored post is sent back.
+func (s *Server) restoreRevision() hr.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps hr.Params) {
+
+		ctx := r.Context()
+
+		currentUser, ok := ctx.Value(userContextKey).(uuid.UUID)
+		if !ok {
+			s.Log.Errorln("no userID in context")
+			http.Error(w, http.StatusText(500), http.StatusForbidden)
+			return
+		}
+
+		if uuid.Equal(currentUser, uuid.Nil) {
+			s.Log.Errorln("userID came in with nil value.")
+			http.Error(w, http.StatusText(400), http.StatusBadRequest)
+			return
+		}
+
+		postID, err := uuid.FromString(ps.ByName("postid"))
+		if err != nil {
+			s.Log.Errorln(err)
+			http.Error(w, http.StatusText(400), http.StatusBadRequest)
+			return
+		}
+
+		number, err := revisionNumber(ps.ByName("number"))
+		if err != nil {
+			s.Log.Errorln(err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		//load the stored post and confirm the current user may edit it
+		post, status := s.authorizePost(currentUser, postID, CanEditPost)
+		if status != http.StatusOK {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+
+		postCh := make(chan *models.Post)
+		staleCh := make(chan *models.Post)
+		errCh := make(chan error)
+
+		go func() {
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			revision, err := s.DB.OneRevision(postID, number)
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			post.Title = revision.Title
+			post.Body = revision.Body
+			post.Tags = models.ExtractTags(revision.Body)
+			post.Updated = time.Now().UTC()
+
+			//the restore applies to the version just read, so an edit saved in between refuses it
+			err = s.DB.UpdatePost(post)
+			if err == models.ErrVersionConflict {
+				current, err := s.DB.OnePost(postID, currentUser)
+				if err != nil {
+					errCh <- err
+					return
+				}
+				staleCh <- current
+				return
+			}
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			//reload the post for its restored tags
+			restored, err := s.DB.OnePost(postID, currentUser)
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			postCh <- restored
+			return
+
+		}()
+
+		select {
+		case <-ctx.Done():
+			s.Log.Errorln(ctx.Err())
+			http.Error(w, "We could not process your request at this time. Please try again later.", http.StatusRequestTimeout)
+			return
+		case err := <-errCh:
+			s.Log.Errorln("error restoring revision:", err)
+			if err == sql.ErrNoRows {
+				http.Error(w, http.StatusText(404), http.StatusNotFound)
+				return
+			}
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		case current := <-staleCh:
+			s.Log.Errorln("stale revision restore")
+			err = writeStale(w, current.Version, current)
+			if err != nil {
+				s.Log.Errorln(err)
+			}
+			return
+		case restored := <-postCh:
+			w.Header().Set("ETag", versionETag(restored.Version))
+			w.Header().Set("Content-Type", "application/json")
+			err = json.NewEncoder(w).Encode(restored)
+			if err != nil {
+				s.Log.Errorln(err)
+				http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+				return
+			}
+			return
+		}
+	}
+}
+
+//revisionNumber parses a revision number from the url or query.
+func revisionNumber(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 {
+		return 0, errInvalidRevision
+	}
+	return n, nil
+}
@@ -0,0 +1,123 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/chiips/snippets/API/models"
+	hr "github.com/julienschmidt/httprouter"
+)
+
+func TestRevisions(t *testing.T) {
+
+	router := hr.New()
+	s := Server{DB: &mockDB{}, Router: router, Log: testLog}
+	s.Routes()
+
+	//edit the sample post twice
+	for i, body := range []string{"Body with more words", "Body with other words"} {
+		submission, err := json.Marshal(&models.Post{ID: postID2, Title: "Post", Body: body, Author: models.User{ID: userID}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		req, err := http.NewRequest("PUT", "/api/post", bytes.NewReader(submission))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("If-Match", versionETag(i+1))
+		authenticate(t, &s, req, userID)
+		router.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusOK {
+			t.Fatalf("handler returned wrong status code:\ngot: %v\nwant: %v", status, http.StatusOK)
+		}
+	}
+
+	//the original and both edits are kept, newest first
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", fmt.Sprintf("/api/post/%s/revisions", postID2), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router.ServeHTTP(rr, req)
+
+	revisions := []*models.Revision{}
+	if err := json.NewDecoder(rr.Body).Decode(&page{Data: &revisions}); err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 3 || revisions[0].Number != 3 || revisions[2].Body != "Body" {
+		t.Fatalf("handler returned wrong revisions: %v", revisions)
+	}
+
+	//the word diff shows only the changed word
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", fmt.Sprintf("/api/post/%s/diff?from=2&to=3", postID2), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router.ServeHTTP(rr, req)
+
+	diff := revisionDiff{}
+	if err := json.NewDecoder(rr.Body).Decode(&diff); err != nil {
+		t.Fatal(err)
+	}
+	want := []models.DiffOp{{Op: models.DiffEqual, Text: "Body with "}, {Op: models.DiffDelete, Text: "more"}, {Op: models.DiffInsert, Text: "other"}, {Op: models.DiffEqual, Text: " words"}}
+	if fmt.Sprint(diff.Body) != fmt.Sprint(want) || len(diff.Title) != 1 || diff.By != "word" {
+		t.Errorf("handler returned wrong diff:\ngot: %v\nwant: %v", diff.Body, want)
+	}
+
+	//only the author may restore a revision
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", fmt.Sprintf("/api/post/%s/revisions/1/restore", postID2), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticate(t, &s, req, otherUserID)
+	router.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusForbidden {
+		t.Errorf("handler returned wrong status code:\ngot: %v\nwant: %v", status, http.StatusForbidden)
+	}
+
+	//restoring puts the original back as a new revision
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("POST", fmt.Sprintf("/api/post/%s/revisions/1/restore", postID2), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticate(t, &s, req, userID)
+	router.ServeHTTP(rr, req)
+
+	restored := models.Post{}
+	if err := json.NewDecoder(rr.Body).Decode(&restored); err != nil {
+		t.Fatal(err)
+	}
+	if restored.Body != "Body" {
+		t.Errorf("handler restored wrong body:\ngot: %v\nwant: %v", restored.Body, "Body")
+	}
+
+	//missing revisions are not found and bad revision numbers are rejected
+	for path, want := range map[string]int{
+		fmt.Sprintf("/api/post/%s/diff?from=1&to=5", postID2):      http.StatusNotFound,
+		fmt.Sprintf("/api/post/%s/diff?from=0&to=4", postID2):      http.StatusBadRequest,
+		fmt.Sprintf("/api/post/%s/diff?from=1&to=4", postID2):      http.StatusOK,
+		fmt.Sprintf("/api/post/%s/diff?from=1&to=4&by=x", postID2): http.StatusBadRequest,
+	} {
+		rr = httptest.NewRecorder()
+		req, err = http.NewRequest("GET", path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != want {
+			t.Errorf("handler returned wrong status code for %v:\ngot: %v\nwant: %v", path, status, want)
+		}
+	}
+}
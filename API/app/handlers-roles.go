@@ -0,0 +1,295 @@
+package app
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/chiips/snippets/API/models"
+	hr "github.com/julienschmidt/httprouter"
+	uuid "github.com/satori/go.uuid"
+)
+
+//userRoles is the response of the role endpoints: a user and the roles they hold.
+type userRoles struct {
+	ID    uuid.UUID `json:"id"`
+	Roles []string  `json:"roles"`
+}
+
+//errors returned to the client when a role change is refused
+var (
+	errInvalidRole    = errors.New("invalid role: must be moderator or admin")
+	errOwnAdminRevoke = errors.New("cannot revoke your own admin role")
+)
+
+//roles retrieves the roles of the user in the url.
+func (s *Server) roles() hr.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps hr.Params) {
+
+		ctx := r.Context()
+
+		id, err := uuid.FromString(ps.ByName("userid"))
+		if err != nil {
+			s.Log.Errorln(err)
+			http.Error(w, http.StatusText(400), http.StatusBadRequest)
+			return
+		}
+
+		rolesCh := make(chan []string)
+		errCh := make(chan error)
+
+		go func() {
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			roles, err := s.DB.UserRoles(id)
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			rolesCh <- roles
+			return
+
+		}()
+
+		select {
+		case <-ctx.Done():
+			s.Log.Errorln(ctx.Err())
+			http.Error(w, "We could not process your request at this time. Please try again later.", http.StatusRequestTimeout)
+			return
+		case err := <-errCh:
+			s.Log.Errorln(err)
+			if err == sql.ErrNoRows {
+				http.Error(w, http.StatusText(404), http.StatusNotFound)
+				return
+			}
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		case roles := <-rolesCh:
+			w.Header().Set("Content-Type", "application/json")
+			err = json.NewEncoder(w).Encode(&userRoles{ID: id, Roles: roles})
+			if err != nil {
+				s.Log.Errorln(err)
+				http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+				return
+			}
+			return
+		}
+	}
+}
+
+//grantRole handles admins granting the role in the url to the user in the url. Granting a role the user holds has no further effect.
+func (s *Server) grantRole() hr.Handle {
+	return s.changeRole(true)
+}
+
+//revokeRole handles admins revoking the role in the url from the user in the url. Revoking a role the user does not hold has no effect.
+func (s *Server) revokeRole() hr.Handle {
+	return s.changeRole(false)
+}
+
+//changeRole grants or revokes a role as the current user, recording the change, and responds with the user's updated roles.
+//The user's JWT keeps its old roles until it is next created.
+func (s *Server) changeRole(grant bool) hr.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps hr.Params) {
+
+		ctx := r.Context()
+
+		currentUser, ok := ctx.Value(userContextKey).(uuid.UUID)
+		if !ok {
+			s.Log.Errorln("no userID in context")
+			http.Error(w, http.StatusText(500), http.StatusForbidden)
+			return
+		}
+
+		if uuid.Equal(currentUser, uuid.Nil) {
+			s.Log.Errorln("userID came in with nil value.")
+			http.Error(w, http.StatusText(400), http.StatusBadRequest)
+			return
+		}
+
+		id, err := uuid.FromString(ps.ByName("userid"))
+		if err != nil {
+			s.Log.Errorln(err)
+			http.Error(w, http.StatusText(400), http.StatusBadRequest)
+			return
+		}
+
+		role := ps.ByName("role")
+		if !models.GrantableRole(role) {
+			s.Log.Errorln(errInvalidRole)
+			http.Error(w, errInvalidRole.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if !grant && !CanRevokeRole(currentUser, id, role) {
+			s.Log.Errorln(errOwnAdminRevoke)
+			http.Error(w, errOwnAdminRevoke.Error(), http.StatusForbidden)
+			return
+		}
+
+		changeID, err := uuid.NewV4()
+		if err != nil {
+			s.Log.Errorln(err)
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		}
+
+		change := &models.RoleChange{ID: changeID, Actor: currentUser, Target: id, Role: role, IP: s.clientIP(r), Created: time.Now().UTC()}
+
+		rolesCh := make(chan []string)
+		errCh := make(chan error)
+
+		go func() {
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			var changed bool
+			if grant {
+				changed, err = s.DB.GrantRole(change)
+			} else {
+				changed, err = s.DB.RevokeRole(change)
+			}
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			//granting a held role or revoking one not held changes nothing to record
+			if changed {
+				s.Log.WithField("ip", change.IP).Infoln("role change:", change.Actor, change.Action, change.Role, change.Target)
+				s.audit(r, models.AuditRoleChange, change.Actor, change.Target, change.Action+" "+change.Role)
+			}
+
+			//reload the user's roles as changed
+			roles, err := s.DB.UserRoles(id)
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			rolesCh <- roles
+			return
+
+		}()
+
+		select {
+		case <-ctx.Done():
+			s.Log.Errorln(ctx.Err())
+			http.Error(w, "We could not process your request at this time. Please try again later.", http.StatusRequestTimeout)
+			return
+		case err := <-errCh:
+			s.Log.Errorln("error changing role:", err)
+			if err == sql.ErrNoRows {
+				http.Error(w, http.StatusText(404), http.StatusNotFound)
+				return
+			}
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		case roles := <-rolesCh:
+			w.Header().Set("Content-Type", "application/json")
+			err = json.NewEncoder(w).Encode(&userRoles{ID: id, Roles: roles})
+			if err != nil {
+				s.Log.Errorln(err)
+				http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+				return
+			}
+			return
+		}
+	}
+}
+
+//roleChanges retrieves one page of the role change audit, newest first.
+//The user query parameter limits it to the changes made to one user.
+func (s *Server) roleChanges() hr.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ hr.Params) {
+
+		ctx := r.Context()
+
+		target := uuid.Nil
+		if u := r.URL.Query().Get("user"); u != "" {
+			id, err := uuid.FromString(u)
+			if err != nil {
+				s.Log.Errorln(err)
+				http.Error(w, http.StatusText(400), http.StatusBadRequest)
+				return
+			}
+			target = id
+		}
+
+		before, limit, err := s.parsePagination(r)
+		if err != nil {
+			s.Log.Errorln(err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		changesCh := make(chan []*models.RoleChange)
+		errCh := make(chan error)
+
+		go func() {
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			changes, err := s.DB.RoleChanges(target, before, limit+1)
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			changesCh <- changes
+			return
+
+		}()
+
+		select {
+		case <-ctx.Done():
+			s.Log.Errorln(ctx.Err())
+			http.Error(w, "We could not process your request at this time. Please try again later.", http.StatusRequestTimeout)
+			return
+		case err := <-errCh:
+			s.Log.Errorln(err)
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		case changes := <-changesCh:
+			var next *models.Cursor
+			if len(changes) > limit {
+				changes = changes[:limit]
+				last := changes[limit-1]
+				next = &models.Cursor{Created: last.Created, ID: last.ID}
+			}
+
+			err = s.writePage(w, r, changes, limit, next)
+			if err != nil {
+				s.Log.Errorln(err)
+				http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+				return
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/chiips/snippets/API/models"
+	hr "github.com/julienschmidt/httprouter"
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestRoles(t *testing.T) {
+
+	router := hr.New()
+	mdb := &mockDB{roles: map[uuid.UUID][]string{thirdUserID: {models.RoleAdmin}}}
+	s := Server{DB: mdb, Router: router, Log: testLog}
+	s.Routes()
+
+	//send runs a request as the given user and returns the response
+	send := func(user uuid.UUID, method, url string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		authenticate(t, &s, req, user)
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rolesURL := fmt.Sprintf("/api/admin/users/%s/roles", otherUserID)
+	moderatorURL := rolesURL + "/" + models.RoleModerator
+
+	//users without the permission or role are refused
+	for _, tc := range []struct{ method, url string }{
+		{"GET", rolesURL},
+		{"PUT", moderatorURL},
+		{"DELETE", moderatorURL},
+		{"GET", "/api/admin/rolechanges"},
+	} {
+		if rr := send(userID, tc.method, tc.url); rr.Code != http.StatusForbidden {
+			t.Errorf("%v %v returned wrong status code:\ngot: %v\nwant: %v", tc.method, tc.url, rr.Code, http.StatusForbidden)
+		}
+	}
+
+	//granting a role twice changes and records it once
+	for i := 0; i < 2; i++ {
+		rr := send(thirdUserID, "PUT", moderatorURL)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("grant returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusOK)
+		}
+
+		roles := &userRoles{}
+		if err := json.NewDecoder(rr.Body).Decode(roles); err != nil {
+			t.Fatal(err)
+		}
+		if !models.HasRole(roles.Roles, models.RoleModerator) || len(roles.Roles) != 2 {
+			t.Errorf("grant returned wrong roles: %v", roles.Roles)
+		}
+	}
+
+	//the moderator's next JWT carries the role but it does not allow managing roles
+	if rr := send(otherUserID, "GET", rolesURL); rr.Code != http.StatusForbidden {
+		t.Errorf("moderator managed roles:\ngot: %v\nwant: %v", rr.Code, http.StatusForbidden)
+	}
+
+	//the user role cannot be changed, unknown users are not found, and admins cannot revoke their own admin role
+	for _, tc := range []struct {
+		method, url string
+		want        int
+	}{
+		{"PUT", rolesURL + "/" + models.RoleUser, http.StatusBadRequest},
+		{"DELETE", rolesURL + "/owner", http.StatusBadRequest},
+		{"PUT", fmt.Sprintf("/api/admin/users/%s/roles/%s", postID1, models.RoleModerator), http.StatusNotFound},
+		{"DELETE", fmt.Sprintf("/api/admin/users/%s/roles/%s", thirdUserID, models.RoleAdmin), http.StatusForbidden},
+	} {
+		if rr := send(thirdUserID, tc.method, tc.url); rr.Code != tc.want {
+			t.Errorf("%v %v returned wrong status code:\ngot: %v\nwant: %v", tc.method, tc.url, rr.Code, tc.want)
+		}
+	}
+
+	if rr := send(thirdUserID, "DELETE", moderatorURL); rr.Code != http.StatusOK {
+		t.Fatalf("revoke returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusOK)
+	}
+
+	//the audit lists every change that applied, newest first
+	rr := send(thirdUserID, "GET", "/api/admin/rolechanges?user="+otherUserID.String())
+	if rr.Code != http.StatusOK {
+		t.Fatalf("audit returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusOK)
+	}
+
+	changes := []*models.RoleChange{}
+	if err := json.NewDecoder(rr.Body).Decode(&page{Data: &changes}); err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 2 || changes[0].Action != models.RoleRevoked || changes[1].Action != models.RoleGranted {
+		t.Fatalf("audit returned wrong changes: %v", changes)
+	}
+	for _, change := range changes {
+		if change.Actor != thirdUserID || change.Target != otherUserID || change.Role != models.RoleModerator {
+			t.Errorf("audit recorded wrong change: %+v", change)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package app
+
+import (
+	"net/http"
+
+	"github.com/chiips/snippets/API/models"
+	hr "github.com/julienschmidt/httprouter"
+	uuid "github.com/satori/go.uuid"
+)
+
+//searchPosts runs a full-text search over post titles and bodies and returns one page of results at a time, best match first.
+//The q parameter takes words, "quoted phrases", and prefix* words, all of which must match.
+func (s *Server) searchPosts() hr.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ hr.Params) {
+
+		ctx := r.Context()
+
+		viewer, _ := ctx.Value(userContextKey).(uuid.UUID)
+
+		query := models.ParseSearchQuery(r.URL.Query().Get("q"))
+		if query.Empty() {
+			s.Log.Errorln("invalid search query")
+			http.Error(w, "invalid search query", http.StatusBadRequest)
+			return
+		}
+
+		//without a cursor start at the best match as of now.
+		//the cursor keeps that point in time so that scores, which favour recent posts, stay stable from page to page.
+		before, limit, err := s.parsePagination(r)
+		if err != nil {
+			s.Log.Errorln(err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		resultsCh := make(chan []*models.PostResult)
+		errCh := make(chan error)
+
+		go func() {
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			results, err := s.DB.SearchPosts(query, before, limit+1, viewer)
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			resultsCh <- results
+			return
+
+		}()
+
+		select {
+		case <-ctx.Done():
+			s.Log.Errorln(ctx.Err())
+			http.Error(w, "We could not process your request at this time. Please try again later.", http.StatusRequestTimeout)
+			return
+		case err := <-errCh:
+			s.Log.Errorln(err)
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		case results := <-resultsCh:
+			//if there is another page then point the next cursor at the score of the last result sent.
+			var next *models.Cursor
+			if len(results) > limit {
+				results = results[:limit]
+				last := results[limit-1]
+				next = &models.Cursor{Created: before.Created, Score: last.Score, ID: last.ID}
+			}
+
+			err = s.writePage(w, r, results, limit, next)
+			if err != nil {
+				s.Log.Errorln(err)
+				http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+				return
+			}
+			return
+		}
+	}
+}
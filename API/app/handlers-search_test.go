@@ -0,0 +1,115 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/chiips/snippets/API/models"
+	hr "github.com/julienschmidt/httprouter"
+)
+
+//searchPostsFor runs a post search through the router and returns the results with the next cursor
+func searchPostsFor(t *testing.T, router *hr.Router, q, cursor string) ([]*models.PostResult, string) {
+
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "/api/search/posts?limit=1&q="+url.QueryEscape(q)+"&cursor="+cursor, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code for %q:\ngot: %v\nwant: %v", q, status, http.StatusOK)
+	}
+
+	results := []*models.PostResult{}
+	p := page{Data: &results}
+	if err := json.NewDecoder(rr.Body).Decode(&p); err != nil {
+		t.Fatal(err)
+	}
+
+	return results, p.NextCursor
+}
+
+func TestSearchPosts(t *testing.T) {
+
+	router := hr.New()
+	s := Server{DB: &mockDB{}, Router: router, Log: testLog}
+	s.Routes()
+
+	//a phrase matches one post and is highlighted
+	results, next := searchPostsFor(t, router, `"body 1"`, "")
+	if len(results) != 1 || results[0].ID != postID1 || next != "" {
+		t.Fatalf("phrase search returned wrong results: %v", results)
+	}
+
+	if want := "<mark>Body</mark> <mark>1</mark>"; results[0].Highlights.Body != want {
+		t.Errorf("phrase search returned wrong highlight:\ngot: %v\nwant: %v", results[0].Highlights.Body, want)
+	}
+
+	//a prefix matches both posts, one page at a time
+	seen := map[string]bool{}
+	cursor := ""
+	for i := 0; i < 2; i++ {
+		results, cursor = searchPostsFor(t, router, "bod*", cursor)
+		if len(results) != 1 {
+			t.Fatalf("prefix search returned wrong page %d: %v", i, results)
+		}
+		seen[results[0].ID.String()] = true
+	}
+
+	if !seen[postID1.String()] || !seen[postID2.String()] || cursor != "" {
+		t.Errorf("prefix search did not page through both posts: %v, next cursor %q", seen, cursor)
+	}
+
+	//editing a post re-indexes it
+	body, err := json.Marshal(&models.Post{ID: postID2, Title: "Post 2", Body: "Rewritten text", Author: models.User{ID: userID}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("PUT", "/api/post", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("If-Match", `"1"`)
+	authenticate(t, &s, req, userID)
+	router.ServeHTTP(rr, req)
+
+	if results, _ := searchPostsFor(t, router, "rewritten", ""); len(results) != 1 || results[0].ID != postID2 {
+		t.Errorf("search did not find the edited post: %v", results)
+	}
+
+	//deleting a post removes it from the index
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("DELETE", fmt.Sprintf("/api/post/%s", postID1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticate(t, &s, req, userID)
+	router.ServeHTTP(rr, req)
+
+	if results, _ := searchPostsFor(t, router, "body", ""); len(results) != 0 {
+		t.Errorf("search found edited or deleted content: %v", results)
+	}
+
+	//queries without any words are rejected
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("GET", "/api/search/posts?q=%22%22*", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code:\ngot: %v\nwant: %v", status, http.StatusBadRequest)
+	}
+
+}
@@ -0,0 +1,281 @@
+package app
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/chiips/snippets/API/models"
+	hr "github.com/julienschmidt/httprouter"
+	uuid "github.com/satori/go.uuid"
+)
+
+//listedSession is a session as listed to its user, marking the one the request came from
+type listedSession struct {
+	*models.Session
+	Current bool `json:"current"`
+}
+
+//sessions lists the current user's sessions, most recently seen first, so they can see where they are logged in
+func (s *Server) sessions() hr.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ hr.Params) {
+
+		ctx := r.Context()
+
+		currentUser, ok := ctx.Value(userContextKey).(uuid.UUID)
+		if !ok {
+			s.Log.Errorln("no userID in context")
+			http.Error(w, http.StatusText(500), http.StatusForbidden)
+			return
+		}
+
+		currentSession, _ := ctx.Value(sessionContextKey).(uuid.UUID)
+
+		sessionsCh := make(chan []*models.Session)
+		errCh := make(chan error)
+
+		go func() {
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			sessions, err := s.DB.UserSessions(currentUser, time.Now().UTC())
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			sessionsCh <- sessions
+			return
+
+		}()
+
+		select {
+		case <-ctx.Done():
+			s.Log.Errorln(ctx.Err())
+			http.Error(w, "We could not process your request at this time. Please try again later.", http.StatusRequestTimeout)
+			return
+		case err := <-errCh:
+			s.Log.Errorln("error listing sessions:", err)
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		case sessions := <-sessionsCh:
+			listed := []*listedSession{}
+			for _, session := range sessions {
+				listed = append(listed, &listedSession{Session: session, Current: uuid.Equal(session.ID, currentSession)})
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Cache-Control", "no-store")
+			err := json.NewEncoder(w).Encode(listed)
+			if err != nil {
+				s.Log.Errorln(err)
+			}
+			return
+		}
+	}
+}
+
+//deleteSession revokes one of the current user's sessions, logging that device out at once, or with the id "others",
+//every session but the current one.
+func (s *Server) deleteSession() hr.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps hr.Params) {
+
+		ctx := r.Context()
+
+		currentUser, ok := ctx.Value(userContextKey).(uuid.UUID)
+		if !ok {
+			s.Log.Errorln("no userID in context")
+			http.Error(w, http.StatusText(500), http.StatusForbidden)
+			return
+		}
+
+		currentSession, ok := ctx.Value(sessionContextKey).(uuid.UUID)
+		if !ok {
+			s.Log.Errorln("no session in context")
+			http.Error(w, http.StatusText(500), http.StatusForbidden)
+			return
+		}
+
+		others := ps.ByName("sessionid") == "others"
+
+		id, err := uuid.FromString(ps.ByName("sessionid"))
+		if err != nil && !others {
+			s.Log.Errorln(err)
+			http.Error(w, http.StatusText(404), http.StatusNotFound)
+			return
+		}
+
+		//how many sessions were revoked
+		revokedCh := make(chan int64)
+		errCh := make(chan error)
+
+		go func() {
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			if others {
+				revoked, err := s.DB.DeleteOtherSessions(currentUser, currentSession)
+				if err != nil {
+					errCh <- err
+					return
+				}
+				if ctx.Err() != nil {
+					return
+				}
+				revokedCh <- revoked
+				return
+			}
+
+			err := s.DB.DeleteSession(currentUser, id)
+			if err != nil && err != sql.ErrNoRows {
+				errCh <- err
+				return
+			}
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err == sql.ErrNoRows {
+				revokedCh <- 0
+				return
+			}
+
+			revokedCh <- 1
+			return
+
+		}()
+
+		select {
+		case <-ctx.Done():
+			s.Log.Errorln(ctx.Err())
+			http.Error(w, "We could not process your request at this time. Please try again later.", http.StatusRequestTimeout)
+			return
+		case err := <-errCh:
+			s.Log.Errorln("error revoking session:", err)
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		case revoked := <-revokedCh:
+			if others {
+				s.Log.WithField("ip", s.clientIP(r)).Infoln("other sessions revoked:", currentUser, revoked)
+				fmt.Fprintf(w, "%d other sessions revoked.", revoked)
+				return
+			}
+			if revoked == 0 {
+				s.Log.Errorln("no session to revoke:", id)
+				http.Error(w, http.StatusText(404), http.StatusNotFound)
+				return
+			}
+			s.Log.WithField("ip", s.clientIP(r)).Infoln("session revoked:", currentUser, id)
+			fmt.Fprint(w, "session revoked.")
+			return
+		}
+	}
+}
+
+//refreshSession sends the current user a new JWT in cookies for their session before their JWT expires, with their current roles.
+//Sessions are refreshed for up to sessionMaxAge after logging in; then the user must log in again.
+func (s *Server) refreshSession() hr.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ hr.Params) {
+
+		ctx := r.Context()
+
+		currentUser, ok := ctx.Value(userContextKey).(uuid.UUID)
+		if !ok {
+			s.Log.Errorln("no userID in context")
+			http.Error(w, http.StatusText(500), http.StatusForbidden)
+			return
+		}
+
+		currentSession, ok := ctx.Value(sessionContextKey).(uuid.UUID)
+		if !ok {
+			s.Log.Errorln("no session in context")
+			http.Error(w, http.StatusText(500), http.StatusForbidden)
+			return
+		}
+
+		rolesCh := make(chan []string)
+		expiredCh := make(chan bool)
+		errCh := make(chan error)
+
+		go func() {
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			session, err := s.DB.Session(currentSession)
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			now := time.Now().UTC()
+
+			if now.Sub(session.Created) >= sessionMaxAge {
+				if ctx.Err() != nil {
+					return
+				}
+				expiredCh <- true
+				return
+			}
+
+			//role changes reach the user as their session is refreshed
+			roles, err := s.DB.UserRoles(currentUser)
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			err = s.DB.RefreshSession(currentSession, now, now.Add(jwtLifetime))
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			s.audit(r, models.AuditSessionRefresh, currentUser, currentUser, currentSession.String())
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			rolesCh <- roles
+			return
+
+		}()
+
+		select {
+		case <-ctx.Done():
+			s.Log.Errorln(ctx.Err())
+			http.Error(w, "We could not process your request at this time. Please try again later.", http.StatusRequestTimeout)
+			return
+		case err := <-errCh:
+			s.Log.Errorln("error refreshing session:", err)
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		case <-expiredCh:
+			s.Log.Errorln("session too old to refresh:", currentUser, currentSession)
+			http.Error(w, "session expired. Please log in again.", http.StatusUnauthorized)
+			return
+		case roles := <-rolesCh:
+			err := s.setJWTCookies(w, currentUser, roles, currentSession)
+			if err != nil {
+				s.Log.Errorln(err)
+				http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+				return
+			}
+			fmt.Fprint(w, "session refreshed.")
+			return
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/chiips/snippets/API/models"
+	hr "github.com/julienschmidt/httprouter"
+)
+
+func TestSessions(t *testing.T) {
+
+	os.Setenv("jwt_key", "test-key")
+	os.Setenv("jwt_issuer", "test-issuer")
+
+	router := hr.New()
+	mdb := &mockDB{}
+	s := Server{DB: mdb, Router: router, Log: testLog, State: NewMemoryStore(), Passwords: testHasher}
+	s.Routes()
+
+	//send sends the body to the url from the device with the cookies
+	send := func(method, url string, cookies []*http.Cookie, body interface{}) *httptest.ResponseRecorder {
+		b, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest(method, url, bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	//login logs userID in from a device with the user agent and address, returning the JWT cookies
+	login := func(userAgent, addr string) []*http.Cookie {
+		b, err := json.Marshal(&models.User{Email: "user-1@example.com", Password: testPassword})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest("POST", "/api/login", bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("User-Agent", userAgent)
+		req.RemoteAddr = addr + ":4000"
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("login returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusOK)
+		}
+		return rr.Result().Cookies()
+	}
+
+	//list lists the sessions as the device with the cookies
+	list := func(cookies []*http.Cookie) []*listedSession {
+		rr := send("GET", "/api/sessions", cookies, nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("session list returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusOK)
+		}
+		sessions := []*listedSession{}
+		if err := json.NewDecoder(rr.Body).Decode(&sessions); err != nil {
+			t.Fatal(err)
+		}
+		return sessions
+	}
+
+	//each login starts a session, recording the device
+	laptop := login("Laptop Browser", "192.0.2.1")
+	phone := login("Phone Browser", "192.0.2.2")
+	tablet := login("Tablet Browser", "192.0.2.3")
+
+	sessions := list(laptop)
+	if len(sessions) != 3 {
+		t.Fatalf("session list wrong: %+v", sessions)
+	}
+	var current, phoneSession *listedSession
+	for _, session := range sessions {
+		if session.Current {
+			current = session
+		}
+		if session.UserAgent == "Phone Browser" {
+			phoneSession = session
+		}
+	}
+	if current == nil || current.UserAgent != "Laptop Browser" || current.IP != "192.0.2.1" || phoneSession == nil || phoneSession.IP != "192.0.2.2" || phoneSession.Current {
+		t.Fatalf("sessions recorded wrongly: %+v %+v", current, phoneSession)
+	}
+
+	//another user's sessions are neither listed nor revoked
+	req, err := http.NewRequest("GET", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticate(t, &s, req, otherUserID)
+	other := req.Cookies()
+	if sessions := list(other); len(sessions) != 1 {
+		t.Errorf("another user's session list wrong: %+v", sessions)
+	}
+	if rr := send("DELETE", "/api/sessions/"+phoneSession.ID.String(), other, nil); rr.Code != http.StatusNotFound {
+		t.Errorf("revoking another user's session returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusNotFound)
+	}
+
+	//revoking a session refuses its JWT at once, on authenticated and identified routes alike
+	if rr := send("DELETE", "/api/sessions/"+phoneSession.ID.String(), laptop, nil); rr.Code != http.StatusOK {
+		t.Fatalf("revoking session returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusOK)
+	}
+	if rr := send("GET", "/api/timeline", phone, nil); rr.Code != http.StatusUnauthorized {
+		t.Errorf("revoked session's JWT returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusUnauthorized)
+	}
+	if rr := send("GET", "/api/timeline", tablet, nil); rr.Code != http.StatusOK {
+		t.Errorf("other session's JWT returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusOK)
+	}
+	if rr := send("DELETE", "/api/sessions/"+phoneSession.ID.String(), laptop, nil); rr.Code != http.StatusNotFound {
+		t.Errorf("revoking revoked session returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusNotFound)
+	}
+
+	//refreshing keeps the session going with a new JWT
+	rr := send("POST", "/api/sessions/refresh", laptop, nil)
+	if rr.Code != http.StatusOK || len(rr.Result().Cookies()) != 2 {
+		t.Fatalf("refresh returned wrong response: %v %v", rr.Code, rr.Result().Cookies())
+	}
+	refreshed := rr.Result().Cookies()
+	if sessions := list(refreshed); len(sessions) != 2 || !sessions[0].Current && !sessions[1].Current {
+		t.Errorf("refreshed JWT not in the same session: %+v", sessions)
+	}
+
+	//users revoke every other session
+	rr = send("DELETE", "/api/sessions/others", refreshed, nil)
+	if rr.Code != http.StatusOK || rr.Body.String() != "1 other sessions revoked." {
+		t.Errorf("revoking other sessions returned wrong response: %v %q", rr.Code, rr.Body)
+	}
+	if rr := send("GET", "/api/timeline", tablet, nil); rr.Code != http.StatusUnauthorized {
+		t.Errorf("revoked session's JWT returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusUnauthorized)
+	}
+	if sessions := list(refreshed); len(sessions) != 1 || !sessions[0].Current {
+		t.Errorf("session list after revoking others wrong: %+v", sessions)
+	}
+
+	//sessions refresh for up to sessionMaxAge
+	mdb.sessions[current.ID].Created = time.Now().Add(-sessionMaxAge)
+	if rr := send("POST", "/api/sessions/refresh", refreshed, nil); rr.Code != http.StatusUnauthorized {
+		t.Errorf("refreshing old session returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusUnauthorized)
+	}
+
+	//logging out ends the session
+	if rr := send("POST", "/api/logout", refreshed, nil); rr.Code != http.StatusOK {
+		t.Fatalf("logout returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusOK)
+	}
+	if _, ok := mdb.sessions[current.ID]; ok {
+		t.Errorf("session not ended by logout")
+	}
+
+	//expired sessions refuse their JWT, even one not expired itself
+	for _, session := range mdb.sessions {
+		session.Expires = time.Now().Add(-time.Minute)
+	}
+	if rr := send("GET", "/api/sessions", other, nil); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expired session's JWT returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusUnauthorized)
+	}
+}
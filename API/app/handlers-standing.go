@@ -0,0 +1,228 @@
+package app
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/chiips/snippets/API/models"
+	hr "github.com/julienschmidt/httprouter"
+	uuid "github.com/satori/go.uuid"
+)
+
+//standing retrieves the suspension and shadow-ban state of the user in the url.
+func (s *Server) standing() hr.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps hr.Params) {
+
+		ctx := r.Context()
+
+		id, err := uuid.FromString(ps.ByName("userid"))
+		if err != nil {
+			s.Log.Errorln(err)
+			http.Error(w, http.StatusText(400), http.StatusBadRequest)
+			return
+		}
+
+		standingCh := make(chan *models.Standing)
+		errCh := make(chan error)
+
+		go func() {
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			standing, err := s.DB.AccountStanding(id)
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			standingCh <- standing
+			return
+
+		}()
+
+		select {
+		case <-ctx.Done():
+			s.Log.Errorln(ctx.Err())
+			http.Error(w, "We could not process your request at this time. Please try again later.", http.StatusRequestTimeout)
+			return
+		case err := <-errCh:
+			s.Log.Errorln(err)
+			if err == sql.ErrNoRows {
+				http.Error(w, http.StatusText(404), http.StatusNotFound)
+				return
+			}
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		case standing := <-standingCh:
+			w.Header().Set("Content-Type", "application/json")
+			err = json.NewEncoder(w).Encode(standing)
+			if err != nil {
+				s.Log.Errorln(err)
+				http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+				return
+			}
+			return
+		}
+	}
+}
+
+//suspend handles admins suspending the user in the url until the time given, replacing any suspension in place.
+//The user is refused at authentication from the next request on.
+func (s *Server) suspend() hr.Handle {
+	return s.changeStanding(models.ActionSuspend)
+}
+
+//unsuspend handles admins lifting the suspension of the user in the url.
+func (s *Server) unsuspend() hr.Handle {
+	return s.changeStanding(models.ActionUnsuspend)
+}
+
+//shadowban handles admins shadow-banning the user in the url, so their posts are shown to no one but themselves.
+func (s *Server) shadowban() hr.Handle {
+	return s.changeStanding(models.ActionShadowban)
+}
+
+//unshadowban handles admins lifting the shadow-ban of the user in the url.
+func (s *Server) unshadowban() hr.Handle {
+	return s.changeStanding(models.ActionUnshadowban)
+}
+
+//changeStanding applies a standing action to the user in the url as the current user, recording it with the moderator actions,
+//and responds with the user's updated standing. The optional body gives a note and, to suspend, the until time.
+func (s *Server) changeStanding(action string) hr.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps hr.Params) {
+
+		ctx := r.Context()
+
+		currentUser, ok := ctx.Value(userContextKey).(uuid.UUID)
+		if !ok {
+			s.Log.Errorln("no userID in context")
+			http.Error(w, http.StatusText(500), http.StatusForbidden)
+			return
+		}
+
+		if uuid.Equal(currentUser, uuid.Nil) {
+			s.Log.Errorln("userID came in with nil value.")
+			http.Error(w, http.StatusText(400), http.StatusBadRequest)
+			return
+		}
+
+		id, err := uuid.FromString(ps.ByName("userid"))
+		if err != nil {
+			s.Log.Errorln(err)
+			http.Error(w, http.StatusText(400), http.StatusBadRequest)
+			return
+		}
+
+		if !CanChangeStanding(currentUser, id) {
+			s.Log.Errorln("user tried to change their own standing")
+			http.Error(w, "cannot change your own standing", http.StatusForbidden)
+			return
+		}
+
+		submission := models.ModAction{}
+		err = json.NewDecoder(r.Body).Decode(&submission)
+		if err != nil && err != io.EOF {
+			s.Log.Errorln(err)
+			http.Error(w, http.StatusText(400), http.StatusBadRequest)
+			return
+		}
+
+		if utf8.RuneCountInString(submission.Note) > maxModNote {
+			s.Log.Errorln("invalid note")
+			http.Error(w, "invalid note", http.StatusBadRequest)
+			return
+		}
+
+		created := time.Now().UTC()
+
+		if action == models.ActionSuspend && (submission.Until == nil || !submission.Until.After(created)) {
+			s.Log.Errorln("invalid suspension")
+			http.Error(w, "invalid until: a suspension must end in the future", http.StatusBadRequest)
+			return
+		}
+		if action != models.ActionSuspend {
+			submission.Until = nil
+		}
+
+		actionID, err := uuid.NewV4()
+		if err != nil {
+			s.Log.Errorln(err)
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		}
+
+		change := &models.ModAction{ID: actionID, Moderator: currentUser, Subject: id, Action: action, Note: strings.TrimSpace(submission.Note), Until: submission.Until, Created: created}
+
+		standingCh := make(chan *models.Standing)
+		errCh := make(chan error)
+
+		go func() {
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			err := s.DB.SetStanding(change)
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			s.Log.WithField("ip", s.clientIP(r)).Infoln("standing change:", change.Moderator, change.Action, change.Subject)
+			s.audit(r, models.AuditModeration, change.Moderator, change.Subject, change.Action)
+
+			//reload the standing as changed
+			standing, err := s.DB.AccountStanding(id)
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			standingCh <- standing
+			return
+
+		}()
+
+		select {
+		case <-ctx.Done():
+			s.Log.Errorln(ctx.Err())
+			http.Error(w, "We could not process your request at this time. Please try again later.", http.StatusRequestTimeout)
+			return
+		case err := <-errCh:
+			s.Log.Errorln("error changing standing:", err)
+			if err == sql.ErrNoRows {
+				http.Error(w, http.StatusText(404), http.StatusNotFound)
+				return
+			}
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		case standing := <-standingCh:
+			w.Header().Set("Content-Type", "application/json")
+			err = json.NewEncoder(w).Encode(standing)
+			if err != nil {
+				s.Log.Errorln(err)
+				http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+				return
+			}
+			return
+		}
+	}
+}
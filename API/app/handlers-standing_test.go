@@ -0,0 +1,147 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/chiips/snippets/API/models"
+	hr "github.com/julienschmidt/httprouter"
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestStanding(t *testing.T) {
+
+	router := hr.New()
+	mdb := &mockDB{roles: map[uuid.UUID][]string{thirdUserID: {models.RoleAdmin}, otherUserID: {models.RoleModerator}}}
+	s := Server{DB: mdb, Router: router, Log: testLog}
+	s.Routes()
+
+	//send runs a request as the given user, anonymously for uuid.Nil, and returns the response
+	send := func(user uuid.UUID, method, url string, body interface{}) *httptest.ResponseRecorder {
+		b, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req, err := http.NewRequest(method, url, bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !uuid.Equal(user, uuid.Nil) {
+			authenticate(t, &s, req, user)
+		}
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	//count returns how many posts a list endpoint shows the viewer
+	count := func(viewer uuid.UUID, url string) int {
+		rr := send(viewer, "GET", url, nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%v returned wrong status code:\ngot: %v\nwant: %v", url, rr.Code, http.StatusOK)
+		}
+		posts := []*models.Post{}
+		if err := json.NewDecoder(rr.Body).Decode(&page{Data: &posts}); err != nil {
+			t.Fatal(err)
+		}
+		return len(posts)
+	}
+
+	suspensionURL := fmt.Sprintf("/api/admin/users/%s/suspension", userID)
+	shadowbanURL := fmt.Sprintf("/api/admin/users/%s/shadowban", userID)
+	postURL := fmt.Sprintf("/api/post/%s", postID1)
+	submission := &models.Post{Title: "Post", Body: "Body"}
+
+	//only admins manage standing, and not their own
+	for _, tc := range []struct {
+		user      uuid.UUID
+		method, u string
+		want      int
+	}{
+		{otherUserID, "PUT", shadowbanURL, http.StatusForbidden},
+		{thirdUserID, "PUT", fmt.Sprintf("/api/admin/users/%s/shadowban", thirdUserID), http.StatusForbidden},
+		{thirdUserID, "PUT", suspensionURL, http.StatusBadRequest},
+		{thirdUserID, "PUT", fmt.Sprintf("/api/admin/users/%s/shadowban", postID1), http.StatusNotFound},
+	} {
+		if rr := send(tc.user, tc.method, tc.u, nil); rr.Code != tc.want {
+			t.Errorf("%v %v returned wrong status code:\ngot: %v\nwant: %v", tc.method, tc.u, rr.Code, tc.want)
+		}
+	}
+
+	//a suspended user is refused at authentication with a still valid JWT, and browses anonymously
+	until := time.Now().Add(time.Hour).UTC()
+	rr := send(thirdUserID, "PUT", suspensionURL, &models.ModAction{Until: &until, Note: "cooling off"})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("suspend returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusOK)
+	}
+
+	standing := &models.Standing{}
+	if err := json.NewDecoder(rr.Body).Decode(standing); err != nil {
+		t.Fatal(err)
+	}
+	if !standing.Suspended(time.Now()) || !standing.SuspendedUntil.Equal(until) {
+		t.Errorf("suspend returned wrong standing: %+v", standing)
+	}
+
+	if rr := send(userID, "POST", "/api/post", submission); rr.Code != http.StatusForbidden {
+		t.Errorf("suspended user's post returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusForbidden)
+	}
+	if got := count(userID, "/api/posts"); got != 2 {
+		t.Errorf("suspended user saw wrong number of posts: %v", got)
+	}
+
+	if rr := send(thirdUserID, "DELETE", suspensionURL, nil); rr.Code != http.StatusOK {
+		t.Fatalf("unsuspend returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusOK)
+	}
+	if rr := send(userID, "POST", "/api/post", submission); rr.Code != http.StatusOK {
+		t.Errorf("unsuspended user's post returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusOK)
+	}
+
+	//a shadow-banned user's posts are shown only to themselves
+	if rr := send(thirdUserID, "PUT", shadowbanURL, nil); rr.Code != http.StatusOK {
+		t.Fatalf("shadowban returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusOK)
+	}
+
+	//search also finds the post submitted after the suspension was lifted
+	for viewer, want := range map[uuid.UUID]int{uuid.Nil: 0, otherUserID: 0, userID: 2} {
+		if got := count(viewer, "/api/posts"); got != want {
+			t.Errorf("feed showed %v wrong number of posts:\ngot: %v\nwant: %v", viewer, got, want)
+		}
+		if want > 0 {
+			want++
+		}
+		if got := count(viewer, "/api/search/posts?q=body"); got != want {
+			t.Errorf("search showed %v wrong number of posts:\ngot: %v\nwant: %v", viewer, got, want)
+		}
+	}
+
+	for viewer, want := range map[uuid.UUID]int{otherUserID: http.StatusNotFound, userID: http.StatusOK} {
+		if rr := send(viewer, "GET", postURL, nil); rr.Code != want {
+			t.Errorf("post returned wrong status code to %v:\ngot: %v\nwant: %v", viewer, rr.Code, want)
+		}
+	}
+
+	//the user is told of the suspension but not of the shadow-ban
+	rr = send(userID, "GET", "/api/moderation/notices", nil)
+	notices := []*models.ModAction{}
+	if err := json.NewDecoder(rr.Body).Decode(&page{Data: &notices}); err != nil {
+		t.Fatal(err)
+	}
+	if len(notices) != 2 || notices[0].Action != models.ActionUnsuspend || notices[1].Action != models.ActionSuspend {
+		t.Errorf("notices returned wrong actions: %v", notices)
+	}
+
+	if rr := send(thirdUserID, "DELETE", shadowbanURL, nil); rr.Code != http.StatusOK {
+		t.Fatalf("unshadowban returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusOK)
+	}
+	if got := count(otherUserID, "/api/posts"); got != 2 {
+		t.Errorf("feed showed wrong number of posts after the shadow-ban was lifted: %v", got)
+	}
+}
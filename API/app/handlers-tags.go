@@ -0,0 +1,157 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/chiips/snippets/API/models"
+	hr "github.com/julienschmidt/httprouter"
+	uuid "github.com/satori/go.uuid"
+)
+
+//trending tags weigh the posts of the last day, list up to ten tags, and may be cached by clients for a minute
+const (
+	trendingWindow = 24 * time.Hour
+	trendingLimit  = 10
+	trendingMaxAge = 60
+)
+
+//tagPosts retrieves one page of the posts using the tag in the url, most recent first.
+func (s *Server) tagPosts() hr.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps hr.Params) {
+
+		ctx := r.Context()
+
+		//the viewer is set by the identifyJWT middleware when logged in and is otherwise uuid.Nil.
+		viewer, _ := ctx.Value(userContextKey).(uuid.UUID)
+
+		//accept the tag with or without its # and in any case
+		tag, ok := models.NormalizeTag(ps.ByName("tag"))
+		if !ok {
+			s.Log.Errorln("invalid tag")
+			http.Error(w, "invalid tag", http.StatusBadRequest)
+			return
+		}
+
+		before, limit, err := s.parsePagination(r)
+		if err != nil {
+			s.Log.Errorln(err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		postsCh := make(chan []*models.Post)
+		errCh := make(chan error)
+
+		go func() {
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			posts, err := s.DB.TagPosts(tag, before, limit+1, viewer)
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			postsCh <- posts
+			return
+
+		}()
+
+		select {
+		case <-ctx.Done():
+			s.Log.Errorln(ctx.Err())
+			http.Error(w, "We could not process your request at this time. Please try again later.", http.StatusRequestTimeout)
+			return
+		case err := <-errCh:
+			s.Log.Errorln(err)
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		case posts := <-postsCh:
+			var next *models.Cursor
+			if len(posts) > limit {
+				posts = posts[:limit]
+				last := posts[limit-1]
+				next = &models.Cursor{Created: last.Created, ID: last.ID}
+			}
+
+			err = s.writePage(w, r, posts, limit, next)
+			if err != nil {
+				s.Log.Errorln(err)
+				http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+				return
+			}
+			return
+		}
+	}
+}
+
+//trendingTags retrieves the tags used most by recent posts.
+//It is routed as /api/tags/:tag because httprouter cannot hold the static /api/tags/trending beside /api/tags/:tag/posts,
+//so any other tag in that position is not found.
+func (s *Server) trendingTags() hr.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps hr.Params) {
+
+		ctx := r.Context()
+
+		if ps.ByName("tag") != "trending" {
+			http.NotFound(w, r)
+			return
+		}
+
+		tagsCh := make(chan []*models.TrendingTag)
+		errCh := make(chan error)
+
+		go func() {
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			tags, err := s.DB.TrendingTags(time.Now().UTC(), trendingWindow, trendingLimit)
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			tagsCh <- tags
+			return
+
+		}()
+
+		select {
+		case <-ctx.Done():
+			s.Log.Errorln(ctx.Err())
+			http.Error(w, "We could not process your request at this time. Please try again later.", http.StatusRequestTimeout)
+			return
+		case err := <-errCh:
+			s.Log.Errorln(err)
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		case tags := <-tagsCh:
+			w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", trendingMaxAge))
+			w.Header().Set("Content-Type", "application/json")
+			err := json.NewEncoder(w).Encode(tags)
+			if err != nil {
+				s.Log.Errorln(err)
+				http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+				return
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/chiips/snippets/API/models"
+	hr "github.com/julienschmidt/httprouter"
+	uuid "github.com/satori/go.uuid"
+)
+
+//tagFeed fetches the first page of a tag's posts through the router
+func tagFeed(t *testing.T, router *hr.Router, tag string) []*models.Post {
+
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "/api/tags/"+tag+"/posts", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code for %q:\ngot: %v\nwant: %v", tag, status, http.StatusOK)
+	}
+
+	posts := []*models.Post{}
+	if err := json.NewDecoder(rr.Body).Decode(&page{Data: &posts}); err != nil {
+		t.Fatal(err)
+	}
+
+	return posts
+}
+
+//trendingTags fetches the trending tags through the router
+func trendingTags(t *testing.T, router *hr.Router) []*models.TrendingTag {
+
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "/api/tags/trending", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code:\ngot: %v\nwant: %v", status, http.StatusOK)
+	}
+
+	trending := []*models.TrendingTag{}
+	if err := json.NewDecoder(rr.Body).Decode(&trending); err != nil {
+		t.Fatal(err)
+	}
+
+	return trending
+}
+
+func TestTags(t *testing.T) {
+
+	router := hr.New()
+	mdb := &mockDB{}
+	s := Server{DB: mdb, Router: router, Log: testLog}
+	s.Routes()
+
+	//submitting a post extracts its hashtags
+	body, err := json.Marshal(&models.Post{Title: "Tagged", Body: "Learning #Go today. #golang #go, not issue#1 or &#39;"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	req, err := http.NewRequest("POST", "/api/post", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticate(t, &s, req, userID)
+	router.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code:\ngot: %v\nwant: %v", status, http.StatusOK)
+	}
+
+	posts := tagFeed(t, router, "%23GO")
+	if len(posts) != 1 {
+		t.Fatalf("tag feed returned wrong number of posts: %v", len(posts))
+	}
+	if got := posts[0].Tags; len(got) != 2 || got[0] != "go" || got[1] != "golang" {
+		t.Errorf("post has wrong tags:\ngot: %v\nwant: %v", got, []string{"go", "golang"})
+	}
+
+	//editing the body re-syncs the tags, whatever tags the client sends
+	edited := posts[0]
+	edited.Body = "Still learning #golang"
+	edited.Tags = []string{"go"}
+	body, err = json.Marshal(edited)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr = httptest.NewRecorder()
+	req, err = http.NewRequest("PUT", "/api/post", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("If-Match", versionETag(edited.Version))
+	authenticate(t, &s, req, userID)
+	router.ServeHTTP(rr, req)
+
+	if posts := tagFeed(t, router, "go"); len(posts) != 0 {
+		t.Errorf("tag feed kept a post whose tag was edited out: %v", posts)
+	}
+	if posts := tagFeed(t, router, "golang"); len(posts) != 1 {
+		t.Errorf("tag feed lost a post that kept its tag: %v", posts)
+	}
+
+	//trending counts the tags still in use
+	trending := trendingTags(t, router)
+	if len(trending) != 1 || trending[0].Name != "golang" || trending[0].Posts != 1 {
+		t.Errorf("handler returned wrong trending tags: %v", trending)
+	}
+
+	//but not those of hidden posts or of posts by shadow-banned users
+	mdb.hidden = map[uuid.UUID]bool{edited.ID: true}
+	if trending := trendingTags(t, router); len(trending) != 0 {
+		t.Errorf("trending counted a hidden post: %v", trending)
+	}
+	mdb.hidden = nil
+	mdb.shadowbanned = map[uuid.UUID]bool{userID: true}
+	if trending := trendingTags(t, router); len(trending) != 0 {
+		t.Errorf("trending counted a shadow-banned user's post: %v", trending)
+	}
+	mdb.shadowbanned = nil
+
+	//invalid tags are rejected and other paths beside trending are not found
+	for path, want := range map[string]int{"/api/tags/1st/posts": http.StatusBadRequest, "/api/tags/golang": http.StatusNotFound} {
+		rr = httptest.NewRecorder()
+		req, err = http.NewRequest("GET", path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		router.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != want {
+			t.Errorf("handler returned wrong status code for %v:\ngot: %v\nwant: %v", path, status, want)
+		}
+	}
+}
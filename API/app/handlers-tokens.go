@@ -0,0 +1,285 @@
+package app
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/chiips/snippets/API/models"
+	hr "github.com/julienschmidt/httprouter"
+	uuid "github.com/satori/go.uuid"
+)
+
+//accessTokenRequest is the body of a request to create a personal access token: the name to show it by, the scopes it grants,
+//and how many days until it expires, 30 if left out
+type accessTokenRequest struct {
+	Name        string   `json:"name"`
+	Scopes      []string `json:"scopes"`
+	ExpiresDays int      `json:"expires_days"`
+}
+
+//createdAccessToken is the response creating a personal access token: the token as listed, and the token itself, shown only this once
+type createdAccessToken struct {
+	*models.AccessToken
+	Token string `json:"token"`
+}
+
+//createAccessToken creates a personal access token for the current user, sending the token once. Only its hash is stored.
+func (s *Server) createAccessToken() hr.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ hr.Params) {
+
+		ctx := r.Context()
+
+		currentUser, ok := ctx.Value(userContextKey).(uuid.UUID)
+		if !ok {
+			s.Log.Errorln("no userID in context")
+			http.Error(w, http.StatusText(500), http.StatusForbidden)
+			return
+		}
+
+		submission := accessTokenRequest{}
+		err := json.NewDecoder(r.Body).Decode(&submission)
+		if err != nil {
+			s.Log.Errorln(err)
+			http.Error(w, http.StatusText(400), http.StatusBadRequest)
+			return
+		}
+
+		name, ok := accessTokenName(submission.Name)
+		if !ok {
+			s.Log.Errorln("bad form request")
+			http.Error(w, "invalid token name", http.StatusBadRequest)
+			return
+		}
+
+		if !validScopes(submission.Scopes) {
+			s.Log.Errorln("bad form request")
+			http.Error(w, "invalid token scopes", http.StatusBadRequest)
+			return
+		}
+
+		lifetime := time.Duration(submission.ExpiresDays) * 24 * time.Hour
+		if submission.ExpiresDays == 0 {
+			lifetime = defaultTokenLifetime
+		}
+		if lifetime <= 0 || lifetime > maxTokenLifetime {
+			s.Log.Errorln("bad form request")
+			http.Error(w, fmt.Sprintf("tokens expire within %d days", int(maxTokenLifetime.Hours()/24)), http.StatusBadRequest)
+			return
+		}
+
+		createdCh := make(chan *createdAccessToken)
+		fullCh := make(chan bool)
+		errCh := make(chan error)
+
+		go func() {
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			tokens, err := s.DB.UserAccessTokens(currentUser)
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			if len(tokens) >= maxAccessTokens {
+				if ctx.Err() != nil {
+					return
+				}
+				fullCh <- true
+				return
+			}
+
+			token, hash, err := newAccessToken()
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			id, err := uuid.NewV4()
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			now := time.Now().UTC()
+			t := &models.AccessToken{
+				ID:      id,
+				User:    currentUser,
+				Name:    name,
+				Hash:    hash,
+				Scopes:  submission.Scopes,
+				Created: now,
+				Expires: now.Add(lifetime),
+			}
+
+			err = s.DB.CreateAccessToken(t)
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			createdCh <- &createdAccessToken{AccessToken: t, Token: token}
+			return
+
+		}()
+
+		select {
+		case <-ctx.Done():
+			s.Log.Errorln(ctx.Err())
+			http.Error(w, "We could not process your request at this time. Please try again later.", http.StatusRequestTimeout)
+			return
+		case err := <-errCh:
+			s.Log.Errorln("error creating access token:", err)
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		case <-fullCh:
+			s.Log.Errorln("too many access tokens:", currentUser)
+			http.Error(w, fmt.Sprintf("you can have at most %d access tokens. Please revoke one first.", maxAccessTokens), http.StatusConflict)
+			return
+		case created := <-createdCh:
+			s.Log.WithField("ip", s.clientIP(r)).Infoln("access token created:", currentUser, created.ID, created.Scopes)
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Cache-Control", "no-store")
+			w.WriteHeader(http.StatusCreated)
+			err = json.NewEncoder(w).Encode(created)
+			if err != nil {
+				s.Log.Errorln(err)
+			}
+			return
+		}
+	}
+}
+
+//accessTokens lists the current user's personal access tokens, oldest first, without the tokens themselves
+func (s *Server) accessTokens() hr.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ hr.Params) {
+
+		ctx := r.Context()
+
+		currentUser, ok := ctx.Value(userContextKey).(uuid.UUID)
+		if !ok {
+			s.Log.Errorln("no userID in context")
+			http.Error(w, http.StatusText(500), http.StatusForbidden)
+			return
+		}
+
+		tokensCh := make(chan []*models.AccessToken)
+		errCh := make(chan error)
+
+		go func() {
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			tokens, err := s.DB.UserAccessTokens(currentUser)
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			tokensCh <- tokens
+			return
+
+		}()
+
+		select {
+		case <-ctx.Done():
+			s.Log.Errorln(ctx.Err())
+			http.Error(w, "We could not process your request at this time. Please try again later.", http.StatusRequestTimeout)
+			return
+		case err := <-errCh:
+			s.Log.Errorln("error listing access tokens:", err)
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		case tokens := <-tokensCh:
+			w.Header().Set("Content-Type", "application/json")
+			err := json.NewEncoder(w).Encode(tokens)
+			if err != nil {
+				s.Log.Errorln(err)
+			}
+			return
+		}
+	}
+}
+
+//deleteAccessToken revokes one of the current user's personal access tokens, so it no longer authenticates
+func (s *Server) deleteAccessToken() hr.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps hr.Params) {
+
+		ctx := r.Context()
+
+		currentUser, ok := ctx.Value(userContextKey).(uuid.UUID)
+		if !ok {
+			s.Log.Errorln("no userID in context")
+			http.Error(w, http.StatusText(500), http.StatusForbidden)
+			return
+		}
+
+		id, err := uuid.FromString(ps.ByName("tokenid"))
+		if err != nil {
+			s.Log.Errorln(err)
+			http.Error(w, http.StatusText(404), http.StatusNotFound)
+			return
+		}
+
+		okCh := make(chan bool)
+		errCh := make(chan error)
+
+		go func() {
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			err := s.DB.DeleteAccessToken(currentUser, id)
+			if err != nil && err != sql.ErrNoRows {
+				errCh <- err
+				return
+			}
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			okCh <- err == nil
+			return
+
+		}()
+
+		select {
+		case <-ctx.Done():
+			s.Log.Errorln(ctx.Err())
+			http.Error(w, "We could not process your request at this time. Please try again later.", http.StatusRequestTimeout)
+			return
+		case err := <-errCh:
+			s.Log.Errorln("error revoking access token:", err)
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		case deleted := <-okCh:
+			if !deleted {
+				s.Log.Errorln("no access token to revoke:", id)
+				http.Error(w, http.StatusText(404), http.StatusNotFound)
+				return
+			}
+			s.Log.WithField("ip", s.clientIP(r)).Infoln("access token revoked:", currentUser, id)
+			fmt.Fprint(w, "access token revoked.")
+			return
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/csrf"
+	hr "github.com/julienschmidt/httprouter"
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestAccessTokens(t *testing.T) {
+
+	router := hr.New()
+	mdb := &mockDB{}
+	s := Server{DB: mdb, Router: router, Log: testLog, State: NewMemoryStore()}
+	s.Routes()
+
+	//send sends the body to the url as the user unless nil, and with the bearer token unless empty
+	send := func(method, url string, user uuid.UUID, token string, body interface{}) *httptest.ResponseRecorder {
+		b, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest(method, url, bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !uuid.Equal(user, uuid.Nil) {
+			authenticate(t, &s, req, user)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	//create creates a token for the user with the scopes, returning it
+	create := func(user uuid.UUID, scopes ...string) *createdAccessToken {
+		rr := send("POST", "/api/tokens", user, "", &accessTokenRequest{Name: "script", Scopes: scopes})
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("creating token returned wrong status code:\ngot: %v\nwant: %v %q", rr.Code, http.StatusCreated, rr.Body)
+		}
+		created := &createdAccessToken{}
+		if err := json.NewDecoder(rr.Body).Decode(created); err != nil {
+			t.Fatal(err)
+		}
+		return created
+	}
+
+	//tokens are shown once, and stored only as hashes
+	read := create(userID, ScopePostsRead, ScopeProfileRead)
+	if !strings.HasPrefix(read.Token, accessTokenPrefix) || len(read.Scopes) != 2 {
+		t.Fatalf("token created wrongly: %+v", read)
+	}
+	stored := mdb.accessTokens[read.ID]
+	if stored.Hash != accessTokenHash(read.Token) || strings.Contains(stored.Hash, read.Token) {
+		t.Errorf("token stored wrongly: %+v", stored)
+	}
+	if days := stored.Expires.Sub(stored.Created); days != defaultTokenLifetime {
+		t.Errorf("token expires after wrong lifetime:\ngot: %v\nwant: %v", days, defaultTokenLifetime)
+	}
+
+	rr := send("GET", "/api/tokens", userID, "", nil)
+	if strings.Contains(rr.Body.String(), read.Token) || strings.Contains(rr.Body.String(), stored.Hash) || !strings.Contains(rr.Body.String(), read.ID.String()) {
+		t.Errorf("token list wrong: %q", rr.Body)
+	}
+
+	for name, body := range map[string]*accessTokenRequest{
+		"no scopes":         {Name: "script"},
+		"unknown scope":     {Scopes: []string{"roles:manage"}},
+		"repeated scope":    {Scopes: []string{ScopePostsRead, ScopePostsRead}},
+		"too long lifetime": {Scopes: []string{ScopePostsRead}, ExpiresDays: 366},
+		"negative lifetime": {Scopes: []string{ScopePostsRead}, ExpiresDays: -1},
+		"too long name":     {Name: strings.Repeat("x", maxAccessTokenName+1), Scopes: []string{ScopePostsRead}},
+	} {
+		if rr := send("POST", "/api/tokens", userID, "", body); rr.Code != http.StatusBadRequest {
+			t.Errorf("token with %v returned wrong status code:\ngot: %v\nwant: %v", name, rr.Code, http.StatusBadRequest)
+		}
+	}
+
+	//tokens authenticate on the routes taking their scopes, and record their use
+	if rr := send("GET", "/api/timeline", uuid.Nil, read.Token, nil); rr.Code != http.StatusOK {
+		t.Errorf("token with scope returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusOK)
+	}
+	if mdb.accessTokens[read.ID].LastUsed == nil {
+		t.Errorf("token use not recorded")
+	}
+	if rr := send("PUT", "/api/profile/"+otherUserID.String()+"/follow", uuid.Nil, read.Token, nil); rr.Code != http.StatusForbidden {
+		t.Errorf("token without scope returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusForbidden)
+	}
+
+	write := create(userID, ScopeProfileWrite)
+	if rr := send("PUT", "/api/profile/"+otherUserID.String()+"/follow", uuid.Nil, write.Token, nil); rr.Code != http.StatusOK {
+		t.Errorf("token with scope returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusOK)
+	}
+	if !mdb.follows[[2]uuid.UUID{userID, otherUserID}] {
+		t.Errorf("follow with token not made as the token's user")
+	}
+
+	//routes without a scope take the JWT only, and cookies are not trusted alongside a token
+	for _, route := range [][2]string{{"GET", "/api/tokens"}, {"POST", "/api/tokens"}, {"DELETE", "/api/mfa/totp"}, {"PUT", "/api/profile/" + userID.String() + "/password"}} {
+		if rr := send(route[0], route[1], userID, read.Token, nil); rr.Code != http.StatusUnauthorized {
+			t.Errorf("token on %v returned wrong status code:\ngot: %v\nwant: %v", route, rr.Code, http.StatusUnauthorized)
+		}
+	}
+
+	//invalid tokens are refused rather than served anonymously
+	for name, token := range map[string]string{"unknown": accessTokenPrefix + "unknown", "malformed": "garbage", "JWT": "a.b.c"} {
+		if rr := send("GET", "/api/posts", uuid.Nil, token, nil); rr.Code != http.StatusUnauthorized {
+			t.Errorf("%v token returned wrong status code:\ngot: %v\nwant: %v", name, rr.Code, http.StatusUnauthorized)
+		}
+	}
+	if rr := send("GET", "/api/posts", uuid.Nil, read.Token, nil); rr.Code != http.StatusOK {
+		t.Errorf("token on public route returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusOK)
+	}
+
+	//expired tokens and tokens of suspended accounts are refused
+	mdb.accessTokens[write.ID].Expires = time.Now().Add(-time.Second)
+	if rr := send("PUT", "/api/profile/"+otherUserID.String()+"/follow", uuid.Nil, write.Token, nil); rr.Code != http.StatusUnauthorized {
+		t.Errorf("expired token returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusUnauthorized)
+	}
+	mdb.suspended = map[uuid.UUID]time.Time{userID: time.Now().Add(time.Hour)}
+	if rr := send("GET", "/api/timeline", uuid.Nil, read.Token, nil); rr.Code != http.StatusForbidden {
+		t.Errorf("suspended user's token returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusForbidden)
+	}
+	mdb.suspended = nil
+
+	//users revoke their own tokens only
+	if rr := send("DELETE", "/api/tokens/"+read.ID.String(), otherUserID, "", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("revoking another user's token returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusNotFound)
+	}
+	if rr := send("DELETE", "/api/tokens/"+read.ID.String(), userID, "", nil); rr.Code != http.StatusOK {
+		t.Errorf("revoking token returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusOK)
+	}
+	if rr := send("GET", "/api/timeline", uuid.Nil, read.Token, nil); rr.Code != http.StatusUnauthorized {
+		t.Errorf("revoked token returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestSkipBearerCSRF(t *testing.T) {
+
+	s := Server{Log: testLog}
+	protected := s.SkipBearerCSRF(csrf.Protect([]byte("01234567890123456789012345678901"), csrf.Secure(false))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+	//requests with a bearer token need no CSRF token; others still do
+	for header, want := range map[string]int{"Bearer " + accessTokenPrefix + "token": http.StatusOK, "": http.StatusForbidden, "Basic dXNlcjpwYXNz": http.StatusForbidden} {
+		req := httptest.NewRequest("POST", "http://example.com/api/post", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		rr := httptest.NewRecorder()
+		protected.ServeHTTP(rr, req)
+		if rr.Code != want {
+			t.Errorf("request with Authorization %q returned wrong status code:\ngot: %v\nwant: %v", header, rr.Code, want)
+		}
+	}
+}
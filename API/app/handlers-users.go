@@ -1,17 +1,20 @@
 package app
 
 import (
-	"context"
 	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"io"
+	"math"
 	"mime"
 	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 	"unicode"
@@ -19,25 +22,19 @@ import (
 	"github.com/chiips/snippets/API/models"
 	hr "github.com/julienschmidt/httprouter"
 	uuid "github.com/satori/go.uuid"
-	"golang.org/x/crypto/bcrypt"
 )
 
-//searchUsers checks the query parameter of the request and returns 10 results at a time
+//searchUsers checks the query parameter of the request and returns one page of results at a time
 func (s *Server) searchUsers() hr.Handle {
 	return func(w http.ResponseWriter, r *http.Request, _ hr.Params) {
 		ctx := r.Context()
 
-		//set limit of 10 results
-		limit := 10
-
-		//default prevDate is now to start at most recent result.
-		prevDate := time.Now().UTC().Format(time.RFC3339)
-
-		//if previous date is in the query then update prevDate to get results from that point in time back.
-		prevDateQuery, ok := r.URL.Query()["prev"]
-
-		if ok && strings.TrimSpace(prevDateQuery[0]) != "" && len(prevDateQuery[0]) >= 1 {
-			prevDate = prevDateQuery[0]
+		//read the cursor and limit. without a cursor start at the most recent result.
+		before, limit, err := s.parsePagination(r)
+		if err != nil {
+			s.Log.Errorln(err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
 
 		//check the query
@@ -53,17 +50,8 @@ func (s *Server) searchUsers() hr.Handle {
 			return
 		}
 
-		//escape Postgres wildcard characters
-		if strings.Contains(query, "%") {
-			query = strings.Replace(query, "%", "\\%", -1)
-		}
-
-		if strings.Contains(query, "_") {
-			query = strings.Replace(query, "_", "\\_", -1)
-		}
-
 		//create a usersCh to communicate results and an error channe to communicate errors
-		usersCh := make(chan []*models.User)
+		usersCh := make(chan []*models.UserResult)
 		errCh := make(chan error)
 
 		//send a separate goroutine to search the database.
@@ -75,7 +63,7 @@ func (s *Server) searchUsers() hr.Handle {
 			}
 
 			//call the database
-			users, err := s.DB.SearchUsers(query, prevDate, limit)
+			users, err := s.DB.SearchUsers(query, before, limit+1)
 
 			//check if the request context is cancelled by the time we're done searching the database.
 			if ctx.Err() != nil {
@@ -108,9 +96,16 @@ func (s *Server) searchUsers() hr.Handle {
 			return
 		//3. success
 		case users := <-usersCh:
+			//if there is another page then point the next cursor at the score and id of the last user sent.
+			var next *models.Cursor
+			if len(users) > limit {
+				users = users[:limit]
+				last := users[limit-1]
+				next = &models.Cursor{Created: before.Created, Score: last.Score, ID: last.ID}
+			}
+
 			//send the results as JSON data to the client
-			w.Header().Set("Content-Type", "application/json")
-			err = json.NewEncoder(w).Encode(users)
+			err = s.writePage(w, r, users, limit, next)
 			if err != nil {
 				s.Log.Errorln(err)
 				http.Error(w, http.StatusText(500), http.StatusInternalServerError)
@@ -121,8 +116,102 @@ func (s *Server) searchUsers() hr.Handle {
 	}
 }
 
+//number of users autocomplete suggests and how long clients and shared caches may keep the suggestions
+const (
+	autocompleteLimit  = 8
+	autocompleteMaxAge = 300
+)
+
+//mention is the small view of a user autocomplete sends for typeahead
+type mention struct {
+	ID     uuid.UUID `json:"id"`
+	Name   string    `json:"name"`
+	Avatar string    `json:"avatar"`
+}
+
+//autocompleteUsers suggests the users whose names start with the query parameter for @-mention typeahead.
+//Suggestions are public and change rarely so they carry a long Cache-Control and an ETag for conditional requests.
+func (s *Server) autocompleteUsers() hr.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ hr.Params) {
+		ctx := r.Context()
+
+		prefix := strings.TrimSpace(r.URL.Query().Get("q"))
+		if prefix == "" {
+			s.Log.Errorln("invalid autocomplete query")
+			http.Error(w, http.StatusText(400), http.StatusBadRequest)
+			return
+		}
+
+		usersCh := make(chan []*models.User)
+		errCh := make(chan error)
+
+		go func() {
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			users, err := s.DB.AutocompleteUsers(prefix, autocompleteLimit)
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			usersCh <- users
+			return
+
+		}()
+
+		select {
+		case <-ctx.Done():
+			s.Log.Errorln(ctx.Err())
+			http.Error(w, "We could not process your request at this time. Please try again later.", http.StatusRequestTimeout)
+			return
+		case err := <-errCh:
+			s.Log.Errorln(err)
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		case users := <-usersCh:
+			mentions := []mention{}
+			for _, user := range users {
+				mentions = append(mentions, mention{ID: user.ID, Name: user.Name, Avatar: user.Avatar})
+			}
+
+			body, err := json.Marshal(mentions)
+			if err != nil {
+				s.Log.Errorln(err)
+				http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+				return
+			}
+
+			//tag the suggestions by their content so unchanged suggestions are not sent again
+			sum := sha256.Sum256(body)
+			etag := fmt.Sprintf("\"%x\"", sum[:16])
+
+			w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", autocompleteMaxAge))
+			w.Header().Set("ETag", etag)
+
+			if r.Header.Get("If-None-Match") == etag {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			_, err = w.Write(body)
+			if err != nil {
+				s.Log.Errorln(err)
+			}
+			return
+		}
+	}
+}
+
 //signup checks a new account request and logs the user in
-//login handler would perform similar checks and send tokens in cookies. logout handler would delete those cookies.
 func (s *Server) signup() hr.Handle {
 	return func(w http.ResponseWriter, r *http.Request, _ hr.Params) {
 
@@ -174,6 +263,13 @@ func (s *Server) signup() hr.Handle {
 			return
 		}
 
+		//check the password has not appeared in a data breach
+		if s.passwordIsCompromised(password) {
+			s.Log.Errorln("compromised password")
+			http.Error(w, msgCompromisedPassword, http.StatusBadRequest)
+			return
+		}
+
 		//check that the email is not already in use
 		exists, err := s.DB.EmailCheck(email)
 		if err != nil {
@@ -203,13 +299,12 @@ func (s *Server) signup() hr.Handle {
 		}
 
 		//hash the password
-		bs, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+		pwd, err := s.hasher().Hash(password)
 		if err != nil {
 			s.Log.Errorln(err)
 			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
 			return
 		}
-		pwd := string(bs)
 
 		//create uuid for user
 		id, err := uuid.NewV4()
@@ -250,6 +345,8 @@ func (s *Server) signup() hr.Handle {
 				return
 			}
 
+			s.audit(r, models.AuditSignup, user.ID, user.ID, "")
+
 			okCh <- true
 			return
 
@@ -265,34 +362,13 @@ func (s *Server) signup() hr.Handle {
 			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
 			return
 		case <-okCh:
-			//create a JWT, split into headerpaylod and signature, and put each into cookies.
-			headerpayload, signature, err := s.createJWT(user.ID)
+			//start a session and create its JWT, split into headerpaylod and signature, and put each into cookies.
+			err := s.startSession(w, r, user.ID, user.Roles)
 			if err != nil {
 				s.Log.Errorln(err)
 				http.Error(w, http.StatusText(500), http.StatusInternalServerError)
 				return
 			}
-			//header and payload in non-HttpOnly cookie
-			c1 := &http.Cookie{
-				Name:     "token-hp",
-				Value:    headerpayload,
-				Secure:   true, //for testing over http, set Secure to false.
-				Path:     "/",
-				MaxAge:   0,
-				SameSite: http.SameSiteDefaultMode,
-			}
-			http.SetCookie(w, c1)
-			//signature in HttpOnly cookie
-			c2 := &http.Cookie{
-				Name:     "token-s",
-				Value:    signature,
-				Secure:   true,
-				HttpOnly: true,
-				Path:     "/",
-				MaxAge:   0,
-				SameSite: http.SameSiteDefaultMode,
-			}
-			http.SetCookie(w, c2)
 			fmt.Fprint(w, "account created!")
 			return
 		}
@@ -300,6 +376,48 @@ func (s *Server) signup() hr.Handle {
 	}
 }
 
+//logout handles users logging out: their session ends and their JWT is revoked until it expires, so it is refused even if the cookies survive,
+//and the cookies are deleted.
+//It reads the JWT itself rather than going through authenticateJWT so suspended users can still log out.
+func (s *Server) logout() hr.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps hr.Params) {
+
+		claims, status := s.parseJWT(r)
+		if status != http.StatusOK {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+
+		err := s.revokeJWT(claims)
+		if err != nil {
+			s.Log.Errorln("error revoking JWT:", err)
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		}
+
+		//the session may already have been revoked from another device
+		err = s.DB.DeleteSession(claims.ID, claims.Session)
+		if err != nil && err != sql.ErrNoRows {
+			s.Log.Errorln("error ending session:", err)
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		}
+
+		//delete the JWT cookies
+		for _, name := range []string{"token-hp", "token-s"} {
+			http.SetCookie(w, &http.Cookie{
+				Name:   name,
+				Value:  "",
+				Path:   "/",
+				MaxAge: -1,
+			})
+		}
+
+		fmt.Fprint(w, "logged out.")
+		return
+	}
+}
+
 //passwordIsValid checks that the proposed password meets the following criteria.
 func passwordIsValid(s string) bool {
 
@@ -323,6 +441,219 @@ func passwordIsValid(s string) bool {
 	return minLen && upper && lower && number && special
 }
 
+//msgCompromisedPassword is sent for new passwords found in the breached password filter.
+//It begins with "compromised password" for clients to tell it from other invalid passwords.
+const msgCompromisedPassword = "compromised password: this password has appeared in a data breach. Please choose another."
+
+//passwordIsCompromised reports whether the password is in the server's breached password filter, if it has one.
+//The filter is loaded from a file at startup, so checking makes no network calls.
+func (s *Server) passwordIsCompromised(password string) bool {
+	return s.Breached != nil && s.Breached.Compromised(password)
+}
+
+//passwordChange is the body of a request to change a user's password
+type passwordChange struct {
+	Current  string `json:"current"`
+	Password string `json:"password"`
+}
+
+//changePassword handles users changing their password, given their current one.
+//The new password must be valid as at signup and not have appeared in a data breach.
+//Wrong current passwords count as failed logins to the account, so they are throttled and lock it as at login. See loginguard.go.
+//Changing the password revokes the user's other sessions, keeping the one it was changed in.
+func (s *Server) changePassword() hr.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps hr.Params) {
+
+		ctx := r.Context()
+
+		currentUser, ok := ctx.Value(userContextKey).(uuid.UUID)
+		if !ok {
+			s.Log.Errorln("no userID in context")
+			http.Error(w, http.StatusText(500), http.StatusForbidden)
+			return
+		}
+
+		currentSession, ok := ctx.Value(sessionContextKey).(uuid.UUID)
+		if !ok {
+			s.Log.Errorln("no session in context")
+			http.Error(w, http.StatusText(500), http.StatusForbidden)
+			return
+		}
+
+		urlID := ps.ByName("userid")
+
+		if urlID == "" {
+			s.Log.Errorln("userid came in with zero value.")
+			http.Error(w, http.StatusText(404), http.StatusNotFound)
+			return
+		}
+
+		id, err := uuid.FromString(urlID)
+		if err != nil {
+			s.Log.Errorln(err)
+			http.Error(w, http.StatusText(400), http.StatusBadRequest)
+			return
+		}
+
+		if !CanEditProfile(currentUser, id) {
+			s.Log.Errorln("forbidden request.")
+			http.Error(w, http.StatusText(403), http.StatusForbidden)
+			return
+		}
+
+		change := passwordChange{}
+		err = json.NewDecoder(r.Body).Decode(&change)
+		if err != nil {
+			s.Log.Errorln(err)
+			http.Error(w, http.StatusText(400), http.StatusBadRequest)
+			return
+		}
+
+		if !passwordIsValid(change.Password) {
+			s.Log.Errorln("invalid password")
+			http.Error(w, "invalid password", http.StatusBadRequest)
+			return
+		}
+
+		if s.passwordIsCompromised(change.Password) {
+			s.Log.Errorln("compromised password")
+			http.Error(w, msgCompromisedPassword, http.StatusBadRequest)
+			return
+		}
+
+		ip := s.clientIP(r)
+
+		okCh := make(chan bool)
+		waitCh := make(chan time.Duration)
+		errCh := make(chan error)
+
+		go func() {
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			user, err := s.DB.UserByID(id)
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			attempt, wait, err := s.loginReserve(user.Email, ip)
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			if wait > 0 {
+				if ctx.Err() != nil {
+					return
+				}
+				waitCh <- wait
+				return
+			}
+
+			hash, err := s.DB.UserPassword(id)
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			valid, err := s.hasher().Verify(change.Current, hash)
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			if !valid {
+				//count the failure even if the request has timed out
+				failures, locked, err := s.loginFailed(attempt)
+				if err != nil {
+					errCh <- err
+					return
+				}
+
+				if locked {
+					s.Log.Errorln("login locked after wrong current passwords from:", ip)
+					s.lockedOut(user, ip, failures)
+				}
+
+				if ctx.Err() != nil {
+					return
+				}
+				okCh <- false
+				return
+			}
+
+			err = s.loginPassed(attempt)
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			err = s.loginSucceeded(user.Email)
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			hash, err = s.hasher().Hash(change.Password)
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			err = s.DB.UpdatePassword(id, hash)
+
+			//no checking context again here: once the update reached the database the password has changed.
+
+			if err != nil {
+				errCh <- err
+				return
+			}
+
+			s.audit(r, models.AuditPasswordChange, currentUser, id, "")
+
+			//log out every other device, which may be where the old password was used
+			revoked, err := s.DB.DeleteOtherSessions(currentUser, currentSession)
+			if err != nil {
+				errCh <- err
+				return
+			}
+			s.Log.WithField("ip", ip).Infoln("other sessions revoked after password change:", currentUser, revoked)
+
+			okCh <- true
+			return
+
+		}()
+
+		select {
+		case <-ctx.Done():
+			s.Log.Errorln(ctx.Err())
+			http.Error(w, "We could not process your request at this time. Please try again later.", http.StatusRequestTimeout)
+			return
+		case err := <-errCh:
+			s.Log.Errorln("error changing password:", err)
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		case wait := <-waitCh:
+			s.Log.Errorln("password change too soon after failed logins from:", ip)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, msgThrottledLogin, http.StatusTooManyRequests)
+			return
+		case changed := <-okCh:
+			if !changed {
+				s.Log.Errorln("wrong current password")
+				http.Error(w, "incorrect current password", http.StatusForbidden)
+				return
+			}
+			fmt.Fprint(w, "password changed.")
+			return
+		}
+
+	}
+}
+
 //set the maximum upload size of the image: 1MB
 const maxUploadSize = 1024 * 1024
 
@@ -366,16 +697,42 @@ func (s *Server) editProfilePhoto() hr.Handle {
 			return
 		}
 
-		//confirm currentUser equals url id. if not then this request is forbidden.
-		if !uuid.Equal(currentUser, id) {
+		//confirm the current user may edit this profile. if not then this request is forbidden.
+		if !CanEditProfile(currentUser, id) {
 			s.Log.Errorln("forbidden request.")
 			http.Error(w, http.StatusText(403), http.StatusForbidden)
 			return
 		}
 
+		//refuse a stale edit before replacing the stored avatar
+		profile, err := s.DB.UserProfile(id, currentUser)
+		if err != nil {
+			s.Log.Errorln(err)
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		}
+
+		//the edit must name the profile version it was made to so it cannot overwrite an edit saved since
+		version, err := ifMatchVersion(r, profile.Version)
+		if err != nil {
+			s.Log.Errorln(err)
+			http.Error(w, err.Error(), http.StatusPreconditionRequired)
+			return
+		}
+
+		if profile.Version != version {
+			s.Log.Errorln("stale profile edit")
+			err = writeStale(w, profile.Version, profile)
+			if err != nil {
+				s.Log.Errorln(err)
+			}
+			return
+		}
+
 		//start building user
 		user := &models.User{}
 		user.ID = id
+		user.Version = version
 
 		//validate file size
 		r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
@@ -443,16 +800,13 @@ func (s *Server) editProfilePhoto() hr.Handle {
 		user.Avatar = avatarName
 		user.Updated = time.Now().UTC()
 
-		//go add to file system and database
+		//store the file first under its new name, next to the current avatar, and only then update the profile,
+		//so the stored avatar always names a file on disk: a failed or stale update removes the new file,
+		//and a successful one removes the old.
 
-		//create two done channels, one for each task.
-		doneCh := make(chan bool, 2)
+		doneCh := make(chan bool)
 		errCh := make(chan error)
 
-		//set handler context with cancel
-		handlerCtx, cancel := context.WithCancel(context.Background())
-		defer cancel()
-
 		go func() {
 
 			//check cancelled request.
@@ -460,45 +814,32 @@ func (s *Server) editProfilePhoto() hr.Handle {
 				return
 			}
 
-			//check if the second task fails.
-			if handlerCtx.Err() != nil {
-				return
-			}
-
 			//create and store the file on the server
 			err := createFile(mf, avatarName, currentUser)
-
-			//if there's an error then also cancel the context to halt the second task.
 			if err != nil {
-				//send the error on the error channel first to send an error to the client instead of cancelled message.
 				errCh <- err
-				cancel()
 				return
 			}
 
-			doneCh <- true
-			return
-
-		}()
-
-		go func() {
+			err = s.DB.UpdateUserPhoto(user)
 
-			//check cancelled request
-			if ctx.Err() != nil {
+			//if there's an error then the new file is never used.
+			if err != nil {
+				if rmErr := removeAvatar(currentUser, avatarName); rmErr != nil {
+					s.Log.Errorln("error removing unused avatar:", rmErr)
+				}
+				errCh <- err
 				return
 			}
 
-			//check if the second task fails.
-			if handlerCtx.Err() != nil {
-				return
-			}
+			s.audit(r, models.AuditAvatarChange, currentUser, id, user.Avatar)
 
-			err = s.DB.UpdateUserPhoto(user)
+			//the old avatar is no longer named by the profile. a failure to remove it does not fail the request
+			if rmErr := removeOtherAvatars(currentUser, avatarName); rmErr != nil {
+				s.Log.Errorln("error removing old avatar:", rmErr)
+			}
 
-			//if there's an error then also cancel the context to halt the first task.
-			if err != nil {
-				errCh <- err
-				cancel()
+			if ctx.Err() != nil {
 				return
 			}
 
@@ -508,33 +849,42 @@ func (s *Server) editProfilePhoto() hr.Handle {
 		}()
 
 		//listen for three options:
-		doneTasks := 0
-		for {
-			select {
-			//1. context cancelled
-			case <-ctx.Done():
-				s.Log.Errorln(ctx.Err())
-				http.Error(w, "We could not process your request at this time. Please try again later.", http.StatusRequestTimeout)
-				return
-			//2. error occurred
-			case err := <-errCh:
+		select {
+		//1. context cancelled
+		case <-ctx.Done():
+			s.Log.Errorln(ctx.Err())
+			http.Error(w, "We could not process your request at this time. Please try again later.", http.StatusRequestTimeout)
+			return
+		//2. error occurred
+		case err := <-errCh:
+			s.Log.Errorln(err)
+			//the profile was edited after the check above so send the current copy
+			if err == models.ErrVersionConflict {
+				profile, err := s.DB.UserProfile(id, currentUser)
+				if err != nil {
+					s.Log.Errorln(err)
+					http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+					return
+				}
+				err = writeStale(w, profile.Version, profile)
+				if err != nil {
+					s.Log.Errorln(err)
+				}
+				return
+			}
+			http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+			return
+		//3. file stored and profile updated
+		case <-doneCh:
+			w.Header().Set("ETag", versionETag(user.Version))
+			w.Header().Set("Content-Type", "application/json")
+			err = json.NewEncoder(w).Encode(user)
+			if err != nil {
 				s.Log.Errorln(err)
 				http.Error(w, http.StatusText(500), http.StatusInternalServerError)
 				return
-			//3. both tasks were completed successfully
-			case <-doneCh:
-				doneTasks++
-				if doneTasks == 2 {
-					w.Header().Set("Content-Type", "application/json")
-					err = json.NewEncoder(w).Encode(user)
-					if err != nil {
-						s.Log.Errorln(err)
-						http.Error(w, http.StatusText(500), http.StatusInternalServerError)
-						return
-					}
-					return
-				}
 			}
+			return
 		}
 
 	}
@@ -552,21 +902,12 @@ func createFile(mf multipart.File, avatarName string, currentUser uuid.UUID) err
 	//set a folder path including the id of the current user. each user gets their own folder.
 	folderPath := fmt.Sprintf("private/assets/%s", currentUser)
 	//check the status of the folder path. if it doesn't exist then create it.
+	//if it does the user has uploaded an avatar before, which stays until the profile names the new one.
 	if _, err := os.Stat(folderPath); os.IsNotExist(err) {
 		err = os.MkdirAll(folderPath, os.ModePerm)
 		if err != nil {
 			return err
 		}
-	} else {
-		//the user already has a path, i.e., they've already uploaded a unique avatar before.
-		oldPath := filepath.Join(wd, folderPath)
-
-		//remove the contents of existing path using a helper function
-		err := removeContents(oldPath)
-		if err != nil {
-			return err
-		}
-
 	}
 
 	//create the new file path
@@ -584,6 +925,31 @@ func createFile(mf multipart.File, avatarName string, currentUser uuid.UUID) err
 	return nil
 }
 
+//removeAvatar removes the named avatar from the user's folder.
+func removeAvatar(currentUser uuid.UUID, avatarName string) error {
+	return os.Remove(filepath.Join("private/assets", currentUser.String(), avatarName))
+}
+
+//removeOtherAvatars removes every file in the user's folder but the named avatar.
+func removeOtherAvatars(currentUser uuid.UUID, avatarName string) error {
+	dir := filepath.Join("private/assets", currentUser.String())
+
+	names, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if filepath.Base(name) == avatarName {
+			continue
+		}
+		err = os.RemoveAll(name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 //removeContents clears all files in the given directory.
 func removeContents(dir string) error {
 	//open the directory
@@ -636,7 +1002,7 @@ func (s *Server) deleteUser() hr.Handle {
 			return
 		}
 
-		if !uuid.Equal(currentUser, id) {
+		if !CanDeleteAccount(currentUser, id) {
 			s.Log.Errorln("forbidden request.")
 			http.Error(w, http.StatusText(403), http.StatusForbidden)
 			return
@@ -684,6 +1050,8 @@ func (s *Server) deleteUser() hr.Handle {
 			return
 		}
 
+		s.audit(r, models.AuditAccountDelete, currentUser, id, "")
+
 		//delete any cookies
 		for _, cookie := range r.Cookies() {
 
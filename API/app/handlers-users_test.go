@@ -1,14 +1,21 @@
 package app
 
 import (
+	"bytes"
+	"crypto/sha1"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/chiips/snippets/API/breach"
 	"github.com/chiips/snippets/API/models"
 	hr "github.com/julienschmidt/httprouter"
+	uuid "github.com/satori/go.uuid"
 )
 
 func TestSearchUsers(t *testing.T) {
@@ -49,20 +56,197 @@ func TestSearchUsers(t *testing.T) {
 
 	//check what is actually returned
 	got := []*models.User{}
-	if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+	if err := json.NewDecoder(rr.Body).Decode(&page{Data: &got}); err != nil {
 		t.Fatal(err)
 	}
 
+	//the exact match ranks first, followed by the similar names
+	if len(got) != 3 {
+		t.Fatalf("handler returned wrong number of users:\ngot: %v\nwant: %v", len(got), 3)
+	}
+
 	//compare results
-	same, gw := compareUserList(got, want)
+	same, gw := compareUserList(got[:1], want)
+
+	if !same {
+		t.Error(gw)
+	}
+
+}
+
+func TestAutocompleteUsers(t *testing.T) {
+
+	//set up router and server
+	router := hr.New()
+	s := Server{DB: &mockDB{}, Router: router, Log: testLog}
+	s.Routes()
+
+	req, err := http.NewRequest("GET", "/api/users/autocomplete?q=user-", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("handler returned wrong status code:\ngot: %v\n want: %v", status, http.StatusOK)
+	}
+
+	if cache := rr.Header().Get("Cache-Control"); cache != "public, max-age=300" {
+		t.Errorf("handler returned wrong Cache-Control header:\ngot: %v\nwant: %v", cache, "public, max-age=300")
+	}
+
+	got := []*models.User{}
+	if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
 
+	want := []*models.User{
+		{ID: userID, Name: "User-1", Avatar: "sailboat.jpg"},
+		{ID: otherUserID, Name: "User-2", Avatar: "sailboat.jpg"},
+		{ID: thirdUserID, Name: "User-3", Avatar: "sailboat.jpg"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("handler returned wrong number of users:\ngot: %v\nwant: %v", len(got), len(want))
+	}
+	same, gw := compareUserList(got, want)
 	if !same {
 		t.Error(gw)
 	}
 
+	//asking again with the ETag gets no body
+	etag := rr.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("handler returned no ETag")
+	}
+
+	req.Header.Set("If-None-Match", etag)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusNotModified {
+		t.Errorf("handler returned wrong status code:\ngot: %v\n want: %v", status, http.StatusNotModified)
+	}
+	if rr.Body.Len() != 0 {
+		t.Errorf("handler returned a body with 304: %v", rr.Body.String())
+	}
+
+	//names that do not start with the query are not suggested
+	req, err = http.NewRequest("GET", "/api/users/autocomplete?q=ser", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	got = []*models.User{}
+	if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Errorf("handler suggested users not starting with the query: %v", len(got))
+	}
 }
 
 //compareUserList compares got vs want for a collection of posts
+func TestChangePassword(t *testing.T) {
+
+	router := hr.New()
+	mdb := &mockDB{}
+	breached := breach.NewFilter(10, 0.001)
+	breached.Add(sha1.Sum([]byte("Breached1!")))
+	s := Server{DB: mdb, Router: router, Log: testLog, Passwords: testHasher, Breached: breached, State: NewMemoryStore()}
+	s.Routes()
+
+	//send sends the body to the url, as the given user unless nil
+	send := func(method, url string, id uuid.UUID, body interface{}) *httptest.ResponseRecorder {
+		b, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest(method, url, bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !uuid.Equal(id, uuid.Nil) {
+			authenticate(t, &s, req, id)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	url := "/api/profile/" + userID.String() + "/password"
+
+	//elsewhere is logged in to the user's account on another device
+	elsewhere, err := http.NewRequest("GET", "/api/sessions", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticate(t, &s, elsewhere, userID)
+
+	tests := []struct {
+		name   string
+		user   uuid.UUID
+		change passwordChange
+		status int
+		body   string
+	}{
+		{"another user's password", otherUserID, passwordChange{Current: testPassword, Password: "NewPassword1!"}, http.StatusForbidden, "Forbidden\n"},
+		{"invalid password", userID, passwordChange{Current: testPassword, Password: "short"}, http.StatusBadRequest, "invalid password\n"},
+		{"compromised password", userID, passwordChange{Current: testPassword, Password: "Breached1!"}, http.StatusBadRequest, msgCompromisedPassword + "\n"},
+		{"wrong current password", userID, passwordChange{Current: "Wrong1!", Password: "NewPassword1!"}, http.StatusForbidden, "incorrect current password\n"},
+		{"changed", userID, passwordChange{Current: testPassword, Password: "NewPassword1!"}, http.StatusOK, "password changed."},
+	}
+
+	for _, test := range tests {
+		rr := send("PUT", url, test.user, test.change)
+		if rr.Code != test.status || rr.Body.String() != test.body {
+			t.Errorf("%v returned wrong response:\ngot: %v %q\nwant: %v %q", test.name, rr.Code, rr.Body, test.status, test.body)
+		}
+	}
+
+	//changing the password logged out every other session, keeping the one it was changed in
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, elsewhere)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("other session after password change returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusUnauthorized)
+	}
+	if sessions, err := mdb.UserSessions(userID, time.Now()); err != nil || len(sessions) != 1 {
+		t.Errorf("sessions after password change wrong: %v %v", len(sessions), err)
+	}
+
+	//the new password is hashed with the server's hasher and replaces the old
+	if ok, err := testHasher.Verify("NewPassword1!", mdb.passwords[userID]); !ok || err != nil || testHasher.NeedsRehash(mdb.passwords[userID]) {
+		t.Errorf("new password stored wrongly: %q", mdb.passwords[userID])
+	}
+
+	//wrong current passwords count as failed logins: after the free failures the next change must wait, even with the right password
+	for i := 0; i <= accountFreeFailures; i++ {
+		if rr := send("PUT", url, userID, passwordChange{Current: "Wrong1!", Password: "NewPassword2!"}); rr.Code != http.StatusForbidden {
+			t.Fatalf("wrong current password %v returned wrong status code:\ngot: %v\nwant: %v", i, rr.Code, http.StatusForbidden)
+		}
+	}
+	rr = send("PUT", url, userID, passwordChange{Current: "NewPassword1!", Password: "NewPassword2!"})
+	if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") == "" {
+		t.Errorf("throttled password change returned wrong response: %v %v", rr.Code, rr.Header().Get("Retry-After"))
+	}
+
+	//and they throttle logging in to the account too
+	rr = send("POST", "/api/login", uuid.Nil, &models.User{Email: "user-1@example.com", Password: "NewPassword1!"})
+	if rr.Code != http.StatusTooManyRequests {
+		t.Errorf("login after wrong current passwords returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusTooManyRequests)
+	}
+
+	//signing up with a compromised password is refused before any account is created
+	rr = send("POST", "/api/signup", uuid.Nil, &models.User{Name: "newuser", Email: "newuser@example.com", Password: "Breached1!"})
+	if rr.Code != http.StatusBadRequest || !strings.HasPrefix(rr.Body.String(), "compromised password") {
+		t.Errorf("signup with compromised password returned wrong response: %v %q", rr.Code, rr.Body)
+	}
+}
+
 func compareUserList(got, want []*models.User) (bool, string) {
 	for key, PostGot := range got {
 		if PostGot.ID != want[key].ID {
@@ -80,3 +264,68 @@ func compareUserList(got, want []*models.User) (bool, string) {
 	}
 	return true, ""
 }
+
+//authenticate adds the JWT cookies of a logged in user, in a new session, to a test request
+func authenticate(t *testing.T, s *Server, req *http.Request, id uuid.UUID) {
+	os.Setenv("jwt_key", "test-key")
+	os.Setenv("jwt_issuer", "test-issuer")
+
+	roles, err := s.DB.UserRoles(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session, err := uuid.NewV4()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC()
+	err = s.DB.CreateSession(&models.Session{ID: session, User: id, Created: now, LastSeen: now, Expires: now.Add(jwtLifetime)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	headerpayload, signature, err := s.createJWT(id, roles, session)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.AddCookie(&http.Cookie{Name: "token-hp", Value: headerpayload})
+	req.AddCookie(&http.Cookie{Name: "token-s", Value: signature})
+}
+
+func TestEditProfilePhotoIfMatch(t *testing.T) {
+
+	router := hr.New()
+	s := Server{DB: &mockDB{}, Router: router, Log: testLog}
+	s.Routes()
+
+	//the sample profile is at version 1. edits matching it get past the precondition to the missing file.
+	for _, tc := range []struct {
+		ifMatch string
+		want    int
+	}{
+		{"", http.StatusPreconditionRequired},
+		{`"2"`, http.StatusPreconditionFailed},
+		{`W/"1"`, http.StatusPreconditionFailed},
+		{`"2", "3"`, http.StatusPreconditionFailed},
+		{"*", http.StatusBadRequest},
+		{`"1"`, http.StatusBadRequest},
+		{`"2", "1"`, http.StatusBadRequest},
+	} {
+		req, err := http.NewRequest("PUT", fmt.Sprintf("/api/profilephoto/%s", userID), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tc.ifMatch != "" {
+			req.Header.Set("If-Match", tc.ifMatch)
+		}
+		authenticate(t, &s, req, userID)
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != tc.want {
+			t.Errorf("handler returned wrong status code for %q:\ngot: %v\nwant: %v", tc.ifMatch, rr.Code, tc.want)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package app
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+)
+
+//Limits on password guessing. Failed logins are counted per account and per client IP over loginFailureWindow.
+//After the free failures each further attempt must wait, twice as long after each failure, and enough failures on one account lock it
+//until loginLockout passes or the link emailed to the account is followed. Accounts are tracked by email, whether or not an account has it,
+//so the responses are the same for unknown emails and do not reveal which accounts exist.
+const (
+	loginFailureWindow   = time.Hour
+	accountFreeFailures  = 3
+	ipFreeFailures       = 10
+	loginBaseDelay       = time.Second
+	loginMaxDelay        = 5 * time.Minute
+	loginLockoutFailures = 10
+	loginLockout         = time.Hour
+)
+
+//errNoLoginState is returned when there is no state store to track logins in
+var errNoLoginState = errors.New("no state store to track logins in")
+
+//loginAccountKey is the prefix of the state store keys tracking logins to the account with the email.
+//Emails are hashed so the store does not hold them.
+func loginAccountKey(email string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(email))))
+	return "login:account:" + hex.EncodeToString(sum[:])
+}
+
+//loginIPKey is the prefix of the state store keys tracking logins from the IP
+func loginIPKey(ip string) string {
+	return "login:ip:" + ip
+}
+
+//loginBackoff returns how long the next attempt must wait after the given number of failures:
+//nothing within the free failures, then loginBaseDelay doubling with each failure up to loginMaxDelay.
+func loginBackoff(failures, free int) time.Duration {
+
+	if failures <= free {
+		return 0
+	}
+
+	delay := loginBaseDelay
+	for i := free + 1; i < failures && delay < loginMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > loginMaxDelay {
+		delay = loginMaxDelay
+	}
+
+	return delay
+}
+
+//loginAttempt is a login attempt reserved by loginReserve, with the account's and IP's failures counting it.
+type loginAttempt struct {
+	account, client      string
+	failures, ipFailures int
+}
+
+//loginReserve reserves a login attempt to the account from the IP before its credentials are checked, counting it as a failure until it succeeds,
+//or returns how long the account and IP must wait before their next attempt. Attempts whose failure would make the next wait also take the account's
+//and IP's turn, which only one attempt gets in each backoff, so concurrent attempts get no more tries than attempts one after another.
+func (s *Server) loginReserve(email, ip string) (*loginAttempt, time.Duration, error) {
+
+	if s.State == nil {
+		return nil, 0, errNoLoginState
+	}
+
+	attempt := &loginAttempt{account: loginAccountKey(email), client: loginIPKey(ip)}
+
+	var wait time.Duration
+	for _, key := range []string{attempt.account + ":lock", attempt.account + ":wait", attempt.client + ":wait"} {
+		ttl, err := s.State.TTL(key)
+		if err != nil {
+			return nil, 0, err
+		}
+		if ttl > wait {
+			wait = ttl
+		}
+	}
+
+	if wait > 0 {
+		return nil, wait, nil
+	}
+
+	var err error
+
+	attempt.failures, _, err = s.State.Incr(attempt.account+":failures", loginFailureWindow)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	attempt.ipFailures, _, err = s.State.Incr(attempt.client+":failures", loginFailureWindow)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	for _, turn := range []struct {
+		key            string
+		failures, free int
+	}{
+		{attempt.account + ":turn", attempt.failures, accountFreeFailures},
+		{attempt.client + ":turn", attempt.ipFailures, ipFreeFailures},
+	} {
+		backoff := loginBackoff(turn.failures, turn.free)
+		if backoff == 0 {
+			continue
+		}
+		taken, reset, err := s.State.Incr(turn.key, backoff)
+		if err != nil {
+			return nil, 0, err
+		}
+		if taken > 1 && reset > wait {
+			wait = reset
+		}
+	}
+
+	if wait > 0 {
+		return nil, wait, nil
+	}
+
+	return attempt, 0, nil
+}
+
+//loginFailed makes the next attempts to the account and from the IP wait after the reserved attempt failed.
+//It returns the account's failures in the window and whether they lock the account, in which case the account is locked.
+func (s *Server) loginFailed(attempt *loginAttempt) (int, bool, error) {
+
+	if s.State == nil {
+		return 0, false, errNoLoginState
+	}
+
+	err := s.State.Set(attempt.account+":wait", loginBackoff(attempt.failures, accountFreeFailures))
+	if err != nil {
+		return 0, false, err
+	}
+
+	err = s.State.Set(attempt.client+":wait", loginBackoff(attempt.ipFailures, ipFreeFailures))
+	if err != nil {
+		return 0, false, err
+	}
+
+	if attempt.failures < loginLockoutFailures {
+		return attempt.failures, false, nil
+	}
+
+	err = s.State.Set(attempt.account+":lock", loginLockout)
+	if err != nil {
+		return 0, false, err
+	}
+
+	return attempt.failures, true, nil
+}
+
+//loginPassed takes back the IP's failure for the reserved attempt, whose credentials were right.
+//The account's stays counted until loginSucceeded, so an unfinished two-factor login still counts against the account.
+func (s *Server) loginPassed(attempt *loginAttempt) error {
+
+	if s.State == nil {
+		return errNoLoginState
+	}
+
+	return s.State.Decr(attempt.client + ":failures")
+}
+
+//loginSucceeded clears the account's failed logins. The IP's are kept, so logging in to one account does not reset guessing at others.
+func (s *Server) loginSucceeded(email string) error {
+
+	if s.State == nil {
+		return errNoLoginState
+	}
+
+	account := loginAccountKey(email)
+
+	return s.State.Delete(account+":failures", account+":wait", account+":turn")
+}
+
+//unlockToken creates a token unlocking the account with the email, valid as long as the lockout.
+//Only a hash of the token is kept.
+func (s *Server) unlockToken(email string) (string, error) {
+
+	if s.State == nil {
+		return "", errNoLoginState
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	err := s.State.Set(unlockKey(email, token), loginLockout)
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+//unlockAccount lifts the lockout and clears the failed logins of the account with the email if the unlock token is valid for it,
+//and reports whether it was. Each token unlocks once.
+func (s *Server) unlockAccount(email, token string) (bool, error) {
+
+	if s.State == nil {
+		return false, errNoLoginState
+	}
+
+	key := unlockKey(email, token)
+
+	valid, err := s.State.Exists(key)
+	if err != nil || !valid {
+		return false, err
+	}
+
+	account := loginAccountKey(email)
+
+	err = s.State.Delete(key, account+":lock", account+":failures", account+":wait", account+":turn")
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+//unlockKey is the state store key marking the unlock token valid for the account with the email
+func unlockKey(email, token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return loginAccountKey(email) + ":unlock:" + hex.EncodeToString(sum[:])
+}
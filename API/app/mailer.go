@@ -0,0 +1,68 @@
+package app
+
+import (
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/chiips/snippets/API/logs"
+)
+
+//Mailer sends plain text emails to users, e.g. the link to unlock an account locked after repeated failed logins.
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+//NewMailer returns a Mailer sending through the SMTP server at host:port from the given address,
+//authenticating if a username is given. Without a host emails are only logged, without their bodies, for development.
+func NewMailer(host, port, username, password, from string, log *logs.Log) Mailer {
+
+	if host == "" {
+		return &logMailer{log: log}
+	}
+
+	m := &smtpMailer{addr: net.JoinHostPort(host, port), from: from}
+	if username != "" {
+		m.auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return m
+}
+
+//smtpMailer sends emails through an SMTP server
+type smtpMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+//Send satisfies the Mailer interface.
+func (m *smtpMailer) Send(to, subject, body string) error {
+
+	//the addresses and subject come from the server and validated signups, but refuse line breaks so nothing can add headers
+	if strings.ContainsAny(to+subject, "\r\n") {
+		return fmt.Errorf("invalid email header")
+	}
+
+	msg := "From: " + m.from + "\r\n" +
+		"To: " + to + "\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"Date: " + time.Now().Format(time.RFC1123Z) + "\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"\r\n" + body
+
+	return smtp.SendMail(m.addr, m.auth, m.from, []string{to}, []byte(msg))
+}
+
+//logMailer logs the emails it is given instead of sending them. Bodies are left out since they carry unlock links.
+type logMailer struct {
+	log *logs.Log
+}
+
+//Send satisfies the Mailer interface.
+func (m *logMailer) Send(to, subject, body string) error {
+	m.log.Infoln("email not sent, no SMTP server configured:", to, subject)
+	return nil
+}
@@ -0,0 +1,199 @@
+package app
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/chiips/snippets/API/totp"
+	uuid "github.com/satori/go.uuid"
+)
+
+//Two-factor logins. Once the password of an account with confirmed TOTP is verified, login issues a pending token for mfaPendingTTL
+//instead of the JWT, and the user has mfaAttempts tries to send a code from their authenticator app or a recovery code with it.
+const (
+	mfaPendingTTL = 5 * time.Minute
+	mfaAttempts   = 5
+	recoveryCodes = 10
+)
+
+//errNoMFAState is returned when there is no state store to keep pending two-factor logins in
+var errNoMFAState = errors.New("no state store to keep pending two-factor logins in")
+
+//mfaPendingKey is the state store key marking the pending two-factor login token valid. Only a hash of the token is kept.
+func mfaPendingKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "mfa:pending:" + hex.EncodeToString(sum[:])
+}
+
+//startMFA returns a token for the user to finish logging in with their second factor.
+//The token names the user, so the store only needs to know it is valid.
+func (s *Server) startMFA(user uuid.UUID) (string, error) {
+
+	if s.State == nil {
+		return "", errNoMFAState
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := user.String() + "." + base64.RawURLEncoding.EncodeToString(b)
+
+	err := s.State.Set(mfaPendingKey(token), mfaPendingTTL)
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+//pendingMFA returns the user a pending two-factor login token was issued to, and whether it is still valid.
+//Each call counts an attempt, and the token is spent once it has had mfaAttempts.
+func (s *Server) pendingMFA(token string) (uuid.UUID, bool, error) {
+
+	if s.State == nil {
+		return uuid.Nil, false, errNoMFAState
+	}
+
+	parts := strings.SplitN(token, ".", 2)
+	user, err := uuid.FromString(parts[0])
+	if err != nil || len(parts) != 2 {
+		return uuid.Nil, false, nil
+	}
+
+	key := mfaPendingKey(token)
+
+	valid, err := s.State.Exists(key)
+	if err != nil || !valid {
+		return uuid.Nil, false, err
+	}
+
+	attempts, _, err := s.State.Incr(key+":attempts", mfaPendingTTL)
+	if err != nil {
+		return uuid.Nil, false, err
+	}
+
+	if attempts > mfaAttempts {
+		return uuid.Nil, false, s.finishMFA(token)
+	}
+
+	return user, true, nil
+}
+
+//finishMFA spends a pending two-factor login token.
+func (s *Server) finishMFA(token string) error {
+
+	if s.State == nil {
+		return errNoMFAState
+	}
+
+	key := mfaPendingKey(token)
+
+	return s.State.Delete(key, key+":attempts")
+}
+
+//mfaEnabled reports whether the user has confirmed TOTP, so logging in needs a second factor
+func (s *Server) mfaEnabled(user uuid.UUID) (bool, error) {
+
+	t, err := s.DB.TOTP(user)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return t.Confirmed, nil
+}
+
+//setMFACookie sends the pending two-factor login token in an HttpOnly cookie only sent back to finish the login,
+//or clears it given an empty token.
+func setMFACookie(w http.ResponseWriter, token string) {
+
+	maxAge := int(mfaPendingTTL.Seconds())
+	if token == "" {
+		maxAge = -1
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     "token-mfa",
+		Value:    token,
+		Secure:   true, //for testing over http, set Secure to false.
+		HttpOnly: true,
+		Path:     "/api/login/mfa",
+		MaxAge:   maxAge,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+//secondFactor reports whether the code is a valid second factor for the user: a code from their confirmed authenticator app
+//for a later time step than the last accepted, or one of their unused recovery codes. Either is used up once accepted.
+func (s *Server) secondFactor(user uuid.UUID, code string) (bool, error) {
+
+	if isRecoveryCode(code) {
+		return s.DB.UseRecoveryCode(user, recoveryCodeHash(code))
+	}
+
+	t, err := s.DB.TOTP(user)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if !t.Confirmed {
+		return false, nil
+	}
+
+	step, valid, err := totp.Validate(t.Secret, code, time.Now())
+	if err != nil || !valid {
+		return false, err
+	}
+
+	return s.DB.UseTOTPStep(user, step)
+}
+
+//newRecoveryCodes returns a fresh set of recovery codes, formatted xxxxx-xxxxx, and the hashes to store them as
+func newRecoveryCodes() ([]string, []string, error) {
+
+	codes := make([]string, recoveryCodes)
+	hashes := make([]string, recoveryCodes)
+
+	for i := range codes {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		//base32 leaves out 0, 1, and 8, which are easily misread as letters
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(b))[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = recoveryCodeHash(codes[i])
+	}
+
+	return codes, hashes, nil
+}
+
+//normalizeRecoveryCode strips the separators and case users may type recovery codes with
+func normalizeRecoveryCode(code string) string {
+	return strings.NewReplacer("-", "", " ", "").Replace(strings.ToLower(strings.TrimSpace(code)))
+}
+
+//isRecoveryCode reports whether the code has the form of a recovery code rather than an authenticator app code
+func isRecoveryCode(code string) bool {
+	return len(normalizeRecoveryCode(code)) == 10
+}
+
+//recoveryCodeHash is the hash a recovery code is stored as. Recovery codes are random, so a fast hash suffices.
+func recoveryCodeHash(code string) string {
+	sum := sha256.Sum256([]byte(normalizeRecoveryCode(code)))
+	return hex.EncodeToString(sum[:])
+}
@@ -2,13 +2,17 @@ package app
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"net/http"
 	"os"
 	"regexp"
+	"time"
 
+	"github.com/chiips/snippets/API/models"
 	"github.com/dgrijalva/jwt-go"
 	hr "github.com/julienschmidt/httprouter"
+	uuid "github.com/satori/go.uuid"
 )
 
 //define a custom contextkey type
@@ -16,104 +20,317 @@ type contextKey string
 
 const userContextKey contextKey = "userID"
 
+//rolesContextKey holds the roles from the user's JWT, for requireRole and requirePermission
+const rolesContextKey contextKey = "roles"
+
 //authenticateJWT controls access to handlers according to the validity of the incoming JWT.
 func (s *Server) authenticateJWT(next hr.Handle) hr.Handle {
 	return func(w http.ResponseWriter, r *http.Request, ps hr.Params) {
 
-		//get the JWT header.payload
-		c1, err := r.Cookie("token-hp")
-		if err != nil {
-			if err == http.ErrNoCookie {
-				s.Log.Errorln(err)
-				http.Error(w, http.StatusText(401), http.StatusUnauthorized)
-				return
-			}
-			s.Log.Errorln(err)
-			http.Error(w, http.StatusText(400), http.StatusBadRequest)
+		claims, status := s.parseJWT(r)
+		if status != http.StatusOK {
+			http.Error(w, http.StatusText(status), status)
 			return
 		}
 
-		//get the JWT signature
-		c2, err := r.Cookie("token-s")
-		if err != nil {
-			if err == http.ErrNoCookie {
-				s.Log.Errorln(err)
-				http.Error(w, http.StatusText(401), http.StatusUnauthorized)
-				return
-			}
-			s.Log.Errorln(err)
-			http.Error(w, http.StatusText(400), http.StatusBadRequest)
+		//reject JWTs of sessions the user revoked
+		if status := s.activeSession(claims); status != http.StatusOK {
+			http.Error(w, http.StatusText(status), status)
 			return
 		}
 
-		//combine for full JWT
-		tknStr := c1.Value + "." + c2.Value
+		//a valid JWT is not enough: reject deleted and suspended accounts before their tokens expire
+		if !s.activeAccount(w, claims.ID) {
+			return
+		}
 
-		//Parse the JWT string and store the result in `&MyClaims{}`.
-		tkn, err := jwt.ParseWithClaims(tknStr, &MyClaims{}, func(token *jwt.Token) (interface{}, error) {
+		//reject if authenticated but trying to reach login
+		requestPath := r.URL.Path
+		requestPath = hr.CleanPath(requestPath)
 
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
-			}
+		matched, err := regexp.MatchString("/api/login", requestPath)
 
-			return []byte(os.Getenv("jwt_key")), nil
-		})
+		if matched && err == nil {
+			s.Log.Errorln("login attempt with existing valid JWT")
+			//status ok so frontend knows just redirect to logged in homepage
+			w.WriteHeader(http.StatusOK)
+			return
+		}
 
-		//catch any errors
-		if err != nil {
-			if err == jwt.ErrSignatureInvalid {
-				s.Log.Errorln(err)
-				http.Error(w, http.StatusText(401), http.StatusUnauthorized)
-				return
-			}
-			s.Log.Errorln(err)
-			http.Error(w, http.StatusText(400), http.StatusBadRequest)
+		//put ID, roles, and session in context to pass along request chain
+		ctx := context.WithValue(r.Context(), userContextKey, claims.ID)
+		ctx = context.WithValue(ctx, rolesContextKey, claims.Roles)
+		ctx = context.WithValue(ctx, sessionContextKey, claims.Session)
+		r = r.WithContext(ctx)
+
+		s.Log.Infoln("JWT authentication OK, serving next")
+		next(w, r, ps)
+
+	}
+
+}
+
+//authenticateToken controls access to handlers like authenticateJWT, also accepting personal access tokens granting the scope.
+//Requests with an Authorization: Bearer header are authenticated by their token alone; the rest go to authenticateJWT.
+func (s *Server) authenticateToken(scope string, next hr.Handle) hr.Handle {
+	authenticated := s.authenticateJWT(next)
+	return func(w http.ResponseWriter, r *http.Request, ps hr.Params) {
+
+		token, ok := bearerToken(r)
+		if !ok {
+			authenticated(w, r, ps)
 			return
 		}
 
-		//check validity of the token
-		if !tkn.Valid {
-			s.Log.Errorln(err)
-			http.Error(w, http.StatusText(401), http.StatusUnauthorized)
+		t, status := s.parseAccessToken(r, token, scope)
+		if status != http.StatusOK {
+			http.Error(w, http.StatusText(status), status)
 			return
 		}
 
-		//make sure we can get the claims
-		claims, ok := tkn.Claims.(*MyClaims)
+		r, ok = s.tokenContext(w, r, t)
 		if !ok {
-			s.Log.Errorln("invalid claims")
-			http.Error(w, http.StatusText(400), http.StatusBadRequest)
 			return
 		}
 
-		//verify the issuer field of the JWT
-		verifiedIssuer := claims.VerifyIssuer(os.Getenv("jwt_issuer"), true)
-		if !verifiedIssuer {
-			s.Log.Errorln("invalid issuer")
-			http.Error(w, http.StatusText(400), http.StatusBadRequest)
+		s.Log.Infoln("access token authentication OK, serving next")
+		next(w, r, ps)
+
+	}
+
+}
+
+//identifyToken identifies viewers like identifyJWT, also accepting personal access tokens granting the scope.
+//Unlike a JWT cookie a bearer token is sent on purpose, so an invalid one is refused rather than served anonymously.
+func (s *Server) identifyToken(scope string, next hr.Handle) hr.Handle {
+	identified := s.identifyJWT(next)
+	return func(w http.ResponseWriter, r *http.Request, ps hr.Params) {
+
+		token, ok := bearerToken(r)
+		if !ok {
+			identified(w, r, ps)
 			return
 		}
 
-		//reject if authenticated but trying to reach login
-		requestPath := r.URL.Path
-		requestPath = hr.CleanPath(requestPath)
+		t, status := s.parseAccessToken(r, token, scope)
+		if status != http.StatusOK {
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
 
-		matched, err := regexp.MatchString("/api/login", requestPath)
+		r, ok = s.tokenContext(w, r, t)
+		if !ok {
+			return
+		}
 
-		if matched && err == nil {
-			s.Log.Errorln("login attempt with existing valid JWT")
-			//status ok so frontend knows just redirect to logged in homepage
-			w.WriteHeader(http.StatusOK)
+		next(w, r, ps)
+
+	}
+
+}
+
+//tokenContext puts the ID and roles of the access token's user in context as authenticateJWT does for a JWT's,
+//once their account is checked. It reports whether the request may go on, having sent the response otherwise.
+func (s *Server) tokenContext(w http.ResponseWriter, r *http.Request, t *models.AccessToken) (*http.Request, bool) {
+
+	if !s.activeAccount(w, t.User) {
+		return r, false
+	}
+
+	//tokens carry no roles, so role changes reach them at once
+	roles, err := s.DB.UserRoles(t.User)
+	if err != nil {
+		s.Log.Errorln(err)
+		http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+		return r, false
+	}
+
+	ctx := context.WithValue(r.Context(), userContextKey, t.User)
+	ctx = context.WithValue(ctx, rolesContextKey, roles)
+
+	return r.WithContext(ctx), true
+}
+
+//identifyJWT puts the user ID in context when the incoming JWT is valid, for public handlers whose response depends on the viewer.
+//Requests without a valid JWT, with the JWT of a revoked session, or from deleted or suspended accounts, are served anonymously rather than rejected.
+func (s *Server) identifyJWT(next hr.Handle) hr.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps hr.Params) {
+
+		//only attempt to identify viewers who sent a token
+		if _, err := r.Cookie("token-hp"); err == nil {
+			if claims, status := s.parseJWT(r); status == http.StatusOK && s.activeSession(claims) == http.StatusOK {
+				if standing, status := s.accountStanding(claims.ID); status == http.StatusOK && !standing.Suspended(time.Now()) {
+					ctx := context.WithValue(r.Context(), userContextKey, claims.ID)
+					ctx = context.WithValue(ctx, rolesContextKey, claims.Roles)
+					r = r.WithContext(ctx)
+				}
+			}
+		}
+
+		next(w, r, ps)
+
+	}
+
+}
+
+//accountStanding loads the standing of the account a JWT was issued to.
+//It returns the status to send the client if the account is gone or cannot be loaded, or http.StatusOK.
+func (s *Server) accountStanding(id uuid.UUID) (*models.Standing, int) {
+
+	standing, err := s.DB.AccountStanding(id)
+	switch {
+	case err == sql.ErrNoRows:
+		s.Log.Errorln("JWT for deleted user:", id)
+		return nil, http.StatusUnauthorized
+	case err != nil:
+		s.Log.Errorln(err)
+		return nil, http.StatusInternalServerError
+	}
+
+	return standing, http.StatusOK
+}
+
+//activeAccount refuses deleted and suspended accounts, sending the response, and reports whether the account may go on.
+func (s *Server) activeAccount(w http.ResponseWriter, id uuid.UUID) bool {
+
+	standing, status := s.accountStanding(id)
+	if status != http.StatusOK {
+		http.Error(w, http.StatusText(status), status)
+		return false
+	}
+
+	if standing.Suspended(time.Now()) {
+		s.Log.Errorln("suspended user:", id)
+		http.Error(w, "account suspended until "+standing.SuspendedUntil.UTC().Format(time.RFC3339), http.StatusForbidden)
+		return false
+	}
+
+	return true
+}
+
+//requireRole controls access to handlers by the roles in the user's JWT, serving next only to users holding the role.
+//It reads the roles authenticateJWT puts in context, so it goes inside it: s.authenticateJWT(s.requireRole(role, next)).
+func (s *Server) requireRole(role string, next hr.Handle) hr.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps hr.Params) {
+
+		roles, _ := r.Context().Value(rolesContextKey).([]string)
+		if !models.HasRole(roles, role) {
+			s.Log.Errorln("forbidden request: missing role", role)
+			http.Error(w, http.StatusText(403), http.StatusForbidden)
 			return
 		}
 
-		//put ID in context to pass along request chain
-		ctx := context.WithValue(r.Context(), userContextKey, claims.ID)
-		r = r.WithContext(ctx)
+		next(w, r, ps)
+
+	}
+
+}
+
+//requirePermission controls access to handlers by the permissions the roles in the user's JWT grant, serving next only to users with the permission.
+//Like requireRole, it goes inside authenticateJWT: s.authenticateJWT(s.requirePermission(permission, next)).
+func (s *Server) requirePermission(permission string, next hr.Handle) hr.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps hr.Params) {
+
+		roles, _ := r.Context().Value(rolesContextKey).([]string)
+		if !HasPermission(roles, permission) {
+			s.Log.Errorln("forbidden request: missing permission", permission)
+			http.Error(w, http.StatusText(403), http.StatusForbidden)
+			return
+		}
 
-		s.Log.Infoln("JWT authentication OK, serving next")
 		next(w, r, ps)
 
 	}
 
 }
+
+//parseJWT reads and validates the JWT split across the token-hp and token-s cookies.
+//It returns the JWT's claims, or the status to send the client if the JWT is missing or invalid.
+func (s *Server) parseJWT(r *http.Request) (*MyClaims, int) {
+
+	//requests with a bearer token skip the CSRF check (see SkipBearerCSRF), so their cookies are not trusted
+	if _, ok := bearerToken(r); ok {
+		s.Log.Errorln("JWT cookies sent with a bearer token")
+		return nil, http.StatusUnauthorized
+	}
+
+	//get the JWT header.payload
+	c1, err := r.Cookie("token-hp")
+	if err != nil {
+		if err == http.ErrNoCookie {
+			s.Log.Errorln(err)
+			return nil, http.StatusUnauthorized
+		}
+		s.Log.Errorln(err)
+		return nil, http.StatusBadRequest
+	}
+
+	//get the JWT signature
+	c2, err := r.Cookie("token-s")
+	if err != nil {
+		if err == http.ErrNoCookie {
+			s.Log.Errorln(err)
+			return nil, http.StatusUnauthorized
+		}
+		s.Log.Errorln(err)
+		return nil, http.StatusBadRequest
+	}
+
+	//combine for full JWT
+	tknStr := c1.Value + "." + c2.Value
+
+	//Parse the JWT string and store the result in `&MyClaims{}`.
+	tkn, err := jwt.ParseWithClaims(tknStr, &MyClaims{}, func(token *jwt.Token) (interface{}, error) {
+
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+		}
+
+		return []byte(os.Getenv("jwt_key")), nil
+	})
+
+	//catch any errors
+	if err != nil {
+		if err == jwt.ErrSignatureInvalid {
+			s.Log.Errorln(err)
+			return nil, http.StatusUnauthorized
+		}
+		s.Log.Errorln(err)
+		return nil, http.StatusBadRequest
+	}
+
+	//check validity of the token
+	if !tkn.Valid {
+		s.Log.Errorln(err)
+		return nil, http.StatusUnauthorized
+	}
+
+	//make sure we can get the claims
+	claims, ok := tkn.Claims.(*MyClaims)
+	if !ok {
+		s.Log.Errorln("invalid claims")
+		return nil, http.StatusBadRequest
+	}
+
+	//verify the issuer field of the JWT
+	verifiedIssuer := claims.VerifyIssuer(os.Getenv("jwt_issuer"), true)
+	if !verifiedIssuer {
+		s.Log.Errorln("invalid issuer")
+		return nil, http.StatusBadRequest
+	}
+
+	//reject JWTs revoked before their expiry
+	if s.State != nil && claims.Id != "" {
+		revoked, err := s.State.Exists(revokedKey(claims.Id))
+		if err != nil {
+			s.Log.Errorln(err)
+			return nil, http.StatusInternalServerError
+		}
+		if revoked {
+			s.Log.Errorln("revoked JWT for user:", claims.ID)
+			return nil, http.StatusUnauthorized
+		}
+	}
+
+	return claims, http.StatusOK
+}
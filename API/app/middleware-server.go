@@ -5,29 +5,12 @@ import (
 	"net/http"
 	"time"
 
-	"github.com/didip/tollbooth"
-	"github.com/didip/tollbooth/limiter"
 	"github.com/gorilla/csrf"
 	log "github.com/sirupsen/logrus"
 )
 
 //These middlewares protect the server's router and therefore apply to all routes.
 
-//NewLimiter sets up tollbooth rate limiter
-func (s *Server) NewLimiter() *limiter.Limiter {
-
-	lmt := tollbooth.NewLimiter(2, &limiter.ExpirableOptions{DefaultExpirationTTL: time.Hour})
-
-	lmt.SetIPLookups([]string{"X-Forwarded-For", "RemoteAddr", "X-Real-IP"})
-	lmt.SetOnLimitReached(func(w http.ResponseWriter, r *http.Request) {
-		log := s.Log.WithFields(log.Fields{"request id": r.Header.Get("X-REQUEST-ID"), "request uri": r.RequestURI, "request method": r.Method})
-		log.Errorln("request limit reached")
-	})
-
-	return lmt
-
-}
-
 //Timeout sets a context withcancel that matches &http.Server read + write timeout in main.go.
 //This middleware allows the handlers to respond precisely to timeout errors while the &http.Server timeout serves as an absolute safeguard.
 func (s *Server) Timeout(next http.Handler) http.Handler {
@@ -44,11 +27,11 @@ func (s *Server) Timeout(next http.Handler) http.Handler {
 
 }
 
-//LogRequests logs the custom request ID (created in the SPA), URI, and method to the logger.
+//LogRequests logs the custom request ID (created in the SPA), client IP, URI, and method to the logger.
 func (s *Server) LogRequests(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
-		log := s.Log.WithFields(log.Fields{"id": r.Header.Get("X-REQUEST-ID"), "uri": r.RequestURI, "method": r.Method})
+		log := s.Log.WithFields(log.Fields{"id": r.Header.Get("X-REQUEST-ID"), "ip": s.clientIP(r), "uri": r.RequestURI, "method": r.Method})
 
 		log.Infoln("about to serve")
 
@@ -72,6 +55,22 @@ func (s *Server) SetHeaders(next http.Handler) http.Handler {
 
 }
 
+//SkipBearerCSRF lets requests with an Authorization: Bearer header, from scripts and other clients rather than browsers, past the CSRF check.
+//Browsers never add the header on their own as they do cookies, so a cross-site page cannot forge such a request,
+//and the cookies of such requests are never trusted (see parseJWT). It goes outside the CSRF middleware.
+func (s *Server) SkipBearerCSRF(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if _, ok := bearerToken(r); ok {
+			r = csrf.UnsafeSkipCheck(r)
+		}
+
+		next.ServeHTTP(w, r)
+
+	})
+
+}
+
 //CSRFErrorHandler is a custom error handler when CSRF tokens come in invalid
 func (s *Server) CSRFErrorHandler() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
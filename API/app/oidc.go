@@ -0,0 +1,160 @@
+package app
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/chiips/snippets/API/oidc"
+	uuid "github.com/satori/go.uuid"
+)
+
+//External logins. Users link accounts at OpenID Connect providers while logged in, and log in with any of them in place of their password.
+//Each flow, to log in or to link, sends the user to the provider with a state, nonce, and PKCE code verifier kept in a cookie for oidcFlowTTL,
+//and is spent once the provider sends them back. Users are only ever found by the provider's subject for a linked account, never by email:
+//an account with the same email at a provider is not proof of owning the account here.
+const oidcFlowTTL = 10 * time.Minute
+
+//the flows sending users to providers
+const (
+	flowLogin = "login"
+	flowLink  = "link"
+)
+
+//the messages sent back with users whose external login or link was refused
+const (
+	msgUnknownProvider = "unknown login provider"
+	msgOIDCExpired     = "login with provider expired. Please try again."
+	msgOIDCCancelled   = "login with provider cancelled"
+	msgOIDCFailed      = "could not log in with provider. Please try again."
+	msgIdentityUnknown = "no account is linked to that login. Please log in another way and link it first."
+	msgIdentityLinked  = "that login is already linked to an account"
+)
+
+//oidcRefusal is a refused flow and the message sending the user back
+type oidcRefusal struct {
+	Flow    string
+	Message string
+}
+
+//errNoOIDCState is returned when there is no state store to keep external login flows in
+var errNoOIDCState = errors.New("no state store to keep external login flows in")
+
+//oidcFlow is an external login or link in progress: the flow, the user linking or nil, and the secrets sent to the provider with them
+type oidcFlow struct {
+	Flow     string
+	User     uuid.UUID
+	State    string
+	Nonce    string
+	Verifier string
+}
+
+//oidcFlowKey is the state store key marking a flow started with the provider, for the user linking or anyone logging in.
+//Only a hash of the state is kept; the secrets stay in the user's cookie.
+func oidcFlowKey(provider string, f *oidcFlow) string {
+	sum := sha256.Sum256([]byte(f.State))
+	return "oidc:" + provider + ":" + f.Flow + ":" + f.User.String() + ":" + hex.EncodeToString(sum[:])
+}
+
+//startOIDC starts the flow with the provider for the user, returning the URL to send them to and setting the cookie that finishes it.
+func (s *Server) startOIDC(w http.ResponseWriter, p *oidc.Provider, flow string, user uuid.UUID) (string, error) {
+
+	if s.State == nil {
+		return "", errNoOIDCState
+	}
+
+	f := &oidcFlow{Flow: flow, User: user}
+	for _, secret := range []*string{&f.State, &f.Nonce, &f.Verifier} {
+		v, err := oidc.NewSecret()
+		if err != nil {
+			return "", err
+		}
+		*secret = v
+	}
+
+	err := s.State.Set(oidcFlowKey(p.Name, f), oidcFlowTTL)
+	if err != nil {
+		return "", err
+	}
+
+	setOIDCCookie(w, strings.Join([]string{f.Flow, f.User.String(), f.State, f.Nonce, f.Verifier}, "."))
+
+	return p.AuthCodeURL(f.State, f.Nonce, f.Verifier), nil
+}
+
+//spendOIDC returns the flow in the cookie if the provider sent the user back with its state and it is unspent, spending it.
+func (s *Server) spendOIDC(p *oidc.Provider, cookie, state string) (*oidcFlow, bool, error) {
+
+	if s.State == nil {
+		return nil, false, errNoOIDCState
+	}
+
+	parts := strings.Split(cookie, ".")
+	if len(parts) != 5 || state == "" || subtle.ConstantTimeCompare([]byte(parts[2]), []byte(state)) != 1 {
+		return nil, false, nil
+	}
+
+	user, err := uuid.FromString(parts[1])
+	if err != nil || (parts[0] != flowLogin && parts[0] != flowLink) {
+		return nil, false, nil
+	}
+
+	f := &oidcFlow{Flow: parts[0], User: user, State: parts[2], Nonce: parts[3], Verifier: parts[4]}
+
+	key := oidcFlowKey(p.Name, f)
+
+	started, err := s.State.Exists(key)
+	if err != nil || !started {
+		return nil, false, err
+	}
+
+	//of callbacks racing with the same state only the first spends it
+	spent, _, err := s.State.Incr(key+":spent", oidcFlowTTL)
+	if err != nil || spent != 1 {
+		return nil, false, err
+	}
+
+	return f, true, s.State.Delete(key)
+}
+
+//setOIDCCookie sends a flow's secrets in an HttpOnly cookie only sent back to the callback, or clears it given an empty value.
+//The provider sends the user back with a cross-site redirect, so the cookie is SameSite Lax rather than Strict.
+func setOIDCCookie(w http.ResponseWriter, value string) {
+
+	maxAge := int(oidcFlowTTL.Seconds())
+	if value == "" {
+		maxAge = -1
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     "token-oidc",
+		Value:    value,
+		Secure:   true, //for testing over http, set Secure to false.
+		HttpOnly: true,
+		Path:     "/api/oidc",
+		MaxAge:   maxAge,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+//oidcPage returns the page of the app to send users back to after the flow, with the query:
+//the login page after logging in, and the settings listing linked logins after linking one
+func oidcPage(flow string, query url.Values) string {
+
+	page := "/login"
+	if flow == flowLink {
+		page = "/settings/identities"
+	}
+
+	if len(query) > 0 {
+		page += "?" + query.Encode()
+	}
+
+	return os.Getenv("app_url") + page
+}
@@ -0,0 +1,151 @@
+package app
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chiips/snippets/API/models"
+	uuid "github.com/satori/go.uuid"
+)
+
+//default and maximum number of results a client may request per page
+const (
+	defaultPageLimit = 10
+	maxPageLimit     = 50
+)
+
+//errors returned to the client when the pagination parameters are unusable
+var (
+	errInvalidCursor = errors.New("invalid cursor")
+	errInvalidLimit  = fmt.Errorf("invalid limit: must be a number from 1 to %d", maxPageLimit)
+)
+
+//page is the response envelope for paginated lists.
+//NextCursor is empty on the last page.
+type page struct {
+	Data       interface{} `json:"data"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+}
+
+//parsePagination reads the cursor and limit query parameters of a list request.
+//Without a cursor the list starts at the most recent result.
+func (s *Server) parsePagination(r *http.Request) (models.Cursor, int, error) {
+
+	before := models.Cursor{Created: time.Now().UTC()}
+	limit := defaultPageLimit
+
+	query := r.URL.Query()
+
+	if c := strings.TrimSpace(query.Get("cursor")); c != "" {
+		decoded, err := s.decodeCursor(c)
+		if err != nil {
+			return before, limit, err
+		}
+		before = decoded
+	}
+
+	if l := strings.TrimSpace(query.Get("limit")); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n < 1 || n > maxPageLimit {
+			return before, limit, errInvalidLimit
+		}
+		limit = n
+	}
+
+	return before, limit, nil
+}
+
+//size of a cursor's created (unix nanoseconds), score, and id before its signature
+const cursorPayloadSize = 8 + 8 + uuid.Size
+
+//encodeCursor serializes a cursor as created (unix nanoseconds), score, and id followed by an HMAC of all three.
+//The result is opaque to clients and cannot be altered without invalidating the signature.
+func (s *Server) encodeCursor(c models.Cursor) string {
+
+	payload := make([]byte, 16, cursorPayloadSize+sha256.Size)
+	binary.BigEndian.PutUint64(payload, uint64(c.Created.UnixNano()))
+	binary.BigEndian.PutUint64(payload[8:], math.Float64bits(c.Score))
+	payload = append(payload, c.ID.Bytes()...)
+
+	return base64.RawURLEncoding.EncodeToString(append(payload, s.signCursor(payload)...))
+}
+
+//decodeCursor verifies and parses a cursor created by encodeCursor.
+func (s *Server) decodeCursor(cursor string) (models.Cursor, error) {
+
+	c := models.Cursor{}
+
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || len(raw) != cursorPayloadSize+sha256.Size {
+		return c, errInvalidCursor
+	}
+
+	payload, signature := raw[:cursorPayloadSize], raw[cursorPayloadSize:]
+	if !hmac.Equal(signature, s.signCursor(payload)) {
+		return c, errInvalidCursor
+	}
+
+	id, err := uuid.FromBytes(payload[16:])
+	if err != nil {
+		return c, errInvalidCursor
+	}
+
+	c.Created = time.Unix(0, int64(binary.BigEndian.Uint64(payload[:8]))).UTC()
+	c.Score = math.Float64frombits(binary.BigEndian.Uint64(payload[8:16]))
+	c.ID = id
+
+	return c, nil
+}
+
+//minCursorKey is the fewest bytes a cursor key may have, the size of the HMAC-SHA256 output
+const minCursorKey = sha256.Size
+
+//ParseCursorKey returns the key cursors are signed with, from the cursor_key environment variable.
+//It refuses a key shorter than minCursorKey bytes, which would let clients forge cursors by guessing it.
+func ParseCursorKey(key string) ([]byte, error) {
+
+	if len(key) < minCursorKey {
+		return nil, fmt.Errorf("invalid cursor key: must be at least %d bytes", minCursorKey)
+	}
+
+	return []byte(key), nil
+}
+
+//signCursor signs the cursor payload with the cursor key.
+func (s *Server) signCursor(payload []byte) []byte {
+	mac := hmac.New(sha256.New, s.CursorKey)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+//writePage sends one page of results as JSON.
+//When next is not nil the envelope carries its cursor and an RFC 8288 Link header points to the following page.
+func (s *Server) writePage(w http.ResponseWriter, r *http.Request, data interface{}, limit int, next *models.Cursor) error {
+
+	p := page{Data: data}
+
+	if next != nil {
+		p.NextCursor = s.encodeCursor(*next)
+
+		nextURL := *r.URL
+		query := nextURL.Query()
+		query.Set("cursor", p.NextCursor)
+		query.Set("limit", strconv.Itoa(limit))
+		nextURL.RawQuery = query.Encode()
+
+		w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"next\"", nextURL.RequestURI()))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(p)
+}
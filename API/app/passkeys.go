@@ -0,0 +1,116 @@
+package app
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+
+	"github.com/chiips/snippets/API/models"
+	"github.com/chiips/snippets/API/webauthn"
+	uuid "github.com/satori/go.uuid"
+)
+
+//Passkey logins. Users register passkeys, WebAuthn credentials, while logged in, and log in with any of them in place of their password.
+//Each ceremony answers a challenge the server issued for webauthn.Timeout and spends once. The authenticator verifies the user with
+//a PIN or biometrics on their device, so passkey logins take no second factor.
+const (
+	maxPasskeys    = 10
+	maxPasskeyName = 50
+)
+
+//the messages sent for refused passkey requests
+const (
+	msgPasskeysDisabled = "passkeys are not enabled"
+	msgPasskeyExpired   = "passkey request expired. Please try again."
+	msgInvalidPasskey   = "passkey not recognized"
+	msgClonedPasskey    = "this passkey may have been copied and can no longer log in. Please log in another way and remove it."
+)
+
+//the ceremonies passkey challenges are issued for
+const (
+	ceremonyRegister = "register"
+	ceremonyLogin    = "login"
+)
+
+//errNoPasskeyState is returned when there is no state store to keep passkey challenges in
+var errNoPasskeyState = errors.New("no state store to keep passkey challenges in")
+
+//passkeyChallengeKey is the state store key marking a challenge issued for the ceremony, to the user registering or to anyone logging in.
+//Only a hash of the challenge is kept.
+func passkeyChallengeKey(ceremony string, user uuid.UUID, challenge string) string {
+	sum := sha256.Sum256([]byte(challenge))
+	return "passkey:" + ceremony + ":" + user.String() + ":" + hex.EncodeToString(sum[:])
+}
+
+//newPasskeyChallenge issues a challenge for the ceremony.
+func (s *Server) newPasskeyChallenge(ceremony string, user uuid.UUID) (string, error) {
+
+	if s.State == nil {
+		return "", errNoPasskeyState
+	}
+
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		return "", err
+	}
+
+	err = s.State.Set(passkeyChallengeKey(ceremony, user, challenge), webauthn.Timeout)
+	if err != nil {
+		return "", err
+	}
+
+	return challenge, nil
+}
+
+//spendPasskeyChallenge reports whether the challenge in the client data of a response was issued for the ceremony and user and is unspent,
+//returning it and spending it if so.
+func (s *Server) spendPasskeyChallenge(ceremony string, user uuid.UUID, clientDataJSON string) (string, bool, error) {
+
+	if s.State == nil {
+		return "", false, errNoPasskeyState
+	}
+
+	challenge, err := webauthn.Challenge(clientDataJSON)
+	if err != nil {
+		return "", false, nil
+	}
+
+	key := passkeyChallengeKey(ceremony, user, challenge)
+
+	issued, err := s.State.Exists(key)
+	if err != nil || !issued {
+		return "", false, err
+	}
+
+	//of responses racing with the same challenge only the first spends it
+	spent, _, err := s.State.Incr(key+":spent", webauthn.Timeout)
+	if err != nil || spent != 1 {
+		return "", false, err
+	}
+
+	return challenge, true, s.State.Delete(key)
+}
+
+//passkeyCredential returns the stored passkey as the credential the relying party verifies assertions with
+func passkeyCredential(p *models.Passkey) (*webauthn.Credential, error) {
+
+	id, err := base64.RawURLEncoding.DecodeString(p.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &webauthn.Credential{ID: id, PublicKey: p.PublicKey, SignCount: uint32(p.SignCount)}, nil
+}
+
+//passkeyName trims the name the user gave a passkey, defaulting it, and reports whether it fits
+func passkeyName(name string) (string, bool) {
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = "Passkey"
+	}
+
+	return name, len([]rune(name)) <= maxPasskeyName
+}
@@ -0,0 +1,171 @@
+package app
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/chiips/snippets/API/models"
+	hr "github.com/julienschmidt/httprouter"
+	uuid "github.com/satori/go.uuid"
+)
+
+//The authorization policy: every rule for who may change what lives here.
+//Rules take the acting user and the resource as stored in the Datastore, never as sent by the client,
+//and handlers reach them through the authorize helpers below, which load the resource first.
+
+//CanViewPost reports whether the user, holding the roles, may see a post. Posts hidden by moderators are seen only by their author and moderators.
+func CanViewPost(user uuid.UUID, roles []string, post *models.Post) bool {
+	return !post.Hidden || (isUser(user) && uuid.Equal(post.Author.ID, user)) || HasPermission(roles, PermModerateContent)
+}
+
+//CanEditPost reports whether the user may change a post's title and body or restore one of its revisions.
+func CanEditPost(user uuid.UUID, post *models.Post) bool {
+	return isUser(user) && uuid.Equal(post.Author.ID, user)
+}
+
+//CanDeletePost reports whether the user may delete a post.
+func CanDeletePost(user uuid.UUID, post *models.Post) bool {
+	return isUser(user) && uuid.Equal(post.Author.ID, user)
+}
+
+//CanEditComment reports whether the user may change a comment's body.
+func CanEditComment(user uuid.UUID, comment *models.Comment) bool {
+	return isUser(user) && uuid.Equal(comment.Author.ID, user)
+}
+
+//CanDeleteComment reports whether the user may delete a comment.
+func CanDeleteComment(user uuid.UUID, comment *models.Comment) bool {
+	return isUser(user) && uuid.Equal(comment.Author.ID, user)
+}
+
+//CanEditProfile reports whether the user may change the profile of the user with the given id.
+func CanEditProfile(user, id uuid.UUID) bool {
+	return isUser(user) && uuid.Equal(id, user)
+}
+
+//CanDeleteAccount reports whether the user may delete the account of the user with the given id.
+func CanDeleteAccount(user, id uuid.UUID) bool {
+	return isUser(user) && uuid.Equal(id, user)
+}
+
+//The permissions roles grant, checked by requirePermission on the routes that need them.
+const (
+	//PermModerateContent allows acting on other users' posts, comments, and accounts for moderation.
+	PermModerateContent = "content:moderate"
+	//PermManageRoles allows granting and revoking roles and reading the role change audit.
+	PermManageRoles = "roles:manage"
+	//PermManageAccounts allows suspending and shadow-banning accounts directly, outside the moderation queue.
+	PermManageAccounts = "accounts:manage"
+	//PermReadAudit allows reading and exporting the security audit log.
+	PermReadAudit = "audit:read"
+)
+
+//rolePermissions lists the permissions each role grants. A user has every permission granted by any of their roles.
+var rolePermissions = map[string][]string{
+	models.RoleUser:      {},
+	models.RoleModerator: {PermModerateContent},
+	models.RoleAdmin:     {PermModerateContent, PermManageRoles, PermManageAccounts, PermReadAudit},
+}
+
+//HasPermission reports whether any of the roles grants the permission.
+func HasPermission(roles []string, permission string) bool {
+	for _, role := range roles {
+		for _, p := range rolePermissions[role] {
+			if p == permission {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//CanRevokeRole reports whether the user may take the role away from the user with the given id.
+//Admins cannot revoke their own admin role, so the last admin cannot lock everyone out of role management.
+func CanRevokeRole(user, id uuid.UUID, role string) bool {
+	return isUser(user) && !(uuid.Equal(id, user) && role == models.RoleAdmin)
+}
+
+//CanReport reports whether the user may report content whose subject, its author or the reported user, is the given id.
+//Users cannot report themselves.
+func CanReport(user, subject uuid.UUID) bool {
+	return isUser(user) && !uuid.Equal(subject, user)
+}
+
+//CanAppeal reports whether the user may appeal a moderator action: only the user it was aimed at may,
+//and only actions taken against them that they are told about can be appealed.
+func CanAppeal(user uuid.UUID, action *models.ModAction) bool {
+	switch action.Action {
+	case models.ActionHide, models.ActionDelete, models.ActionWarn, models.ActionSuspend:
+		return isUser(user) && uuid.Equal(action.Subject, user)
+	}
+	return false
+}
+
+//CanDecideAppeal reports whether the user may decide an appeal against the action: a moderator cannot review their own action.
+func CanDecideAppeal(user uuid.UUID, action *models.ModAction) bool {
+	return isUser(user) && !uuid.Equal(action.Moderator, user)
+}
+
+//CanChangeStanding reports whether the user may suspend, shadow-ban, or restore the account with the given id: anyone's but their own.
+func CanChangeStanding(user, id uuid.UUID) bool {
+	return isUser(user) && !uuid.Equal(id, user)
+}
+
+//isUser reports whether an id names a logged in user rather than an anonymous viewer.
+func isUser(user uuid.UUID) bool {
+	return !uuid.Equal(user, uuid.Nil)
+}
+
+//authorizePost loads the stored post with the given id and checks the user against the rule.
+//It returns the status to send the client if the post cannot be loaded or the user is not allowed, or http.StatusOK.
+func (s *Server) authorizePost(user, id uuid.UUID, can func(uuid.UUID, *models.Post) bool) (*models.Post, int) {
+
+	post, err := s.DB.OnePost(id, user)
+	switch {
+	case err == sql.ErrNoRows:
+		s.Log.Errorln(err)
+		return nil, http.StatusNotFound
+	case err != nil:
+		s.Log.Errorln(err)
+		return nil, http.StatusInternalServerError
+	}
+
+	if !can(user, post) {
+		s.Log.Errorln("forbidden request")
+		return nil, http.StatusForbidden
+	}
+
+	return post, http.StatusOK
+}
+
+//authorizeViewPost loads the stored post with the given id and checks the viewer, holding the roles, may see it.
+//Posts the viewer may not see are reported missing, as onePost does, so hidden posts are not revealed through their revisions or comments.
+//It returns the status to send the client if the post cannot be loaded or seen, or http.StatusOK.
+func (s *Server) authorizeViewPost(viewer uuid.UUID, roles []string, id uuid.UUID) (*models.Post, int) {
+
+	post, status := s.authorizePost(viewer, id, func(user uuid.UUID, post *models.Post) bool {
+		return CanViewPost(user, roles, post)
+	})
+	if status == http.StatusForbidden {
+		return nil, http.StatusNotFound
+	}
+
+	return post, status
+}
+
+//authorizeComment loads the stored comment named by the postid and commentid url parameters and checks the user against the rule.
+//It returns the status to send the client if the comment cannot be loaded or the user is not allowed, or http.StatusOK.
+func (s *Server) authorizeComment(user uuid.UUID, ps hr.Params, can func(uuid.UUID, *models.Comment) bool) (*models.Comment, int) {
+
+	comment, status := s.commentFromParams(ps)
+	if status != http.StatusOK {
+		return nil, status
+	}
+
+	if !can(user, comment) {
+		s.Log.Errorln("forbidden request")
+		return nil, http.StatusForbidden
+	}
+
+	return comment, http.StatusOK
+}
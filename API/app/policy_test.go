@@ -0,0 +1,127 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/chiips/snippets/API/models"
+	hr "github.com/julienschmidt/httprouter"
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestPolicy(t *testing.T) {
+
+	post := &models.Post{ID: postID1, Author: models.User{ID: userID}}
+	comment := &models.Comment{ID: commentID1, Author: models.User{ID: userID}}
+
+	for _, user := range []uuid.UUID{userID, otherUserID, uuid.Nil} {
+		author := uuid.Equal(user, userID)
+
+		if CanEditPost(user, post) != author || CanDeletePost(user, post) != author {
+			t.Errorf("post rules wrong for %v", user)
+		}
+		if CanEditComment(user, comment) != author || CanDeleteComment(user, comment) != author {
+			t.Errorf("comment rules wrong for %v", user)
+		}
+		if CanEditProfile(user, userID) != author || CanDeleteAccount(user, userID) != author {
+			t.Errorf("profile rules wrong for %v", user)
+		}
+	}
+
+	//anonymous viewers own nothing, even a resource with a nil author
+	if CanEditPost(uuid.Nil, &models.Post{}) || CanEditProfile(uuid.Nil, uuid.Nil) {
+		t.Error("anonymous viewer allowed to edit")
+	}
+}
+
+func TestCrossUserTampering(t *testing.T) {
+
+	router := hr.New()
+	s := Server{DB: &mockDB{}, Router: router, Log: testLog}
+	s.Routes()
+
+	//send runs a request as the given user and returns the response
+	send := func(user uuid.UUID, method, url string, body interface{}) *httptest.ResponseRecorder {
+		var reader *bytes.Reader
+		if body != nil {
+			b, err := json.Marshal(body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			reader = bytes.NewReader(b)
+		} else {
+			reader = bytes.NewReader(nil)
+		}
+
+		req, err := http.NewRequest(method, url, reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("If-Match", `"1"`)
+		authenticate(t, &s, req, user)
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	//another user cannot edit a post, whoever the submission claims wrote it
+	for _, claimed := range []uuid.UUID{userID, otherUserID} {
+		rr := send(otherUserID, "PUT", "/api/post", &models.Post{ID: postID1, Title: "Taken", Body: "Taken over", Author: models.User{ID: claimed}})
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("edit claiming author %v returned wrong status code:\ngot: %v\nwant: %v", claimed, rr.Code, http.StatusForbidden)
+		}
+	}
+
+	post, err := s.DB.OnePost(postID1, uuid.Nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if post.Body != "Body" {
+		t.Errorf("post changed by another user: %v", post.Body)
+	}
+
+	//other mutations of someone else's resources are refused
+	for _, tc := range []struct {
+		method, url string
+		body        interface{}
+	}{
+		{"DELETE", fmt.Sprintf("/api/post/%s", postID1), nil},
+		{"POST", fmt.Sprintf("/api/post/%s/revisions/1/restore", postID1), nil},
+		{"PUT", fmt.Sprintf("/api/post/%s/comments/%s", postID1, commentID1), &models.Comment{Body: "Taken over"}},
+		{"DELETE", fmt.Sprintf("/api/post/%s/comments/%s", postID1, commentID1), nil},
+		{"PUT", fmt.Sprintf("/api/profilephoto/%s", userID), nil},
+		{"DELETE", fmt.Sprintf("/api/profile/%s", userID), nil},
+	} {
+		if rr := send(otherUserID, tc.method, tc.url, tc.body); rr.Code != http.StatusForbidden {
+			t.Errorf("%v %v returned wrong status code:\ngot: %v\nwant: %v", tc.method, tc.url, rr.Code, http.StatusForbidden)
+		}
+	}
+
+	//the author's edit applies to the stored post and keeps its stored author
+	rr := send(userID, "PUT", "/api/post", &models.Post{ID: postID1, Title: "Post", Body: "Edited", Author: models.User{ID: otherUserID}})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("author's edit returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusOK)
+	}
+
+	post, err = s.DB.OnePost(postID1, uuid.Nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if post.Body != "Edited" || !uuid.Equal(post.Author.ID, userID) {
+		t.Errorf("author's edit stored wrong post: %v by %v", post.Body, post.Author.ID)
+	}
+
+	//editing a post that does not exist is not found
+	missing, err := uuid.NewV4()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rr := send(userID, "PUT", "/api/post", &models.Post{ID: missing, Title: "Post", Body: "Body"}); rr.Code != http.StatusNotFound {
+		t.Errorf("edit of missing post returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusNotFound)
+	}
+}
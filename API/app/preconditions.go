@@ -0,0 +1,55 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+//errIfMatchRequired is returned to the client when an edit does not say which version it was made to
+var errIfMatchRequired = errors.New("If-Match header required: send the ETag of the version you edited")
+
+//versionETag makes the strong ETag of a post or profile version.
+func versionETag(version int) string {
+	return fmt.Sprintf("\"%d\"", version)
+}
+
+//ifMatchVersion reads the version an edit was made to from its If-Match header, given the current version.
+//The header matches if it is * or lists the current version's strong tag, and the current version is returned.
+//A missing header is an error. A header matching no current version, such as one of weak or malformed tags, gives version 0,
+//which never matches so the edit is refused as stale.
+func ifMatchVersion(r *http.Request, current int) (int, error) {
+
+	ifMatch := strings.TrimSpace(r.Header.Get("If-Match"))
+	if ifMatch == "" {
+		return 0, errIfMatchRequired
+	}
+
+	for _, tag := range strings.Split(ifMatch, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return current, nil
+		}
+		if len(tag) < 2 || !strings.HasPrefix(tag, "\"") || !strings.HasSuffix(tag, "\"") {
+			continue
+		}
+		version, err := strconv.Atoi(tag[1 : len(tag)-1])
+		if err == nil && version >= 1 && version == current {
+			return current, nil
+		}
+	}
+
+	return 0, nil
+}
+
+//writeStale refuses an edit made to an outdated version with 412 Precondition Failed,
+//sending the current server copy and its ETag so the client can merge and retry.
+func writeStale(w http.ResponseWriter, version int, current interface{}) error {
+	w.Header().Set("ETag", versionETag(version))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusPreconditionFailed)
+	return json.NewEncoder(w).Encode(current)
+}
@@ -0,0 +1,200 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	hr "github.com/julienschmidt/httprouter"
+	log "github.com/sirupsen/logrus"
+)
+
+//The rate limit policies routes are assigned in routes.go.
+//Each policy keeps its own buckets, so a client spending its search budget still has its read budget.
+const (
+	PolicyAuth   = "auth"   //signing up and logging in: strict, to slow down account creation and password guessing
+	PolicyWrite  = "write"  //creating, editing, and deleting content
+	PolicyRead   = "read"   //feeds, profiles, and other cheap reads
+	PolicySearch = "search" //full text searches
+)
+
+//RatePolicy type defined
+//RatePolicy allows Limit requests per client in each Window.
+type RatePolicy struct {
+	Name   string
+	Limit  int
+	Window time.Duration
+}
+
+//DefaultRatePolicies returns the policies used unless overridden by the rate_limits environment variable.
+func DefaultRatePolicies() map[string]RatePolicy {
+	return map[string]RatePolicy{
+		PolicyAuth:   {Name: PolicyAuth, Limit: 5, Window: time.Minute},
+		PolicyWrite:  {Name: PolicyWrite, Limit: 30, Window: time.Minute},
+		PolicyRead:   {Name: PolicyRead, Limit: 300, Window: time.Minute},
+		PolicySearch: {Name: PolicySearch, Limit: 60, Window: time.Minute},
+	}
+}
+
+//ParseRatePolicies overrides the default policies with a comma separated list of name=limit/window,
+//e.g. "auth=10/1m,read=1000/1m". Policies left out of the list keep their defaults.
+func ParseRatePolicies(spec string) (map[string]RatePolicy, error) {
+
+	policies := DefaultRatePolicies()
+
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		name, budget := part, ""
+		if i := strings.Index(part, "="); i >= 0 {
+			name, budget = strings.TrimSpace(part[:i]), part[i+1:]
+		}
+
+		if _, ok := policies[name]; !ok {
+			return nil, fmt.Errorf("invalid rate limit %q: unknown policy", part)
+		}
+
+		i := strings.Index(budget, "/")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid rate limit %q: want name=limit/window", part)
+		}
+
+		limit, err := strconv.Atoi(strings.TrimSpace(budget[:i]))
+		if err != nil || limit < 1 {
+			return nil, fmt.Errorf("invalid rate limit %q: limit must be a positive number", part)
+		}
+
+		window, err := time.ParseDuration(strings.TrimSpace(budget[i+1:]))
+		if err != nil || window < time.Second {
+			return nil, fmt.Errorf("invalid rate limit %q: window must be a duration of at least 1s", part)
+		}
+
+		policies[name] = RatePolicy{Name: name, Limit: limit, Window: window}
+	}
+
+	return policies, nil
+}
+
+//RateCounter counts requests in fixed windows. The StateStore backends in store.go satisfy it.
+//Incr adds a request to the key's current window, starting a new window if there is none,
+//and returns the number of requests in the window and the time left until it resets.
+type RateCounter interface {
+	Incr(key string, window time.Duration) (int, time.Duration, error)
+}
+
+//RateLimiter type defined
+//RateLimiter holds the policies routes are limited by and the counter their buckets are kept in.
+type RateLimiter struct {
+	policies map[string]RatePolicy
+	counter  RateCounter
+}
+
+//NewRateLimiter returns a RateLimiter enforcing the policies with the counter.
+func NewRateLimiter(policies map[string]RatePolicy, counter RateCounter) *RateLimiter {
+	return &RateLimiter{policies: policies, counter: counter}
+}
+
+//limit controls access to handlers by the named rate limit policy, counting requests per user when the JWT is valid and per IP otherwise,
+//so users behind one NAT do not share a budget. It goes outermost, before any database work: s.limit(policy, s.authenticateJWT(next)).
+//Requests with a bearer token are counted per IP first, and only then is the token looked up to count them per user too,
+//so made-up tokens cannot reach the database faster than the IP's budget. The lookup is kept in context for authenticateToken and identifyToken.
+//Responses carry the RateLimit-* headers, and refused requests a Retry-After header. Without a Limiter on the Server every request is served.
+func (s *Server) limit(policy string, next hr.Handle) hr.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps hr.Params) {
+
+		if s.Limiter == nil {
+			next(w, r, ps)
+			return
+		}
+
+		p, ok := s.Limiter.policies[policy]
+		if !ok {
+			s.Log.Errorln("unknown rate limit policy:", policy)
+			next(w, r, ps)
+			return
+		}
+
+		token, ok := bearerToken(r)
+		if !ok {
+			if s.countRequest(w, r, p, s.rateClient(r)) {
+				next(w, r, ps)
+			}
+			return
+		}
+
+		if !s.countRequest(w, r, p, "ip:"+s.clientIP(r)) {
+			return
+		}
+
+		if strings.HasPrefix(token, accessTokenPrefix) {
+			t, err := s.DB.AccessToken(accessTokenHash(token))
+			if err != nil && err != sql.ErrNoRows {
+				s.Log.Errorln(err)
+			}
+			r = r.WithContext(context.WithValue(r.Context(), accessTokenContextKey, &accessTokenLookup{token: token, t: t, err: err}))
+
+			//a user's tokens share their budget with their JWT
+			if err == nil && !t.Expired(time.Now()) && !s.countRequest(w, r, p, "user:"+t.User.String()) {
+				return
+			}
+		}
+
+		next(w, r, ps)
+
+	}
+
+}
+
+//countRequest counts the request in the client's bucket under the policy and sets the RateLimit-* headers.
+//It reports whether the request may go on, having refused it otherwise.
+func (s *Server) countRequest(w http.ResponseWriter, r *http.Request, p RatePolicy, client string) bool {
+
+	count, reset, err := s.Limiter.counter.Incr("ratelimit:"+p.Name+":"+client, p.Window)
+	if err != nil {
+		//fail open: an unavailable counter should not take the API down with it
+		s.Log.Errorln("error counting request:", err)
+		return true
+	}
+
+	remaining := p.Limit - count
+	if remaining < 0 {
+		remaining = 0
+	}
+	resetSeconds := strconv.Itoa(int(math.Ceil(reset.Seconds())))
+
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(p.Limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(remaining))
+	w.Header().Set("RateLimit-Reset", resetSeconds)
+	w.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", p.Limit, int(p.Window.Seconds())))
+
+	if count > p.Limit {
+		log := s.Log.WithFields(log.Fields{"id": r.Header.Get("X-REQUEST-ID"), "policy": p.Name, "client": client})
+		log.Errorln("request limit reached")
+		w.Header().Set("Retry-After", resetSeconds)
+		http.Error(w, http.StatusText(429), http.StatusTooManyRequests)
+		return false
+	}
+
+	return true
+}
+
+//rateClient names the bucket a request without a bearer token is counted in: the user for a valid JWT, else the client IP as resolved through the trusted proxies.
+//The JWT is only parsed, not checked against the account's standing, to keep the database out of rate limiting.
+func (s *Server) rateClient(r *http.Request) string {
+
+	if _, err := r.Cookie("token-hp"); err == nil {
+		if claims, status := s.parseJWT(r); status == http.StatusOK {
+			return "user:" + claims.ID.String()
+		}
+	}
+
+	return "ip:" + s.clientIP(r)
+}
@@ -0,0 +1,176 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chiips/snippets/API/models"
+	hr "github.com/julienschmidt/httprouter"
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestRateLimit(t *testing.T) {
+
+	router := hr.New()
+	mdb := &mockDB{}
+	s := Server{DB: mdb, Router: router, Log: testLog}
+	s.Limiter = NewRateLimiter(map[string]RatePolicy{
+		PolicyRead:   {Name: PolicyRead, Limit: 2, Window: time.Minute},
+		PolicySearch: {Name: PolicySearch, Limit: 1, Window: time.Minute},
+	}, NewMemoryStore())
+	s.Routes()
+
+	//send runs a GET request from the given address, as the given user unless uuid.Nil, and returns the response
+	send := func(url, addr string, user uuid.UUID) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.RemoteAddr = addr + ":4000"
+		if !uuid.Equal(user, uuid.Nil) {
+			authenticate(t, &s, req, user)
+		}
+
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	//anonymous clients are counted by IP and refused once the budget is spent
+	for i, want := range []string{"1", "0"} {
+		rr := send("/api/posts", "192.0.2.1", uuid.Nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("request %v returned wrong status code:\ngot: %v\nwant: %v", i, rr.Code, http.StatusOK)
+		}
+		if got := rr.Header().Get("RateLimit-Remaining"); got != want {
+			t.Errorf("request %v returned wrong RateLimit-Remaining:\ngot: %v\nwant: %v", i, got, want)
+		}
+		if got := rr.Header().Get("RateLimit-Policy"); got != "2;w=60" {
+			t.Errorf("request %v returned wrong RateLimit-Policy: %v", i, got)
+		}
+	}
+
+	rr := send("/api/posts", "192.0.2.1", uuid.Nil)
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("limited request returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusTooManyRequests)
+	}
+	if rr.Header().Get("Retry-After") == "" || rr.Header().Get("Retry-After") == "0" {
+		t.Errorf("limited request returned wrong Retry-After: %q", rr.Header().Get("Retry-After"))
+	}
+
+	//other IPs, users behind the limited IP, and other policies keep their own budgets
+	for _, tc := range []struct {
+		url, addr string
+		user      uuid.UUID
+	}{
+		{"/api/posts", "192.0.2.2", uuid.Nil},
+		{"/api/posts", "192.0.2.1", userID},
+		{"/api/posts", "192.0.2.1", otherUserID},
+		{"/api/search/posts?q=body", "192.0.2.1", uuid.Nil},
+	} {
+		if rr := send(tc.url, tc.addr, tc.user); rr.Code != http.StatusOK {
+			t.Errorf("%v from %v as %v returned wrong status code:\ngot: %v\nwant: %v", tc.url, tc.addr, tc.user, rr.Code, http.StatusOK)
+		}
+	}
+
+	//a user's budget follows them across IPs
+	if rr := send("/api/posts", "192.0.2.3", userID); rr.Code != http.StatusOK {
+		t.Errorf("user's second request returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusOK)
+	}
+	if rr := send("/api/posts", "192.0.2.4", userID); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("user's third request returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusTooManyRequests)
+	}
+
+	//and across their access tokens, which share it with their JWT
+	mdb.accessTokens = map[uuid.UUID]*models.AccessToken{}
+	for _, token := range []string{accessTokenPrefix + "first", accessTokenPrefix + "second"} {
+		id, err := uuid.NewV4()
+		if err != nil {
+			t.Fatal(err)
+		}
+		mdb.accessTokens[id] = &models.AccessToken{ID: id, User: otherUserID, Hash: accessTokenHash(token), Scopes: []string{ScopePostsRead}, Expires: time.Now().Add(time.Hour)}
+	}
+
+	//sendToken runs a GET request from the given address with the bearer token and returns the response and the number of token lookups it made
+	sendToken := func(token, addr string) (*httptest.ResponseRecorder, int) {
+		req, err := http.NewRequest("GET", "/api/posts", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.RemoteAddr = addr + ":4000"
+		req.Header.Set("Authorization", "Bearer "+accessTokenPrefix+token)
+
+		lookups := mdb.accessTokenLookups
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr, mdb.accessTokenLookups - lookups
+	}
+
+	//each token is looked up once, shared by the limiter and the handler
+	for i, tc := range []struct {
+		token, addr string
+		want        int
+	}{
+		{"first", "192.0.2.5", http.StatusOK},
+		{"second", "192.0.2.6", http.StatusTooManyRequests},
+	} {
+		rr, lookups := sendToken(tc.token, tc.addr)
+		if rr.Code != tc.want {
+			t.Errorf("token request %v returned wrong status code:\ngot: %v\nwant: %v", i, rr.Code, tc.want)
+		}
+		if lookups != 1 {
+			t.Errorf("token request %v looked up the token wrongly:\ngot: %v\nwant: %v", i, lookups, 1)
+		}
+	}
+
+	//unknown tokens are counted by IP, and once it is limited their requests are refused without looking them up
+	for i, want := range []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests, http.StatusTooManyRequests} {
+		wantLookups := 1
+		if want == http.StatusTooManyRequests {
+			wantLookups = 0
+		}
+		rr, lookups := sendToken(fmt.Sprintf("unknown-%v", i), "192.0.2.7")
+		if rr.Code != want {
+			t.Errorf("unknown token request %v returned wrong status code:\ngot: %v\nwant: %v", i, rr.Code, want)
+		}
+		if lookups != wantLookups {
+			t.Errorf("unknown token request %v looked up the token wrongly:\ngot: %v\nwant: %v", i, lookups, wantLookups)
+		}
+	}
+
+	//routes without a limiter are not limited
+	s.Limiter = nil
+	if rr := send("/api/posts", "192.0.2.1", uuid.Nil); rr.Code != http.StatusOK || rr.Header().Get("RateLimit-Limit") != "" {
+		t.Errorf("unlimited request returned wrong status code or headers: %v %v", rr.Code, rr.Header())
+	}
+}
+
+func TestParseRatePolicies(t *testing.T) {
+
+	policies, err := ParseRatePolicies(" auth=10/30s, read=1000/1h")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]RatePolicy{
+		PolicyAuth:   {Name: PolicyAuth, Limit: 10, Window: 30 * time.Second},
+		PolicyRead:   {Name: PolicyRead, Limit: 1000, Window: time.Hour},
+		PolicyWrite:  DefaultRatePolicies()[PolicyWrite],
+		PolicySearch: DefaultRatePolicies()[PolicySearch],
+	}
+	for name, policy := range want {
+		if policies[name] != policy {
+			t.Errorf("policy %v parsed wrongly:\ngot: %+v\nwant: %+v", name, policies[name], policy)
+		}
+	}
+
+	for _, spec := range []string{"login=5/1m", "auth=5", "auth=0/1m", "auth=five/1m", "auth=5/1ms", "auth=5/minute"} {
+		if _, err := ParseRatePolicies(spec); err == nil || !strings.Contains(err.Error(), "invalid rate limit") {
+			t.Errorf("ParseRatePolicies(%q) returned wrong error: %v", spec, err)
+		}
+	}
+}
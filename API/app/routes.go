@@ -1,19 +1,118 @@
 package app
 
+import "github.com/chiips/snippets/API/models"
+
 //Routes initiates our Server's routes
 func (s *Server) Routes() {
 
+	//limit middleware outermost on every route, by the policy the route's cost calls for (see ratelimit.go)
+
 	//Sample user routes
 	//authenticateJWT middleware on routes that require authorization
-	s.Router.GET("/api/search", s.searchUsers())
-	s.Router.POST("/api/signup", s.signup())
-	s.Router.PUT("/api/profilephoto/:userid", s.authenticateJWT(s.editProfilePhoto()))
-	s.Router.DELETE("/api/profile/:userid", s.authenticateJWT(s.deleteUser()))
+	//authenticateToken and identifyToken in its place on routes personal access tokens may use, with the scope they need
+	s.Router.GET("/api/search", s.limit(PolicySearch, s.searchUsers()))
+	s.Router.GET("/api/users/autocomplete", s.limit(PolicyRead, s.autocompleteUsers()))
+	s.Router.POST("/api/signup", s.limit(PolicyAuth, s.signup()))
+	s.Router.POST("/api/login", s.limit(PolicyAuth, s.login()))
+	s.Router.POST("/api/login/unlock", s.limit(PolicyAuth, s.unlock()))
+	s.Router.POST("/api/login/mfa", s.limit(PolicyAuth, s.loginMFA()))
+	s.Router.POST("/api/logout", s.limit(PolicyWrite, s.logout()))
+	s.Router.PUT("/api/profilephoto/:userid", s.limit(PolicyWrite, s.authenticateToken(ScopeProfileWrite, s.editProfilePhoto())))
+	s.Router.PUT("/api/profile/:userid/password", s.limit(PolicyAuth, s.authenticateJWT(s.changePassword())))
+	s.Router.DELETE("/api/profile/:userid", s.limit(PolicyWrite, s.authenticateJWT(s.deleteUser())))
+
+	//Sample session routes
+	//the session id "others" revokes every session but the current one
+	s.Router.GET("/api/sessions", s.limit(PolicyRead, s.authenticateJWT(s.sessions())))
+	s.Router.POST("/api/sessions/refresh", s.limit(PolicyAuth, s.authenticateJWT(s.refreshSession())))
+	s.Router.DELETE("/api/sessions/:sessionid", s.limit(PolicyAuth, s.authenticateJWT(s.deleteSession())))
+
+	//Sample two-factor authentication routes
+	s.Router.POST("/api/mfa/totp", s.limit(PolicyAuth, s.authenticateJWT(s.enrollTOTP())))
+	s.Router.POST("/api/mfa/totp/confirm", s.limit(PolicyAuth, s.authenticateJWT(s.confirmTOTP())))
+	s.Router.DELETE("/api/mfa/totp", s.limit(PolicyAuth, s.authenticateJWT(s.disableTOTP())))
+
+	//Sample passkey routes
+	s.Router.POST("/api/passkeys/options", s.limit(PolicyAuth, s.authenticateJWT(s.passkeyOptions())))
+	s.Router.POST("/api/passkeys", s.limit(PolicyAuth, s.authenticateJWT(s.registerPasskey())))
+	s.Router.GET("/api/passkeys", s.limit(PolicyRead, s.authenticateJWT(s.passkeys())))
+	s.Router.DELETE("/api/passkeys/:passkeyid", s.limit(PolicyAuth, s.authenticateJWT(s.deletePasskey())))
+	s.Router.POST("/api/login/passkey/options", s.limit(PolicyAuth, s.passkeyLoginOptions()))
+	s.Router.POST("/api/login/passkey", s.limit(PolicyAuth, s.loginPasskey()))
+
+	//Sample external login routes
+	//the callback is a GET the provider redirects the user's browser to
+	s.Router.GET("/api/oidc", s.limit(PolicyRead, s.oidcProviders()))
+	s.Router.POST("/api/oidc/:provider/login", s.limit(PolicyAuth, s.oidcLogin()))
+	s.Router.POST("/api/oidc/:provider/link", s.limit(PolicyAuth, s.authenticateJWT(s.oidcLink())))
+	s.Router.GET("/api/oidc/:provider/callback", s.limit(PolicyAuth, s.oidcCallback()))
+	s.Router.GET("/api/identities", s.limit(PolicyRead, s.authenticateJWT(s.identities())))
+	s.Router.DELETE("/api/identities/:provider", s.limit(PolicyAuth, s.authenticateJWT(s.unlinkIdentity())))
+
+	//Sample access token routes
+	s.Router.POST("/api/tokens", s.limit(PolicyAuth, s.authenticateJWT(s.createAccessToken())))
+	s.Router.GET("/api/tokens", s.limit(PolicyRead, s.authenticateJWT(s.accessTokens())))
+	s.Router.DELETE("/api/tokens/:tokenid", s.limit(PolicyAuth, s.authenticateJWT(s.deleteAccessToken())))
+
+	//Sample follow routes
+	s.Router.GET("/api/profile/:userid", s.limit(PolicyRead, s.identifyToken(ScopeProfileRead, s.userProfile())))
+	s.Router.PUT("/api/profile/:userid/follow", s.limit(PolicyWrite, s.authenticateToken(ScopeProfileWrite, s.follow())))
+	s.Router.DELETE("/api/profile/:userid/follow", s.limit(PolicyWrite, s.authenticateToken(ScopeProfileWrite, s.unfollow())))
+	s.Router.GET("/api/profile/:userid/followers", s.limit(PolicyRead, s.followers()))
+	s.Router.GET("/api/profile/:userid/following", s.limit(PolicyRead, s.following()))
+	s.Router.GET("/api/timeline", s.limit(PolicyRead, s.authenticateToken(ScopePostsRead, s.timeline())))
 
 	//Sample post routes
 	//authenticateJWT middleware on routes that require authorization
-	s.Router.GET("/api/posts", s.allPosts())
-	s.Router.POST("/api/post", s.authenticateJWT(s.submitPost()))
-	s.Router.PUT("/api/post", s.authenticateJWT(s.editPost()))
-	s.Router.DELETE("/api/post/:postid", s.authenticateJWT(s.deletePost()))
+	//identifyJWT middleware on public routes whose response depends on the logged in viewer
+	s.Router.GET("/api/posts", s.limit(PolicyRead, s.identifyToken(ScopePostsRead, s.allPosts())))
+	s.Router.GET("/api/post/:postid", s.limit(PolicyRead, s.identifyToken(ScopePostsRead, s.onePost())))
+	s.Router.POST("/api/post", s.limit(PolicyWrite, s.authenticateToken(ScopePostsWrite, s.submitPost())))
+	s.Router.PUT("/api/post", s.limit(PolicyWrite, s.authenticateToken(ScopePostsWrite, s.editPost())))
+	s.Router.DELETE("/api/post/:postid", s.limit(PolicyWrite, s.authenticateToken(ScopePostsWrite, s.deletePost())))
+	s.Router.GET("/api/search/posts", s.limit(PolicySearch, s.identifyToken(ScopePostsRead, s.searchPosts())))
+
+	//Sample tag routes
+	//trendingTags answers /api/tags/trending through the :tag wildcard the tag feeds need
+	s.Router.GET("/api/tags/:tag", s.limit(PolicyRead, s.trendingTags()))
+	s.Router.GET("/api/tags/:tag/posts", s.limit(PolicyRead, s.identifyToken(ScopePostsRead, s.tagPosts())))
+
+	//Sample revision routes
+	s.Router.GET("/api/post/:postid/revisions", s.limit(PolicyRead, s.identifyToken(ScopePostsRead, s.postRevisions())))
+	s.Router.GET("/api/post/:postid/diff", s.limit(PolicyRead, s.identifyToken(ScopePostsRead, s.diffRevisions())))
+	s.Router.POST("/api/post/:postid/revisions/:number/restore", s.limit(PolicyWrite, s.authenticateToken(ScopePostsWrite, s.restoreRevision())))
+
+	//Sample comment routes
+	s.Router.GET("/api/post/:postid/comments", s.limit(PolicyRead, s.identifyToken(ScopePostsRead, s.postComments())))
+	s.Router.POST("/api/post/:postid/comments", s.limit(PolicyWrite, s.authenticateToken(ScopePostsWrite, s.submitComment())))
+	s.Router.PUT("/api/post/:postid/comments/:commentid", s.limit(PolicyWrite, s.authenticateToken(ScopePostsWrite, s.editComment())))
+	s.Router.DELETE("/api/post/:postid/comments/:commentid", s.limit(PolicyWrite, s.authenticateToken(ScopePostsWrite, s.deleteComment())))
+
+	//Sample reaction routes
+	s.Router.GET("/api/reactions", s.limit(PolicyRead, s.reactionSet()))
+	s.Router.PUT("/api/post/:postid/reactions/:reaction", s.limit(PolicyWrite, s.authenticateToken(ScopePostsWrite, s.addReaction())))
+	s.Router.DELETE("/api/post/:postid/reactions/:reaction", s.limit(PolicyWrite, s.authenticateToken(ScopePostsWrite, s.removeReaction())))
+
+	//Sample moderation routes
+	s.Router.POST("/api/report", s.limit(PolicyWrite, s.authenticateJWT(s.report())))
+	s.Router.GET("/api/moderation/notices", s.limit(PolicyRead, s.authenticateJWT(s.notices())))
+	s.Router.POST("/api/moderation/actions/:actionid/appeal", s.limit(PolicyWrite, s.authenticateJWT(s.appeal())))
+	s.Router.GET("/api/moderation/reports", s.limit(PolicyRead, s.authenticateJWT(s.requirePermission(PermModerateContent, s.reportQueue()))))
+	s.Router.POST("/api/moderation/reports/:reportid/actions", s.limit(PolicyWrite, s.authenticateJWT(s.requirePermission(PermModerateContent, s.moderate()))))
+	s.Router.GET("/api/moderation/appeals", s.limit(PolicyRead, s.authenticateJWT(s.requirePermission(PermModerateContent, s.appeals()))))
+	s.Router.PUT("/api/moderation/appeals/:appealid", s.limit(PolicyWrite, s.authenticateJWT(s.requirePermission(PermModerateContent, s.decideAppeal()))))
+
+	//Sample admin routes
+	//requireRole and requirePermission go inside authenticateJWT, which puts the user's roles in context
+	s.Router.GET("/api/admin/users/:userid/roles", s.limit(PolicyRead, s.authenticateJWT(s.requirePermission(PermManageRoles, s.roles()))))
+	s.Router.PUT("/api/admin/users/:userid/roles/:role", s.limit(PolicyWrite, s.authenticateJWT(s.requirePermission(PermManageRoles, s.grantRole()))))
+	s.Router.DELETE("/api/admin/users/:userid/roles/:role", s.limit(PolicyWrite, s.authenticateJWT(s.requirePermission(PermManageRoles, s.revokeRole()))))
+	s.Router.GET("/api/admin/rolechanges", s.limit(PolicyRead, s.authenticateJWT(s.requireRole(models.RoleAdmin, s.roleChanges()))))
+	s.Router.GET("/api/admin/users/:userid/standing", s.limit(PolicyRead, s.authenticateJWT(s.requirePermission(PermManageAccounts, s.standing()))))
+	s.Router.PUT("/api/admin/users/:userid/suspension", s.limit(PolicyWrite, s.authenticateJWT(s.requirePermission(PermManageAccounts, s.suspend()))))
+	s.Router.DELETE("/api/admin/users/:userid/suspension", s.limit(PolicyWrite, s.authenticateJWT(s.requirePermission(PermManageAccounts, s.unsuspend()))))
+	s.Router.PUT("/api/admin/users/:userid/shadowban", s.limit(PolicyWrite, s.authenticateJWT(s.requirePermission(PermManageAccounts, s.shadowban()))))
+	s.Router.DELETE("/api/admin/users/:userid/shadowban", s.limit(PolicyWrite, s.authenticateJWT(s.requirePermission(PermManageAccounts, s.unshadowban()))))
+	s.Router.GET("/api/admin/audit", s.limit(PolicyRead, s.authenticateJWT(s.requirePermission(PermReadAudit, s.auditEvents()))))
+	s.Router.GET("/api/admin/audit/export", s.limit(PolicyRead, s.authenticateJWT(s.requirePermission(PermReadAudit, s.exportAuditEvents()))))
 }
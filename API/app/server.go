@@ -1,15 +1,46 @@
 package app
 
 import (
+	"net"
+
+	"github.com/chiips/snippets/API/breach"
 	"github.com/chiips/snippets/API/logs"
 	"github.com/chiips/snippets/API/models"
+	"github.com/chiips/snippets/API/oidc"
+	"github.com/chiips/snippets/API/passwords"
+	"github.com/chiips/snippets/API/webauthn"
 	hr "github.com/julienschmidt/httprouter"
 )
 
-//Server struct includes our datastore, router, and logger.
+//Server struct includes our datastore, router, logger, rate limiter, the state store the limiter, JWT revocation, and login protection share,
+//the reverse proxies trusted to report client IPs and the header they report them in, the mailer for emailing users, the hasher for users' passwords,
+//the filter of breached passwords new passwords are checked against, the relying party passkeys are registered with,
+//the OpenID Connect providers users log in with by name, and the key pagination cursors are signed with.
 //All handlers hang off this Server struct to access its components via dependency injection as needed.
 type Server struct {
-	DB     models.Datastore
-	Router *hr.Router
-	Log    *logs.Log
+	DB      models.Datastore
+	Router  *hr.Router
+	Log     *logs.Log
+	Limiter *RateLimiter
+	State   StateStore
+
+	TrustedProxies  []*net.IPNet
+	ForwardedHeader string
+	Mail            Mailer
+	Passwords       *passwords.Hasher
+	Breached        *breach.Filter
+	WebAuthn        *webauthn.RelyingParty
+	Providers       map[string]*oidc.Provider
+	CursorKey       []byte
+}
+
+//defaultHasher hashes passwords for servers without their own Hasher
+var defaultHasher = passwords.NewHasher()
+
+//hasher returns the Hasher for users' passwords
+func (s *Server) hasher() *passwords.Hasher {
+	if s.Passwords == nil {
+		return defaultHasher
+	}
+	return s.Passwords
 }
@@ -0,0 +1,95 @@
+package app
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/chiips/snippets/API/models"
+	uuid "github.com/satori/go.uuid"
+)
+
+//Sessions. Every login starts a session, named in its JWT, recording the device's user agent and IP. The client keeps the session going
+//by refreshing its JWT before it expires, for up to sessionMaxAge, and users list their sessions and revoke any of them, which
+//authenticateJWT enforces on the session's JWT at once.
+const (
+	sessionMaxAge = 30 * 24 * time.Hour
+	//sessionSeenInterval is how stale a session's last seen time may get before it is recorded again, to keep writes off every request
+	sessionSeenInterval = time.Minute
+	maxUserAgent        = 255
+)
+
+//sessionContextKey holds the session of the user's JWT, for the session handlers
+const sessionContextKey contextKey = "session"
+
+//startSession starts a session for a user logging in from the request and sends its JWT in cookies, holding the given roles.
+func (s *Server) startSession(w http.ResponseWriter, r *http.Request, id uuid.UUID, roles []string) error {
+
+	sessionID, err := uuid.NewV4()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	session := &models.Session{
+		ID:        sessionID,
+		User:      id,
+		UserAgent: userAgent(r),
+		IP:        s.clientIP(r),
+		Created:   now,
+		LastSeen:  now,
+		Expires:   now.Add(jwtLifetime),
+	}
+
+	err = s.DB.CreateSession(session)
+	if err != nil {
+		return err
+	}
+
+	return s.setJWTCookies(w, id, roles, sessionID)
+}
+
+//activeSession checks the session a JWT was created in has not been revoked or expired, recording that it was seen.
+//It returns the status to send the client if the session is gone, or http.StatusOK.
+func (s *Server) activeSession(claims *MyClaims) int {
+
+	session, err := s.DB.Session(claims.Session)
+	switch {
+	case err == sql.ErrNoRows:
+		s.Log.Errorln("JWT for revoked session:", claims.ID, claims.Session)
+		return http.StatusUnauthorized
+	case err != nil:
+		s.Log.Errorln(err)
+		return http.StatusInternalServerError
+	}
+
+	if !uuid.Equal(session.User, claims.ID) {
+		s.Log.Errorln("JWT for another user's session:", claims.ID, claims.Session)
+		return http.StatusUnauthorized
+	}
+
+	now := time.Now()
+	if now.After(session.Expires) {
+		s.Log.Errorln("JWT for expired session:", claims.ID, claims.Session)
+		return http.StatusUnauthorized
+	}
+
+	//a failure to record the session was seen does not refuse the request
+	if now.Sub(session.LastSeen) >= sessionSeenInterval {
+		err := s.DB.SeeSession(session.ID, now.UTC())
+		if err != nil {
+			s.Log.Errorln("error recording session seen:", err)
+		}
+	}
+
+	return http.StatusOK
+}
+
+//userAgent returns the user agent of the request, keeping only the start of overlong ones.
+func userAgent(r *http.Request) string {
+	ua := []rune(r.UserAgent())
+	if len(ua) > maxUserAgent {
+		ua = ua[:maxUserAgent]
+	}
+	return string(ua)
+}
@@ -0,0 +1,516 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//StateStore keeps the short-lived state the rate limiter, JWT revocation, and login protection need.
+//Every API instance given the same Redis store shares its state, so limits and revocations hold across instances;
+//the memory store keeps the state to one instance and is the default.
+type StateStore interface {
+	//Incr satisfies the RateCounter interface.
+	Incr(key string, window time.Duration) (int, time.Duration, error)
+	//Decr takes one back from the key's count in its current window, ignoring a key not stored.
+	Decr(key string) error
+	//Set stores the key for ttl. A ttl of zero or less stores nothing.
+	Set(key string, ttl time.Duration) error
+	//Exists reports whether the key is stored and has not expired.
+	Exists(key string) (bool, error)
+	//TTL returns the time left until the key expires, or zero if it is not stored.
+	TTL(key string) (time.Duration, error)
+	//Delete removes the keys, ignoring any not stored.
+	Delete(keys ...string) error
+}
+
+//NewStateStore returns a Redis store for a redis:// URL, e.g. redis://:password@localhost:6379/0, or the memory store for an empty URL.
+func NewStateStore(redisURL string) (StateStore, error) {
+
+	if redisURL == "" {
+		return NewMemoryStore(), nil
+	}
+
+	return NewRedisStore(redisURL)
+}
+
+//stateEntry is one key in a memoryStore
+type stateEntry struct {
+	count   int
+	expires time.Time
+}
+
+//memoryStore keeps the state in the server's memory.
+type memoryStore struct {
+	mu      sync.Mutex
+	entries map[string]*stateEntry
+	sweep   time.Time
+}
+
+//NewMemoryStore returns a StateStore that keeps its state in memory.
+func NewMemoryStore() StateStore {
+	return &memoryStore{entries: make(map[string]*stateEntry)}
+}
+
+//entry returns the key's unexpired entry or nil, dropping expired entries now and then so keys no longer used do not hold memory.
+//The caller holds the lock.
+func (m *memoryStore) entry(key string, now time.Time) *stateEntry {
+
+	if now.After(m.sweep) {
+		for k, e := range m.entries {
+			if !now.Before(e.expires) {
+				delete(m.entries, k)
+			}
+		}
+		m.sweep = now.Add(time.Minute)
+	}
+
+	e, ok := m.entries[key]
+	if !ok || !now.Before(e.expires) {
+		return nil
+	}
+
+	return e
+}
+
+//Incr satisfies the StateStore interface.
+func (m *memoryStore) Incr(key string, window time.Duration) (int, time.Duration, error) {
+
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e := m.entry(key, now)
+	if e == nil {
+		e = &stateEntry{expires: now.Add(window)}
+		m.entries[key] = e
+	}
+	e.count++
+
+	return e.count, e.expires.Sub(now), nil
+}
+
+//Decr satisfies the StateStore interface.
+func (m *memoryStore) Decr(key string) error {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if e := m.entry(key, time.Now()); e != nil && e.count > 0 {
+		e.count--
+	}
+
+	return nil
+}
+
+//Set satisfies the StateStore interface.
+func (m *memoryStore) Set(key string, ttl time.Duration) error {
+
+	if ttl <= 0 {
+		return nil
+	}
+
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.entries[key] = &stateEntry{count: 1, expires: now.Add(ttl)}
+
+	return nil
+}
+
+//Exists satisfies the StateStore interface.
+func (m *memoryStore) Exists(key string) (bool, error) {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.entry(key, time.Now()) != nil, nil
+}
+
+//TTL satisfies the StateStore interface.
+func (m *memoryStore) TTL(key string) (time.Duration, error) {
+
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e := m.entry(key, now)
+	if e == nil {
+		return 0, nil
+	}
+
+	return e.expires.Sub(now), nil
+}
+
+//Delete satisfies the StateStore interface.
+func (m *memoryStore) Delete(keys ...string) error {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, key := range keys {
+		delete(m.entries, key)
+	}
+
+	return nil
+}
+
+//connections kept open to Redis between requests, and the deadline for each exchange with it
+const (
+	redisPoolSize = 10
+	redisTimeout  = time.Second
+)
+
+//errRedisProtocol is returned when Redis replies with something other than RESP
+var errRedisProtocol = errors.New("redis: invalid reply")
+
+//redisError is an error reply from Redis
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+//redisConn is one connection to Redis
+type redisConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+//redisStore keeps the state in Redis, or any server speaking its protocol, through a small pool of connections.
+type redisStore struct {
+	addr     string
+	password string
+	db       int
+	pool     chan *redisConn
+}
+
+//NewRedisStore returns a StateStore that keeps its state in the Redis server at the redis:// URL,
+//checking that the server can be reached with the URL's password and database.
+func NewRedisStore(redisURL string) (StateStore, error) {
+
+	u, err := url.Parse(redisURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "redis" || u.Host == "" {
+		return nil, fmt.Errorf("invalid redis URL %q: want redis://[:password@]host[:port][/db]", redisURL)
+	}
+
+	store := &redisStore{addr: u.Host, pool: make(chan *redisConn, redisPoolSize)}
+	if u.Port() == "" {
+		store.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+
+	if u.User != nil {
+		store.password, _ = u.User.Password()
+	}
+
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		store.db, err = strconv.Atoi(db)
+		if err != nil || store.db < 0 {
+			return nil, fmt.Errorf("invalid redis URL %q: database must be a number", redisURL)
+		}
+	}
+
+	if _, err := store.do([]string{"PING"}); err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+//Incr satisfies the StateStore interface.
+//The window is started, counted, and read in one transaction, so a window cannot be left without an expiry.
+func (s *redisStore) Incr(key string, window time.Duration) (int, time.Duration, error) {
+
+	ms := strconv.FormatInt(window.Milliseconds(), 10)
+
+	replies, err := s.do(
+		[]string{"MULTI"},
+		[]string{"SET", key, "0", "PX", ms, "NX"},
+		[]string{"INCR", key},
+		[]string{"PTTL", key},
+		[]string{"EXEC"},
+	)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	exec, ok := replies[4].([]interface{})
+	if !ok || len(exec) != 3 {
+		return 0, 0, errRedisProtocol
+	}
+	count, ok := exec[1].(int64)
+	if !ok {
+		return 0, 0, errRedisProtocol
+	}
+	ttl, ok := exec[2].(int64)
+	if !ok {
+		return 0, 0, errRedisProtocol
+	}
+
+	reset := time.Duration(ttl) * time.Millisecond
+	if reset < 0 {
+		reset = window
+	}
+
+	return int(count), reset, nil
+}
+
+//Decr satisfies the StateStore interface.
+//A key not stored is set to 1 for a millisecond before the decrement, so it is not left stored without an expiry.
+func (s *redisStore) Decr(key string) error {
+
+	_, err := s.do(
+		[]string{"MULTI"},
+		[]string{"SET", key, "1", "PX", "1", "NX"},
+		[]string{"DECR", key},
+		[]string{"EXEC"},
+	)
+	return err
+}
+
+//Set satisfies the StateStore interface.
+func (s *redisStore) Set(key string, ttl time.Duration) error {
+
+	if ttl <= 0 {
+		return nil
+	}
+
+	//PX takes whole milliseconds and refuses 0
+	ms := ttl.Milliseconds()
+	if ms < 1 {
+		ms = 1
+	}
+
+	_, err := s.do([]string{"SET", key, "1", "PX", strconv.FormatInt(ms, 10)})
+	return err
+}
+
+//Exists satisfies the StateStore interface.
+func (s *redisStore) Exists(key string) (bool, error) {
+
+	replies, err := s.do([]string{"EXISTS", key})
+	if err != nil {
+		return false, err
+	}
+
+	n, ok := replies[0].(int64)
+	if !ok {
+		return false, errRedisProtocol
+	}
+
+	return n > 0, nil
+}
+
+//TTL satisfies the StateStore interface.
+func (s *redisStore) TTL(key string) (time.Duration, error) {
+
+	replies, err := s.do([]string{"PTTL", key})
+	if err != nil {
+		return 0, err
+	}
+
+	ms, ok := replies[0].(int64)
+	if !ok {
+		return 0, errRedisProtocol
+	}
+
+	//-2 for a key not stored; -1, for a key without an expiry, is never set by this store
+	if ms < 0 {
+		return 0, nil
+	}
+
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+//Delete satisfies the StateStore interface.
+func (s *redisStore) Delete(keys ...string) error {
+
+	if len(keys) == 0 {
+		return nil
+	}
+
+	_, err := s.do(append([]string{"DEL"}, keys...))
+	return err
+}
+
+//do sends the commands to Redis in one write and returns their replies in order.
+//An error reply to any command is returned as the error.
+func (s *redisStore) do(commands ...[]string) ([]interface{}, error) {
+
+	conn, err := s.get()
+	if err != nil {
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Now().Add(redisTimeout))
+
+	buf := &bytes.Buffer{}
+	for _, command := range commands {
+		writeCommand(buf, command)
+	}
+
+	if _, err := conn.Write(buf.Bytes()); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	replies := make([]interface{}, len(commands))
+	var replyErr error
+
+	for i := range replies {
+		replies[i], err = readReply(conn.r)
+		if err != nil {
+			//the connection is out of step with its replies and cannot be reused
+			conn.Close()
+			return nil, err
+		}
+		if e, ok := replies[i].(redisError); ok && replyErr == nil {
+			replyErr = e
+		}
+	}
+
+	s.put(conn)
+
+	return replies, replyErr
+}
+
+//get takes a connection from the pool, or dials a new one if the pool is empty.
+func (s *redisStore) get() (*redisConn, error) {
+
+	select {
+	case conn := <-s.pool:
+		return conn, nil
+	default:
+	}
+
+	c, err := net.DialTimeout("tcp", s.addr, redisTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	conn := &redisConn{Conn: c, r: bufio.NewReader(c)}
+	conn.SetDeadline(time.Now().Add(redisTimeout))
+
+	setup := [][]string{}
+	if s.password != "" {
+		setup = append(setup, []string{"AUTH", s.password})
+	}
+	if s.db != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(s.db)})
+	}
+
+	for _, command := range setup {
+		buf := &bytes.Buffer{}
+		writeCommand(buf, command)
+		if _, err := conn.Write(buf.Bytes()); err != nil {
+			conn.Close()
+			return nil, err
+		}
+
+		reply, err := readReply(conn.r)
+		if err == nil {
+			if e, ok := reply.(redisError); ok {
+				err = e
+			}
+		}
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
+
+//put returns a connection to the pool, closing it if the pool is full.
+func (s *redisStore) put(conn *redisConn) {
+
+	select {
+	case s.pool <- conn:
+	default:
+		conn.Close()
+	}
+}
+
+//writeCommand writes a command as a RESP array of bulk strings.
+func writeCommand(buf *bytes.Buffer, args []string) {
+
+	fmt.Fprintf(buf, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(buf, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+}
+
+//readReply reads one RESP reply: a string for simple and bulk strings, redisError for errors, int64 for integers,
+//[]interface{} for arrays, and nil for null bulk strings and arrays.
+func readReply(r *bufio.Reader) (interface{}, error) {
+
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errRedisProtocol
+	}
+
+	kind, body := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return redisError(body), nil
+	case ':':
+		n, err := strconv.ParseInt(body, 10, 64)
+		if err != nil {
+			return nil, errRedisProtocol
+		}
+		return n, nil
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, errRedisProtocol
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		return string(b[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, errRedisProtocol
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			items[i], err = readReply(r)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+
+	return nil, errRedisProtocol
+}
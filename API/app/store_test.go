@@ -0,0 +1,357 @@
+package app
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	hr "github.com/julienschmidt/httprouter"
+)
+
+//fakeRedis is an in-process stand-in for Redis speaking its protocol, with the commands the Redis store sends.
+type fakeRedis struct {
+	net.Listener
+	password string
+
+	mu      sync.Mutex
+	values  map[string]string
+	expires map[string]time.Time
+}
+
+//newFakeRedis starts a fakeRedis on a free local port, requiring the password if it is not empty.
+func newFakeRedis(t *testing.T, password string) *fakeRedis {
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &fakeRedis{Listener: l, password: password, values: map[string]string{}, expires: map[string]time.Time{}}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+
+	return f
+}
+
+//serve answers the commands sent on one connection
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	authed := f.password == ""
+	var queued [][]string
+
+	for {
+		reply, err := readReply(r)
+		if err != nil {
+			return
+		}
+		items, _ := reply.([]interface{})
+		args := make([]string, len(items))
+		for i, item := range items {
+			args[i], _ = item.(string)
+		}
+		if len(args) == 0 {
+			return
+		}
+
+		name := strings.ToUpper(args[0])
+		var out string
+
+		switch {
+		case name == "AUTH":
+			authed = len(args) == 2 && args[1] == f.password
+			out = "+OK\r\n"
+			if !authed {
+				out = "-WRONGPASS invalid password\r\n"
+			}
+		case !authed:
+			out = "-NOAUTH Authentication required.\r\n"
+		case name == "MULTI":
+			queued = [][]string{}
+			out = "+OK\r\n"
+		case name == "EXEC":
+			out = fmt.Sprintf("*%d\r\n", len(queued))
+			for _, command := range queued {
+				out += f.exec(command)
+			}
+			queued = nil
+		case queued != nil:
+			queued = append(queued, args)
+			out = "+QUEUED\r\n"
+		default:
+			out = f.exec(args)
+		}
+
+		if _, err := conn.Write([]byte(out)); err != nil {
+			return
+		}
+	}
+}
+
+//exec runs one command and returns its RESP reply
+func (f *fakeRedis) exec(args []string) string {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	//expire keys lazily, as Redis does on access
+	now := time.Now()
+	for k, at := range f.expires {
+		if !now.Before(at) {
+			delete(f.values, k)
+			delete(f.expires, k)
+		}
+	}
+
+	switch strings.ToUpper(args[0]) {
+	case "PING":
+		return "+PONG\r\n"
+	case "SELECT":
+		return "+OK\r\n"
+	case "SET":
+		key, value := args[1], args[2]
+		var ttl time.Duration
+		nx := false
+		for i := 3; i < len(args); i++ {
+			switch strings.ToUpper(args[i]) {
+			case "NX":
+				nx = true
+			case "PX":
+				i++
+				ms, err := strconv.Atoi(args[i])
+				if err != nil || ms < 1 {
+					return "-ERR invalid expire time in 'set' command\r\n"
+				}
+				ttl = time.Duration(ms) * time.Millisecond
+			}
+		}
+		if _, ok := f.values[key]; ok && nx {
+			return "$-1\r\n"
+		}
+		f.values[key] = value
+		delete(f.expires, key)
+		if ttl > 0 {
+			f.expires[key] = now.Add(ttl)
+		}
+		return "+OK\r\n"
+	case "INCR":
+		n, _ := strconv.Atoi(f.values[args[1]])
+		n++
+		f.values[args[1]] = strconv.Itoa(n)
+		return fmt.Sprintf(":%d\r\n", n)
+	case "DECR":
+		n, _ := strconv.Atoi(f.values[args[1]])
+		n--
+		f.values[args[1]] = strconv.Itoa(n)
+		return fmt.Sprintf(":%d\r\n", n)
+	case "PTTL":
+		if _, ok := f.values[args[1]]; !ok {
+			return ":-2\r\n"
+		}
+		at, ok := f.expires[args[1]]
+		if !ok {
+			return ":-1\r\n"
+		}
+		return fmt.Sprintf(":%d\r\n", at.Sub(now).Milliseconds())
+	case "DEL":
+		deleted := 0
+		for _, key := range args[1:] {
+			if _, ok := f.values[key]; ok {
+				deleted++
+			}
+			delete(f.values, key)
+			delete(f.expires, key)
+		}
+		return fmt.Sprintf(":%d\r\n", deleted)
+	case "EXISTS":
+		if _, ok := f.values[args[1]]; ok {
+			return ":1\r\n"
+		}
+		return ":0\r\n"
+	}
+
+	return fmt.Sprintf("-ERR unknown command '%s'\r\n", args[0])
+}
+
+func TestStateStores(t *testing.T) {
+
+	redis := newFakeRedis(t, "secret")
+
+	if _, err := NewRedisStore(fmt.Sprintf("redis://:wrong@%s/1", redis.Addr())); err == nil {
+		t.Error("redis store connected with the wrong password")
+	}
+	if _, err := NewStateStore("http://localhost:6379"); err == nil {
+		t.Error("state store accepted a URL that is not redis://")
+	}
+
+	redisStore, err := NewStateStore(fmt.Sprintf("redis://:secret@%s/1", redis.Addr()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	memoryStore, err := NewStateStore("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, store := range map[string]StateStore{"memory": memoryStore, "redis": redisStore} {
+
+		//counts run up within a window and start over in the next
+		for want := 1; want <= 3; want++ {
+			count, reset, err := store.Incr("count", time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count != want || reset <= 0 || reset > time.Minute {
+				t.Errorf("%v store counted wrongly:\ngot: %v, %v\nwant: %v within a minute", name, count, reset, want)
+			}
+		}
+
+		//counts taken back keep their window, and keys not stored stay so
+		if err := store.Decr("count"); err != nil {
+			t.Fatal(err)
+		}
+		if count, _, err := store.Incr("count", time.Minute); err != nil || count != 3 {
+			t.Errorf("%v store did not take back a count: %v %v", name, count, err)
+		}
+		if err := store.Decr("uncounted"); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(5 * time.Millisecond)
+		if got, err := store.Exists("uncounted"); err != nil || got {
+			t.Errorf("%v store stored a key not counted: %v %v", name, got, err)
+		}
+
+		if _, _, err := store.Incr("short", 50*time.Millisecond); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(70 * time.Millisecond)
+		if count, _, err := store.Incr("short", 50*time.Millisecond); err != nil || count != 1 {
+			t.Errorf("%v store did not start a new window: %v %v", name, count, err)
+		}
+
+		//keys are stored until they expire
+		if err := store.Set("key", 50*time.Millisecond); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Set("expired", 0); err != nil {
+			t.Fatal(err)
+		}
+		for key, want := range map[string]bool{"key": true, "expired": false, "missing": false} {
+			if got, err := store.Exists(key); err != nil || got != want {
+				t.Errorf("%v store Exists(%v) returned wrongly:\ngot: %v %v\nwant: %v", name, key, got, err, want)
+			}
+		}
+		if ttl, err := store.TTL("key"); err != nil || ttl <= 0 || ttl > 50*time.Millisecond {
+			t.Errorf("%v store returned wrong TTL: %v %v", name, ttl, err)
+		}
+		time.Sleep(70 * time.Millisecond)
+		if got, err := store.Exists("key"); err != nil || got {
+			t.Errorf("%v store kept an expired key: %v %v", name, got, err)
+		}
+		if ttl, err := store.TTL("key"); err != nil || ttl != 0 {
+			t.Errorf("%v store returned a TTL for an expired key: %v %v", name, ttl, err)
+		}
+
+		//deleted keys are gone, and deleting missing keys is no error
+		if err := store.Set("deleted", time.Minute); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Delete("deleted", "missing"); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := store.Exists("deleted"); err != nil || got {
+			t.Errorf("%v store kept a deleted key: %v %v", name, got, err)
+		}
+	}
+}
+
+func TestSharedState(t *testing.T) {
+
+	redis := newFakeRedis(t, "")
+
+	//two API instances sharing one Redis store and one database
+	db := &mockDB{}
+	servers := make([]*Server, 2)
+	for i := range servers {
+		state, err := NewStateStore("redis://" + redis.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := &Server{DB: db, Router: hr.New(), Log: testLog, State: state}
+		s.Limiter = NewRateLimiter(map[string]RatePolicy{
+			PolicyRead:  {Name: PolicyRead, Limit: 3, Window: time.Minute},
+			PolicyWrite: {Name: PolicyWrite, Limit: 10, Window: time.Minute},
+		}, state)
+		s.Routes()
+		servers[i] = s
+	}
+
+	//send runs a request from the given address on one of the instances and returns the response
+	send := func(s *Server, method, url, addr string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.RemoteAddr = addr + ":4000"
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+
+		rr := httptest.NewRecorder()
+		s.Router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	//the budget is spent across both instances rather than once on each
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		if rr := send(servers[i%2], "GET", "/api/posts", "192.0.2.1", nil); rr.Code != want {
+			t.Errorf("request %v returned wrong status code:\ngot: %v\nwant: %v", i, rr.Code, want)
+		}
+	}
+
+	//a JWT revoked by logging out on one instance is refused by the other, and counted by IP again
+	req, err := http.NewRequest("GET", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticate(t, servers[0], req, userID)
+	cookies := req.Cookies()
+
+	if rr := send(servers[1], "GET", "/api/timeline", "192.0.2.2", cookies); rr.Code != http.StatusOK {
+		t.Fatalf("timeline returned wrong status code before logout:\ngot: %v\nwant: %v", rr.Code, http.StatusOK)
+	}
+
+	rr := send(servers[0], "POST", "/api/logout", "192.0.2.2", cookies)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("logout returned wrong status code:\ngot: %v\nwant: %v", rr.Code, http.StatusOK)
+	}
+	for _, c := range rr.Result().Cookies() {
+		if c.MaxAge >= 0 {
+			t.Errorf("logout did not delete cookie %v", c.Name)
+		}
+	}
+
+	for i, s := range servers {
+		if rr := send(s, "GET", "/api/timeline", "192.0.2.2", cookies); rr.Code != http.StatusUnauthorized {
+			t.Errorf("instance %v accepted a revoked JWT:\ngot: %v\nwant: %v", i, rr.Code, http.StatusUnauthorized)
+		}
+	}
+}
@@ -1,21 +1,42 @@
 package app
 
 import (
+	"bytes"
+	"database/sql"
 	"fmt"
+	"io/ioutil"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/chiips/snippets/API/logs"
 	"github.com/chiips/snippets/API/models"
+	"github.com/chiips/snippets/API/passwords"
 	uuid "github.com/satori/go.uuid"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
 )
 
 //generate variables for sample user and posts
 var userID uuid.UUID
+var otherUserID uuid.UUID
+var thirdUserID uuid.UUID
 var postID1 uuid.UUID
 var postID2 uuid.UUID
+var commentID1, commentID2, replyID1, replyID2 uuid.UUID
 var now time.Time
 var err error
 
+//testLog discards its output so tests can exercise handlers' error paths
+var testLog = newTestLog()
+
+func newTestLog() *logs.Log {
+	logger := log.New()
+	logger.SetOutput(ioutil.Discard)
+	return &logs.Log{Logger: logger}
+}
+
 func init() {
 
 	userID, err = uuid.NewV4()
@@ -24,6 +45,18 @@ func init() {
 		return
 	}
 
+	otherUserID, err = uuid.NewV4()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	thirdUserID, err = uuid.NewV4()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
 	postID1, err = uuid.NewV4()
 	if err != nil {
 		fmt.Println(err)
@@ -36,6 +69,19 @@ func init() {
 		return
 	}
 
+	//both sample posts share a created time so order their ids to match the database's (created, id) descending order.
+	if bytes.Compare(postID1.Bytes(), postID2.Bytes()) < 0 {
+		postID1, postID2 = postID2, postID1
+	}
+
+	for _, id := range []*uuid.UUID{&commentID1, &commentID2, &replyID1, &replyID2} {
+		*id, err = uuid.NewV4()
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+
 	now = time.Now().UTC()
 }
 
@@ -44,32 +90,1040 @@ type mockDB struct {
 	//by embedding models.Datastore, mockDB implements the interface.
 	//this way we don't need to stub each datastore method
 	models.Datastore
+
+	//reactions made through the mock, keyed by post id, user id, and reaction
+	reactions map[[3]string]bool
+
+	//follows made through the mock, keyed by follower and followee
+	follows map[[2]uuid.UUID]bool
+
+	//users searches the sample users
+	users *models.UserIndex
+
+	//tagged holds the posts created and updated through the mock, by id, for the tag methods
+	tagged map[uuid.UUID]*models.Post
+
+	//revisions holds the revisions saved through the mock by post id, oldest first
+	revisions map[uuid.UUID][]*models.Revision
+
+	//index searches the sample posts and the posts created, updated, and deleted through the mock
+	index *models.PostIndex
+
+	//roles holds the roles granted through the mock by user id, beside the user role every sample user holds
+	roles map[uuid.UUID][]string
+
+	//roleChanges holds the role changes recorded through the mock, oldest first
+	roleChanges []*models.RoleChange
+
+	//hidden holds the posts hidden by moderators through the mock
+	hidden map[uuid.UUID]bool
+
+	//reports, modActions, and appeals hold the moderation records made through the mock, oldest first
+	reports    []*models.Report
+	modActions []*models.ModAction
+	appeals    []*models.Appeal
+
+	//suspended and shadowbanned hold the account standings set through the mock
+	suspended    map[uuid.UUID]time.Time
+	shadowbanned map[uuid.UUID]bool
+
+	//lockouts holds the lockout events recorded through the mock, oldest first
+	lockouts []*models.LockoutEvent
+
+	//auditEvents holds the audit events recorded through the mock, oldest first. auditMu guards it for concurrent logins.
+	auditEvents []*models.AuditEvent
+	auditMu     sync.Mutex
+
+	//passwords holds the password hashes updated through the mock by user id, in place of testPasswordHash
+	passwords map[uuid.UUID]string
+
+	//totp holds the TOTP enrollments made through the mock, and recoveryCodes the recovery code hashes by user id, true once used
+	totp          map[uuid.UUID]*models.TOTP
+	recoveryCodes map[uuid.UUID]map[string]bool
+
+	//passkeys holds the passkeys registered through the mock by credential ID
+	passkeys map[string]*models.Passkey
+
+	//identities holds the external accounts linked through the mock, oldest first
+	identities []*models.Identity
+
+	//accessTokens holds the access tokens created through the mock by id, and accessTokenLookups counts the lookups of them by hash
+	accessTokens       map[uuid.UUID]*models.AccessToken
+	accessTokenLookups int
+
+	//sessions holds the sessions started through the mock by id
+	sessions map[uuid.UUID]*models.Session
+}
+
+//testPassword is the password of every sample user, who log in with their name at example.com, e.g. user-1@example.com
+const testPassword = "Password1!"
+
+//testPasswordHash is testPassword hashed as signup hashed passwords before argon2id, so logging in rehashes it
+var testPasswordHash, _ = bcrypt.GenerateFromPassword([]byte(testPassword), bcrypt.MinCost)
+
+//testHasher hashes passwords with argon2id at a cost low enough to keep tests fast
+var testHasher = &passwords.Hasher{Algorithm: passwords.Argon2id, Argon2: passwords.Argon2Params{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}}
+
+//mockMailer records the emails sent through it
+type mockMailer struct {
+	sent []mockEmail
+}
+
+//mockEmail is one email sent through a mockMailer
+type mockEmail struct {
+	to, subject, body string
+}
+
+func (m *mockMailer) Send(to, subject, body string) error {
+	m.sent = append(m.sent, mockEmail{to, subject, body})
+	return nil
 }
 
 //Sample user database method
 
-func (mdb *mockDB) SearchUsers(query, prevDate string, limit int) ([]*models.User, error) {
-	//create slice to serve as database.
-	users := []*models.User{}
-	users = append(users, &models.User{ID: userID, Name: "User-1", Avatar: "sailboat.jpg"})
-	users = append(users, &models.User{ID: userID, Name: "User-2", Avatar: "sailboat.jpg"})
-	users = append(users, &models.User{ID: userID, Name: "User-3", Avatar: "sailboat.jpg"})
+func (mdb *mockDB) SearchUsers(query string, before models.Cursor, limit int) ([]*models.UserResult, error) {
+	return mdb.userIndex().Search(query, before, limit), nil
+}
 
-	results := []*models.User{}
+func (mdb *mockDB) AutocompleteUsers(prefix string, limit int) ([]*models.User, error) {
+	return mdb.userIndex().Autocomplete(prefix, limit), nil
+}
 
-	for _, user := range users {
-		if strings.Contains(user.Name, query) {
-			results = append(results, user)
+func (mdb *mockDB) UserByEmail(email string) (*models.User, error) {
+	for i, id := range []uuid.UUID{userID, otherUserID, thirdUserID} {
+		name := fmt.Sprintf("User-%d", i+1)
+		if email == strings.ToLower(name)+"@example.com" {
+			roles, err := mdb.UserRoles(id)
+			if err != nil {
+				return nil, err
+			}
+			password, ok := mdb.passwords[id]
+			if !ok {
+				password = string(testPasswordHash)
+			}
+			return &models.User{ID: id, Name: name, Email: email, Password: password, Roles: roles}, nil
 		}
 	}
+	return nil, sql.ErrNoRows
+}
 
-	return results, nil
+func (mdb *mockDB) UserByID(id uuid.UUID) (*models.User, error) {
+	for i, u := range []uuid.UUID{userID, otherUserID, thirdUserID} {
+		if id == u {
+			return mdb.UserByEmail(fmt.Sprintf("user-%d@example.com", i+1))
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (mdb *mockDB) UserPassword(id uuid.UUID) (string, error) {
+	if password, ok := mdb.passwords[id]; ok {
+		return password, nil
+	}
+	return string(testPasswordHash), nil
+}
+
+func (mdb *mockDB) UpdatePassword(id uuid.UUID, password string) error {
+	if mdb.passwords == nil {
+		mdb.passwords = make(map[uuid.UUID]string)
+	}
+	mdb.passwords[id] = password
+	return nil
+}
+
+//userIndex returns the mock's user search index, starting it with the sample users
+func (mdb *mockDB) userIndex() *models.UserIndex {
+	if mdb.users == nil {
+		mdb.users = models.NewUserIndex()
+		mdb.users.Index(&models.User{ID: userID, Name: "User-1", Avatar: "sailboat.jpg"})
+		mdb.users.Index(&models.User{ID: otherUserID, Name: "User-2", Avatar: "sailboat.jpg"})
+		mdb.users.Index(&models.User{ID: thirdUserID, Name: "User-3", Avatar: "sailboat.jpg"})
+	}
+	return mdb.users
 }
 
 //Sample post database method
-func (mdb *mockDB) AllPosts(prevDate string, limit int) ([]*models.Post, error) {
+func (mdb *mockDB) AllPosts(before models.Cursor, limit int, viewer uuid.UUID) ([]*models.Post, error) {
 	posts := []*models.Post{}
 	posts = append(posts, &models.Post{ID: postID1, Title: "Post 1", Body: "Body 1", Created: now, Updated: now, Author: models.User{ID: userID, Name: "User-1", Avatar: "sailboat.jpg"}})
 	posts = append(posts, &models.Post{ID: postID2, Title: "Post 2", Body: "Body 2", Created: now, Updated: now, Author: models.User{ID: userID, Name: "User-1", Avatar: "sailboat.jpg"}})
-	return posts, nil
+
+	results := []*models.Post{}
+
+	for _, post := range posts {
+		if len(results) == limit {
+			break
+		}
+		if mdb.hidden[post.ID] || mdb.shadowed(post.Author.ID, viewer) {
+			continue
+		}
+		if post.Created.Before(before.Created) || (post.Created.Equal(before.Created) && bytes.Compare(post.ID.Bytes(), before.ID.Bytes()) < 0) {
+			mdb.attachReactions(post, viewer)
+			results = append(results, post)
+		}
+	}
+
+	return results, nil
+}
+
+func (mdb *mockDB) OnePost(id, viewer uuid.UUID) (*models.Post, error) {
+	//posts created through the mock
+	if created, ok := mdb.tagged[id]; ok && id != postID1 && id != postID2 {
+		post := *created
+		mdb.attachReactions(&post, viewer)
+		return &post, nil
+	}
+	if id != postID1 && id != postID2 || mdb.shadowed(userID, viewer) {
+		return nil, sql.ErrNoRows
+	}
+	post := &models.Post{ID: id, Title: "Post", Body: "Body", Created: now, Updated: now, Author: models.User{ID: userID, Name: "User-1", Avatar: "sailboat.jpg"}, Version: 1, Hidden: mdb.hidden[id]}
+	//show edits made through the mock
+	if edited, ok := mdb.tagged[id]; ok {
+		post.Title, post.Body, post.Tags, post.Version = edited.Title, edited.Body, edited.Tags, edited.Version
+	}
+	mdb.attachReactions(post, viewer)
+	return post, nil
+}
+
+//Sample reaction database methods
+
+func (mdb *mockDB) AddReaction(postID, userID uuid.UUID, reaction string, created time.Time) error {
+	if mdb.reactions == nil {
+		mdb.reactions = map[[3]string]bool{}
+	}
+	mdb.reactions[[3]string{postID.String(), userID.String(), reaction}] = true
+	return nil
+}
+
+func (mdb *mockDB) RemoveReaction(postID, userID uuid.UUID, reaction string) error {
+	delete(mdb.reactions, [3]string{postID.String(), userID.String(), reaction})
+	return nil
+}
+
+//attachReactions counts the reactions made to a post through the mock
+func (mdb *mockDB) attachReactions(post *models.Post, viewer uuid.UUID) {
+	post.Reactions = map[string]int{}
+	post.Reacted = []string{}
+	for key := range mdb.reactions {
+		if key[0] != post.ID.String() {
+			continue
+		}
+		post.Reactions[key[2]]++
+		if key[1] == viewer.String() {
+			post.Reacted = append(post.Reacted, key[2])
+		}
+	}
+}
+
+//Sample comment database methods
+
+//mockComments builds a thread on postID1: two comments by userID, a reply to the first, and a reply to that reply.
+func mockComments() []*models.Comment {
+	author := models.User{ID: userID, Name: "User-1", Avatar: "sailboat.jpg"}
+	return []*models.Comment{
+		{ID: commentID1, PostID: postID1, Body: "Comment 1", Created: now, Updated: now, Author: author, ReplyCount: 1},
+		{ID: commentID2, PostID: postID1, Body: "Comment 2", Created: now.Add(-time.Minute), Updated: now, Author: author},
+		{ID: replyID1, PostID: postID1, ParentID: commentID1, Body: "Reply 1", Created: now.Add(time.Minute), Updated: now, Author: author, ReplyCount: 1},
+		{ID: replyID2, PostID: postID1, ParentID: replyID1, Body: "Reply 2", Created: now.Add(2 * time.Minute), Updated: now, Author: author},
+	}
+}
+
+func (mdb *mockDB) PostComments(postID, parentID uuid.UUID, before models.Cursor, limit int) ([]*models.Comment, error) {
+	results := []*models.Comment{}
+	for _, comment := range mockComments() {
+		if len(results) < limit && comment.PostID == postID && comment.ParentID == parentID && comment.Created.Before(before.Created) {
+			results = append(results, comment)
+		}
+	}
+	return results, nil
+}
+
+func (mdb *mockDB) CommentReplies(parentIDs []uuid.UUID, depth int) ([]*models.Comment, error) {
+	results := []*models.Comment{}
+	for level := 0; level < depth; level++ {
+		next := []uuid.UUID{}
+		for _, comment := range mockComments() {
+			for _, parentID := range parentIDs {
+				if comment.ParentID == parentID {
+					results = append(results, comment)
+					next = append(next, comment.ID)
+				}
+			}
+		}
+		parentIDs = next
+	}
+	return results, nil
+}
+
+func (mdb *mockDB) OneComment(id uuid.UUID) (*models.Comment, error) {
+	for _, comment := range mockComments() {
+		if comment.ID == id {
+			return comment, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (mdb *mockDB) UpdateComment(comment *models.Comment) error {
+	return nil
+}
+
+//Sample follow database methods
+
+func (mdb *mockDB) UserProfile(id, viewer uuid.UUID) (*models.Profile, error) {
+	if id != userID && id != otherUserID {
+		return nil, sql.ErrNoRows
+	}
+	profile := &models.Profile{User: models.User{ID: id, Name: "User", Avatar: "sailboat.jpg", Version: 1}}
+	for follow := range mdb.follows {
+		if follow[1] == id {
+			profile.Followers++
+		}
+		if follow[0] == id {
+			profile.Following++
+		}
+	}
+	profile.Followed = mdb.follows[[2]uuid.UUID{viewer, id}]
+	return profile, nil
+}
+
+func (mdb *mockDB) Follow(follower, followee uuid.UUID, created time.Time) error {
+	if mdb.follows == nil {
+		mdb.follows = map[[2]uuid.UUID]bool{}
+	}
+	mdb.follows[[2]uuid.UUID{follower, followee}] = true
+	return nil
+}
+
+func (mdb *mockDB) Unfollow(follower, followee uuid.UUID) error {
+	delete(mdb.follows, [2]uuid.UUID{follower, followee})
+	return nil
+}
+
+//Timeline returns the sample posts, all by userID, to the user and their followers
+func (mdb *mockDB) Timeline(id uuid.UUID, before models.Cursor, limit int) ([]*models.Post, error) {
+	if id != userID && !mdb.follows[[2]uuid.UUID{id, userID}] {
+		return []*models.Post{}, nil
+	}
+	return mdb.AllPosts(before, limit, id)
+}
+
+//Sample search database methods
+
+//postIndex returns the mock's search index, starting it with the sample posts
+func (mdb *mockDB) postIndex() *models.PostIndex {
+	if mdb.index == nil {
+		mdb.index = models.NewPostIndex()
+		//read the sample posts as their author so a shadow-ban leaves none out
+		posts, _ := mdb.AllPosts(models.Cursor{Created: now.Add(time.Second)}, 2, userID)
+		for _, post := range posts {
+			mdb.index.Index(post)
+		}
+	}
+	return mdb.index
+}
+
+func (mdb *mockDB) SearchPosts(query models.SearchQuery, before models.Cursor, limit int, viewer uuid.UUID) ([]*models.PostResult, error) {
+	results := []*models.PostResult{}
+	for _, result := range mdb.postIndex().Search(query, before, limit) {
+		if !mdb.shadowed(result.Author.ID, viewer) {
+			results = append(results, result)
+		}
+	}
+	return results, nil
+}
+
+func (mdb *mockDB) CreatePost(post *models.Post) error {
+	post.Version = 1
+	mdb.postIndex().Index(post)
+	mdb.tag(post)
+	mdb.saveRevision(post, post.Created)
+	return nil
+}
+
+func (mdb *mockDB) UpdatePost(post *models.Post) error {
+	//only edits to the current version apply
+	current := 1
+	if edited, ok := mdb.tagged[post.ID]; ok {
+		current = edited.Version
+	} else if post.ID != postID1 && post.ID != postID2 {
+		return models.ErrVersionConflict
+	}
+	if post.Version != current {
+		return models.ErrVersionConflict
+	}
+	post.Version++
+
+	//save the sample posts' original content before their first edit
+	if len(mdb.revisions[post.ID]) == 0 {
+		if original, err := mdb.OnePost(post.ID, uuid.Nil); err == nil {
+			mdb.saveRevision(original, original.Updated)
+		}
+	}
+	mdb.postIndex().Index(post)
+	mdb.tag(post)
+	mdb.saveRevision(post, post.Updated)
+	return nil
+}
+
+func (mdb *mockDB) DeletePost(post *models.Post) error {
+	mdb.postIndex().Remove(post.ID)
+	delete(mdb.tagged, post.ID)
+	delete(mdb.revisions, post.ID)
+	return nil
+}
+
+//Sample revision database methods
+
+func (mdb *mockDB) saveRevision(post *models.Post, created time.Time) {
+	if mdb.revisions == nil {
+		mdb.revisions = map[uuid.UUID][]*models.Revision{}
+	}
+	id, _ := uuid.NewV4()
+	revisions := mdb.revisions[post.ID]
+	mdb.revisions[post.ID] = append(revisions, &models.Revision{ID: id, PostID: post.ID, Number: len(revisions) + 1, Title: post.Title, Body: post.Body, Created: created, Author: post.Author})
+}
+
+func (mdb *mockDB) PostRevisions(postID uuid.UUID, before models.Cursor, limit int) ([]*models.Revision, error) {
+	results := []*models.Revision{}
+	revisions := mdb.revisions[postID]
+	for i := len(revisions) - 1; i >= 0 && len(results) < limit; i-- {
+		if !revisions[i].Created.After(before.Created) {
+			results = append(results, revisions[i])
+		}
+	}
+	return results, nil
+}
+
+func (mdb *mockDB) OneRevision(postID uuid.UUID, number int) (*models.Revision, error) {
+	revisions := mdb.revisions[postID]
+	if number > len(revisions) {
+		return nil, sql.ErrNoRows
+	}
+	return revisions[number-1], nil
+}
+
+//tag records a copy of a post and its tags for TagPosts and TrendingTags, keeping the created time of an earlier version
+func (mdb *mockDB) tag(post *models.Post) {
+	if mdb.tagged == nil {
+		mdb.tagged = map[uuid.UUID]*models.Post{}
+	}
+	tagged := *post
+	if earlier, ok := mdb.tagged[post.ID]; ok {
+		tagged.Created = earlier.Created
+	}
+	mdb.tagged[post.ID] = &tagged
+}
+
+func (mdb *mockDB) TagPosts(tag string, before models.Cursor, limit int, viewer uuid.UUID) ([]*models.Post, error) {
+	results := []*models.Post{}
+	for _, post := range mdb.tagged {
+		if !hasTag(post, tag) || mdb.shadowed(post.Author.ID, viewer) {
+			continue
+		}
+		if post.Created.Before(before.Created) || (post.Created.Equal(before.Created) && bytes.Compare(post.ID.Bytes(), before.ID.Bytes()) < 0) {
+			results = append(results, post)
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if !results[i].Created.Equal(results[j].Created) {
+			return results[i].Created.After(results[j].Created)
+		}
+		return bytes.Compare(results[i].ID.Bytes(), results[j].ID.Bytes()) > 0
+	})
+
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+//TrendingTags scores each visible post in the window as 1, most used tag first
+func (mdb *mockDB) TrendingTags(asOf time.Time, window time.Duration, limit int) ([]*models.TrendingTag, error) {
+	counts := map[string]int{}
+	for _, post := range mdb.tagged {
+		if post.Created.After(asOf) || !post.Created.After(asOf.Add(-window)) {
+			continue
+		}
+		if mdb.hidden[post.ID] || mdb.shadowed(post.Author.ID, uuid.Nil) {
+			continue
+		}
+		for _, tag := range post.Tags {
+			counts[tag]++
+		}
+	}
+
+	tags := []*models.TrendingTag{}
+	for name, count := range counts {
+		tags = append(tags, &models.TrendingTag{Name: name, Posts: count, Score: float64(count)})
+	}
+
+	sort.Slice(tags, func(i, j int) bool {
+		if tags[i].Score != tags[j].Score {
+			return tags[i].Score > tags[j].Score
+		}
+		return tags[i].Name < tags[j].Name
+	})
+
+	if len(tags) > limit {
+		tags = tags[:limit]
+	}
+	return tags, nil
+}
+
+func hasTag(post *models.Post, tag string) bool {
+	for _, t := range post.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+//Sample role database methods
+
+func (mdb *mockDB) UserRoles(id uuid.UUID) ([]string, error) {
+	if id != userID && id != otherUserID && id != thirdUserID {
+		return nil, sql.ErrNoRows
+	}
+	return append([]string{models.RoleUser}, mdb.roles[id]...), nil
+}
+
+func (mdb *mockDB) GrantRole(change *models.RoleChange) (bool, error) {
+	change.Action = models.RoleGranted
+	roles, err := mdb.UserRoles(change.Target)
+	if err != nil || models.HasRole(roles, change.Role) {
+		return false, err
+	}
+	if mdb.roles == nil {
+		mdb.roles = map[uuid.UUID][]string{}
+	}
+	mdb.roles[change.Target] = append(mdb.roles[change.Target], change.Role)
+	mdb.roleChanges = append(mdb.roleChanges, change)
+	return true, nil
+}
+
+func (mdb *mockDB) RevokeRole(change *models.RoleChange) (bool, error) {
+	change.Action = models.RoleRevoked
+	roles, err := mdb.UserRoles(change.Target)
+	if err != nil || !models.HasRole(roles, change.Role) {
+		return false, err
+	}
+	kept := []string{}
+	for _, role := range mdb.roles[change.Target] {
+		if role != change.Role {
+			kept = append(kept, role)
+		}
+	}
+	mdb.roles[change.Target] = kept
+	mdb.roleChanges = append(mdb.roleChanges, change)
+	return true, nil
+}
+
+//RoleChanges returns the recorded changes newest first, ignoring the cursor
+func (mdb *mockDB) RoleChanges(target uuid.UUID, before models.Cursor, limit int) ([]*models.RoleChange, error) {
+	changes := []*models.RoleChange{}
+	for i := len(mdb.roleChanges) - 1; i >= 0 && len(changes) < limit; i-- {
+		if change := mdb.roleChanges[i]; target == uuid.Nil || change.Target == target {
+			changes = append(changes, change)
+		}
+	}
+	return changes, nil
+}
+
+//Sample moderation database methods
+
+func (mdb *mockDB) CreateReport(report *models.Report) error {
+	report.Status = models.ReportOpen
+	for _, r := range mdb.reports {
+		if r.Reporter == report.Reporter && r.Kind == report.Kind && r.Target == report.Target && r.Status == models.ReportOpen {
+			return nil
+		}
+	}
+	mdb.reports = append(mdb.reports, report)
+	return nil
+}
+
+//Reports returns the matching reports newest first, ignoring the cursor
+func (mdb *mockDB) Reports(filter models.ReportFilter, before models.Cursor, limit int) ([]*models.Report, error) {
+	reports := []*models.Report{}
+	for i := len(mdb.reports) - 1; i >= 0 && len(reports) < limit; i-- {
+		r := mdb.reports[i]
+		if (filter.Status == "" || r.Status == filter.Status) && (filter.Kind == "" || r.Kind == filter.Kind) && (filter.Reason == "" || r.Reason == filter.Reason) {
+			reports = append(reports, r)
+		}
+	}
+	return reports, nil
+}
+
+func (mdb *mockDB) OneReport(id uuid.UUID) (*models.Report, error) {
+	for _, r := range mdb.reports {
+		if r.ID == id {
+			return r, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+//Moderate records the action and closes the reports; only hiding and suspending change the mock
+func (mdb *mockDB) Moderate(action *models.ModAction) error {
+	if action.Action == models.ActionHide {
+		if mdb.hidden == nil {
+			mdb.hidden = map[uuid.UUID]bool{}
+		}
+		mdb.hidden[action.Target] = true
+	}
+	if action.Action == models.ActionSuspend {
+		if mdb.suspended == nil {
+			mdb.suspended = map[uuid.UUID]time.Time{}
+		}
+		mdb.suspended[action.Subject] = *action.Until
+	}
+	mdb.modActions = append(mdb.modActions, action)
+	for _, r := range mdb.reports {
+		if r.Kind == action.Kind && r.Target == action.Target && r.Status == models.ReportOpen {
+			r.Status = models.ReportResolved
+			if action.Action == models.ActionDismiss {
+				r.Status = models.ReportDismissed
+			}
+			r.Action = action.ID
+		}
+	}
+	return nil
+}
+
+//ModActions returns the actions aimed at the subject newest first, ignoring the cursor
+func (mdb *mockDB) ModActions(subject uuid.UUID, before models.Cursor, limit int) ([]*models.ModAction, error) {
+	actions := []*models.ModAction{}
+	for i := len(mdb.modActions) - 1; i >= 0 && len(actions) < limit; i-- {
+		if a := mdb.modActions[i]; a.Subject == subject && a.Action != models.ActionShadowban && a.Action != models.ActionUnshadowban {
+			actions = append(actions, mdb.modActions[i])
+		}
+	}
+	return actions, nil
+}
+
+func (mdb *mockDB) OneModAction(id uuid.UUID) (*models.ModAction, error) {
+	for _, a := range mdb.modActions {
+		if a.ID == id {
+			return a, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (mdb *mockDB) CreateAppeal(appeal *models.Appeal) error {
+	for _, a := range mdb.appeals {
+		if a.Action == appeal.Action {
+			return models.ErrAppealExists
+		}
+	}
+	appeal.Status = models.AppealOpen
+	mdb.appeals = append(mdb.appeals, appeal)
+	return nil
+}
+
+//Appeals returns the appeals in the status newest first, ignoring the cursor
+func (mdb *mockDB) Appeals(status string, before models.Cursor, limit int) ([]*models.Appeal, error) {
+	appeals := []*models.Appeal{}
+	for i := len(mdb.appeals) - 1; i >= 0 && len(appeals) < limit; i-- {
+		if status == "" || mdb.appeals[i].Status == status {
+			appeals = append(appeals, mdb.appeals[i])
+		}
+	}
+	return appeals, nil
+}
+
+//OneAppeal returns a copy so decisions only apply through DecideAppeal
+func (mdb *mockDB) OneAppeal(id uuid.UUID) (*models.Appeal, error) {
+	for _, a := range mdb.appeals {
+		if a.ID == id {
+			appeal := *a
+			return &appeal, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (mdb *mockDB) DecideAppeal(appeal *models.Appeal) error {
+	for i, a := range mdb.appeals {
+		if a.ID != appeal.ID {
+			continue
+		}
+		if a.Status != models.AppealOpen {
+			return models.ErrAppealDecided
+		}
+		mdb.appeals[i] = appeal
+		action, err := mdb.OneModAction(a.Action)
+		if err != nil {
+			return err
+		}
+		if appeal.Status == models.AppealOverturned && action.Action == models.ActionHide {
+			delete(mdb.hidden, action.Target)
+		}
+		return nil
+	}
+	return sql.ErrNoRows
+}
+
+//Sample standing database methods
+
+func (mdb *mockDB) AccountStanding(id uuid.UUID) (*models.Standing, error) {
+	if id != userID && id != otherUserID && id != thirdUserID {
+		return nil, sql.ErrNoRows
+	}
+	standing := &models.Standing{ID: id, Shadowbanned: mdb.shadowbanned[id]}
+	if until, ok := mdb.suspended[id]; ok {
+		standing.SuspendedUntil = &until
+	}
+	return standing, nil
+}
+
+func (mdb *mockDB) SetStanding(action *models.ModAction) error {
+	if _, err := mdb.AccountStanding(action.Subject); err != nil {
+		return err
+	}
+	if mdb.suspended == nil {
+		mdb.suspended = map[uuid.UUID]time.Time{}
+	}
+	if mdb.shadowbanned == nil {
+		mdb.shadowbanned = map[uuid.UUID]bool{}
+	}
+	switch action.Action {
+	case models.ActionSuspend:
+		mdb.suspended[action.Subject] = *action.Until
+	case models.ActionUnsuspend:
+		delete(mdb.suspended, action.Subject)
+	case models.ActionShadowban, models.ActionUnshadowban:
+		mdb.shadowbanned[action.Subject] = action.Action == models.ActionShadowban
+	}
+	action.Kind, action.Target = models.KindUser, action.Subject
+	mdb.modActions = append(mdb.modActions, action)
+	return nil
+}
+
+//shadowed reports whether a post by the author is kept from the viewer by a shadow-ban
+func (mdb *mockDB) shadowed(author, viewer uuid.UUID) bool {
+	return mdb.shadowbanned[author] && author != viewer
+}
+
+//Sample lockout database methods
+
+func (mdb *mockDB) RecordLockout(event *models.LockoutEvent) error {
+	mdb.lockouts = append(mdb.lockouts, event)
+	return nil
+}
+
+//Sample MFA database methods
+
+func (mdb *mockDB) TOTP(user uuid.UUID) (*models.TOTP, error) {
+	t, ok := mdb.totp[user]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	copied := *t
+	return &copied, nil
+}
+
+func (mdb *mockDB) EnrollTOTP(t *models.TOTP) error {
+	if mdb.totp == nil {
+		mdb.totp = make(map[uuid.UUID]*models.TOTP)
+	}
+	if old, ok := mdb.totp[t.User]; ok && old.Confirmed {
+		return models.ErrTOTPConfirmed
+	}
+	mdb.totp[t.User] = &models.TOTP{User: t.User, Secret: t.Secret, Created: t.Created}
+	return nil
+}
+
+func (mdb *mockDB) ConfirmTOTP(user uuid.UUID, step int64, hashes []string) error {
+	t, ok := mdb.totp[user]
+	if !ok || t.Confirmed {
+		return sql.ErrNoRows
+	}
+	t.Confirmed, t.LastStep = true, step
+	if mdb.recoveryCodes == nil {
+		mdb.recoveryCodes = make(map[uuid.UUID]map[string]bool)
+	}
+	mdb.recoveryCodes[user] = make(map[string]bool)
+	for _, hash := range hashes {
+		mdb.recoveryCodes[user][hash] = false
+	}
+	return nil
+}
+
+func (mdb *mockDB) UseTOTPStep(user uuid.UUID, step int64) (bool, error) {
+	t, ok := mdb.totp[user]
+	if !ok || !t.Confirmed || t.LastStep >= step {
+		return false, nil
+	}
+	t.LastStep = step
+	return true, nil
+}
+
+func (mdb *mockDB) UseRecoveryCode(user uuid.UUID, hash string) (bool, error) {
+	used, ok := mdb.recoveryCodes[user][hash]
+	if !ok || used {
+		return false, nil
+	}
+	mdb.recoveryCodes[user][hash] = true
+	return true, nil
+}
+
+func (mdb *mockDB) DeleteTOTP(user uuid.UUID) error {
+	delete(mdb.totp, user)
+	delete(mdb.recoveryCodes, user)
+	return nil
+}
+
+//Sample passkey database methods
+
+func (mdb *mockDB) Passkey(id string) (*models.Passkey, error) {
+	p, ok := mdb.passkeys[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	copied := *p
+	return &copied, nil
+}
+
+func (mdb *mockDB) UserPasskeys(user uuid.UUID) ([]*models.Passkey, error) {
+	passkeys := []*models.Passkey{}
+	for _, p := range mdb.passkeys {
+		if uuid.Equal(p.User, user) {
+			copied := *p
+			passkeys = append(passkeys, &copied)
+		}
+	}
+	sort.Slice(passkeys, func(i, j int) bool { return passkeys[i].Created.Before(passkeys[j].Created) })
+	return passkeys, nil
+}
+
+func (mdb *mockDB) CreatePasskey(p *models.Passkey) error {
+	if mdb.passkeys == nil {
+		mdb.passkeys = make(map[string]*models.Passkey)
+	}
+	if _, ok := mdb.passkeys[p.ID]; ok {
+		return models.ErrPasskeyExists
+	}
+	copied := *p
+	mdb.passkeys[p.ID] = &copied
+	return nil
+}
+
+func (mdb *mockDB) UsePasskey(id string, signCount int64, used time.Time) (bool, error) {
+	p, ok := mdb.passkeys[id]
+	if !ok || p.Cloned || !(p.SignCount < signCount || p.SignCount == 0 && signCount == 0) {
+		return false, nil
+	}
+	p.SignCount, p.LastUsed = signCount, &used
+	return true, nil
+}
+
+func (mdb *mockDB) FlagClonedPasskey(id string) error {
+	if p, ok := mdb.passkeys[id]; ok {
+		p.Cloned = true
+	}
+	return nil
+}
+
+func (mdb *mockDB) DeletePasskey(user uuid.UUID, id string) error {
+	p, ok := mdb.passkeys[id]
+	if !ok || !uuid.Equal(p.User, user) {
+		return sql.ErrNoRows
+	}
+	delete(mdb.passkeys, id)
+	return nil
+}
+
+//Sample identity database methods
+
+func (mdb *mockDB) Identity(provider, subject string) (*models.Identity, error) {
+	for _, i := range mdb.identities {
+		if i.Provider == provider && i.Subject == subject {
+			copied := *i
+			return &copied, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (mdb *mockDB) UserIdentities(user uuid.UUID) ([]*models.Identity, error) {
+	identities := []*models.Identity{}
+	for _, i := range mdb.identities {
+		if uuid.Equal(i.User, user) {
+			copied := *i
+			identities = append(identities, &copied)
+		}
+	}
+	return identities, nil
+}
+
+func (mdb *mockDB) LinkIdentity(i *models.Identity) error {
+	for _, linked := range mdb.identities {
+		if linked.Provider == i.Provider && (linked.Subject == i.Subject || uuid.Equal(linked.User, i.User)) {
+			return models.ErrIdentityLinked
+		}
+	}
+	copied := *i
+	mdb.identities = append(mdb.identities, &copied)
+	return nil
+}
+
+func (mdb *mockDB) UnlinkIdentity(user uuid.UUID, provider string) error {
+	for n, i := range mdb.identities {
+		if uuid.Equal(i.User, user) && i.Provider == provider {
+			mdb.identities = append(mdb.identities[:n], mdb.identities[n+1:]...)
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+//Sample access token database methods
+
+func (mdb *mockDB) AccessToken(hash string) (*models.AccessToken, error) {
+	mdb.accessTokenLookups++
+	for _, t := range mdb.accessTokens {
+		if t.Hash == hash {
+			copied := *t
+			return &copied, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (mdb *mockDB) UserAccessTokens(user uuid.UUID) ([]*models.AccessToken, error) {
+	tokens := []*models.AccessToken{}
+	for _, t := range mdb.accessTokens {
+		if uuid.Equal(t.User, user) {
+			copied := *t
+			tokens = append(tokens, &copied)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].Created.Before(tokens[j].Created) })
+	return tokens, nil
+}
+
+func (mdb *mockDB) CreateAccessToken(t *models.AccessToken) error {
+	if mdb.accessTokens == nil {
+		mdb.accessTokens = make(map[uuid.UUID]*models.AccessToken)
+	}
+	copied := *t
+	mdb.accessTokens[t.ID] = &copied
+	return nil
+}
+
+func (mdb *mockDB) UseAccessToken(id uuid.UUID, used time.Time) error {
+	if t, ok := mdb.accessTokens[id]; ok {
+		t.LastUsed = &used
+	}
+	return nil
+}
+
+func (mdb *mockDB) DeleteAccessToken(user, id uuid.UUID) error {
+	t, ok := mdb.accessTokens[id]
+	if !ok || !uuid.Equal(t.User, user) {
+		return sql.ErrNoRows
+	}
+	delete(mdb.accessTokens, id)
+	return nil
+}
+
+//Sample session database methods
+
+func (mdb *mockDB) Session(id uuid.UUID) (*models.Session, error) {
+	session, ok := mdb.sessions[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	copied := *session
+	return &copied, nil
+}
+
+func (mdb *mockDB) UserSessions(user uuid.UUID, at time.Time) ([]*models.Session, error) {
+	sessions := []*models.Session{}
+	for _, session := range mdb.sessions {
+		if uuid.Equal(session.User, user) && session.Expires.After(at) {
+			copied := *session
+			sessions = append(sessions, &copied)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].LastSeen.After(sessions[j].LastSeen) })
+	return sessions, nil
+}
+
+func (mdb *mockDB) CreateSession(s *models.Session) error {
+	if mdb.sessions == nil {
+		mdb.sessions = make(map[uuid.UUID]*models.Session)
+	}
+	copied := *s
+	mdb.sessions[s.ID] = &copied
+	return nil
+}
+
+func (mdb *mockDB) SeeSession(id uuid.UUID, seen time.Time) error {
+	if session, ok := mdb.sessions[id]; ok {
+		session.LastSeen = seen
+	}
+	return nil
+}
+
+func (mdb *mockDB) RefreshSession(id uuid.UUID, seen, expires time.Time) error {
+	session, ok := mdb.sessions[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	session.LastSeen, session.Expires = seen, expires
+	return nil
+}
+
+func (mdb *mockDB) DeleteSession(user, id uuid.UUID) error {
+	session, ok := mdb.sessions[id]
+	if !ok || !uuid.Equal(session.User, user) {
+		return sql.ErrNoRows
+	}
+	delete(mdb.sessions, id)
+	return nil
+}
+
+func (mdb *mockDB) DeleteOtherSessions(user, keep uuid.UUID) (int64, error) {
+	var deleted int64
+	for id, session := range mdb.sessions {
+		if uuid.Equal(session.User, user) && !uuid.Equal(id, keep) {
+			delete(mdb.sessions, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+//Sample audit database methods
+
+func (mdb *mockDB) RecordAuditEvent(event *models.AuditEvent) error {
+	mdb.auditMu.Lock()
+	defer mdb.auditMu.Unlock()
+	mdb.auditEvents = append(mdb.auditEvents, event)
+	return nil
+}
+
+//AuditEvents returns the matching events newest first, as recorded, from before the cursor's created time
+func (mdb *mockDB) AuditEvents(filter models.AuditFilter, before models.Cursor, limit int) ([]*models.AuditEvent, error) {
+	events := []*models.AuditEvent{}
+	for i := len(mdb.auditEvents) - 1; i >= 0 && len(events) < limit; i-- {
+		event := mdb.auditEvents[i]
+		switch {
+		case !event.Created.Before(before.Created) && !(event.Created.Equal(before.Created) && bytes.Compare(event.ID.Bytes(), before.ID.Bytes()) < 0):
+		case filter.Action != "" && event.Action != filter.Action:
+		case filter.Actor != uuid.Nil && event.Actor != filter.Actor:
+		case filter.Target != uuid.Nil && event.Target != filter.Target:
+		case filter.IP != "" && event.IP != filter.IP:
+		case filter.Since != nil && event.Created.Before(*filter.Since):
+		case filter.Until != nil && !event.Created.Before(*filter.Until):
+		default:
+			events = append(events, event)
+		}
+	}
+	return events, nil
 }
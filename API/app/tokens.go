@@ -0,0 +1,171 @@
+package app
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/chiips/snippets/API/models"
+)
+
+//Personal access tokens. Users create tokens for scripts and other clients, which send them in an Authorization: Bearer header
+//in place of the JWT cookies. Each token grants only the scopes it was created with, on the routes that accept tokens with that scope:
+//routes go through authenticateToken or identifyToken with the scope they need, and every other route takes the JWT only,
+//so a token never reaches account security such as passwords, two-factor authentication, or other tokens.
+const (
+	maxAccessTokens      = 20
+	maxAccessTokenName   = 50
+	defaultTokenLifetime = 30 * 24 * time.Hour
+	maxTokenLifetime     = 365 * 24 * time.Hour
+
+	//accessTokenPrefix marks the tokens, so they are recognized when leaked, e.g. by secret scanners
+	accessTokenPrefix = "snp_"
+	//tokenUseInterval is how stale a token's last use may get before it is recorded again, to keep writes off every request
+	tokenUseInterval = time.Minute
+)
+
+//The scopes access tokens may grant, checked by authenticateToken and identifyToken on the routes that accept tokens.
+const (
+	//ScopePostsRead allows reading posts as the user, e.g. their timeline.
+	ScopePostsRead = "posts:read"
+	//ScopePostsWrite allows writing, editing, and deleting the user's posts, comments, and reactions.
+	ScopePostsWrite = "posts:write"
+	//ScopeProfileRead allows reading profiles as the user.
+	ScopeProfileRead = "profile:read"
+	//ScopeProfileWrite allows changing the user's profile photo and whom they follow.
+	ScopeProfileWrite = "profile:write"
+)
+
+//tokenScopes lists the scopes users may create tokens with
+var tokenScopes = []string{ScopePostsRead, ScopePostsWrite, ScopeProfileRead, ScopeProfileWrite}
+
+//validScopes reports whether the scopes are known, at least one and without repeats
+func validScopes(scopes []string) bool {
+
+	if len(scopes) == 0 {
+		return false
+	}
+
+	seen := make(map[string]bool)
+	for _, scope := range scopes {
+		known := false
+		for _, s := range tokenScopes {
+			known = known || s == scope
+		}
+		if !known || seen[scope] {
+			return false
+		}
+		seen[scope] = true
+	}
+
+	return true
+}
+
+//newAccessToken returns a new token and the hash to store it as
+func newAccessToken() (string, string, error) {
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token := accessTokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+
+	return token, accessTokenHash(token), nil
+}
+
+//accessTokenHash is the hash a token is stored as. Tokens are random, so a fast hash suffices.
+func accessTokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+//bearerToken returns the token in the request's Authorization: Bearer header, and whether it has one
+func bearerToken(r *http.Request) (string, bool) {
+
+	header := r.Header.Get("Authorization")
+	if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") {
+		return "", false
+	}
+
+	return strings.TrimSpace(header[7:]), true
+}
+
+//accessTokenContextKey holds the lookup of the request's bearer token made by the rate limiter,
+//so authenticateToken and identifyToken do not look the token up again
+const accessTokenContextKey contextKey = "accessToken"
+
+//accessTokenLookup is the result of looking up a bearer token
+type accessTokenLookup struct {
+	token string
+	t     *models.AccessToken
+	err   error
+}
+
+//lookupAccessToken returns the stored access token for the bearer token, from the request's context if the rate limiter looked it up already.
+//It returns sql.ErrNoRows if there is none.
+func (s *Server) lookupAccessToken(r *http.Request, token string) (*models.AccessToken, error) {
+
+	if lookup, ok := r.Context().Value(accessTokenContextKey).(*accessTokenLookup); ok && lookup.token == token {
+		return lookup.t, lookup.err
+	}
+
+	return s.DB.AccessToken(accessTokenHash(token))
+}
+
+//parseAccessToken looks up the request's token and checks it is unexpired and grants the scope, recording its use.
+//It returns the token, or the status to send the client if the token is unknown, expired, or lacks the scope.
+func (s *Server) parseAccessToken(r *http.Request, token, scope string) (*models.AccessToken, int) {
+
+	if !strings.HasPrefix(token, accessTokenPrefix) {
+		s.Log.Errorln("malformed access token")
+		return nil, http.StatusUnauthorized
+	}
+
+	t, err := s.lookupAccessToken(r, token)
+	switch {
+	case err == sql.ErrNoRows:
+		s.Log.Errorln("unknown access token")
+		return nil, http.StatusUnauthorized
+	case err != nil:
+		s.Log.Errorln(err)
+		return nil, http.StatusInternalServerError
+	}
+
+	now := time.Now()
+
+	if t.Expired(now) {
+		s.Log.Errorln("expired access token:", t.ID)
+		return nil, http.StatusUnauthorized
+	}
+
+	if !t.HasScope(scope) {
+		s.Log.Errorln("forbidden request: access token missing scope", scope)
+		return nil, http.StatusForbidden
+	}
+
+	//a failure to record the use does not refuse the request
+	if t.LastUsed == nil || now.Sub(*t.LastUsed) >= tokenUseInterval {
+		err := s.DB.UseAccessToken(t.ID, now.UTC())
+		if err != nil {
+			s.Log.Errorln("error recording access token use:", err)
+		}
+	}
+
+	return t, http.StatusOK
+}
+
+//accessTokenName trims the name the user gave a token, defaulting it, and reports whether it fits
+func accessTokenName(name string) (string, bool) {
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = "Access token"
+	}
+
+	return name, len([]rune(name)) <= maxAccessTokenName
+}
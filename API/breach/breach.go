@@ -0,0 +1,210 @@
+package breach
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+//magic begins every filter file, naming the format and its version
+const magic = "BRF1"
+
+//ErrInvalidFilter is returned when reading a file that is not a filter written by WriteTo
+var ErrInvalidFilter = errors.New("breach: invalid filter file")
+
+//Filter type defined
+//Filter is a bloom filter of the SHA-1 hashes of breached passwords, as published by Have I Been Pwned.
+//It may report a password that was never breached as breached, at the false positive rate it was sized for, but never the reverse,
+//and holds the corpus in a fraction of the space of the hashes. Filters are built by cmd/breachfilter and loaded from a file:
+//checking a password needs no network calls.
+type Filter struct {
+	k    uint32 //hashes per item
+	m    uint64 //bits
+	n    uint64 //items added
+	bits []uint64
+}
+
+//NewFilter returns an empty Filter sized for n items at the given false positive rate, e.g. 0.001 for one in a thousand.
+func NewFilter(n uint64, falsePositives float64) *Filter {
+
+	if n < 1 {
+		n = 1
+	}
+	if falsePositives <= 0 || falsePositives >= 1 {
+		falsePositives = 0.001
+	}
+
+	//the optimal bits and hashes for n items at rate p: m = -n ln p / (ln 2)^2 and k = m/n ln 2
+	m := uint64(math.Ceil(-float64(n) * math.Log(falsePositives) / (math.Ln2 * math.Ln2)))
+	m = (m + 63) / 64 * 64
+	k := uint32(math.Max(1, math.Round(float64(m)/float64(n)*math.Ln2)))
+
+	return &Filter{k: k, m: m, bits: make([]uint64, m/64)}
+}
+
+//Add adds the SHA-1 hash of a breached password.
+func (f *Filter) Add(digest [sha1.Size]byte) {
+
+	h1, h2 := split(digest)
+	for i := uint64(0); i < uint64(f.k); i++ {
+		bit := (h1 + i*h2) % f.m
+		f.bits[bit/64] |= 1 << (bit % 64)
+	}
+	f.n++
+}
+
+//Has reports whether the SHA-1 hash is probably of a breached password.
+func (f *Filter) Has(digest [sha1.Size]byte) bool {
+
+	h1, h2 := split(digest)
+	for i := uint64(0); i < uint64(f.k); i++ {
+		bit := (h1 + i*h2) % f.m
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+//Compromised reports whether the password has probably appeared in a data breach.
+func (f *Filter) Compromised(password string) bool {
+	return f.Has(sha1.Sum([]byte(password)))
+}
+
+//Len returns the number of hashes added to the filter.
+func (f *Filter) Len() uint64 {
+	return f.n
+}
+
+//split derives the two hashes double hashing combines into each of the k bit positions.
+//SHA-1 hashes are already uniform, so they are read from the digest; h2 is odd so the positions do not repeat early.
+func split(digest [sha1.Size]byte) (uint64, uint64) {
+	return binary.BigEndian.Uint64(digest[0:8]), binary.BigEndian.Uint64(digest[8:16]) | 1
+}
+
+//WriteTo writes the filter to w in the format ReadFilter reads: the magic, k, m, and n, then the bits, all big-endian.
+func (f *Filter) WriteTo(w io.Writer) (int64, error) {
+
+	bw := bufio.NewWriter(w)
+
+	header := make([]byte, len(magic)+4+8+8)
+	copy(header, magic)
+	binary.BigEndian.PutUint32(header[4:], f.k)
+	binary.BigEndian.PutUint64(header[8:], f.m)
+	binary.BigEndian.PutUint64(header[16:], f.n)
+
+	if _, err := bw.Write(header); err != nil {
+		return 0, err
+	}
+
+	word := make([]byte, 8)
+	for _, b := range f.bits {
+		binary.BigEndian.PutUint64(word, b)
+		if _, err := bw.Write(word); err != nil {
+			return 0, err
+		}
+	}
+
+	return int64(len(header)) + int64(len(f.bits))*8, bw.Flush()
+}
+
+//ReadFilter reads a filter written by WriteTo.
+func ReadFilter(r io.Reader) (*Filter, error) {
+
+	br := bufio.NewReader(r)
+
+	header := make([]byte, len(magic)+4+8+8)
+	if _, err := io.ReadFull(br, header); err != nil || string(header[:4]) != magic {
+		return nil, ErrInvalidFilter
+	}
+
+	f := &Filter{k: binary.BigEndian.Uint32(header[4:]), m: binary.BigEndian.Uint64(header[8:]), n: binary.BigEndian.Uint64(header[16:])}
+	if f.k == 0 || f.m == 0 || f.m%64 != 0 {
+		return nil, ErrInvalidFilter
+	}
+
+	f.bits = make([]uint64, f.m/64)
+	word := make([]byte, 8)
+	for i := range f.bits {
+		if _, err := io.ReadFull(br, word); err != nil {
+			return nil, ErrInvalidFilter
+		}
+		f.bits[i] = binary.BigEndian.Uint64(word)
+	}
+
+	return f, nil
+}
+
+//Load reads the filter in the file at path.
+func Load(path string) (*Filter, error) {
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ReadFilter(file)
+}
+
+//ReadHIBP reads the SHA-1 hashes in a Have I Been Pwned password file and calls add with each seen at least minCount times,
+//returning how many it added. Files hold a "HASH:COUNT" line per hash: either the full 40 hex digit hash,
+//or the 35 digits after a 5 digit prefix for files of one range, as the range API and its downloader give, in which case prefix is that range.
+//Padding lines with a count of zero are skipped with any minCount.
+func ReadHIBP(r io.Reader, prefix string, minCount int, add func([sha1.Size]byte)) (int, error) {
+
+	if minCount < 1 {
+		minCount = 1
+	}
+
+	added := 0
+	scanner := bufio.NewScanner(r)
+
+	for line := 1; scanner.Scan(); line++ {
+
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		parts := strings.SplitN(text, ":", 2)
+		count := 1
+		if len(parts) == 2 {
+			n, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+			if err != nil {
+				return added, fmt.Errorf("line %d: invalid count %q", line, parts[1])
+			}
+			count = n
+		}
+
+		hash := parts[0]
+		if len(hash) == 35 {
+			hash = prefix + hash
+		}
+
+		var digest [sha1.Size]byte
+		if len(hash) != 2*sha1.Size {
+			return added, fmt.Errorf("line %d: not a SHA-1 hash: %q", line, parts[0])
+		}
+		if _, err := hex.Decode(digest[:], []byte(hash)); err != nil {
+			return added, fmt.Errorf("line %d: not a SHA-1 hash: %q", line, parts[0])
+		}
+
+		if count < minCount {
+			continue
+		}
+
+		add(digest)
+		added++
+	}
+
+	return added, scanner.Err()
+}
@@ -0,0 +1,101 @@
+package breach
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+
+	n := 10000
+	f := NewFilter(uint64(n), 0.01)
+
+	for i := 0; i < n; i++ {
+		f.Add(sha1.Sum([]byte(fmt.Sprintf("breached-%d", i))))
+	}
+	if f.Len() != uint64(n) {
+		t.Errorf("filter holds wrong number of hashes:\ngot: %v\nwant: %v", f.Len(), n)
+	}
+
+	//every added hash is found
+	for i := 0; i < n; i++ {
+		if !f.Compromised(fmt.Sprintf("breached-%d", i)) {
+			t.Fatalf("breached-%d not found", i)
+		}
+	}
+
+	//others are found no more often than the filter was sized for, with some slack
+	falsePositives := 0
+	for i := 0; i < n; i++ {
+		if f.Compromised(fmt.Sprintf("safe-%d", i)) {
+			falsePositives++
+		}
+	}
+	if rate := float64(falsePositives) / float64(n); rate > 0.02 {
+		t.Errorf("filter false positive rate too high: %v", rate)
+	}
+
+	//a written filter reads back the same
+	var buf bytes.Buffer
+	size, err := f.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != int64(buf.Len()) {
+		t.Errorf("WriteTo returned wrong size:\ngot: %v\nwant: %v", size, buf.Len())
+	}
+
+	read, err := ReadFilter(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.k != f.k || read.m != f.m || read.n != f.n || !read.Compromised("breached-0") || read.Compromised("safe-0") != f.Compromised("safe-0") {
+		t.Errorf("filter read back wrongly: k=%v m=%v n=%v", read.k, read.m, read.n)
+	}
+
+	for _, bad := range [][]byte{nil, []byte("not a filter at all"), buf.Bytes()[:buf.Len()-1]} {
+		if _, err := ReadFilter(bytes.NewReader(bad)); err != ErrInvalidFilter {
+			t.Errorf("ReadFilter of %d bytes returned wrong error:\ngot: %v\nwant: %v", len(bad), err, ErrInvalidFilter)
+		}
+	}
+}
+
+func TestReadHIBP(t *testing.T) {
+
+	//SHA-1 of "password" is 5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8
+	full := "5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8:9545824\n" +
+		"7C4A8D09CA3762AF61E59520943DC26494F8941B:37359195\n" + //123456
+		"B1B3773A05C0ED0176787A4F1574FF0075F7521E:3\n" //qwerty, seen too few times below
+
+	f := NewFilter(3, 0.001)
+	added, err := ReadHIBP(strings.NewReader(full), "", 5, f.Add)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added != 2 || !f.Compromised("password") || !f.Compromised("123456") || f.Compromised("qwerty") {
+		t.Errorf("full hashes read wrongly: added %v", added)
+	}
+
+	//range files hold the hash after the prefix, lower case is accepted, and padding lines are skipped
+	ranged := "1E4C9B93F3F0682250B6CF8331B7EE68FD8:9545824\r\n" +
+		"00000000000000000000000000000000000:0\r\n" +
+		"\r\n"
+
+	f = NewFilter(2, 0.001)
+	added, err = ReadHIBP(strings.NewReader(strings.ToLower(ranged)), "5BAA6", 0, f.Add)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added != 1 || !f.Compromised("password") {
+		t.Errorf("range hashes read wrongly: added %v", added)
+	}
+
+	for _, bad := range []string{"5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8:many\n", "8846F7EAEE8FB117AD06BDD830B7586C:1\n", "ZZAA61E4C9B93F3F0682250B6CF8331B7EE68FD8:1\n"} {
+		if _, err := ReadHIBP(strings.NewReader(bad), "", 1, f.Add); err == nil || !strings.HasPrefix(err.Error(), "line 1:") {
+			t.Errorf("ReadHIBP of %q returned wrong error: %v", bad, err)
+		}
+	}
+}
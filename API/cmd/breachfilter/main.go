@@ -0,0 +1,133 @@
+//Breachfilter builds the breached password filter the API checks new passwords against, from Have I Been Pwned password files:
+//files of full "HASH:COUNT" lines, or directories of range files named by their 5 digit prefix, as the HIBP downloader writes them.
+//The files are read twice, once to size the filter and once to fill it.
+//Run it wherever the files are, e.g. go run ./cmd/breachfilter -out breached.bf -min-count 10 pwnedpasswords/
+//and point the API's breached_passwords environment variable at the output.
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/chiips/snippets/API/breach"
+)
+
+//source is one HIBP file to read, with the prefix of the range it holds if any
+type source struct {
+	path, prefix string
+}
+
+func main() {
+
+	out := flag.String("out", "breached.bf", "file to write the filter to")
+	falsePositives := flag.Float64("fp", 0.001, "false positive rate to size the filter for")
+	minCount := flag.Int("min-count", 1, "leave out hashes seen fewer times than this in breaches")
+	flag.Parse()
+
+	if flag.NArg() == 0 || *falsePositives <= 0 || *falsePositives >= 1 {
+		fmt.Fprintln(os.Stderr, "usage: breachfilter [-out file] [-fp rate] [-min-count n] file-or-directory...")
+		os.Exit(2)
+	}
+
+	sources, err := sourcesOf(flag.Args())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	//count first, so the filter is sized for the hashes it will hold
+	var n uint64
+	err = read(sources, *minCount, func([sha1.Size]byte) { n++ })
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	filter := breach.NewFilter(n, *falsePositives)
+	err = read(sources, *minCount, filter.Add)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	file, err := os.Create(*out)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	size, err := filter.WriteTo(file)
+	if err == nil {
+		err = file.Close()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("wrote %d hashes from %d files to %s (%d MiB)\n", filter.Len(), len(sources), *out, size>>20)
+}
+
+//sourcesOf lists the files to read from the given paths. Files in a directory named by a 5 digit hex prefix are read as range files.
+func sourcesOf(paths []string) ([]source, error) {
+
+	sources := []source{}
+
+	for _, path := range paths {
+
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+
+		if !info.IsDir() {
+			sources = append(sources, source{path: path})
+			continue
+		}
+
+		files, err := ioutil.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, file := range files {
+			if file.IsDir() {
+				continue
+			}
+			name := strings.TrimSuffix(file.Name(), filepath.Ext(file.Name()))
+			prefix := ""
+			if _, err := hex.DecodeString(name + "0"); err == nil && len(name) == 5 {
+				prefix = strings.ToUpper(name)
+			}
+			sources = append(sources, source{path: filepath.Join(path, file.Name()), prefix: prefix})
+		}
+	}
+
+	return sources, nil
+}
+
+//read reads every source, calling add with each hash seen at least minCount times
+func read(sources []source, minCount int, add func([sha1.Size]byte)) error {
+
+	for _, src := range sources {
+
+		file, err := os.Open(src.path)
+		if err != nil {
+			return err
+		}
+
+		_, err = breach.ReadHIBP(file, src.prefix, minCount, add)
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %v", src.path, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,31 @@
+//Hashparams picks argon2id parameters for this hardware: it finds the iterations that make one password hash
+//with the given memory and parallelism take the target time, and prints the password_hash setting to run the API with.
+//Run it on the hardware the API runs on, e.g. go run ./cmd/hashparams -target 250ms -memory 65536 -parallelism 4
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/chiips/snippets/API/passwords"
+)
+
+func main() {
+
+	target := flag.Duration("target", 250*time.Millisecond, "how long one password hash should take")
+	memory := flag.Uint("memory", uint(passwords.DefaultArgon2.Memory), "memory per hash in KiB")
+	parallelism := flag.Uint("parallelism", uint(passwords.DefaultArgon2.Parallelism), "threads per hash")
+	flag.Parse()
+
+	if *memory < 8 || *memory > 1<<32-1 || *parallelism < 1 || *parallelism > 255 {
+		fmt.Println("memory must be at least 8 KiB and parallelism between 1 and 255")
+		return
+	}
+
+	p, took := passwords.Calibrate(*target, uint32(*memory), uint8(*parallelism))
+
+	h := &passwords.Hasher{Algorithm: passwords.Argon2id, Argon2: p}
+	fmt.Printf("one hash takes %v with %d iterations\n", took.Round(time.Millisecond), p.Iterations)
+	fmt.Printf("password_hash=%s\n", h)
+}
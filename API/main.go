@@ -1,16 +1,21 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"time"
 
 	"net/http"
 	"os"
+	"strings"
 
 	"github.com/chiips/snippets/API/app"
+	"github.com/chiips/snippets/API/breach"
 	"github.com/chiips/snippets/API/logs"
 	"github.com/chiips/snippets/API/models"
-	"github.com/didip/tollbooth"
+	"github.com/chiips/snippets/API/oidc"
+	"github.com/chiips/snippets/API/passwords"
+	"github.com/chiips/snippets/API/webauthn"
 	"github.com/gorilla/csrf"
 	"github.com/joho/godotenv"
 	hr "github.com/julienschmidt/httprouter"
@@ -50,29 +55,109 @@ func main() {
 	//set up new router using Julien Schmidt's httprouter
 	router := hr.New()
 
-	//assign database, router, and logger to our app's Server struct
-	s := app.Server{DB: db, Router: router, Log: logger}
+	//set up rate limiter with the default policies, overridden by any in rate_limits (e.g. "auth=5/1m,read=300/1m")
+	policies, err := app.ParseRatePolicies(os.Getenv("rate_limits"))
+	if err != nil {
+		logger.Panic(err)
+	}
+
+	//set up the state store shared by the rate limiter and JWT revocation: Redis at redis_url, so every API instance shares limits and revocations, or memory if unset
+	state, err := app.NewStateStore(os.Getenv("redis_url"))
+	if err != nil {
+		logger.Panic(err)
+	}
+	limiter := app.NewRateLimiter(policies, state)
+
+	//set up the reverse proxies trusted to report client IPs, e.g. "127.0.0.1" for nginx on the same host. Without any the connection's address is used.
+	proxies, err := app.ParseTrustedProxies(os.Getenv("trusted_proxies"))
+	if err != nil {
+		logger.Panic(err)
+	}
+
+	//set up the header the proxies report client IPs in: X-Forwarded-For, as nginx.conf sets, unless forwarded_header is Forwarded
+	forwardedHeader, err := app.ParseForwardedHeader(os.Getenv("forwarded_header"))
+	if err != nil {
+		logger.Panic(err)
+	}
+
+	//load the key pagination cursors are signed with from cursor_key, at least 32 random bytes, so clients cannot forge cursors
+	cursorKey, err := app.ParseCursorKey(os.Getenv("cursor_key"))
+	if err != nil {
+		logger.Panic(err)
+	}
+
+	//set up the mailer for emailing users, e.g. unlock links for accounts locked after failed logins. Without an smtp_host emails are only logged.
+	mailer := app.NewMailer(os.Getenv("smtp_host"), os.Getenv("smtp_port"), os.Getenv("smtp_user"), os.Getenv("smtp_pass"), os.Getenv("mail_from"), logger)
+
+	//set up password hashing: argon2id with the defaults, or the algorithm and cost in password_hash (e.g. "argon2id,m=65536,t=3,p=4", see cmd/hashparams).
+	//Passwords hashed otherwise are rehashed when their users next log in.
+	hasher, err := passwords.ParseHasher(os.Getenv("password_hash"))
+	if err != nil {
+		logger.Panic(err)
+	}
+
+	//load the breached password filter built by cmd/breachfilter from the file at breached_passwords. Without one new passwords are not checked for breaches.
+	var breached *breach.Filter
+	if path := os.Getenv("breached_passwords"); path != "" {
+		breached, err = breach.Load(path)
+		if err != nil {
+			logger.Panic(err)
+		}
+		logger.Infoln("loaded breached password filter:", breached.Len(), "hashes")
+	} else {
+		logger.Warnln("no breached_passwords filter: new passwords are not checked against breaches")
+	}
+
+	//set up passkeys for the domain in webauthn_rp_id (e.g. "example.com"), from pages served at the origins in webauthn_origins (e.g. "https://example.com").
+	//Without a webauthn_rp_id users log in with passwords only.
+	var relyingParty *webauthn.RelyingParty
+	if rpID := os.Getenv("webauthn_rp_id"); rpID != "" {
+		relyingParty = &webauthn.RelyingParty{ID: rpID, Name: "Snippets", Origins: strings.Split(os.Getenv("webauthn_origins"), ",")}
+	} else {
+		logger.Warnln("no webauthn_rp_id: passkeys are not enabled")
+	}
+
+	//set up the OpenID Connect providers named in oidc_providers (e.g. "google,github"), each from oidc_<name>_issuer, oidc_<name>_client_id, and oidc_<name>_client_secret.
+	//Providers send users back to app_url/api/oidc/<name>/callback unless oidc_<name>_redirect_url says otherwise. Without any users log in here only.
+	providers := make(map[string]*oidc.Provider)
+	for _, name := range strings.Split(os.Getenv("oidc_providers"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		redirectURL := os.Getenv("oidc_" + name + "_redirect_url")
+		if redirectURL == "" {
+			redirectURL = os.Getenv("app_url") + "/api/oidc/" + name + "/callback"
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		provider, err := oidc.NewProvider(ctx, name, os.Getenv("oidc_"+name+"_issuer"), os.Getenv("oidc_"+name+"_client_id"), os.Getenv("oidc_"+name+"_client_secret"), redirectURL)
+		cancel()
+		if err != nil {
+			logger.Panic(err)
+		}
+		providers[name] = provider
+		logger.Infoln("set up OpenID Connect provider:", name, provider.Issuer)
+	}
+
+	//assign database, router, logger, rate limiter, state store, trusted proxies, mailer, password hasher, breached password filter, passkey relying party, OpenID Connect providers, and cursor key to our app's Server struct
+	s := app.Server{DB: db, Router: router, Log: logger, Limiter: limiter, State: state, TrustedProxies: proxies, ForwardedHeader: forwardedHeader, Mail: mailer, Passwords: hasher, Breached: breached, WebAuthn: relyingParty, Providers: providers, CursorKey: cursorKey}
 	//initialize the Server's routes
 	s.Routes()
 
-	//Initiate CSRF protection
+	//Initiate CSRF protection, which requests with personal access tokens skip
 	key := []byte(os.Getenv("32-byte-auth-key"))
 	errHandler := csrf.ErrorHandler(s.CSRFErrorHandler())
 	security := csrf.Secure(false) //for development over http instead of https. true by default
 	csrfProtect := csrf.Protect(key, errHandler, security)
 
-	//initialize new limiter
-	lmt := s.NewLimiter()
-
 	//set our server object for ListenAndServe with all the server middleware
-	srvHandler := s.Timeout(csrfProtect(s.LogRequests(s.SetHeaders(s.Router))))
-	limitedHandler := tollbooth.LimitHandler(lmt, srvHandler)
+	srvHandler := s.ResolveClientIP(s.Timeout(s.SkipBearerCSRF(csrfProtect(s.LogRequests(s.SetHeaders(s.Router))))))
 	srv := &http.Server{
 		Addr:         port,
 		ReadTimeout:  5 * time.Second,
 		WriteTimeout: 10 * time.Second,
 		IdleTimeout:  20 * time.Second,
-		Handler:      limitedHandler,
+		Handler:      srvHandler,
 	}
 
 	//listen and serve
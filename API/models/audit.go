@@ -0,0 +1,98 @@
+package models
+
+import (
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+//The actions recorded by an AuditEvent
+const (
+	AuditSignup         = "signup"
+	AuditLogin          = "login"
+	AuditLoginFailed    = "login_failed"
+	AuditSessionRefresh = "session_refresh"
+	AuditPasswordChange = "password_change"
+	AuditAvatarChange   = "avatar_change"
+	AuditAccountDelete  = "account_delete"
+	AuditRoleChange     = "role_change"
+	AuditModeration     = "moderation"
+)
+
+//auditActions lists every action an AuditEvent records
+var auditActions = []string{AuditSignup, AuditLogin, AuditLoginFailed, AuditSessionRefresh, AuditPasswordChange, AuditAvatarChange, AuditAccountDelete, AuditRoleChange, AuditModeration}
+
+//AuditEvent type defined
+//AuditEvent is one entry of the security audit log: which user did what to which user, from which IP, user agent, and request, and when.
+//Actor is uuid.Nil when no user is logged in, e.g. for failed logins, and Target when the account is not known.
+type AuditEvent struct {
+	ID        uuid.UUID `json:"id"`
+	Action    string    `json:"action"`
+	Actor     uuid.UUID `json:"actor"`
+	Target    uuid.UUID `json:"target"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	RequestID string    `json:"request_id"`
+	Detail    string    `json:"detail,omitempty"`
+	Created   time.Time `json:"created"`
+}
+
+//AuditFilter narrows a list of audit events to those matching every field set.
+//Since and Until bound the events' created time, Since inclusive and Until exclusive.
+type AuditFilter struct {
+	Action string
+	Actor  uuid.UUID
+	Target uuid.UUID
+	IP     string
+	Since  *time.Time
+	Until  *time.Time
+}
+
+//ValidAuditAction reports whether action is an action recorded by audit events.
+func ValidAuditAction(action string) bool {
+	for _, a := range auditActions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+//Our selection of sample Audit methods to satisfy the Datastore interface:
+
+//RecordAuditEvent appends an event to the audit log and returns nil or an error. Audit events are never updated or deleted.
+//RecordAuditEvent expects event will come in with id uuid.UUID, action string, actor uuid.UUID, target uuid.UUID, ip string, user_agent string, request_id string, detail string, created time.Time
+func (db *DB) RecordAuditEvent(event *AuditEvent) error {
+
+	_, err := db.Exec("INSERT INTO audit_events (id, action, actor, target, ip, user_agent, request_id, detail, created) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9);", event.ID, event.Action, event.Actor, event.Target, event.IP, event.UserAgent, event.RequestID, event.Detail, event.Created)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+//AuditEvents takes a filter, cursor, and limit and returns one page of the audit events matching the filter, newest first, or an error.
+func (db *DB) AuditEvents(filter AuditFilter, before Cursor, limit int) ([]*AuditEvent, error) {
+	events := []*AuditEvent{}
+
+	rows, err := db.Query("SELECT id, action, actor, target, ip, user_agent, request_id, detail, created FROM audit_events WHERE ($1 = '' OR action = $1) AND ($2::uuid = $10 OR actor = $2) AND ($3::uuid = $10 OR target = $3) AND ($4 = '' OR ip = $4) AND ($5::timestamptz IS NULL OR created >= $5) AND ($6::timestamptz IS NULL OR created < $6) AND (created, id) < ($7, $8) ORDER BY created DESC, id DESC LIMIT $9;", filter.Action, filter.Actor, filter.Target, filter.IP, filter.Since, filter.Until, before.Created, before.ID, limit, uuid.Nil)
+	if err != nil {
+		return events, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		event := &AuditEvent{}
+		err := rows.Scan(&event.ID, &event.Action, &event.Actor, &event.Target, &event.IP, &event.UserAgent, &event.RequestID, &event.Detail, &event.Created)
+		if err != nil {
+			return events, err
+		}
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return events, err
+	}
+
+	return events, nil
+}
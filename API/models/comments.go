@@ -0,0 +1,152 @@
+package models
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+	uuid "github.com/satori/go.uuid"
+)
+
+//Comment type defined
+//ParentID is uuid.Nil for comments made directly on a post.
+type Comment struct {
+	ID         uuid.UUID  `json:"id"`
+	PostID     uuid.UUID  `json:"postid"`
+	ParentID   uuid.UUID  `json:"parentid"`
+	Body       string     `json:"body"`
+	Created    time.Time  `json:"created"`
+	Updated    time.Time  `json:"updated"`
+	Author     User       `json:"author"`
+	ReplyCount int        `json:"replycount"`
+	Replies    []*Comment `json:"replies,omitempty"`
+}
+
+//Our selection of sample Comment methods to satisfy the Datastore interface:
+
+//PostComments takes a post id, parent id, cursor, and limit and returns the comments before that cursor in reverse chronological order or an error.
+//A nil parentID returns the comments made directly on the post; otherwise the direct replies to that comment are returned.
+func (db *DB) PostComments(postID, parentID uuid.UUID, before Cursor, limit int) ([]*Comment, error) {
+	comments := []*Comment{}
+
+	parent := uuid.NullUUID{UUID: parentID, Valid: parentID != uuid.Nil}
+
+	rows, err := db.Query("SELECT comments.id, comments.post_id, comments.parent, comments.body, comments.created, comments.updated, (SELECT COUNT(*) FROM comments replies WHERE replies.parent = comments.id), users.id, users.name, users.avatar FROM comments INNER JOIN users ON comments.uid = users.id WHERE comments.post_id = $1 AND comments.parent IS NOT DISTINCT FROM $2 AND (comments.created, comments.id) < ($3, $4) ORDER BY comments.created DESC, comments.id DESC LIMIT $5;", postID, parent, before.Created, before.ID, limit)
+	if err != nil {
+		return comments, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		comment, err := scanComment(rows)
+		if err != nil {
+			return comments, err
+		}
+		comments = append(comments, comment)
+	}
+	if err := rows.Err(); err != nil {
+		return comments, err
+	}
+
+	return comments, nil
+}
+
+//CommentReplies takes a set of parent comment ids and returns their replies down to the given depth in chronological order or an error.
+//Depth 1 returns only direct replies. The replies are returned flat; each carries its ParentID for nesting.
+func (db *DB) CommentReplies(parentIDs []uuid.UUID, depth int) ([]*Comment, error) {
+	comments := []*Comment{}
+
+	if len(parentIDs) == 0 || depth < 1 {
+		return comments, nil
+	}
+
+	ids := make([]string, len(parentIDs))
+	for i, id := range parentIDs {
+		ids[i] = id.String()
+	}
+
+	rows, err := db.Query("WITH RECURSIVE thread AS (SELECT id, post_id, parent, body, created, updated, uid, 1 AS depth FROM comments WHERE parent = ANY($1::uuid[]) UNION ALL SELECT comments.id, comments.post_id, comments.parent, comments.body, comments.created, comments.updated, comments.uid, thread.depth + 1 FROM comments INNER JOIN thread ON comments.parent = thread.id WHERE thread.depth < $2) SELECT thread.id, thread.post_id, thread.parent, thread.body, thread.created, thread.updated, (SELECT COUNT(*) FROM comments replies WHERE replies.parent = thread.id), users.id, users.name, users.avatar FROM thread INNER JOIN users ON thread.uid = users.id ORDER BY thread.created, thread.id;", pq.Array(ids), depth)
+	if err != nil {
+		return comments, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		comment, err := scanComment(rows)
+		if err != nil {
+			return comments, err
+		}
+		comments = append(comments, comment)
+	}
+	if err := rows.Err(); err != nil {
+		return comments, err
+	}
+
+	return comments, nil
+}
+
+//OneComment returns one specific comment or an error
+func (db *DB) OneComment(id uuid.UUID) (*Comment, error) {
+
+	row := db.QueryRow("SELECT comments.id, comments.post_id, comments.parent, comments.body, comments.created, comments.updated, (SELECT COUNT(*) FROM comments replies WHERE replies.parent = comments.id), users.id, users.name, users.avatar FROM comments INNER JOIN users ON comments.uid = users.id WHERE comments.id = $1", id)
+
+	return scanComment(row)
+}
+
+//CreateComment creates a new comment in the DB and returns an error.
+//CreateComment expects comment will come in with id uuid.UUID, postid uuid.UUID, parentid uuid.UUID, body string, created time.Time, uid uuid.UUID
+func (db *DB) CreateComment(comment *Comment) error {
+
+	parent := uuid.NullUUID{UUID: comment.ParentID, Valid: comment.ParentID != uuid.Nil}
+
+	_, err := db.Exec("INSERT INTO comments (id, post_id, parent, body, created, updated, uid) VALUES ($1, $2, $3, $4, $5, $6, $7)", comment.ID, comment.PostID, parent, comment.Body, comment.Created, comment.Updated, comment.Author.ID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+//UpdateComment updates a specific comment in DB and returns an error.
+//UpdateComment expects comment will come in with id uuid.UUID, body string, updated time.Time
+func (db *DB) UpdateComment(comment *Comment) error {
+
+	_, err := db.Exec("UPDATE comments SET body=$2, updated=$3 WHERE id=$1;", comment.ID, comment.Body, comment.Updated)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+//DeleteComment deletes one specific comment from DB, along with its replies, and returns an error.
+//DeleteComment expects comment will come in with id uuid.UUID
+func (db *DB) DeleteComment(comment *Comment) error {
+
+	//replies are removed by the ON DELETE CASCADE of comments.parent
+	_, err := db.Exec("DELETE FROM comments WHERE id=$1;", comment.ID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+//scanner is satisfied by both *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+//scanComment reads one comment selected in the column order used by the Comment methods above.
+func scanComment(row scanner) (*Comment, error) {
+
+	comment := &Comment{}
+	parent := uuid.NullUUID{}
+
+	err := row.Scan(&comment.ID, &comment.PostID, &parent, &comment.Body, &comment.Created, &comment.Updated, &comment.ReplyCount, &comment.Author.ID, &comment.Author.Name, &comment.Author.Avatar)
+	if err != nil {
+		return comment, err
+	}
+	comment.ParentID = parent.UUID
+
+	return comment, nil
+}
@@ -2,6 +2,8 @@ package models
 
 import (
 	"database/sql"
+	"errors"
+	"time"
 
 	//pq is necessary for connecting with PostgreSQL
 	_ "github.com/lib/pq"
@@ -14,21 +16,135 @@ import (
 type Datastore interface {
 
 	//Sample User methods
-	SearchUsers(query, prevDate string, limit int) ([]*User, error)
+	SearchUsers(query string, before Cursor, limit int) ([]*UserResult, error)
+	AutocompleteUsers(prefix string, limit int) ([]*User, error)
 	CreateUser(user *User) error
 	EmailCheck(email string) (bool, error)
+	UserByEmail(email string) (*User, error)
+	UserByID(id uuid.UUID) (*User, error)
+	UserPassword(id uuid.UUID) (string, error)
+	UpdatePassword(id uuid.UUID, password string) error
 	NameCheck(name string) (bool, error)
 	UpdateUserPhoto(user *User) error
 	DeleteUser(user *User) error
 
 	//Sample Post methods
-	AllPosts(prevDate string, limit int) ([]*Post, error)
-	OnePost(id uuid.UUID) (*Post, error)
+	AllPosts(before Cursor, limit int, viewer uuid.UUID) ([]*Post, error)
+	OnePost(id, viewer uuid.UUID) (*Post, error)
 	CreatePost(Post *Post) error
 	UpdatePost(Post *Post) error
 	DeletePost(Post *Post) error
+	SearchPosts(query SearchQuery, before Cursor, limit int, viewer uuid.UUID) ([]*PostResult, error)
+
+	//Sample Comment methods
+	PostComments(postID, parentID uuid.UUID, before Cursor, limit int) ([]*Comment, error)
+	CommentReplies(parentIDs []uuid.UUID, depth int) ([]*Comment, error)
+	OneComment(id uuid.UUID) (*Comment, error)
+	CreateComment(comment *Comment) error
+	UpdateComment(comment *Comment) error
+	DeleteComment(comment *Comment) error
+
+	//Sample Reaction methods
+	AddReaction(postID, userID uuid.UUID, reaction string, created time.Time) error
+	RemoveReaction(postID, userID uuid.UUID, reaction string) error
+
+	//Sample Follow methods
+	UserProfile(id, viewer uuid.UUID) (*Profile, error)
+	Follow(follower, followee uuid.UUID, created time.Time) error
+	Unfollow(follower, followee uuid.UUID) error
+	Followers(id uuid.UUID, before Cursor, limit int) ([]*Follow, error)
+	Following(id uuid.UUID, before Cursor, limit int) ([]*Follow, error)
+	Timeline(id uuid.UUID, before Cursor, limit int) ([]*Post, error)
+
+	//Sample Tag methods
+	TagPosts(tag string, before Cursor, limit int, viewer uuid.UUID) ([]*Post, error)
+	TrendingTags(asOf time.Time, window time.Duration, limit int) ([]*TrendingTag, error)
+
+	//Sample Revision methods
+	PostRevisions(postID uuid.UUID, before Cursor, limit int) ([]*Revision, error)
+	OneRevision(postID uuid.UUID, number int) (*Revision, error)
+
+	//Sample Role methods
+	UserRoles(id uuid.UUID) ([]string, error)
+	GrantRole(change *RoleChange) (bool, error)
+	RevokeRole(change *RoleChange) (bool, error)
+	RoleChanges(target uuid.UUID, before Cursor, limit int) ([]*RoleChange, error)
+
+	//Sample Moderation methods
+	CreateReport(report *Report) error
+	Reports(filter ReportFilter, before Cursor, limit int) ([]*Report, error)
+	OneReport(id uuid.UUID) (*Report, error)
+	Moderate(action *ModAction) error
+	ModActions(subject uuid.UUID, before Cursor, limit int) ([]*ModAction, error)
+	OneModAction(id uuid.UUID) (*ModAction, error)
+	CreateAppeal(appeal *Appeal) error
+	Appeals(status string, before Cursor, limit int) ([]*Appeal, error)
+	OneAppeal(id uuid.UUID) (*Appeal, error)
+	DecideAppeal(appeal *Appeal) error
+
+	//Sample Standing methods
+	AccountStanding(id uuid.UUID) (*Standing, error)
+	SetStanding(action *ModAction) error
+
+	//Sample Lockout methods
+	RecordLockout(event *LockoutEvent) error
+
+	//Sample MFA methods
+	TOTP(user uuid.UUID) (*TOTP, error)
+	EnrollTOTP(t *TOTP) error
+	ConfirmTOTP(user uuid.UUID, step int64, hashes []string) error
+	UseTOTPStep(user uuid.UUID, step int64) (bool, error)
+	UseRecoveryCode(user uuid.UUID, hash string) (bool, error)
+	DeleteTOTP(user uuid.UUID) error
+
+	//Sample Passkey methods
+	Passkey(id string) (*Passkey, error)
+	UserPasskeys(user uuid.UUID) ([]*Passkey, error)
+	CreatePasskey(p *Passkey) error
+	UsePasskey(id string, signCount int64, used time.Time) (bool, error)
+	FlagClonedPasskey(id string) error
+	DeletePasskey(user uuid.UUID, id string) error
+
+	//Sample Identity methods
+	Identity(provider, subject string) (*Identity, error)
+	UserIdentities(user uuid.UUID) ([]*Identity, error)
+	LinkIdentity(i *Identity) error
+	UnlinkIdentity(user uuid.UUID, provider string) error
+
+	//Sample AccessToken methods
+	AccessToken(hash string) (*AccessToken, error)
+	UserAccessTokens(user uuid.UUID) ([]*AccessToken, error)
+	CreateAccessToken(t *AccessToken) error
+	UseAccessToken(id uuid.UUID, used time.Time) error
+	DeleteAccessToken(user, id uuid.UUID) error
+
+	//Sample Session methods
+	Session(id uuid.UUID) (*Session, error)
+	UserSessions(user uuid.UUID, at time.Time) ([]*Session, error)
+	CreateSession(s *Session) error
+	SeeSession(id uuid.UUID, seen time.Time) error
+	RefreshSession(id uuid.UUID, seen, expires time.Time) error
+	DeleteSession(user, id uuid.UUID) error
+	DeleteOtherSessions(user, keep uuid.UUID) (int64, error)
+
+	//Sample Audit methods
+	RecordAuditEvent(event *AuditEvent) error
+	AuditEvents(filter AuditFilter, before Cursor, limit int) ([]*AuditEvent, error)
+}
+
+//Cursor marks a position in a reverse chronological list.
+//Rows are ordered by (created, id) so that rows sharing the same created timestamp are neither skipped nor repeated across pages.
+//Ranked lists are ordered by (score, id) instead and use Created as the fixed point in time the ranking was computed at.
+type Cursor struct {
+	Created time.Time
+	Score   float64
+	ID      uuid.UUID
 }
 
+//ErrVersionConflict is returned by updates made to a version of a row that is no longer current.
+//Clients edit the version they last read, so this means someone else saved an edit in between.
+var ErrVersionConflict = errors.New("models: edit made to an outdated version")
+
 //DB is our database type
 //By attaching the Datastore interface's methods, our DB struct will implement the Datastore interface.
 type DB struct {
@@ -0,0 +1,185 @@
+package models
+
+import (
+	"regexp"
+	"strings"
+)
+
+//DiffOp type defined
+//DiffOp is one run of a diff: text kept in both versions, inserted by the newer version, or deleted from the older one.
+type DiffOp struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+//kinds of diff runs
+const (
+	DiffEqual  = "equal"
+	DiffInsert = "insert"
+	DiffDelete = "delete"
+)
+
+//maxDiffEdits bounds the work of a diff. Texts further apart than this are shown as a whole deletion followed by a whole insertion.
+const maxDiffEdits = 1000
+
+//rxDiffWord splits text into words and the whitespace between them so a word diff keeps the original spacing
+var rxDiffWord = regexp.MustCompile(`\s+|\S+`)
+
+//DiffLines compares two texts line by line.
+func DiffLines(a, b string) []DiffOp {
+	return diff(splitLines(a), splitLines(b))
+}
+
+//DiffWords compares two texts word by word.
+func DiffWords(a, b string) []DiffOp {
+	return diff(rxDiffWord.FindAllString(a, -1), rxDiffWord.FindAllString(b, -1))
+}
+
+//splitLines splits text after each newline so joining the lines gives back the text
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+//diff finds the shortest edit script turning a into b with Myers' algorithm and returns it as runs of joined tokens.
+func diff(a, b []string) []DiffOp {
+
+	//the common prefix and suffix need no search
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	ops := &diffBuilder{}
+	ops.add(DiffEqual, a[:prefix]...)
+	middle := myers(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])
+	for _, op := range middle {
+		ops.add(op.Op, op.Text)
+	}
+	ops.add(DiffEqual, a[len(a)-suffix:]...)
+
+	return ops.ops
+}
+
+//myers returns the edits between a and b one token at a time.
+func myers(a, b []string) []DiffOp {
+
+	n, m := len(a), len(b)
+	max := n + m
+	if max > maxDiffEdits {
+		max = maxDiffEdits
+	}
+
+	//v holds the furthest x reached on each diagonal k = x - y, offset by max.
+	//trace keeps the diagonals -d-1 to d+1 of v at the start of each round d for the walk back.
+	offset := max + 1
+	v := make([]int, 2*max+3)
+	trace := [][]int{}
+
+	for d := 0; d <= max; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d-1:offset+d+2]...))
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+
+			if x >= n && y >= m {
+				return backtrack(a, b, trace, d)
+			}
+		}
+	}
+
+	//too far apart to search: delete everything then insert everything
+	edits := []DiffOp{}
+	for _, token := range a {
+		edits = append(edits, DiffOp{Op: DiffDelete, Text: token})
+	}
+	for _, token := range b {
+		edits = append(edits, DiffOp{Op: DiffInsert, Text: token})
+	}
+	return edits
+}
+
+//backtrack walks the saved rounds from the end of both texts back to the start, recording the path taken.
+func backtrack(a, b []string, trace [][]int, d int) []DiffOp {
+
+	edits := []DiffOp{}
+	x, y := len(a), len(b)
+
+	for ; d > 0; d-- {
+		v := trace[d]
+		offset := d + 1
+		k := x - y
+
+		var prevK int
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[offset+prevK]
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			x--
+			y--
+			edits = append(edits, DiffOp{Op: DiffEqual, Text: a[x]})
+		}
+
+		if x == prevX {
+			y--
+			edits = append(edits, DiffOp{Op: DiffInsert, Text: b[y]})
+		} else {
+			x--
+			edits = append(edits, DiffOp{Op: DiffDelete, Text: a[x]})
+		}
+	}
+
+	for x > 0 && y > 0 {
+		x--
+		y--
+		edits = append(edits, DiffOp{Op: DiffEqual, Text: a[x]})
+	}
+
+	//reverse into reading order
+	for i, j := 0, len(edits)-1; i < j; i, j = i+1, j-1 {
+		edits[i], edits[j] = edits[j], edits[i]
+	}
+
+	return edits
+}
+
+//diffBuilder joins consecutive tokens of the same kind into one run.
+type diffBuilder struct {
+	ops []DiffOp
+}
+
+func (builder *diffBuilder) add(op string, tokens ...string) {
+	for _, token := range tokens {
+		if last := len(builder.ops) - 1; last >= 0 && builder.ops[last].Op == op {
+			builder.ops[last].Text += token
+			continue
+		}
+		builder.ops = append(builder.ops, DiffOp{Op: op, Text: token})
+	}
+}
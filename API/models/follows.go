@@ -0,0 +1,206 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+//Profile type defined
+//Profile adds the follow graph to a user: how many users follow them, how many they follow, and whether the viewer follows them.
+type Profile struct {
+	User
+	Followers int  `json:"followers"`
+	Following int  `json:"following"`
+	Followed  bool `json:"followed"`
+}
+
+//Follow type defined
+//Follow is one entry of a followers or following list: the other user and when the follow began.
+type Follow struct {
+	User    User      `json:"user"`
+	Created time.Time `json:"created"`
+}
+
+//Our selection of sample Follow methods to satisfy the Datastore interface.
+//Follower and following counts are kept on the users table alongside the follows themselves so profiles read counts without counting rows.
+
+//UserProfile returns one specific user's profile, as seen by the viewer, or an error.
+//The viewer may be uuid.Nil for anonymous viewers.
+func (db *DB) UserProfile(id, viewer uuid.UUID) (*Profile, error) {
+
+	profile := &Profile{}
+
+	row := db.QueryRow("SELECT id, name, avatar, created, version, followers, following, EXISTS(SELECT 1 FROM follows WHERE follower = $2 AND followee = users.id) FROM users WHERE id = $1", id, viewer)
+
+	err := row.Scan(&profile.ID, &profile.Name, &profile.Avatar, &profile.Created, &profile.Version, &profile.Followers, &profile.Following, &profile.Followed)
+	if err != nil {
+		return profile, err
+	}
+
+	return profile, nil
+}
+
+//Follow records that follower follows followee from the time created and returns an error.
+//Following a user already followed changes nothing.
+func (db *DB) Follow(follower, followee uuid.UUID, created time.Time) error {
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("INSERT INTO follows (follower, followee, created) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING;", follower, followee, created)
+	if err != nil {
+		return err
+	}
+
+	//only count the follow if it is new
+	added, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if added == 1 {
+		_, err = tx.Exec("UPDATE users SET following = following + 1 WHERE id=$1;", follower)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec("UPDATE users SET followers = followers + 1 WHERE id=$1;", followee)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+//Unfollow removes the follow of followee by follower and returns an error.
+//Unfollowing a user not followed changes nothing.
+func (db *DB) Unfollow(follower, followee uuid.UUID) error {
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("DELETE FROM follows WHERE follower=$1 AND followee=$2;", follower, followee)
+	if err != nil {
+		return err
+	}
+
+	removed, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if removed == 1 {
+		_, err = tx.Exec("UPDATE users SET following = following - 1 WHERE id=$1;", follower)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec("UPDATE users SET followers = followers - 1 WHERE id=$1;", followee)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+//Followers takes a user id, cursor, and limit and returns the users following that user, most recent follow first, or an error.
+//The cursor marks the created time of a follow and the id of the follower.
+func (db *DB) Followers(id uuid.UUID, before Cursor, limit int) ([]*Follow, error) {
+	return db.follows("SELECT users.id, users.name, users.avatar, follows.created FROM follows INNER JOIN users ON follows.follower = users.id WHERE follows.followee = $1 AND (follows.created, follows.follower) < ($2, $3) ORDER BY follows.created DESC, follows.follower DESC LIMIT $4;", id, before, limit)
+}
+
+//Following takes a user id, cursor, and limit and returns the users that user follows, most recent follow first, or an error.
+//The cursor marks the created time of a follow and the id of the followed user.
+func (db *DB) Following(id uuid.UUID, before Cursor, limit int) ([]*Follow, error) {
+	return db.follows("SELECT users.id, users.name, users.avatar, follows.created FROM follows INNER JOIN users ON follows.followee = users.id WHERE follows.follower = $1 AND (follows.created, follows.followee) < ($2, $3) ORDER BY follows.created DESC, follows.followee DESC LIMIT $4;", id, before, limit)
+}
+
+//follows runs one of the follow list queries above.
+func (db *DB) follows(query string, id uuid.UUID, before Cursor, limit int) ([]*Follow, error) {
+	follows := []*Follow{}
+
+	rows, err := db.Query(query, id, before.Created, before.ID, limit)
+	if err != nil {
+		return follows, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		follow := &Follow{}
+		err := rows.Scan(&follow.User.ID, &follow.User.Name, &follow.User.Avatar, &follow.Created)
+		if err != nil {
+			return follows, err
+		}
+		follows = append(follows, follow)
+	}
+	if err := rows.Err(); err != nil {
+		return follows, err
+	}
+
+	return follows, nil
+}
+
+//Timeline takes a user id, cursor, and limit and returns the posts of the user and of the users they follow in reverse chronological order or an error.
+//The timeline is built on read: the follows primary key (follower, followee) resolves the authors, and for each author the posts (uid, created, id) index
+//reads at most one page of their posts before the cursor, so following thousands of users stays bounded without a per-user inbox.
+//Posts of shadow-banned users the user follows are left out.
+func (db *DB) Timeline(id uuid.UUID, before Cursor, limit int) ([]*Post, error) {
+	posts := []*Post{}
+
+	rows, err := db.Query("SELECT posts.ID, posts.title, posts.body, posts.created, posts.updated, posts.version, users.id, users.name, users.avatar FROM (SELECT $1::uuid AS uid UNION ALL SELECT followee FROM follows WHERE follower = $1) AS authors CROSS JOIN LATERAL (SELECT * FROM posts WHERE posts.uid = authors.uid AND NOT posts.hidden AND (posts.created, posts.id) < ($2, $3) ORDER BY posts.created DESC, posts.id DESC LIMIT $4) AS posts INNER JOIN users ON posts.uid = users.id WHERE NOT users.shadowbanned OR users.id = $1 ORDER BY posts.created DESC, posts.id DESC LIMIT $4;", id, before.Created, before.ID, limit)
+	if err != nil {
+		return posts, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		post := &Post{}
+		err := rows.Scan(&post.ID, &post.Title, &post.Body, &post.Created, &post.Updated, &post.Version, &post.Author.ID, &post.Author.Name, &post.Author.Avatar)
+		if err != nil {
+			return posts, err
+		}
+		posts = append(posts, post)
+	}
+	if err := rows.Err(); err != nil {
+		return posts, err
+	}
+
+	err = db.attachReactions(posts, id)
+	if err != nil {
+		return posts, err
+	}
+
+	err = db.attachTags(posts)
+	if err != nil {
+		return posts, err
+	}
+
+	return posts, nil
+}
+
+//deleteUserFollows removes a user's follows in both directions, keeping the counts of the users on the other side in step.
+func deleteUserFollows(tx *sql.Tx, id uuid.UUID) error {
+
+	_, err := tx.Exec("UPDATE users SET followers = followers - 1 WHERE id IN (SELECT followee FROM follows WHERE follower=$1);", id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE users SET following = following - 1 WHERE id IN (SELECT follower FROM follows WHERE followee=$1);", id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM follows WHERE follower=$1 OR followee=$1;", id)
+	return err
+}
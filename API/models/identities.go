@@ -0,0 +1,108 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+//Identity type defined
+//Identity is an account at an external OpenID Connect provider linked to a user, who may log in with it in place of their password:
+//the provider's name, the subject the provider identifies the account by, and the email the provider gave when it was linked.
+type Identity struct {
+	Provider string    `json:"provider"`
+	Subject  string    `json:"-"`
+	User     uuid.UUID `json:"-"`
+	Email    string    `json:"email"`
+	Created  time.Time `json:"created"`
+}
+
+//ErrIdentityLinked is returned by LinkIdentity for an external account already linked to a user, or a provider the user already linked an account at.
+var ErrIdentityLinked = errors.New("models: identity already linked")
+
+//Our selection of sample Identity methods to satisfy the Datastore interface:
+
+//Identity returns the identity with the subject at the provider, or an error.
+//It returns sql.ErrNoRows if no user linked it.
+func (db *DB) Identity(provider, subject string) (*Identity, error) {
+
+	i := &Identity{}
+
+	row := db.QueryRow("SELECT provider, subject, uid, email, created FROM identities WHERE provider = $1 AND subject = $2;", provider, subject)
+	err := row.Scan(&i.Provider, &i.Subject, &i.User, &i.Email, &i.Created)
+	if err != nil {
+		return i, err
+	}
+
+	return i, nil
+}
+
+//UserIdentities returns the identities a user linked, oldest first, or an error.
+func (db *DB) UserIdentities(user uuid.UUID) ([]*Identity, error) {
+
+	rows, err := db.Query("SELECT provider, subject, uid, email, created FROM identities WHERE uid = $1 ORDER BY created, provider;", user)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := []*Identity{}
+	for rows.Next() {
+		i := &Identity{}
+		err := rows.Scan(&i.Provider, &i.Subject, &i.User, &i.Email, &i.Created)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, i)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return identities, nil
+}
+
+//LinkIdentity links an external account to a user, and returns nil or an error.
+//LinkIdentity expects i will come in with provider string, subject string, user uuid.UUID, email string, created time.Time
+//It returns ErrIdentityLinked without changing anything if the account is linked already, or the user has an account at the provider linked.
+func (db *DB) LinkIdentity(i *Identity) error {
+
+	res, err := db.Exec("INSERT INTO identities (provider, subject, uid, email, created) VALUES ($1, $2, $3, $4, $5) ON CONFLICT DO NOTHING;", i.Provider, i.Subject, i.User, i.Email, i.Created)
+	if err != nil {
+		return err
+	}
+
+	saved, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if saved == 0 {
+		return ErrIdentityLinked
+	}
+
+	return nil
+}
+
+//UnlinkIdentity removes the account at the provider a user linked, so it no longer logs in, and returns nil or an error.
+//It returns sql.ErrNoRows if the user has no account at the provider linked.
+func (db *DB) UnlinkIdentity(user uuid.UUID, provider string) error {
+
+	res, err := db.Exec("DELETE FROM identities WHERE uid = $1 AND provider = $2;", user, provider)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if deleted == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+//The events recorded by a LockoutEvent
+const (
+	LockoutLocked   = "lock"
+	LockoutUnlocked = "unlock"
+)
+
+//LockoutEvent type defined
+//LockoutEvent is one audited login lockout: an account locked after repeated failed logins, from which IP, and until when,
+//or an account unlocked early through the emailed unlock link.
+type LockoutEvent struct {
+	ID       uuid.UUID  `json:"id"`
+	User     uuid.UUID  `json:"user"`
+	Event    string     `json:"event"`
+	IP       string     `json:"ip"`
+	Failures int        `json:"failures,omitempty"`
+	Until    *time.Time `json:"until,omitempty"`
+	Created  time.Time  `json:"created"`
+}
+
+//Our selection of sample Lockout methods to satisfy the Datastore interface:
+
+//RecordLockout records a lockout event and returns nil or an error.
+//RecordLockout expects event will come in with id uuid.UUID, user uuid.UUID, event string, ip string, created time.Time, and failures int and until time.Time to lock
+func (db *DB) RecordLockout(event *LockoutEvent) error {
+
+	_, err := db.Exec("INSERT INTO lockout_events (id, user_id, event, ip, failures, until, created) VALUES ($1, $2, $3, $4, $5, $6, $7);", event.ID, event.User, event.Event, event.IP, event.Failures, event.Until, event.Created)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,158 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+//TOTP type defined
+//TOTP is a user's authenticator app enrollment for two-factor authentication: the shared secret, whether the user has confirmed it
+//with a first code, and the last time step a code was accepted for, so each code logs in once.
+//Unconfirmed enrollments do not protect the account and are replaced by the next enrollment.
+type TOTP struct {
+	User      uuid.UUID `json:"-"`
+	Secret    string    `json:"-"`
+	Confirmed bool      `json:"confirmed"`
+	LastStep  int64     `json:"-"`
+	Created   time.Time `json:"created"`
+}
+
+//ErrTOTPConfirmed is returned by EnrollTOTP for a user who has already confirmed an enrollment, which must be deleted first.
+var ErrTOTPConfirmed = errors.New("models: two-factor authentication already enabled")
+
+//Our selection of sample MFA methods to satisfy the Datastore interface:
+
+//TOTP returns a user's TOTP enrollment, confirmed or not, or an error.
+//It returns sql.ErrNoRows if the user has none.
+func (db *DB) TOTP(user uuid.UUID) (*TOTP, error) {
+
+	t := &TOTP{}
+
+	row := db.QueryRow("SELECT uid, secret, confirmed, last_step, created FROM totp WHERE uid = $1;", user)
+	err := row.Scan(&t.User, &t.Secret, &t.Confirmed, &t.LastStep, &t.Created)
+	if err != nil {
+		return t, err
+	}
+
+	return t, nil
+}
+
+//EnrollTOTP saves a new unconfirmed TOTP enrollment for a user, replacing one not yet confirmed, and returns nil or an error.
+//EnrollTOTP expects t will come in with user uuid.UUID, secret string, created time.Time
+//It returns ErrTOTPConfirmed without changing anything if the user has already confirmed an enrollment.
+func (db *DB) EnrollTOTP(t *TOTP) error {
+
+	res, err := db.Exec("INSERT INTO totp (uid, secret, confirmed, last_step, created) VALUES ($1, $2, false, 0, $3) ON CONFLICT (uid) DO UPDATE SET secret = $2, created = $3 WHERE totp.confirmed = false;", t.User, t.Secret, t.Created)
+	if err != nil {
+		return err
+	}
+
+	saved, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if saved == 0 {
+		return ErrTOTPConfirmed
+	}
+
+	return nil
+}
+
+//ConfirmTOTP confirms a user's TOTP enrollment with the step of its first code and replaces their recovery codes, and returns nil or an error.
+//ConfirmTOTP expects user uuid.UUID, step int64, and hashes []string, the SHA-256 hex hashes of the new recovery codes
+//It returns sql.ErrNoRows if the user has no unconfirmed enrollment.
+func (db *DB) ConfirmTOTP(user uuid.UUID, step int64, hashes []string) error {
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("UPDATE totp SET confirmed = true, last_step = $2 WHERE uid = $1 AND confirmed = false;", user, step)
+	if err != nil {
+		return err
+	}
+
+	confirmed, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if confirmed == 0 {
+		return sql.ErrNoRows
+	}
+
+	_, err = tx.Exec("DELETE FROM recovery_codes WHERE uid = $1;", user)
+	if err != nil {
+		return err
+	}
+
+	for _, hash := range hashes {
+		_, err = tx.Exec("INSERT INTO recovery_codes (uid, hash) VALUES ($1, $2);", user, hash)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+//UseTOTPStep records a code accepted for a user's confirmed enrollment at the time step, and reports whether it was after the last one accepted.
+//A code for the same or an earlier step has been used or superseded and must be refused.
+func (db *DB) UseTOTPStep(user uuid.UUID, step int64) (bool, error) {
+
+	res, err := db.Exec("UPDATE totp SET last_step = $2 WHERE uid = $1 AND confirmed = true AND last_step < $2;", user, step)
+	if err != nil {
+		return false, err
+	}
+
+	used, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return used == 1, nil
+}
+
+//UseRecoveryCode marks a user's unused recovery code with the hash used, and reports whether there was one.
+func (db *DB) UseRecoveryCode(user uuid.UUID, hash string) (bool, error) {
+
+	res, err := db.Exec("UPDATE recovery_codes SET used = now() WHERE uid = $1 AND hash = $2 AND used IS NULL;", user, hash)
+	if err != nil {
+		return false, err
+	}
+
+	used, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return used == 1, nil
+}
+
+//DeleteTOTP removes a user's TOTP enrollment and recovery codes, turning two-factor authentication off, and returns nil or an error.
+func (db *DB) DeleteTOTP(user uuid.UUID) error {
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM recovery_codes WHERE uid = $1;", user)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM totp WHERE uid = $1;", user)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,406 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+	uuid "github.com/satori/go.uuid"
+)
+
+//The kinds of content a report or moderator action can be about.
+const (
+	KindPost    = "post"
+	KindComment = "comment"
+	KindUser    = "user"
+)
+
+//ReportReasons are the reason codes a report can give.
+var ReportReasons = []string{"spam", "harassment", "hate", "violence", "sexual", "self-harm", "impersonation", "other"}
+
+//The states of a report. Open reports wait in the moderation queue until a moderator acts on their content.
+const (
+	ReportOpen      = "open"
+	ReportResolved  = "resolved"
+	ReportDismissed = "dismissed"
+)
+
+//The actions a moderator can take on reported content.
+//Warn and suspend are aimed at the author of the content; dismiss closes the reports without acting.
+const (
+	ActionHide    = "hide"
+	ActionDelete  = "delete"
+	ActionWarn    = "warn"
+	ActionSuspend = "suspend"
+	ActionDismiss = "dismiss"
+)
+
+//kindActions lists the actions that apply to each kind of content.
+var kindActions = map[string][]string{
+	KindPost:    {ActionHide, ActionDelete, ActionWarn, ActionSuspend, ActionDismiss},
+	KindComment: {ActionDelete, ActionWarn, ActionSuspend, ActionDismiss},
+	KindUser:    {ActionWarn, ActionSuspend, ActionDismiss},
+}
+
+//The states of an appeal. A moderator upholds the action appealed or overturns it.
+const (
+	AppealOpen       = "open"
+	AppealUpheld     = "upheld"
+	AppealOverturned = "overturned"
+)
+
+//errors returned by the moderation methods when a change no longer applies
+var (
+	ErrAppealExists  = errors.New("models: action already appealed")
+	ErrAppealDecided = errors.New("models: appeal already decided")
+)
+
+//Report type defined
+//Report is one user's flag on a post, comment, or user. Subject is the user responsible: the author of the content, or the reported user.
+type Report struct {
+	ID       uuid.UUID `json:"id"`
+	Reporter uuid.UUID `json:"reporter"`
+	Kind     string    `json:"kind"`
+	Target   uuid.UUID `json:"target"`
+	Subject  uuid.UUID `json:"subject"`
+	Reason   string    `json:"reason"`
+	Details  string    `json:"details,omitempty"`
+	Status   string    `json:"status"`
+	Created  time.Time `json:"created"`
+
+	//Action is the moderator action that closed the report, once there is one.
+	Action uuid.UUID `json:"action"`
+}
+
+//ReportFilter narrows the moderation queue. Empty fields match every report.
+type ReportFilter struct {
+	Status string
+	Kind   string
+	Reason string
+}
+
+//ModAction type defined
+//ModAction is the record of one moderator action on a post, comment, or user, and of whom it was aimed at.
+type ModAction struct {
+	ID        uuid.UUID `json:"id"`
+	Moderator uuid.UUID `json:"moderator"`
+	Kind      string    `json:"kind"`
+	Target    uuid.UUID `json:"target"`
+	Subject   uuid.UUID `json:"subject"`
+	Action    string    `json:"action"`
+	Note      string    `json:"note,omitempty"`
+	Created   time.Time `json:"created"`
+
+	//Until is the end of a suspension.
+	Until *time.Time `json:"until,omitempty"`
+}
+
+//Appeal type defined
+//Appeal is a subject's request to reverse a moderator action, and the moderator's decision on it.
+type Appeal struct {
+	ID      uuid.UUID `json:"id"`
+	Action  uuid.UUID `json:"action"`
+	Subject uuid.UUID `json:"subject"`
+	Body    string    `json:"body"`
+	Status  string    `json:"status"`
+	Created time.Time `json:"created"`
+
+	//DecidedBy, Decided, and Note are set once a moderator decides the appeal.
+	DecidedBy uuid.UUID  `json:"decided_by"`
+	Decided   *time.Time `json:"decided,omitempty"`
+	Note      string     `json:"note,omitempty"`
+}
+
+//ValidKind reports whether kind is a kind of content that can be reported.
+func ValidKind(kind string) bool {
+	_, ok := kindActions[kind]
+	return ok
+}
+
+//ValidReason reports whether reason is one of the ReportReasons.
+func ValidReason(reason string) bool {
+	for _, r := range ReportReasons {
+		if r == reason {
+			return true
+		}
+	}
+	return false
+}
+
+//ValidAction reports whether a moderator can take action on the kind of content.
+func ValidAction(kind, action string) bool {
+	for _, a := range kindActions[kind] {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+//Our selection of sample Moderation methods to satisfy the Datastore interface:
+
+//CreateReport files a report and returns nil or an error.
+//CreateReport expects report will come in with id uuid.UUID, reporter uuid.UUID, kind string, target uuid.UUID, subject uuid.UUID, reason string, details string, created time.Time
+//A user's second report on content they already have an open report on changes nothing.
+func (db *DB) CreateReport(report *Report) error {
+
+	_, err := db.Exec("INSERT INTO reports (id, reporter, kind, target, subject, reason, details, status, created) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) ON CONFLICT (reporter, kind, target) WHERE status = 'open' DO NOTHING;", report.ID, report.Reporter, report.Kind, report.Target, report.Subject, report.Reason, report.Details, ReportOpen, report.Created)
+	if err != nil {
+		return err
+	}
+
+	report.Status = ReportOpen
+	return nil
+}
+
+//Reports takes a filter, cursor, and limit and returns one page of the matching reports, newest first, or an error.
+func (db *DB) Reports(filter ReportFilter, before Cursor, limit int) ([]*Report, error) {
+	reports := []*Report{}
+
+	rows, err := db.Query("SELECT id, reporter, kind, target, subject, reason, details, status, created, action_id FROM reports WHERE ($1 = '' OR status = $1) AND ($2 = '' OR kind = $2) AND ($3 = '' OR reason = $3) AND (created, id) < ($4, $5) ORDER BY created DESC, id DESC LIMIT $6;", filter.Status, filter.Kind, filter.Reason, before.Created, before.ID, limit)
+	if err != nil {
+		return reports, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		report, err := scanReport(rows)
+		if err != nil {
+			return reports, err
+		}
+		reports = append(reports, report)
+	}
+	if err := rows.Err(); err != nil {
+		return reports, err
+	}
+
+	return reports, nil
+}
+
+//OneReport returns one specific report or an error.
+func (db *DB) OneReport(id uuid.UUID) (*Report, error) {
+	row := db.QueryRow("SELECT id, reporter, kind, target, subject, reason, details, status, created, action_id FROM reports WHERE id = $1", id)
+	return scanReport(row)
+}
+
+//scanReport reads one report selected in the column order used by the Report methods above.
+func scanReport(row scanner) (*Report, error) {
+
+	report := &Report{}
+	action := uuid.NullUUID{}
+
+	err := row.Scan(&report.ID, &report.Reporter, &report.Kind, &report.Target, &report.Subject, &report.Reason, &report.Details, &report.Status, &report.Created, &action)
+	if err != nil {
+		return report, err
+	}
+	report.Action = action.UUID
+
+	return report, nil
+}
+
+//Moderate applies a moderator action to its content, records it, and closes every open report on that content, all in one transaction.
+//Moderate expects action will come in with id uuid.UUID, moderator uuid.UUID, kind string, target uuid.UUID, subject uuid.UUID, action string, note string, created time.Time, and until time.Time to suspend
+func (db *DB) Moderate(action *ModAction) error {
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	switch {
+	case action.Action == ActionHide:
+		_, err = tx.Exec("UPDATE posts SET hidden = true WHERE id=$1;", action.Target)
+	case action.Action == ActionDelete && action.Kind == KindPost:
+		err = deletePost(tx, action.Target)
+	case action.Action == ActionDelete && action.Kind == KindComment:
+		_, err = tx.Exec("DELETE FROM comments WHERE id=$1;", action.Target)
+	case action.Action == ActionSuspend:
+		//a longer suspension already in place is kept
+		_, err = tx.Exec("UPDATE users SET suspended_until = GREATEST(suspended_until, $2) WHERE id=$1;", action.Subject, action.Until)
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("INSERT INTO moderation_actions (id, moderator, kind, target, subject, action, note, until, created) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9);", action.ID, action.Moderator, action.Kind, action.Target, action.Subject, action.Action, action.Note, action.Until, action.Created)
+	if err != nil {
+		return err
+	}
+
+	status := ReportResolved
+	if action.Action == ActionDismiss {
+		status = ReportDismissed
+	}
+
+	_, err = tx.Exec("UPDATE reports SET status=$3, action_id=$4 WHERE kind=$1 AND target=$2 AND status='open';", action.Kind, action.Target, status, action.ID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+//ModActions takes a user id, cursor, and limit and returns one page of the moderator actions aimed at that user, newest first, or an error.
+//Shadow-bans are left out: they are never shown to the user.
+func (db *DB) ModActions(subject uuid.UUID, before Cursor, limit int) ([]*ModAction, error) {
+	actions := []*ModAction{}
+
+	rows, err := db.Query("SELECT id, moderator, kind, target, subject, action, note, until, created FROM moderation_actions WHERE subject = $1 AND NOT action = ANY($5) AND (created, id) < ($2, $3) ORDER BY created DESC, id DESC LIMIT $4;", subject, before.Created, before.ID, limit, pq.Array([]string{ActionShadowban, ActionUnshadowban}))
+	if err != nil {
+		return actions, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		action, err := scanModAction(rows)
+		if err != nil {
+			return actions, err
+		}
+		actions = append(actions, action)
+	}
+	if err := rows.Err(); err != nil {
+		return actions, err
+	}
+
+	return actions, nil
+}
+
+//OneModAction returns one specific moderator action or an error.
+func (db *DB) OneModAction(id uuid.UUID) (*ModAction, error) {
+	row := db.QueryRow("SELECT id, moderator, kind, target, subject, action, note, until, created FROM moderation_actions WHERE id = $1", id)
+	return scanModAction(row)
+}
+
+//scanModAction reads one moderator action selected in the column order used by the ModAction methods above.
+func scanModAction(row scanner) (*ModAction, error) {
+
+	action := &ModAction{}
+	until := pq.NullTime{}
+
+	err := row.Scan(&action.ID, &action.Moderator, &action.Kind, &action.Target, &action.Subject, &action.Action, &action.Note, &until, &action.Created)
+	if err != nil {
+		return action, err
+	}
+	action.Until = timePtr(until)
+
+	return action, nil
+}
+
+//CreateAppeal files an appeal against a moderator action and returns nil or an error.
+//CreateAppeal expects appeal will come in with id uuid.UUID, action uuid.UUID, subject uuid.UUID, body string, created time.Time
+//Each action can be appealed once: a second appeal returns ErrAppealExists.
+func (db *DB) CreateAppeal(appeal *Appeal) error {
+
+	res, err := db.Exec("INSERT INTO appeals (id, action_id, subject, body, status, created) VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT (action_id) DO NOTHING;", appeal.ID, appeal.Action, appeal.Subject, appeal.Body, AppealOpen, appeal.Created)
+	if err != nil {
+		return err
+	}
+
+	created, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if created == 0 {
+		return ErrAppealExists
+	}
+
+	appeal.Status = AppealOpen
+	return nil
+}
+
+//Appeals takes a status, cursor, and limit and returns one page of the appeals in that status, or every appeal for an empty status, newest first, or an error.
+func (db *DB) Appeals(status string, before Cursor, limit int) ([]*Appeal, error) {
+	appeals := []*Appeal{}
+
+	rows, err := db.Query("SELECT id, action_id, subject, body, status, created, decided_by, decided, note FROM appeals WHERE ($1 = '' OR status = $1) AND (created, id) < ($2, $3) ORDER BY created DESC, id DESC LIMIT $4;", status, before.Created, before.ID, limit)
+	if err != nil {
+		return appeals, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		appeal, err := scanAppeal(rows)
+		if err != nil {
+			return appeals, err
+		}
+		appeals = append(appeals, appeal)
+	}
+	if err := rows.Err(); err != nil {
+		return appeals, err
+	}
+
+	return appeals, nil
+}
+
+//OneAppeal returns one specific appeal or an error.
+func (db *DB) OneAppeal(id uuid.UUID) (*Appeal, error) {
+	row := db.QueryRow("SELECT id, action_id, subject, body, status, created, decided_by, decided, note FROM appeals WHERE id = $1", id)
+	return scanAppeal(row)
+}
+
+//scanAppeal reads one appeal selected in the column order used by the Appeal methods above.
+func scanAppeal(row scanner) (*Appeal, error) {
+
+	appeal := &Appeal{}
+	decidedBy := uuid.NullUUID{}
+	decided := pq.NullTime{}
+
+	err := row.Scan(&appeal.ID, &appeal.Action, &appeal.Subject, &appeal.Body, &appeal.Status, &appeal.Created, &decidedBy, &decided, &appeal.Note)
+	if err != nil {
+		return appeal, err
+	}
+	appeal.DecidedBy = decidedBy.UUID
+	appeal.Decided = timePtr(decided)
+
+	return appeal, nil
+}
+
+//DecideAppeal records a moderator's decision on an open appeal and returns nil or an error.
+//DecideAppeal expects appeal will come in with id uuid.UUID, status string, decidedby uuid.UUID, decided time.Time, note string
+//Overturning an appeal reverses a hide or a suspension in the same transaction; deleted content cannot be brought back.
+//It returns ErrAppealDecided if the appeal was already decided.
+func (db *DB) DecideAppeal(appeal *Appeal) error {
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	action := &ModAction{}
+	until := pq.NullTime{}
+
+	err = tx.QueryRow("UPDATE appeals SET status=$2, decided_by=$3, decided=$4, note=$5 FROM moderation_actions WHERE appeals.id=$1 AND appeals.status='open' AND moderation_actions.id = appeals.action_id RETURNING moderation_actions.kind, moderation_actions.target, moderation_actions.subject, moderation_actions.action, moderation_actions.until;", appeal.ID, appeal.Status, appeal.DecidedBy, appeal.Decided, appeal.Note).Scan(&action.Kind, &action.Target, &action.Subject, &action.Action, &until)
+	if err == sql.ErrNoRows {
+		return ErrAppealDecided
+	}
+	if err != nil {
+		return err
+	}
+
+	if appeal.Status == AppealOverturned {
+		switch action.Action {
+		case ActionHide:
+			_, err = tx.Exec("UPDATE posts SET hidden = false WHERE id=$1;", action.Target)
+		case ActionSuspend:
+			//lift the suspension unless a later action extended it
+			_, err = tx.Exec("UPDATE users SET suspended_until = NULL WHERE id=$1 AND suspended_until <= $2;", action.Subject, until.Time)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+//timePtr returns a nullable timestamp's time, or nil when it was NULL.
+func timePtr(nt pq.NullTime) *time.Time {
+	if !nt.Valid {
+		return nil
+	}
+	return &nt.Time
+}
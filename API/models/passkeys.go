@@ -0,0 +1,136 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+//Passkey type defined
+//Passkey is a WebAuthn credential a user has registered to log in without a password: its base64url credential ID, the name the user gave it,
+//its public key in the COSE_Key encoding, and the authenticator's signature counter as of its last use.
+//A passkey whose counter went backwards may have been cloned, and is flagged so it no longer logs in.
+type Passkey struct {
+	ID        string     `json:"id"`
+	User      uuid.UUID  `json:"-"`
+	Name      string     `json:"name"`
+	PublicKey []byte     `json:"-"`
+	SignCount int64      `json:"-"`
+	Cloned    bool       `json:"cloned"`
+	Created   time.Time  `json:"created"`
+	LastUsed  *time.Time `json:"last_used"`
+}
+
+//ErrPasskeyExists is returned by CreatePasskey for a credential ID already registered, by this user or another.
+var ErrPasskeyExists = errors.New("models: passkey already registered")
+
+//Our selection of sample Passkey methods to satisfy the Datastore interface:
+
+//Passkey returns the passkey with the credential ID, or an error.
+//It returns sql.ErrNoRows if there is none.
+func (db *DB) Passkey(id string) (*Passkey, error) {
+
+	p := &Passkey{}
+
+	row := db.QueryRow("SELECT id, uid, name, public_key, sign_count, cloned, created, last_used FROM passkeys WHERE id = $1;", id)
+	err := row.Scan(&p.ID, &p.User, &p.Name, &p.PublicKey, &p.SignCount, &p.Cloned, &p.Created, &p.LastUsed)
+	if err != nil {
+		return p, err
+	}
+
+	return p, nil
+}
+
+//UserPasskeys returns a user's passkeys, oldest first, or an error.
+func (db *DB) UserPasskeys(user uuid.UUID) ([]*Passkey, error) {
+
+	rows, err := db.Query("SELECT id, uid, name, public_key, sign_count, cloned, created, last_used FROM passkeys WHERE uid = $1 ORDER BY created, id;", user)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	passkeys := []*Passkey{}
+	for rows.Next() {
+		p := &Passkey{}
+		err := rows.Scan(&p.ID, &p.User, &p.Name, &p.PublicKey, &p.SignCount, &p.Cloned, &p.Created, &p.LastUsed)
+		if err != nil {
+			return nil, err
+		}
+		passkeys = append(passkeys, p)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return passkeys, nil
+}
+
+//CreatePasskey saves a newly registered passkey, and returns nil or an error.
+//CreatePasskey expects p will come in with id string, user uuid.UUID, name string, publicKey []byte, signCount int64, created time.Time
+//It returns ErrPasskeyExists without changing anything if the credential ID is already registered.
+func (db *DB) CreatePasskey(p *Passkey) error {
+
+	res, err := db.Exec("INSERT INTO passkeys (id, uid, name, public_key, sign_count, created) VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT (id) DO NOTHING;", p.ID, p.User, p.Name, p.PublicKey, p.SignCount, p.Created)
+	if err != nil {
+		return err
+	}
+
+	saved, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if saved == 0 {
+		return ErrPasskeyExists
+	}
+
+	return nil
+}
+
+//UsePasskey records a login with the passkey at the signature counter the authenticator reported, and reports whether the counter was accepted:
+//higher than the stored one, or zero for authenticators that do not count. Of two logins racing with the same counter only one is accepted.
+func (db *DB) UsePasskey(id string, signCount int64, used time.Time) (bool, error) {
+
+	res, err := db.Exec("UPDATE passkeys SET sign_count = $2, last_used = $3 WHERE id = $1 AND cloned = false AND (sign_count < $2 OR (sign_count = 0 AND $2 = 0));", id, signCount, used)
+	if err != nil {
+		return false, err
+	}
+
+	accepted, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return accepted == 1, nil
+}
+
+//FlagClonedPasskey marks the passkey as possibly cloned, so it no longer logs in, and returns nil or an error.
+func (db *DB) FlagClonedPasskey(id string) error {
+
+	_, err := db.Exec("UPDATE passkeys SET cloned = true WHERE id = $1;", id)
+	return err
+}
+
+//DeletePasskey removes one of a user's passkeys, and returns nil or an error.
+//It returns sql.ErrNoRows if the user has no passkey with the credential ID.
+func (db *DB) DeletePasskey(user uuid.UUID, id string) error {
+
+	res, err := db.Exec("DELETE FROM passkeys WHERE uid = $1 AND id = $2;", user, id)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if deleted == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
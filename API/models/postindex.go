@@ -0,0 +1,314 @@
+package models
+
+import (
+	"bytes"
+	"html"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+//PostIndex is an in-process full-text index of posts for Datastores without Postgres text search.
+//It matches and ranks like SearchPosts, without English stemming: a Datastore calls Index from CreatePost and UpdatePost,
+//Remove from DeletePost, and answers SearchPosts with Search.
+type PostIndex struct {
+	mu sync.RWMutex
+
+	//posts by id with the tokens of their title followed by the tokens of their body
+	posts map[uuid.UUID]*indexedPost
+
+	//postings maps each token to the ids of the posts that contain it
+	postings map[string]map[uuid.UUID]bool
+}
+
+type indexedPost struct {
+	post      Post
+	tokens    []string
+	titleSize int
+}
+
+//weights of a match in the title and in the body, matching the Postgres defaults for the A and B weights the search column uses
+const (
+	titleWeight = 1.0
+	bodyWeight  = 0.4
+)
+
+//NewPostIndex creates an empty PostIndex
+func NewPostIndex() *PostIndex {
+	return &PostIndex{posts: map[uuid.UUID]*indexedPost{}, postings: map[string]map[uuid.UUID]bool{}}
+}
+
+//Index adds a post to the index, replacing any earlier version of it.
+func (idx *PostIndex) Index(post *Post) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.remove(post.ID)
+
+	title := tokenize(post.Title)
+	entry := &indexedPost{post: *post, tokens: append(title, tokenize(post.Body)...), titleSize: len(title)}
+	idx.posts[post.ID] = entry
+
+	for _, token := range entry.tokens {
+		if idx.postings[token] == nil {
+			idx.postings[token] = map[uuid.UUID]bool{}
+		}
+		idx.postings[token][post.ID] = true
+	}
+}
+
+//Remove takes a post out of the index.
+func (idx *PostIndex) Remove(id uuid.UUID) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.remove(id)
+}
+
+func (idx *PostIndex) remove(id uuid.UUID) {
+	entry, ok := idx.posts[id]
+	if !ok {
+		return
+	}
+
+	for _, token := range entry.tokens {
+		delete(idx.postings[token], id)
+		if len(idx.postings[token]) == 0 {
+			delete(idx.postings, token)
+		}
+	}
+	delete(idx.posts, id)
+}
+
+//Search returns the posts matching the query ranked by relevance and recency, following the cursor rules of SearchPosts.
+func (idx *PostIndex) Search(query SearchQuery, before Cursor, limit int) []*PostResult {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	results := []*PostResult{}
+
+	for id := range idx.candidates(query) {
+		entry := idx.posts[id]
+		if entry.post.Created.After(before.Created) {
+			continue
+		}
+
+		relevance, ok := entry.match(query)
+		if !ok {
+			continue
+		}
+
+		//normalize like ts_rank_cd's rank / (rank + 1) before boosting by recency
+		score := relevance / (relevance + 1) * recencyBoost(entry.post.Created, before.Created)
+
+		if !uuid.Equal(before.ID, uuid.Nil) && (score > before.Score || (score == before.Score && bytes.Compare(id.Bytes(), before.ID.Bytes()) >= 0)) {
+			continue
+		}
+
+		result := &PostResult{Post: entry.post, Score: score}
+		result.Highlights.Title = highlight(entry.post.Title, query, 0)
+		result.Highlights.Body = highlight(entry.post.Body, query, snippetWords)
+		results = append(results, result)
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return bytes.Compare(results[i].ID.Bytes(), results[j].ID.Bytes()) > 0
+	})
+
+	if len(results) > limit {
+		results = results[:limit]
+	}
+
+	return results
+}
+
+//candidates narrows the search to the posts containing the query's rarest required word, or to the posts containing a prefix.
+func (idx *PostIndex) candidates(query SearchQuery) map[uuid.UUID]bool {
+
+	required := append([]string{}, query.Words...)
+	for _, phrase := range query.Phrases {
+		required = append(required, phrase...)
+	}
+
+	if len(required) > 0 {
+		rarest := idx.postings[required[0]]
+		for _, word := range required[1:] {
+			if len(idx.postings[word]) < len(rarest) {
+				rarest = idx.postings[word]
+			}
+		}
+		return rarest
+	}
+
+	candidates := map[uuid.UUID]bool{}
+	if len(query.Prefixes) == 0 {
+		return candidates
+	}
+
+	for token, ids := range idx.postings {
+		if strings.HasPrefix(token, query.Prefixes[0]) {
+			for id := range ids {
+				candidates[id] = true
+			}
+		}
+	}
+	return candidates
+}
+
+//match reports whether the post matches every part of the query and, if so, its weighted count of matching tokens.
+func (entry *indexedPost) match(query SearchQuery) (float64, bool) {
+
+	relevance := 0.0
+
+	//count each matching position by where it falls
+	count := func(positions []int) {
+		for _, position := range positions {
+			if position < entry.titleSize {
+				relevance += titleWeight
+			} else {
+				relevance += bodyWeight
+			}
+		}
+	}
+
+	for _, word := range query.Words {
+		positions := entry.positions(func(token string) bool { return token == word })
+		if len(positions) == 0 {
+			return 0, false
+		}
+		count(positions)
+	}
+
+	for _, prefix := range query.Prefixes {
+		positions := entry.positions(func(token string) bool { return strings.HasPrefix(token, prefix) })
+		if len(positions) == 0 {
+			return 0, false
+		}
+		count(positions)
+	}
+
+	for _, phrase := range query.Phrases {
+		positions := []int{}
+		for _, start := range entry.positions(func(token string) bool { return token == phrase[0] }) {
+			if start+len(phrase) <= len(entry.tokens) && equalTokens(entry.tokens[start:start+len(phrase)], phrase) {
+				positions = append(positions, start)
+			}
+		}
+		if len(positions) == 0 {
+			return 0, false
+		}
+		count(positions)
+	}
+
+	return relevance, true
+}
+
+//positions returns where the post's tokens satisfy the test.
+func (entry *indexedPost) positions(test func(string) bool) []int {
+	positions := []int{}
+	for i, token := range entry.tokens {
+		if test(token) {
+			positions = append(positions, i)
+		}
+	}
+	return positions
+}
+
+func equalTokens(a, b []string) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return len(a) == len(b)
+}
+
+//number of words in a body snippet
+const snippetWords = 20
+
+//rxWord matches the runs of letters and numbers that tokenize splits text into
+var rxWord = regexp.MustCompile(`[\p{L}\p{N}]+`)
+
+//highlight HTML-escapes text and wraps the words matching the query in <mark> tags.
+//With a positive size it returns only that many words, starting shortly before the first match.
+func highlight(text string, query SearchQuery, size int) string {
+
+	terms := map[string]bool{}
+	for _, word := range query.Words {
+		terms[word] = true
+	}
+	for _, phrase := range query.Phrases {
+		for _, word := range phrase {
+			terms[word] = true
+		}
+	}
+
+	matches := func(word string) bool {
+		word = strings.ToLower(word)
+		if terms[word] {
+			return true
+		}
+		for _, prefix := range query.Prefixes {
+			if strings.HasPrefix(word, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+
+	spans := rxWord.FindAllStringIndex(text, -1)
+
+	//choose the words to show
+	first, last := 0, len(spans)
+	if size > 0 && len(spans) > size {
+		for i, span := range spans {
+			if matches(text[span[0]:span[1]]) {
+				first = i - 2
+				break
+			}
+		}
+		if first < 0 {
+			first = 0
+		}
+		if first+size > len(spans) {
+			first = len(spans) - size
+		}
+		last = first + size
+	}
+
+	if len(spans) == 0 {
+		return html.EscapeString(text)
+	}
+
+	var b strings.Builder
+	start := spans[first][0]
+	if first == 0 {
+		start = 0
+	} else {
+		b.WriteString("… ")
+	}
+
+	for _, span := range spans[first:last] {
+		b.WriteString(html.EscapeString(text[start:span[0]]))
+		word := html.EscapeString(text[span[0]:span[1]])
+		if matches(text[span[0]:span[1]]) {
+			word = "<mark>" + word + "</mark>"
+		}
+		b.WriteString(word)
+		start = span[1]
+	}
+
+	if last == len(spans) {
+		b.WriteString(html.EscapeString(text[start:]))
+	} else {
+		b.WriteString(" …")
+	}
+
+	return b.String()
+}
@@ -0,0 +1,140 @@
+package models
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+//newID returns a random id for an indexed post or user
+func newID(t *testing.T) uuid.UUID {
+	id, err := uuid.NewV4()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestPostIndex(t *testing.T) {
+
+	now := time.Now().UTC()
+	idx := NewPostIndex()
+
+	//index adds a post with the title and body, created the given time ago, and returns its id
+	index := func(title, body string, age time.Duration) uuid.UUID {
+		id := newID(t)
+		idx.Index(&Post{ID: id, Title: title, Body: body, Created: now.Add(-age)})
+		return id
+	}
+
+	inTitle := index("Gophers", "A post", time.Hour)
+	inBody := index("A post", "About gophers", time.Hour)
+	twice := index("Gophers", "More gophers", time.Hour)
+	older := index("Gophers", "A post", 30*24*time.Hour)
+	future := index("Gophers", "A post", -time.Hour)
+	index("Unrelated", "Nothing here", time.Hour)
+
+	//search returns the ids found for the query, best first, from the first page
+	search := func(q string) []uuid.UUID {
+		ids := []uuid.UUID{}
+		for _, result := range idx.Search(ParseSearchQuery(q), Cursor{Created: now}, 10) {
+			ids = append(ids, result.ID)
+		}
+		return ids
+	}
+
+	//more matches outrank fewer, the title outranks the body, newer outranks older, and posts newer than the cursor are left out
+	got := search("gophers")
+	want := []uuid.UUID{twice, inTitle, older, inBody}
+	if len(got) != len(want) {
+		t.Fatalf("search returned wrong posts:\ngot: %v\nwant: %v", got, want)
+	}
+	for i := range want {
+		if !uuid.Equal(got[i], want[i]) {
+			t.Errorf("search ranked wrongly at %v:\ngot: %v\nwant: %v", i, got[i], want[i])
+		}
+	}
+	for _, id := range got {
+		if uuid.Equal(id, future) {
+			t.Errorf("search found a post newer than the cursor")
+		}
+	}
+
+	//every word, phrase, and prefix must match
+	for q, want := range map[string]int{
+		"gophers post":    3,
+		`"more gophers"`:  1,
+		`"gophers post"`:  0,
+		"goph*":           4,
+		"about goph*":     1,
+		"gopher":          0,
+		"gophers missing": 0,
+	} {
+		if got := search(q); len(got) != want {
+			t.Errorf("search for %q found wrong number of posts:\ngot: %v\nwant: %v", q, len(got), want)
+		}
+	}
+
+	//pages follow each other by (score, id) without repeating or skipping posts, ties broken by id
+	tied := []uuid.UUID{}
+	for i := 0; i < 5; i++ {
+		tied = append(tied, index("Paging", "Same post", time.Hour))
+	}
+	seen := map[uuid.UUID]bool{}
+	before := Cursor{Created: now}
+	for page := 0; page < 5; page++ {
+		results := idx.Search(ParseSearchQuery("paging"), before, 2)
+		if len(results) == 0 {
+			break
+		}
+		for _, result := range results {
+			if seen[result.ID] {
+				t.Errorf("post %v repeated on page %v", result.ID, page)
+			}
+			seen[result.ID] = true
+		}
+		last := results[len(results)-1]
+		before = Cursor{Created: now, Score: last.Score, ID: last.ID}
+	}
+	if len(seen) != len(tied) {
+		t.Errorf("pages found wrong number of posts:\ngot: %v\nwant: %v", len(seen), len(tied))
+	}
+
+	//reindexing replaces a post and removing takes it out
+	idx.Index(&Post{ID: inBody, Title: "Renamed", Body: "Nothing", Created: now.Add(-time.Hour)})
+	idx.Remove(twice)
+	if got := search("gophers"); len(got) != 2 {
+		t.Errorf("search after reindexing and removing found wrong posts: %v", got)
+	}
+	if got := search("renamed"); len(got) != 1 || !uuid.Equal(got[0], inBody) {
+		t.Errorf("search did not find a reindexed post: %v", got)
+	}
+}
+
+func TestHighlight(t *testing.T) {
+
+	query := ParseSearchQuery(`gopher "go fast" hel*`)
+
+	for _, tc := range []struct {
+		text string
+		size int
+		want string
+	}{
+		{"Hello <b>Gopher</b>!", 0, "<mark>Hello</mark> &lt;b&gt;<mark>Gopher</mark>&lt;/b&gt;!"},
+		{"Go fast, gophers", 0, "<mark>Go</mark> <mark>fast</mark>, gophers"},
+		{"one two three four five six gopher seven eight", 3, "… five six <mark>gopher</mark> …"},
+		{"gopher one two three", 2, "<mark>gopher</mark> one …"},
+		{"one two three gopher", 3, "… two three <mark>gopher</mark>"},
+		{"!!!", 5, "!!!"},
+	} {
+		if got := highlight(tc.text, query, tc.size); got != tc.want {
+			t.Errorf("highlight of %q returned wrongly:\ngot: %v\nwant: %v", tc.text, got, tc.want)
+		}
+	}
+
+	if got := highlight(strings.Repeat("word ", 30), query, snippetWords); strings.Count(got, "word") != snippetWords {
+		t.Errorf("highlight without a match returned wrong snippet: %q", got)
+	}
+}
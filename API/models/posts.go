@@ -1,6 +1,7 @@
 package models
 
 import (
+	"database/sql"
 	"time"
 
 	uuid "github.com/satori/go.uuid"
@@ -14,15 +15,30 @@ type Post struct {
 	Created time.Time `json:"created"`
 	Updated time.Time `json:"updated"`
 	Author  User      `json:"author"`
+
+	//Version counts the saved edits of the post, starting at 1. UpdatePost only applies an edit made to the current version.
+	Version int `json:"version"`
+
+	//Reactions counts each reaction made to the post and Reacted lists the reactions the viewing user made.
+	Reactions map[string]int `json:"reactions"`
+	Reacted   []string       `json:"reacted"`
+
+	//Tags lists the hashtags used in the body.
+	Tags []string `json:"tags"`
+
+	//Hidden marks a post hidden by a moderator. Hidden posts are left out of every feed and search.
+	Hidden bool `json:"hidden,omitempty"`
 }
 
 //Our selection of sample Post methods to satisfy the Dataface interface:
 
-//AllPosts takes a previous date and limit and returns all posts in reverse chronological order or an error.
-func (db *DB) AllPosts(prevDate string, limit int) ([]*Post, error) {
+//AllPosts takes a cursor, limit, and viewer and returns the posts before that cursor in reverse chronological order or an error.
+//Posts hidden by moderators are left out, as are shadow-banned users' posts unless the viewer wrote them.
+//Each post carries its reaction counts and the reactions of the viewer, which may be uuid.Nil for anonymous viewers.
+func (db *DB) AllPosts(before Cursor, limit int, viewer uuid.UUID) ([]*Post, error) {
 	posts := []*Post{}
 
-	rows, err := db.Query("SELECT posts.ID, posts.title, posts.body, posts.created, posts.updated, users.id, users.name, users.avatar FROM posts INNER JOIN users ON posts.uid = users.id WHERE posts.created < $1 ORDER BY posts.created DESC LIMIT $2;", prevDate, limit)
+	rows, err := db.Query("SELECT posts.ID, posts.title, posts.body, posts.created, posts.updated, posts.version, users.id, users.name, users.avatar FROM posts INNER JOIN users ON posts.uid = users.id WHERE NOT posts.hidden AND (NOT users.shadowbanned OR users.id = $4) AND (posts.created, posts.id) < ($1, $2) ORDER BY posts.created DESC, posts.id DESC LIMIT $3;", before.Created, before.ID, limit, viewer)
 	if err != nil {
 		return posts, err
 	}
@@ -30,7 +46,7 @@ func (db *DB) AllPosts(prevDate string, limit int) ([]*Post, error) {
 
 	for rows.Next() {
 		Post := &Post{}
-		err := rows.Scan(&Post.ID, &Post.Title, &Post.Body, &Post.Created, &Post.Updated, &Post.Author.ID, &Post.Author.Name, &Post.Author.Avatar)
+		err := rows.Scan(&Post.ID, &Post.Title, &Post.Body, &Post.Created, &Post.Updated, &Post.Version, &Post.Author.ID, &Post.Author.Name, &Post.Author.Avatar)
 		if err != nil {
 			return posts, err
 		}
@@ -40,18 +56,40 @@ func (db *DB) AllPosts(prevDate string, limit int) ([]*Post, error) {
 		return posts, err
 	}
 
+	err = db.attachReactions(posts, viewer)
+	if err != nil {
+		return posts, err
+	}
+
+	err = db.attachTags(posts)
+	if err != nil {
+		return posts, err
+	}
+
 	return posts, nil
 }
 
 
-//OnePost returns one specific post or an error
-func (db *DB) OnePost(id uuid.UUID) (*Post, error) {
+//OnePost returns one specific post, with its reaction counts and the reactions of the viewer, or an error
+//Hidden posts are returned too, marked Hidden, for handlers to decide who may see them.
+//A shadow-banned user's post is only found when the viewer wrote it.
+func (db *DB) OnePost(id, viewer uuid.UUID) (*Post, error) {
 
 	post := &Post{}
 
-	row := db.QueryRow("SELECT posts.ID, posts.title, posts.body, posts.created, posts.updated, users.id, users.name, users.avatar FROM posts INNER JOIN users ON posts.uid = users.id WHERE posts.id = $1", id)
+	row := db.QueryRow("SELECT posts.ID, posts.title, posts.body, posts.created, posts.updated, posts.version, posts.hidden, users.id, users.name, users.avatar FROM posts INNER JOIN users ON posts.uid = users.id WHERE posts.id = $1 AND (NOT users.shadowbanned OR users.id = $2)", id, viewer)
 
-	err := row.Scan(&post.ID, &post.Title, &post.Body, &post.Created, &post.Updated, &post.Author.ID, &post.Author.Name, &post.Author.Avatar)
+	err := row.Scan(&post.ID, &post.Title, &post.Body, &post.Created, &post.Updated, &post.Version, &post.Hidden, &post.Author.ID, &post.Author.Name, &post.Author.Avatar)
+	if err != nil {
+		return post, err
+	}
+
+	err = db.attachReactions([]*Post{post}, viewer)
+	if err != nil {
+		return post, err
+	}
+
+	err = db.attachTags([]*Post{post})
 	if err != nil {
 		return post, err
 	}
@@ -60,35 +98,137 @@ func (db *DB) OnePost(id uuid.UUID) (*Post, error) {
 }
 
 
-//CreatePost creates a new post in the DB and returns an error.
-//CreatePost expects Post will come in with id uuid.UUID, title string, body string, created time.Time, uid uuid.UUID
+//CreatePost creates a new post in the DB, along with its tags and first revision, and returns an error.
+//CreatePost expects Post will come in with id uuid.UUID, title string, body string, created time.Time, uid uuid.UUID, tags []string
 func (db *DB) CreatePost(Post *Post) error {
 
-	_, err := db.Exec("INSERT INTO posts (id, title, body, created, updated, uid) VALUES ($1, $2, $3, $4, $5, $6)", Post.ID, Post.Title, Post.Body, Post.Created, Post.Updated, Post.Author.ID)
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("INSERT INTO posts (id, title, body, created, updated, uid, version) VALUES ($1, $2, $3, $4, $5, $6, 1)", Post.ID, Post.Title, Post.Body, Post.Created, Post.Updated, Post.Author.ID)
 	if err != nil {
 		return err
 	}
 
+	err = saveRevision(tx, Post, Post.Created)
+	if err != nil {
+		return err
+	}
+
+	err = syncPostTags(tx, Post.ID, Post.Tags)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	Post.Version = 1
 	return nil
 }
 
-//UpdatePost updates a specific Post in DB and returns an error.
-//UpdatePost expects Post will come in with id uuid.UUID, title string, body string, updated time.Time
+//UpdatePost updates a specific Post in DB, replacing its tags and saving the edit as a new revision, and returns an error.
+//UpdatePost expects Post will come in with id uuid.UUID, title string, body string, updated time.Time, uid uuid.UUID, tags []string, version int
+//It returns ErrVersionConflict without changing anything if the post is no longer at that version, and otherwise leaves Post at its new version.
 func (db *DB) UpdatePost(Post *Post) error {
 
-	_, err := db.Exec("UPDATE posts SET title=$2, body=$3, updated=$4 WHERE id=$1;", Post.ID, Post.Title, Post.Body, Post.Updated)
+	tx, err := db.Begin()
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
+	err = saveOriginal(tx, Post.ID)
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.Exec("UPDATE posts SET title=$2, body=$3, updated=$4, version = version + 1 WHERE id=$1 AND version=$5;", Post.ID, Post.Title, Post.Body, Post.Updated, Post.Version)
+	if err != nil {
+		return err
+	}
+
+	//no row means the post is gone or was edited since the version the edit was made to
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if updated == 0 {
+		return ErrVersionConflict
+	}
+
+	err = saveRevision(tx, Post, Post.Updated)
+	if err != nil {
+		return err
+	}
+
+	err = syncPostTags(tx, Post.ID, Post.Tags)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	Post.Version++
 	return nil
 }
 
-//DeletePost deletes one specific Post from DB and returns an error.
+//DeletePost deletes one specific Post from DB, along with its comments, reactions, tags, and revisions, and returns an error.
 //DeletePost expects Post will come in with id uuid.UUID
 func (db *DB) DeletePost(Post *Post) error {
 
-	_, err := db.Exec("DELETE FROM posts WHERE id=$1;", Post.ID)
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = deletePost(tx, Post.ID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+//deletePost removes a post and everything attached to it, for DeletePost's and Moderate's transactions.
+func deletePost(tx *sql.Tx, id uuid.UUID) error {
+
+	_, err := tx.Exec("DELETE FROM comments WHERE post_id=$1;", id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM post_reactions WHERE post_id=$1;", id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM reaction_counts WHERE post_id=$1;", id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM post_tags WHERE post_id=$1;", id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM post_revisions WHERE post_id=$1;", id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM posts WHERE id=$1;", id)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,153 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+	uuid "github.com/satori/go.uuid"
+)
+
+//ReactionEmoji maps the name of each reaction users may add to a post to the emoji displayed for it.
+var ReactionEmoji = map[string]string{
+	"like":  "👍",
+	"love":  "❤️",
+	"laugh": "😂",
+	"wow":   "😮",
+	"sad":   "😢",
+	"angry": "😠",
+}
+
+//Our selection of sample Reaction methods to satisfy the Datastore interface.
+//Each post's reaction counts are kept in reaction_counts alongside the individual reactions in post_reactions so feeds read counts without counting rows.
+
+//AddReaction records a user's reaction to a post made at the time created and returns an error.
+//Adding a reaction the user has already made changes nothing.
+func (db *DB) AddReaction(postID, userID uuid.UUID, reaction string, created time.Time) error {
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("INSERT INTO post_reactions (post_id, uid, reaction, created) VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING;", postID, userID, reaction, created)
+	if err != nil {
+		return err
+	}
+
+	//only count the reaction if it is new
+	added, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if added == 1 {
+		_, err = tx.Exec("INSERT INTO reaction_counts (post_id, reaction, count) VALUES ($1, $2, 1) ON CONFLICT (post_id, reaction) DO UPDATE SET count = reaction_counts.count + 1;", postID, reaction)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+//RemoveReaction removes a user's reaction to a post and returns an error.
+//Removing a reaction the user has not made changes nothing.
+func (db *DB) RemoveReaction(postID, userID uuid.UUID, reaction string) error {
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec("DELETE FROM post_reactions WHERE post_id=$1 AND uid=$2 AND reaction=$3;", postID, userID, reaction)
+	if err != nil {
+		return err
+	}
+
+	removed, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if removed == 1 {
+		_, err = tx.Exec("UPDATE reaction_counts SET count = count - 1 WHERE post_id=$1 AND reaction=$2;", postID, reaction)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+//attachReactions fills in the reaction counts of the given posts and the reactions the viewer made to them.
+//A nil viewer is anonymous and has made no reactions.
+func (db *DB) attachReactions(posts []*Post, viewer uuid.UUID) error {
+
+	if len(posts) == 0 {
+		return nil
+	}
+
+	byID := make(map[uuid.UUID]*Post, len(posts))
+	ids := make([]string, len(posts))
+	for i, post := range posts {
+		post.Reactions = map[string]int{}
+		post.Reacted = []string{}
+		byID[post.ID] = post
+		ids[i] = post.ID.String()
+	}
+
+	rows, err := db.Query("SELECT post_id, reaction, count FROM reaction_counts WHERE post_id = ANY($1::uuid[]) AND count > 0;", pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var postID uuid.UUID
+		var reaction string
+		var count int
+		if err := rows.Scan(&postID, &reaction, &count); err != nil {
+			return err
+		}
+		byID[postID].Reactions[reaction] = count
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if uuid.Equal(viewer, uuid.Nil) {
+		return nil
+	}
+
+	reacted, err := db.Query("SELECT post_id, reaction FROM post_reactions WHERE post_id = ANY($1::uuid[]) AND uid = $2 ORDER BY created;", pq.Array(ids), viewer)
+	if err != nil {
+		return err
+	}
+	defer reacted.Close()
+
+	for reacted.Next() {
+		var postID uuid.UUID
+		var reaction string
+		if err := reacted.Scan(&postID, &reaction); err != nil {
+			return err
+		}
+		byID[postID].Reacted = append(byID[postID].Reacted, reaction)
+	}
+
+	return reacted.Err()
+}
+
+//deleteUserReactions removes a user's reactions from the posts they reacted to, keeping the counts of those posts in step.
+func deleteUserReactions(tx *sql.Tx, userID uuid.UUID) error {
+
+	_, err := tx.Exec("UPDATE reaction_counts SET count = reaction_counts.count - mine.n FROM (SELECT post_id, reaction, COUNT(*) AS n FROM post_reactions WHERE uid=$1 GROUP BY post_id, reaction) AS mine WHERE reaction_counts.post_id = mine.post_id AND reaction_counts.reaction = mine.reaction;", userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM post_reactions WHERE uid=$1;", userID)
+	return err
+}
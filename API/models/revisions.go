@@ -0,0 +1,90 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+//Revision type defined
+//Revision is one saved version of a post: its title and body as written by the author at that time.
+//Revisions of a post are numbered from 1, the post as first created.
+type Revision struct {
+	ID      uuid.UUID `json:"id"`
+	PostID  uuid.UUID `json:"post_id"`
+	Number  int       `json:"number"`
+	Title   string    `json:"title"`
+	Body    string    `json:"body"`
+	Created time.Time `json:"created"`
+	Author  User      `json:"author"`
+}
+
+//Our selection of sample Revision methods to satisfy the Datastore interface.
+//CreatePost saves a post's first revision and UpdatePost saves each edit as the next one, in the same transaction as the change itself.
+
+//PostRevisions takes a post id, cursor, and limit and returns the revisions of that post, newest first, or an error.
+func (db *DB) PostRevisions(postID uuid.UUID, before Cursor, limit int) ([]*Revision, error) {
+	revisions := []*Revision{}
+
+	rows, err := db.Query("SELECT post_revisions.id, post_revisions.post_id, post_revisions.number, post_revisions.title, post_revisions.body, post_revisions.created, users.id, users.name, users.avatar FROM post_revisions INNER JOIN users ON post_revisions.uid = users.id WHERE post_revisions.post_id = $1 AND (post_revisions.created, post_revisions.id) < ($2, $3) ORDER BY post_revisions.created DESC, post_revisions.id DESC LIMIT $4;", postID, before.Created, before.ID, limit)
+	if err != nil {
+		return revisions, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		revision := &Revision{}
+		err := rows.Scan(&revision.ID, &revision.PostID, &revision.Number, &revision.Title, &revision.Body, &revision.Created, &revision.Author.ID, &revision.Author.Name, &revision.Author.Avatar)
+		if err != nil {
+			return revisions, err
+		}
+		revisions = append(revisions, revision)
+	}
+	if err := rows.Err(); err != nil {
+		return revisions, err
+	}
+
+	return revisions, nil
+}
+
+//OneRevision returns one numbered revision of a post or an error.
+func (db *DB) OneRevision(postID uuid.UUID, number int) (*Revision, error) {
+
+	revision := &Revision{}
+
+	row := db.QueryRow("SELECT post_revisions.id, post_revisions.post_id, post_revisions.number, post_revisions.title, post_revisions.body, post_revisions.created, users.id, users.name, users.avatar FROM post_revisions INNER JOIN users ON post_revisions.uid = users.id WHERE post_revisions.post_id = $1 AND post_revisions.number = $2;", postID, number)
+
+	err := row.Scan(&revision.ID, &revision.PostID, &revision.Number, &revision.Title, &revision.Body, &revision.Created, &revision.Author.ID, &revision.Author.Name, &revision.Author.Avatar)
+	if err != nil {
+		return revision, err
+	}
+
+	return revision, nil
+}
+
+//saveOriginal saves the stored content of a post created before revisions were kept as its revision 1, so editing it does not lose the original.
+//It does nothing for posts that already have revisions.
+func saveOriginal(tx *sql.Tx, postID uuid.UUID) error {
+
+	id, err := uuid.NewV4()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("INSERT INTO post_revisions (id, post_id, number, title, body, created, uid) SELECT $2, id, 1, title, body, updated, uid FROM posts WHERE id=$1 AND NOT EXISTS (SELECT 1 FROM post_revisions WHERE post_id=$1);", postID, id)
+	return err
+}
+
+//saveRevision records the post's title and body as its next revision, written by the post's author at the given time.
+//Callers write the posts row first in the same transaction, so its row lock keeps concurrent edits from taking the same number.
+func saveRevision(tx *sql.Tx, post *Post, created time.Time) error {
+
+	id, err := uuid.NewV4()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("INSERT INTO post_revisions (id, post_id, number, title, body, created, uid) SELECT $2, $1, COALESCE(MAX(number), 0) + 1, $3, $4, $5, $6 FROM post_revisions WHERE post_id=$1;", post.ID, id, post.Title, post.Body, created, post.Author.ID)
+	return err
+}
@@ -0,0 +1,148 @@
+package models
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+	uuid "github.com/satori/go.uuid"
+)
+
+//The roles a user can hold. Every user holds RoleUser; moderators and admins are granted their roles by an admin.
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+//the actions recorded by a RoleChange
+const (
+	RoleGranted = "grant"
+	RoleRevoked = "revoke"
+)
+
+//RoleChange type defined
+//RoleChange is one audited grant or revocation of a role: which admin changed which user's role, from which IP, and when.
+type RoleChange struct {
+	ID      uuid.UUID `json:"id"`
+	Actor   uuid.UUID `json:"actor"`
+	Target  uuid.UUID `json:"target"`
+	Role    string    `json:"role"`
+	Action  string    `json:"action"`
+	IP      string    `json:"ip"`
+	Created time.Time `json:"created"`
+}
+
+//GrantableRole reports whether a role can be granted and revoked. RoleUser is held by everyone and cannot.
+func GrantableRole(role string) bool {
+	return role == RoleModerator || role == RoleAdmin
+}
+
+//HasRole reports whether roles include role.
+func HasRole(roles []string, role string) bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+//Our selection of sample Role methods to satisfy the Datastore interface:
+
+//UserRoles takes a user id and returns the roles the user holds, or an error.
+func (db *DB) UserRoles(id uuid.UUID) ([]string, error) {
+	roles := []string{}
+
+	row := db.QueryRow("SELECT roles FROM users WHERE id=$1;", id)
+	err := row.Scan(pq.Array(&roles))
+	if err != nil {
+		return roles, err
+	}
+
+	return roles, nil
+}
+
+//GrantRole gives a user a role and records the change, returning whether the user's roles changed and nil or an error.
+//GrantRole expects change will come in with id uuid.UUID, actor uuid.UUID, target uuid.UUID, role string, ip string, created time.Time
+//Granting a role the user already holds changes nothing and records nothing. It returns sql.ErrNoRows if there is no such user.
+func (db *DB) GrantRole(change *RoleChange) (bool, error) {
+	change.Action = RoleGranted
+	return db.changeRole(change, "UPDATE users SET roles = array_append(roles, $2) WHERE id=$1 AND NOT $2 = ANY(roles);")
+}
+
+//RevokeRole takes a role away from a user and records the change, returning whether the user's roles changed and nil or an error.
+//RevokeRole expects change will come in with id uuid.UUID, actor uuid.UUID, target uuid.UUID, role string, ip string, created time.Time
+//Revoking a role the user does not hold changes nothing and records nothing. It returns sql.ErrNoRows if there is no such user.
+func (db *DB) RevokeRole(change *RoleChange) (bool, error) {
+	change.Action = RoleRevoked
+	return db.changeRole(change, "UPDATE users SET roles = array_remove(roles, $2) WHERE id=$1 AND $2 = ANY(roles);")
+}
+
+//changeRole runs a grant or revoke update and, if it changed the user's roles, records the change in the same transaction, returning whether it did.
+func (db *DB) changeRole(change *RoleChange, update string) (bool, error) {
+
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	//lock the user's row so concurrent changes to the same user are recorded in the order they apply
+	var id uuid.UUID
+	err = tx.QueryRow("SELECT id FROM users WHERE id=$1 FOR UPDATE;", change.Target).Scan(&id)
+	if err != nil {
+		return false, err
+	}
+
+	res, err := tx.Exec(update, change.Target, change.Role)
+	if err != nil {
+		return false, err
+	}
+
+	changed, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	if changed == 0 {
+		return false, nil
+	}
+
+	_, err = tx.Exec("INSERT INTO role_changes (id, actor, target, role, action, ip, created) VALUES ($1, $2, $3, $4, $5, $6, $7);", change.ID, change.Actor, change.Target, change.Role, change.Action, change.IP, change.Created)
+	if err != nil {
+		return false, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+//RoleChanges takes a user id, cursor, and limit and returns one page of the role changes made to that user, newest first, or an error.
+//A nil user id returns the role changes made to every user.
+func (db *DB) RoleChanges(target uuid.UUID, before Cursor, limit int) ([]*RoleChange, error) {
+	changes := []*RoleChange{}
+
+	rows, err := db.Query("SELECT id, actor, target, role, action, ip, created FROM role_changes WHERE ($1::uuid = $5 OR target = $1) AND (created, id) < ($2, $3) ORDER BY created DESC, id DESC LIMIT $4;", target, before.Created, before.ID, limit, uuid.Nil)
+	if err != nil {
+		return changes, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		change := &RoleChange{}
+		err := rows.Scan(&change.ID, &change.Actor, &change.Target, &change.Role, &change.Action, &change.IP, &change.Created)
+		if err != nil {
+			return changes, err
+		}
+		changes = append(changes, change)
+	}
+	if err := rows.Err(); err != nil {
+		return changes, err
+	}
+
+	return changes, nil
+}
@@ -0,0 +1,317 @@
+-- Schema for the sample Postgres database behind the Datastore methods in this folder.
+
+-- trigram similarity for user search
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE TABLE IF NOT EXISTS users (
+    id       UUID PRIMARY KEY,
+    name     VARCHAR(15) NOT NULL UNIQUE,
+    email    VARCHAR(254) NOT NULL UNIQUE,
+    -- argon2id hashes in the PHC string format, or bcrypt hashes from before, replaced on login through UpdatePassword
+    password TEXT NOT NULL,
+    avatar   TEXT NOT NULL,
+    created  TIMESTAMPTZ NOT NULL,
+    updated  TIMESTAMPTZ NOT NULL,
+    -- denormalized follow counts, kept in step by Follow, Unfollow, and DeleteUser
+    followers INTEGER NOT NULL DEFAULT 0 CHECK (followers >= 0),
+    following INTEGER NOT NULL DEFAULT 0 CHECK (following >= 0),
+    -- edit count for optimistic concurrency, compared and bumped by UpdateUserPhoto
+    version  INTEGER NOT NULL DEFAULT 1,
+    -- roles carried in the user's JWT, changed by GrantRole and RevokeRole
+    roles    TEXT[] NOT NULL DEFAULT '{user}',
+    -- end of a suspension set by Moderate or SetStanding, or NULL
+    suspended_until TIMESTAMPTZ,
+    -- set by SetStanding to show the user's posts to no one but themselves
+    shadowbanned BOOLEAN NOT NULL DEFAULT false
+);
+
+CREATE TABLE IF NOT EXISTS posts (
+    id      UUID PRIMARY KEY,
+    title   VARCHAR(50) NOT NULL,
+    body    VARCHAR(5000) NOT NULL,
+    created TIMESTAMPTZ NOT NULL,
+    updated TIMESTAMPTZ NOT NULL,
+    uid     UUID NOT NULL REFERENCES users (id),
+    -- edit count for optimistic concurrency, compared and bumped by UpdatePost
+    version INTEGER NOT NULL DEFAULT 1,
+    -- set by Moderate to leave the post out of every feed and search
+    hidden  BOOLEAN NOT NULL DEFAULT false,
+    -- full-text search document, regenerated by Postgres whenever the title or body changes
+    search  TSVECTOR GENERATED ALWAYS AS (setweight(to_tsvector('english', title), 'A') || setweight(to_tsvector('english', body), 'B')) STORED
+);
+
+CREATE INDEX IF NOT EXISTS posts_search_idx ON posts USING GIN (search);
+
+-- cursor pagination walks (created, id) in reverse order
+CREATE INDEX IF NOT EXISTS posts_created_id_idx ON posts (created DESC, id DESC);
+CREATE INDEX IF NOT EXISTS users_created_id_idx ON users (created DESC, id DESC);
+
+-- user search matches names by trigram similarity or substring; autocomplete matches them by prefix
+CREATE INDEX IF NOT EXISTS users_name_trgm_idx ON users USING GIN (lower(name) gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS users_name_prefix_idx ON users (lower(name) text_pattern_ops);
+
+CREATE TABLE IF NOT EXISTS comments (
+    id      UUID PRIMARY KEY,
+    post_id UUID NOT NULL REFERENCES posts (id) ON DELETE CASCADE,
+    parent  UUID REFERENCES comments (id) ON DELETE CASCADE,
+    body    VARCHAR(1000) NOT NULL,
+    created TIMESTAMPTZ NOT NULL,
+    updated TIMESTAMPTZ NOT NULL,
+    uid     UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS comments_post_created_id_idx ON comments (post_id, created DESC, id DESC);
+CREATE INDEX IF NOT EXISTS comments_parent_idx ON comments (parent);
+CREATE INDEX IF NOT EXISTS comments_uid_idx ON comments (uid);
+
+CREATE TABLE IF NOT EXISTS post_reactions (
+    post_id  UUID NOT NULL REFERENCES posts (id) ON DELETE CASCADE,
+    uid      UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    reaction VARCHAR(16) NOT NULL,
+    created  TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (post_id, uid, reaction)
+);
+
+CREATE INDEX IF NOT EXISTS post_reactions_uid_idx ON post_reactions (uid);
+
+-- denormalized per-post counts, kept in step by AddReaction, RemoveReaction, and DeleteUser
+CREATE TABLE IF NOT EXISTS reaction_counts (
+    post_id  UUID NOT NULL REFERENCES posts (id) ON DELETE CASCADE,
+    reaction VARCHAR(16) NOT NULL,
+    count    INTEGER NOT NULL DEFAULT 0 CHECK (count >= 0),
+    PRIMARY KEY (post_id, reaction)
+);
+
+CREATE TABLE IF NOT EXISTS follows (
+    follower UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    followee UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    created  TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (follower, followee),
+    CHECK (follower <> followee)
+);
+
+-- follower and following lists walk (created, user id) in reverse order
+CREATE INDEX IF NOT EXISTS follows_follower_created_idx ON follows (follower, created DESC, followee DESC);
+CREATE INDEX IF NOT EXISTS follows_followee_created_idx ON follows (followee, created DESC, follower DESC);
+
+-- the timeline reads each followed author's posts newest first
+CREATE INDEX IF NOT EXISTS posts_uid_created_id_idx ON posts (uid, created DESC, id DESC);
+
+CREATE TABLE IF NOT EXISTS tags (
+    name VARCHAR(50) PRIMARY KEY
+);
+
+-- created copies the post's created time, kept in step by CreatePost and UpdatePost
+CREATE TABLE IF NOT EXISTS post_tags (
+    post_id UUID NOT NULL REFERENCES posts (id) ON DELETE CASCADE,
+    tag     VARCHAR(50) NOT NULL REFERENCES tags (name),
+    created TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (post_id, tag)
+);
+
+-- tag feeds walk (created, post id) in reverse order; trending scans the recent window of every tag
+CREATE INDEX IF NOT EXISTS post_tags_tag_created_idx ON post_tags (tag, created DESC, post_id DESC);
+CREATE INDEX IF NOT EXISTS post_tags_created_idx ON post_tags (created);
+
+-- every saved version of a post, numbered from 1 by CreatePost and UpdatePost
+CREATE TABLE IF NOT EXISTS post_revisions (
+    id      UUID PRIMARY KEY,
+    post_id UUID NOT NULL REFERENCES posts (id) ON DELETE CASCADE,
+    number  INTEGER NOT NULL CHECK (number > 0),
+    title   VARCHAR(50) NOT NULL,
+    body    VARCHAR(5000) NOT NULL,
+    created TIMESTAMPTZ NOT NULL,
+    uid     UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    UNIQUE (post_id, number)
+);
+
+-- revision lists walk (created, id) in reverse order
+CREATE INDEX IF NOT EXISTS post_revisions_post_created_idx ON post_revisions (post_id, created DESC, id DESC);
+CREATE INDEX IF NOT EXISTS post_revisions_uid_idx ON post_revisions (uid);
+
+-- audit trail of every role granted or revoked by GrantRole and RevokeRole.
+-- actor and target are not foreign keys so the record outlives deleted accounts.
+CREATE TABLE IF NOT EXISTS role_changes (
+    id      UUID PRIMARY KEY,
+    actor   UUID NOT NULL,
+    target  UUID NOT NULL,
+    role    VARCHAR(16) NOT NULL,
+    action  VARCHAR(6) NOT NULL CHECK (action IN ('grant', 'revoke')),
+    ip      VARCHAR(45) NOT NULL DEFAULT '',
+    created TIMESTAMPTZ NOT NULL
+);
+
+-- role change lists walk (created, id) in reverse order, for every user or one
+CREATE INDEX IF NOT EXISTS role_changes_created_idx ON role_changes (created DESC, id DESC);
+CREATE INDEX IF NOT EXISTS role_changes_target_created_idx ON role_changes (target, created DESC, id DESC);
+
+-- reports filed on posts, comments, and users; subject is the author of the content or the reported user.
+-- reports are not tied to their content by foreign keys so they outlive it, like the moderator actions they lead to.
+CREATE TABLE IF NOT EXISTS reports (
+    id        UUID PRIMARY KEY,
+    reporter  UUID NOT NULL,
+    kind      VARCHAR(7) NOT NULL CHECK (kind IN ('post', 'comment', 'user')),
+    target    UUID NOT NULL,
+    subject   UUID NOT NULL,
+    reason    VARCHAR(16) NOT NULL,
+    details   VARCHAR(500) NOT NULL DEFAULT '',
+    status    VARCHAR(9) NOT NULL CHECK (status IN ('open', 'resolved', 'dismissed')),
+    created   TIMESTAMPTZ NOT NULL,
+    action_id UUID
+);
+
+-- a user has at most one open report on the same content
+CREATE UNIQUE INDEX IF NOT EXISTS reports_open_reporter_idx ON reports (reporter, kind, target) WHERE status = 'open';
+-- the queue walks (created, id) in reverse order; Moderate closes the open reports on one target
+CREATE INDEX IF NOT EXISTS reports_created_idx ON reports (created DESC, id DESC);
+CREATE INDEX IF NOT EXISTS reports_target_idx ON reports (kind, target) WHERE status = 'open';
+
+-- the record of every moderator action and account standing change, kept after the content or accounts it concerns are deleted
+CREATE TABLE IF NOT EXISTS moderation_actions (
+    id        UUID PRIMARY KEY,
+    moderator UUID NOT NULL,
+    kind      VARCHAR(7) NOT NULL,
+    target    UUID NOT NULL,
+    subject   UUID NOT NULL,
+    action    VARCHAR(11) NOT NULL CHECK (action IN ('hide', 'delete', 'warn', 'suspend', 'dismiss', 'unsuspend', 'shadowban', 'unshadowban')),
+    note      VARCHAR(500) NOT NULL DEFAULT '',
+    until     TIMESTAMPTZ,
+    created   TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS moderation_actions_subject_created_idx ON moderation_actions (subject, created DESC, id DESC);
+
+-- one appeal per moderator action, decided by a moderator
+CREATE TABLE IF NOT EXISTS appeals (
+    id         UUID PRIMARY KEY,
+    action_id  UUID NOT NULL UNIQUE REFERENCES moderation_actions (id),
+    subject    UUID NOT NULL,
+    body       VARCHAR(1000) NOT NULL,
+    status     VARCHAR(10) NOT NULL CHECK (status IN ('open', 'upheld', 'overturned')),
+    created    TIMESTAMPTZ NOT NULL,
+    decided_by UUID,
+    decided    TIMESTAMPTZ,
+    note       VARCHAR(500) NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS appeals_status_created_idx ON appeals (status, created DESC, id DESC);
+
+-- the record of accounts locked after repeated failed logins and unlocked through the emailed link, kept after the accounts are deleted
+CREATE TABLE IF NOT EXISTS lockout_events (
+    id       UUID PRIMARY KEY,
+    user_id  UUID NOT NULL,
+    event    VARCHAR(6) NOT NULL CHECK (event IN ('lock', 'unlock')),
+    ip       VARCHAR(45) NOT NULL DEFAULT '',
+    failures INTEGER NOT NULL DEFAULT 0,
+    until    TIMESTAMPTZ,
+    created  TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS lockout_events_user_created_idx ON lockout_events (user_id, created DESC, id DESC);
+
+-- authenticator app enrollments for two-factor authentication, one per user, confirmed with a first code before they protect the account.
+-- last_step is the time step of the last code accepted, so each code logs in once.
+CREATE TABLE IF NOT EXISTS totp (
+    uid       UUID PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+    secret    TEXT NOT NULL,
+    confirmed BOOLEAN NOT NULL DEFAULT false,
+    last_step BIGINT NOT NULL DEFAULT 0,
+    created   TIMESTAMPTZ NOT NULL
+);
+
+-- one-time recovery codes for logging in without the authenticator app, kept as SHA-256 hashes and marked when used
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    uid  UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    hash CHAR(64) NOT NULL,
+    used TIMESTAMPTZ,
+    PRIMARY KEY (uid, hash)
+);
+
+-- WebAuthn credentials users log in with in place of a password, any number per user, named by their base64url credential ID.
+-- sign_count is the authenticator's signature counter as of the last login; a login reporting a lower one flags the passkey as cloned.
+CREATE TABLE IF NOT EXISTS passkeys (
+    id         TEXT PRIMARY KEY,
+    uid        UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    name       VARCHAR(50) NOT NULL,
+    public_key BYTEA NOT NULL,
+    sign_count BIGINT NOT NULL DEFAULT 0,
+    cloned     BOOLEAN NOT NULL DEFAULT false,
+    created    TIMESTAMPTZ NOT NULL,
+    last_used  TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS passkeys_uid_idx ON passkeys (uid);
+
+-- accounts at external OpenID Connect providers users log in with in place of a password, identified by the provider's subject.
+-- A user links at most one account per provider, and an account links to one user.
+CREATE TABLE IF NOT EXISTS identities (
+    provider VARCHAR(30) NOT NULL,
+    subject  VARCHAR(255) NOT NULL,
+    uid      UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    email    TEXT NOT NULL DEFAULT '',
+    created  TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (provider, subject),
+    UNIQUE (uid, provider)
+);
+
+-- personal access tokens users create for scripts and other clients, kept as SHA-256 hashes of the token shown once at creation.
+-- scopes lists what the token may do; every token expires.
+CREATE TABLE IF NOT EXISTS access_tokens (
+    id        UUID PRIMARY KEY,
+    uid       UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    name      VARCHAR(50) NOT NULL,
+    hash      CHAR(64) NOT NULL UNIQUE,
+    scopes    TEXT[] NOT NULL,
+    created   TIMESTAMPTZ NOT NULL,
+    expires   TIMESTAMPTZ NOT NULL,
+    last_used TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS access_tokens_uid_idx ON access_tokens (uid);
+
+-- logins of users on their devices, named by the session ID in their JWTs. A session ends when its JWT expires unrefreshed,
+-- or when it is deleted, which revokes its JWT at once.
+CREATE TABLE IF NOT EXISTS sessions (
+    id         UUID PRIMARY KEY,
+    uid        UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    user_agent TEXT NOT NULL DEFAULT '',
+    ip         TEXT NOT NULL DEFAULT '',
+    created    TIMESTAMPTZ NOT NULL,
+    last_seen  TIMESTAMPTZ NOT NULL,
+    expires    TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS sessions_uid_idx ON sessions (uid);
+
+-- the security audit log of authentication and account events. actor and target are not tied to users by foreign keys
+-- so events outlive deleted accounts, and the trigger below refuses updates and deletes, so the log is only ever appended to.
+CREATE TABLE IF NOT EXISTS audit_events (
+    id         UUID PRIMARY KEY,
+    action     VARCHAR(16) NOT NULL,
+    actor      UUID NOT NULL,
+    target     UUID NOT NULL,
+    ip         VARCHAR(45) NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    request_id VARCHAR(64) NOT NULL DEFAULT '',
+    detail     TEXT NOT NULL DEFAULT '',
+    created    TIMESTAMPTZ NOT NULL
+);
+
+-- audit lists walk (created, id) in reverse order, for every user or those acted on or by one
+CREATE INDEX IF NOT EXISTS audit_events_created_idx ON audit_events (created DESC, id DESC);
+CREATE INDEX IF NOT EXISTS audit_events_actor_created_idx ON audit_events (actor, created DESC, id DESC);
+CREATE INDEX IF NOT EXISTS audit_events_target_created_idx ON audit_events (target, created DESC, id DESC);
+
+CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit events are append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_events_append_only ON audit_events;
+CREATE TRIGGER audit_events_append_only BEFORE UPDATE OR DELETE ON audit_events
+    FOR EACH ROW EXECUTE PROCEDURE audit_events_append_only();
+
+DROP TRIGGER IF EXISTS audit_events_no_truncate ON audit_events;
+CREATE TRIGGER audit_events_no_truncate BEFORE TRUNCATE ON audit_events
+    FOR EACH STATEMENT EXECUTE PROCEDURE audit_events_append_only();
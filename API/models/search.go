@@ -0,0 +1,172 @@
+package models
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"time"
+	"unicode"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+//SearchQuery is a parsed full-text search over posts.
+//Every word, "quoted phrase", and prefix* in the query must match for a post to be found.
+type SearchQuery struct {
+	Words    []string
+	Phrases  [][]string
+	Prefixes []string
+}
+
+//ParseSearchQuery splits a search string into its words, phrases, and prefixes.
+//Words are lowercased and stripped of everything but letters and numbers so they are safe to pass to any backend.
+func ParseSearchQuery(q string) SearchQuery {
+
+	query := SearchQuery{}
+
+	//quoted sections are phrases; the rest are words or prefixes
+	for i, section := range strings.Split(q, "\"") {
+		if i%2 == 1 {
+			phrase := tokenize(section)
+			switch len(phrase) {
+			case 0:
+			case 1:
+				query.Words = append(query.Words, phrase[0])
+			default:
+				query.Phrases = append(query.Phrases, phrase)
+			}
+			continue
+		}
+
+		for _, field := range strings.Fields(section) {
+			words := tokenize(field)
+			if len(words) == 0 {
+				continue
+			}
+			//a trailing * makes the last word of the field a prefix
+			if strings.HasSuffix(field, "*") {
+				query.Prefixes = append(query.Prefixes, words[len(words)-1])
+				words = words[:len(words)-1]
+			}
+			query.Words = append(query.Words, words...)
+		}
+	}
+
+	return query
+}
+
+//Empty reports whether the query has nothing to search for.
+func (q SearchQuery) Empty() bool {
+	return len(q.Words) == 0 && len(q.Phrases) == 0 && len(q.Prefixes) == 0
+}
+
+//tsquery writes the query in Postgres to_tsquery syntax.
+//ParseSearchQuery leaves only letters and numbers in each word so no tsquery operators can come from the client.
+func (q SearchQuery) tsquery() string {
+
+	parts := []string{}
+	parts = append(parts, q.Words...)
+	for _, phrase := range q.Phrases {
+		parts = append(parts, "("+strings.Join(phrase, " <-> ")+")")
+	}
+	for _, prefix := range q.Prefixes {
+		parts = append(parts, prefix+":*")
+	}
+
+	return strings.Join(parts, " & ")
+}
+
+//tokenize lowercases a string and splits it into runs of letters and numbers.
+func tokenize(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+//PostResult type defined
+//PostResult is a post found by a search along with its ranking score and highlighted snippets.
+type PostResult struct {
+	Post
+	Score      float64    `json:"score"`
+	Highlights Highlights `json:"highlights"`
+}
+
+//Highlights holds the title and a snippet of the body of a found post.
+//Both are HTML-escaped with the matching words wrapped in <mark> tags.
+type Highlights struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+}
+
+//recencyWindow sets how fast the recency boost fades: a post this old gets half the boost of a brand new one.
+const recencyWindow = 7 * 24 * time.Hour
+
+//recencyBoost multiplies a post's text relevance by up to 2 for new posts, fading towards 1 as the post ages.
+//The Postgres query in SearchPosts computes the same formula.
+func recencyBoost(created, asOf time.Time) float64 {
+	age := asOf.Sub(created)
+	if age < 0 {
+		age = 0
+	}
+	return 1 + 1/(1+float64(age)/float64(recencyWindow))
+}
+
+//sentinels that ts_headline wraps around matching words.
+//They are private use characters, stripped from posts before highlighting, so the HTML-escaped headline can be marked up safely.
+const (
+	markStart = "\ue000"
+	markStop  = "\ue001"
+)
+
+//markHeadline escapes a ts_headline result and turns its sentinels into <mark> tags.
+func markHeadline(headline string) string {
+	headline = html.EscapeString(headline)
+	headline = strings.Replace(headline, markStart, "<mark>", -1)
+	return strings.Replace(headline, markStop, "</mark>", -1)
+}
+
+//SearchPosts takes a search query, cursor, limit, and viewer and returns the matching posts ranked by relevance and recency, or an error.
+//The posts search column is generated from the title and body, so CreatePost, UpdatePost, and DeletePost keep the index in sync.
+//The cursor's Created fixes the time recency is measured from so scores stay stable across pages; posts newer than it are left out.
+//Shadow-banned users' posts are only found when the viewer wrote them.
+func (db *DB) SearchPosts(query SearchQuery, before Cursor, limit int, viewer uuid.UUID) ([]*PostResult, error) {
+	results := []*PostResult{}
+
+	//show every match in the title and the best fragments of the body
+	titleOptions := fmt.Sprintf("StartSel=%s, StopSel=%s, HighlightAll=true", markStart, markStop)
+	bodyOptions := fmt.Sprintf("StartSel=%s, StopSel=%s, MaxFragments=2, MaxWords=20, MinWords=5, FragmentDelimiter=\" … \"", markStart, markStop)
+
+	rows, err := db.Query(`WITH matches AS (
+		SELECT posts.id, posts.title, posts.body, posts.created, posts.updated, posts.version, posts.uid, q.query,
+			ts_rank_cd(posts.search, q.query, 32) * (1 + 1 / (1 + EXTRACT(EPOCH FROM ($2::timestamptz - posts.created)) / $3)) AS score
+		FROM posts, to_tsquery('english', $1) AS q(query)
+		WHERE posts.search @@ q.query AND NOT posts.hidden AND posts.created <= $2
+	)
+	SELECT matches.id, matches.title, matches.body, matches.created, matches.updated, matches.version, users.id, users.name, users.avatar, matches.score,
+		ts_headline('english', replace(replace(matches.title, $7, ''), $8, ''), matches.query, $9),
+		ts_headline('english', replace(replace(matches.body, $7, ''), $8, ''), matches.query, $10)
+	FROM matches INNER JOIN users ON matches.uid = users.id
+	WHERE (NOT users.shadowbanned OR users.id = $12) AND ($5::uuid = $11 OR (matches.score, matches.id) < ($4, $5))
+	ORDER BY matches.score DESC, matches.id DESC LIMIT $6;`,
+		query.tsquery(), before.Created, recencyWindow.Seconds(), before.Score, before.ID, limit, markStart, markStop, titleOptions, bodyOptions, uuid.Nil, viewer)
+	if err != nil {
+		return results, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		result := &PostResult{}
+		err := rows.Scan(&result.ID, &result.Title, &result.Body, &result.Created, &result.Updated, &result.Version, &result.Author.ID, &result.Author.Name, &result.Author.Avatar, &result.Score, &result.Highlights.Title, &result.Highlights.Body)
+		if err != nil {
+			return results, err
+		}
+		result.Highlights.Title = markHeadline(result.Highlights.Title)
+		result.Highlights.Body = markHeadline(result.Highlights.Body)
+		results = append(results, result)
+	}
+	if err := rows.Err(); err != nil {
+		return results, err
+	}
+
+	return results, nil
+}
@@ -0,0 +1,146 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+//Session type defined
+//Session is a login of a user on a device: the user agent and IP it logged in from, when, when it was last seen,
+//and when its current JWT expires. The session ends when that JWT expires without being refreshed, or when it is deleted.
+type Session struct {
+	ID        uuid.UUID `json:"id"`
+	User      uuid.UUID `json:"-"`
+	UserAgent string    `json:"user_agent"`
+	IP        string    `json:"ip"`
+	Created   time.Time `json:"created"`
+	LastSeen  time.Time `json:"last_seen"`
+	Expires   time.Time `json:"-"`
+}
+
+//Our selection of sample Session methods to satisfy the Datastore interface:
+
+//Session returns the session with the id, or an error.
+//It returns sql.ErrNoRows if there is none, e.g. once it was revoked.
+func (db *DB) Session(id uuid.UUID) (*Session, error) {
+
+	s := &Session{}
+
+	row := db.QueryRow("SELECT id, uid, user_agent, ip, created, last_seen, expires FROM sessions WHERE id = $1;", id)
+	err := row.Scan(&s.ID, &s.User, &s.UserAgent, &s.IP, &s.Created, &s.LastSeen, &s.Expires)
+	if err != nil {
+		return s, err
+	}
+
+	return s, nil
+}
+
+//UserSessions returns a user's sessions that have not ended by the time, most recently seen first, or an error.
+func (db *DB) UserSessions(user uuid.UUID, at time.Time) ([]*Session, error) {
+
+	rows, err := db.Query("SELECT id, uid, user_agent, ip, created, last_seen, expires FROM sessions WHERE uid = $1 AND expires > $2 ORDER BY last_seen DESC, id;", user, at)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*Session{}
+	for rows.Next() {
+		s := &Session{}
+		err := rows.Scan(&s.ID, &s.User, &s.UserAgent, &s.IP, &s.Created, &s.LastSeen, &s.Expires)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+//CreateSession saves a new session, clearing the user's sessions that have ended, and returns nil or an error.
+//CreateSession expects s will come in with id uuid.UUID, user uuid.UUID, userAgent string, ip string, created time.Time, lastSeen time.Time, expires time.Time
+func (db *DB) CreateSession(s *Session) error {
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("DELETE FROM sessions WHERE uid = $1 AND expires <= $2;", s.User, s.Created)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("INSERT INTO sessions (id, uid, user_agent, ip, created, last_seen, expires) VALUES ($1, $2, $3, $4, $5, $6, $7);", s.ID, s.User, s.UserAgent, s.IP, s.Created, s.LastSeen, s.Expires)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+//SeeSession records that the session was seen at the time, and returns nil or an error.
+func (db *DB) SeeSession(id uuid.UUID, seen time.Time) error {
+
+	_, err := db.Exec("UPDATE sessions SET last_seen = $2 WHERE id = $1;", id, seen)
+	return err
+}
+
+//RefreshSession records the expiry of the new JWT the session was refreshed with at the time, and returns nil or an error.
+//It returns sql.ErrNoRows if there is no such session.
+func (db *DB) RefreshSession(id uuid.UUID, seen, expires time.Time) error {
+
+	res, err := db.Exec("UPDATE sessions SET last_seen = $2, expires = $3 WHERE id = $1;", id, seen, expires)
+	if err != nil {
+		return err
+	}
+
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if updated == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+//DeleteSession revokes one of a user's sessions, and returns nil or an error.
+//It returns sql.ErrNoRows if the user has no session with the id.
+func (db *DB) DeleteSession(user, id uuid.UUID) error {
+
+	res, err := db.Exec("DELETE FROM sessions WHERE uid = $1 AND id = $2;", user, id)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if deleted == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+//DeleteOtherSessions revokes all of a user's sessions but the one kept, and returns how many were revoked or an error.
+func (db *DB) DeleteOtherSessions(user, keep uuid.UUID) (int64, error) {
+
+	res, err := db.Exec("DELETE FROM sessions WHERE uid = $1 AND id <> $2;", user, keep)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
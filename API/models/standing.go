@@ -0,0 +1,100 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+	uuid "github.com/satori/go.uuid"
+)
+
+//The actions admins take on an account's standing, recorded beside the moderator actions.
+//Shadow-bans are kept from the user's notices so the user cannot tell they are shadow-banned.
+const (
+	ActionUnsuspend   = "unsuspend"
+	ActionShadowban   = "shadowban"
+	ActionUnshadowban = "unshadowban"
+)
+
+//Standing type defined
+//Standing is whether an account may act: a suspended user is refused at authentication until the suspension ends,
+//and a shadow-banned user's posts are shown to no one but themselves.
+type Standing struct {
+	ID             uuid.UUID  `json:"id"`
+	SuspendedUntil *time.Time `json:"suspended_until,omitempty"`
+	Shadowbanned   bool       `json:"shadowbanned"`
+}
+
+//Suspended reports whether the account is suspended at the given time.
+func (st *Standing) Suspended(at time.Time) bool {
+	return st.SuspendedUntil != nil && st.SuspendedUntil.After(at)
+}
+
+//Our selection of sample Standing methods to satisfy the Datastore interface:
+
+//AccountStanding returns one specific user's standing or an error.
+func (db *DB) AccountStanding(id uuid.UUID) (*Standing, error) {
+
+	standing := &Standing{}
+	until := pq.NullTime{}
+
+	row := db.QueryRow("SELECT id, suspended_until, shadowbanned FROM users WHERE id = $1", id)
+	err := row.Scan(&standing.ID, &until, &standing.Shadowbanned)
+	if err != nil {
+		return standing, err
+	}
+	standing.SuspendedUntil = timePtr(until)
+
+	return standing, nil
+}
+
+//SetStanding changes a user's standing and records the change as a moderator action, in one transaction, and returns nil or an error.
+//SetStanding expects action will come in with id uuid.UUID, moderator uuid.UUID, subject uuid.UUID, action string, note string, created time.Time, and until time.Time to suspend
+//Unlike Moderate, a suspension set here replaces the one in place, and open reports are left for moderators to review.
+//It returns sql.ErrNoRows if there is no such user.
+func (db *DB) SetStanding(action *ModAction) error {
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var update string
+	var value interface{}
+
+	switch action.Action {
+	case ActionSuspend:
+		update, value = "UPDATE users SET suspended_until=$2 WHERE id=$1;", action.Until
+	case ActionUnsuspend:
+		update, value = "UPDATE users SET suspended_until=$2 WHERE id=$1;", nil
+	case ActionShadowban:
+		update, value = "UPDATE users SET shadowbanned=$2 WHERE id=$1;", true
+	case ActionUnshadowban:
+		update, value = "UPDATE users SET shadowbanned=$2 WHERE id=$1;", false
+	}
+
+	res, err := tx.Exec(update, action.Subject, value)
+	if err != nil {
+		return err
+	}
+
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if updated == 0 {
+		return sql.ErrNoRows
+	}
+
+	action.Kind = KindUser
+	action.Target = action.Subject
+
+	_, err = tx.Exec("INSERT INTO moderation_actions (id, moderator, kind, target, subject, action, note, until, created) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9);", action.ID, action.Moderator, action.Kind, action.Target, action.Subject, action.Action, action.Note, action.Until, action.Created)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
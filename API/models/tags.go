@@ -0,0 +1,178 @@
+package models
+
+import (
+	"database/sql"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	uuid "github.com/satori/go.uuid"
+)
+
+//TrendingTag type defined
+//TrendingTag is a tag used within the trending window with the number of posts using it and its trending score.
+type TrendingTag struct {
+	Name  string  `json:"name"`
+	Posts int     `json:"posts"`
+	Score float64 `json:"score"`
+}
+
+//the most tags kept from one post and the longest tag name
+const (
+	maxPostTags  = 10
+	maxTagLength = 50
+)
+
+//rxHashtag matches a # followed by a tag name that starts with a letter, unless the # is part of a word or an HTML entity such as &#39;
+var rxHashtag = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_&#])#([\p{L}_][\p{L}\p{N}_]*)`)
+
+//rxTag matches a whole tag name
+var rxTag = regexp.MustCompile(`^[\p{L}_][\p{L}\p{N}_]*$`)
+
+//ExtractTags returns the hashtags in a post body, lowercased and without duplicates, in the order they first appear.
+//Tags longer than the maximum tag length are skipped and at most ten tags are kept.
+func ExtractTags(body string) []string {
+
+	tags := []string{}
+	seen := map[string]bool{}
+
+	for _, match := range rxHashtag.FindAllStringSubmatch(body, -1) {
+		tag := strings.ToLower(match[1])
+		if seen[tag] || len([]rune(tag)) > maxTagLength {
+			continue
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+		if len(tags) == maxPostTags {
+			break
+		}
+	}
+
+	return tags
+}
+
+//NormalizeTag lowercases a tag name, with or without its leading #, and reports whether it is a valid tag.
+func NormalizeTag(tag string) (string, bool) {
+	tag = strings.ToLower(strings.TrimPrefix(tag, "#"))
+	return tag, rxTag.MatchString(tag) && len([]rune(tag)) <= maxTagLength
+}
+
+//Our selection of sample Tag methods to satisfy the Datastore interface.
+//Each post_tags row copies its post's created time so tag feeds and trending find their rows by one index, joining posts only for those rows.
+
+//TagPosts takes a tag, cursor, limit, and viewer and returns the posts using that tag before the cursor in reverse chronological order or an error.
+func (db *DB) TagPosts(tag string, before Cursor, limit int, viewer uuid.UUID) ([]*Post, error) {
+	posts := []*Post{}
+
+	rows, err := db.Query("SELECT posts.ID, posts.title, posts.body, posts.created, posts.updated, posts.version, users.id, users.name, users.avatar FROM post_tags INNER JOIN posts ON post_tags.post_id = posts.id INNER JOIN users ON posts.uid = users.id WHERE post_tags.tag = $1 AND NOT posts.hidden AND (NOT users.shadowbanned OR users.id = $5) AND (post_tags.created, post_tags.post_id) < ($2, $3) ORDER BY post_tags.created DESC, post_tags.post_id DESC LIMIT $4;", tag, before.Created, before.ID, limit, viewer)
+	if err != nil {
+		return posts, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		post := &Post{}
+		err := rows.Scan(&post.ID, &post.Title, &post.Body, &post.Created, &post.Updated, &post.Version, &post.Author.ID, &post.Author.Name, &post.Author.Avatar)
+		if err != nil {
+			return posts, err
+		}
+		posts = append(posts, post)
+	}
+	if err := rows.Err(); err != nil {
+		return posts, err
+	}
+
+	err = db.attachReactions(posts, viewer)
+	if err != nil {
+		return posts, err
+	}
+
+	err = db.attachTags(posts)
+	if err != nil {
+		return posts, err
+	}
+
+	return posts, nil
+}
+
+//TrendingTags takes a time, window, and limit and returns the tags most used by posts created in the window before that time or an error.
+//Each post counts fully when brand new and fades linearly to nothing as it ages out of the window, so trending follows recent use.
+//Hidden posts and posts by shadow-banned users do not count, as no one else sees them.
+func (db *DB) TrendingTags(asOf time.Time, window time.Duration, limit int) ([]*TrendingTag, error) {
+	tags := []*TrendingTag{}
+
+	rows, err := db.Query("SELECT post_tags.tag, COUNT(*), SUM(1 - EXTRACT(EPOCH FROM ($1::timestamptz - post_tags.created)) / $2) AS score FROM post_tags INNER JOIN posts ON post_tags.post_id = posts.id INNER JOIN users ON posts.uid = users.id WHERE post_tags.created > $1::timestamptz - $2 * INTERVAL '1 second' AND post_tags.created <= $1 AND NOT posts.hidden AND NOT users.shadowbanned GROUP BY post_tags.tag ORDER BY score DESC, post_tags.tag LIMIT $3;", asOf, window.Seconds(), limit)
+	if err != nil {
+		return tags, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		tag := &TrendingTag{}
+		err := rows.Scan(&tag.Name, &tag.Posts, &tag.Score)
+		if err != nil {
+			return tags, err
+		}
+		tags = append(tags, tag)
+	}
+	if err := rows.Err(); err != nil {
+		return tags, err
+	}
+
+	return tags, nil
+}
+
+//syncPostTags makes a post's tags match the given tags, adding any new tag names to the tags table.
+func syncPostTags(tx *sql.Tx, postID uuid.UUID, tags []string) error {
+
+	_, err := tx.Exec("DELETE FROM post_tags WHERE post_id=$1 AND NOT (tag = ANY($2::text[]));", postID, pq.Array(tags))
+	if err != nil {
+		return err
+	}
+
+	if len(tags) == 0 {
+		return nil
+	}
+
+	_, err = tx.Exec("INSERT INTO tags (name) SELECT unnest($1::text[]) ON CONFLICT DO NOTHING;", pq.Array(tags))
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("INSERT INTO post_tags (post_id, tag, created) SELECT posts.id, tag, posts.created FROM posts, unnest($2::text[]) AS tag WHERE posts.id=$1 ON CONFLICT DO NOTHING;", postID, pq.Array(tags))
+	return err
+}
+
+//attachTags fills in the tags of the given posts.
+func (db *DB) attachTags(posts []*Post) error {
+
+	if len(posts) == 0 {
+		return nil
+	}
+
+	byID := make(map[uuid.UUID]*Post, len(posts))
+	ids := make([]string, len(posts))
+	for i, post := range posts {
+		post.Tags = []string{}
+		byID[post.ID] = post
+		ids[i] = post.ID.String()
+	}
+
+	rows, err := db.Query("SELECT post_id, tag FROM post_tags WHERE post_id = ANY($1::uuid[]) ORDER BY tag;", pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var postID uuid.UUID
+		var tag string
+		if err := rows.Scan(&postID, &tag); err != nil {
+			return err
+		}
+		byID[postID].Tags = append(byID[postID].Tags, tag)
+	}
+
+	return rows.Err()
+}
@@ -0,0 +1,121 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+	uuid "github.com/satori/go.uuid"
+)
+
+//AccessToken type defined
+//AccessToken is a personal access token a user created for scripts and other clients to authenticate with in place of their JWT:
+//the name the user gave it, the SHA-256 hash it is stored as, the scopes it grants, when it expires, and when it was last used.
+//The token itself is shown once, when it is created.
+type AccessToken struct {
+	ID       uuid.UUID  `json:"id"`
+	User     uuid.UUID  `json:"-"`
+	Name     string     `json:"name"`
+	Hash     string     `json:"-"`
+	Scopes   []string   `json:"scopes"`
+	Created  time.Time  `json:"created"`
+	Expires  time.Time  `json:"expires"`
+	LastUsed *time.Time `json:"last_used"`
+}
+
+//Expired reports whether the token has expired at the time.
+func (t *AccessToken) Expired(at time.Time) bool {
+	return !at.Before(t.Expires)
+}
+
+//HasScope reports whether the token grants the scope.
+func (t *AccessToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+//Our selection of sample AccessToken methods to satisfy the Datastore interface:
+
+//AccessToken returns the access token stored with the hash, or an error.
+//It returns sql.ErrNoRows if there is none.
+func (db *DB) AccessToken(hash string) (*AccessToken, error) {
+
+	t := &AccessToken{}
+	used := pq.NullTime{}
+
+	row := db.QueryRow("SELECT id, uid, name, hash, scopes, created, expires, last_used FROM access_tokens WHERE hash = $1;", hash)
+	err := row.Scan(&t.ID, &t.User, &t.Name, &t.Hash, pq.Array(&t.Scopes), &t.Created, &t.Expires, &used)
+	if err != nil {
+		return t, err
+	}
+	t.LastUsed = timePtr(used)
+
+	return t, nil
+}
+
+//UserAccessTokens returns a user's access tokens, oldest first, or an error.
+func (db *DB) UserAccessTokens(user uuid.UUID) ([]*AccessToken, error) {
+
+	rows, err := db.Query("SELECT id, uid, name, hash, scopes, created, expires, last_used FROM access_tokens WHERE uid = $1 ORDER BY created, id;", user)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []*AccessToken{}
+	for rows.Next() {
+		t := &AccessToken{}
+		used := pq.NullTime{}
+		err := rows.Scan(&t.ID, &t.User, &t.Name, &t.Hash, pq.Array(&t.Scopes), &t.Created, &t.Expires, &used)
+		if err != nil {
+			return nil, err
+		}
+		t.LastUsed = timePtr(used)
+		tokens = append(tokens, t)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+//CreateAccessToken saves a new access token, and returns nil or an error.
+//CreateAccessToken expects t will come in with id uuid.UUID, user uuid.UUID, name string, hash string, scopes []string, created time.Time, expires time.Time
+func (db *DB) CreateAccessToken(t *AccessToken) error {
+
+	_, err := db.Exec("INSERT INTO access_tokens (id, uid, name, hash, scopes, created, expires) VALUES ($1, $2, $3, $4, $5, $6, $7);", t.ID, t.User, t.Name, t.Hash, pq.Array(t.Scopes), t.Created, t.Expires)
+	return err
+}
+
+//UseAccessToken records that the access token was used at the time, and returns nil or an error.
+func (db *DB) UseAccessToken(id uuid.UUID, used time.Time) error {
+
+	_, err := db.Exec("UPDATE access_tokens SET last_used = $2 WHERE id = $1;", id, used)
+	return err
+}
+
+//DeleteAccessToken revokes one of a user's access tokens, and returns nil or an error.
+//It returns sql.ErrNoRows if the user has no access token with the id.
+func (db *DB) DeleteAccessToken(user, id uuid.UUID) error {
+
+	res, err := db.Exec("DELETE FROM access_tokens WHERE uid = $1 AND id = $2;", user, id)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if deleted == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
@@ -0,0 +1,161 @@
+package models
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+//UserIndex is an in-process trigram index of user names for Datastores without pg_trgm.
+//It matches and ranks like SearchUsers and AutocompleteUsers: a Datastore calls Index when a user is created or renamed,
+//Remove when one is deleted, and answers SearchUsers and AutocompleteUsers with Search and Autocomplete.
+type UserIndex struct {
+	mu sync.RWMutex
+
+	//users by id with the trigrams of their lowercased name
+	users map[uuid.UUID]*indexedUser
+}
+
+type indexedUser struct {
+	user     User
+	name     string
+	trigrams map[string]bool
+}
+
+//similarityThreshold is the similarity a name needs to match without containing the query, the pg_trgm default
+const similarityThreshold = 0.3
+
+//NewUserIndex creates an empty UserIndex
+func NewUserIndex() *UserIndex {
+	return &UserIndex{users: map[uuid.UUID]*indexedUser{}}
+}
+
+//Index adds a user to the index, replacing any earlier version of them.
+func (idx *UserIndex) Index(user *User) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	name := strings.ToLower(user.Name)
+	idx.users[user.ID] = &indexedUser{user: User{ID: user.ID, Name: user.Name, Avatar: user.Avatar, Created: user.Created}, name: name, trigrams: trigrams(name)}
+}
+
+//Remove takes a user out of the index.
+func (idx *UserIndex) Remove(id uuid.UUID) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	delete(idx.users, id)
+}
+
+//Search returns the users whose names resemble the query, best match first, following the cursor rules of SearchUsers.
+func (idx *UserIndex) Search(query string, before Cursor, limit int) []*UserResult {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	query = strings.ToLower(query)
+	queryTrigrams := trigrams(query)
+
+	results := []*UserResult{}
+
+	for id, entry := range idx.users {
+		score := similarity(entry.trigrams, queryTrigrams)
+		if score < similarityThreshold && !strings.Contains(entry.name, query) {
+			continue
+		}
+
+		switch {
+		case entry.name == query:
+			score += exactNameBoost
+		case strings.HasPrefix(entry.name, query):
+			score += prefixNameBoost
+		}
+
+		if !uuid.Equal(before.ID, uuid.Nil) && (score > before.Score || (score == before.Score && bytes.Compare(id.Bytes(), before.ID.Bytes()) >= 0)) {
+			continue
+		}
+
+		results = append(results, &UserResult{User: entry.user, Score: score})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return bytes.Compare(results[i].ID.Bytes(), results[j].ID.Bytes()) > 0
+	})
+
+	if len(results) > limit {
+		results = results[:limit]
+	}
+
+	return results
+}
+
+//Autocomplete returns the users whose names start with the prefix, shortest name first.
+func (idx *UserIndex) Autocomplete(prefix string, limit int) []*User {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	prefix = strings.ToLower(prefix)
+
+	entries := []*indexedUser{}
+	for _, entry := range idx.users {
+		if strings.HasPrefix(entry.name, prefix) {
+			entries = append(entries, entry)
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		//count characters like Postgres length()
+		a, b := utf8.RuneCountInString(entries[i].name), utf8.RuneCountInString(entries[j].name)
+		if a != b {
+			return a < b
+		}
+		return entries[i].name < entries[j].name
+	})
+
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+
+	users := []*User{}
+	for _, entry := range entries {
+		user := entry.user
+		users = append(users, &user)
+	}
+
+	return users
+}
+
+//trigrams splits a lowercased string into the set of trigrams pg_trgm would:
+//each run of letters and numbers is padded with two spaces in front and one behind, then cut into every three-character window.
+func trigrams(s string) map[string]bool {
+	set := map[string]bool{}
+	for _, word := range tokenize(s) {
+		padded := []rune("  " + word + " ")
+		for i := 0; i+3 <= len(padded); i++ {
+			set[string(padded[i:i+3])] = true
+		}
+	}
+	return set
+}
+
+//similarity is the share of trigrams two sets have in common, as pg_trgm's similarity function measures it.
+func similarity(a, b map[string]bool) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+
+	shared := 0
+	for trigram := range a {
+		if b[trigram] {
+			shared++
+		}
+	}
+
+	return float64(shared) / float64(len(a)+len(b)-shared)
+}
@@ -0,0 +1,128 @@
+package models
+
+import (
+	"math"
+	"testing"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+func TestUserIndex(t *testing.T) {
+
+	idx := NewUserIndex()
+
+	//index adds a user with the name and returns their id
+	index := func(name string) uuid.UUID {
+		id := newID(t)
+		idx.Index(&User{ID: id, Name: name, Email: name + "@example.com"})
+		return id
+	}
+
+	exact := index("Gopher")
+	prefix := index("Gophers")
+	similar := index("The Gopher")
+	contains := index("supergopherfan")
+	index("Unrelated")
+
+	//search returns the users found for the query, best first, from the first page
+	search := func(q string) []*UserResult {
+		return idx.Search(q, Cursor{}, 10)
+	}
+
+	//exact names rank first, then prefixes, then by similarity, and names containing the query are found however dissimilar
+	got := search("GOPHER")
+	want := []uuid.UUID{exact, prefix, similar, contains}
+	if len(got) != len(want) {
+		t.Fatalf("search returned wrong users:\ngot: %v\nwant: %v", len(got), len(want))
+	}
+	for i := range want {
+		if !uuid.Equal(got[i].ID, want[i]) {
+			t.Errorf("search ranked wrongly at %v:\ngot: %v\nwant: %v", i, got[i].Name, want[i])
+		}
+	}
+	if got[0].Email != "" {
+		t.Errorf("search returned a user's email: %v", got[0].Email)
+	}
+	if got[3].Score >= similarityThreshold {
+		t.Errorf("name containing the query scored as similar: %v", got[3].Score)
+	}
+
+	//similar names are found without containing the query
+	if got := search("gophre"); len(got) == 0 || !uuid.Equal(got[0].ID, exact) {
+		t.Errorf("search for a misspelling found wrong users: %v", got)
+	}
+
+	//pages follow each other by (score, id) without repeating or skipping users
+	tied := map[uuid.UUID]bool{}
+	for i := 0; i < 5; i++ {
+		tied[index("Tie")] = true
+	}
+	seen := map[uuid.UUID]bool{}
+	before := Cursor{}
+	for page := 0; page < 5; page++ {
+		results := idx.Search("tie", before, 2)
+		if len(results) == 0 {
+			break
+		}
+		for _, result := range results {
+			if seen[result.ID] || !tied[result.ID] {
+				t.Errorf("user %v repeated or wrongly found on page %v", result.Name, page)
+			}
+			seen[result.ID] = true
+		}
+		last := results[len(results)-1]
+		before = Cursor{Score: last.Score, ID: last.ID}
+	}
+	if len(seen) != len(tied) {
+		t.Errorf("pages found wrong number of users:\ngot: %v\nwant: %v", len(seen), len(tied))
+	}
+
+	//autocomplete finds names starting with the prefix, shortest first counting characters, then by name
+	idx = NewUserIndex()
+	for _, name := range []string{"Gox", "Gö", "Go", "Ago"} {
+		index(name)
+	}
+	users := idx.Autocomplete("G", 10)
+	names := []string{}
+	for _, user := range users {
+		names = append(names, user.Name)
+	}
+	if len(names) != 3 || names[0] != "Go" || names[1] != "Gö" || names[2] != "Gox" {
+		t.Errorf("autocomplete returned wrong users:\ngot: %v\nwant: %v", names, []string{"Go", "Gö", "Gox"})
+	}
+	if users := idx.Autocomplete("g", 1); len(users) != 1 {
+		t.Errorf("autocomplete returned more users than the limit: %v", len(users))
+	}
+
+	//reindexing renames a user and removing takes them out
+	renamed := index("Before")
+	idx.Index(&User{ID: renamed, Name: "After"})
+	if users := idx.Autocomplete("before", 10); len(users) != 0 {
+		t.Errorf("autocomplete found a user's old name: %v", users)
+	}
+	idx.Remove(renamed)
+	if users := idx.Autocomplete("after", 10); len(users) != 0 {
+		t.Errorf("autocomplete found a removed user: %v", users)
+	}
+}
+
+func TestTrigrams(t *testing.T) {
+
+	got := trigrams("cat")
+	for _, trigram := range []string{"  c", " ca", "cat", "at "} {
+		if !got[trigram] {
+			t.Errorf("trigrams of cat missed %q: %v", trigram, got)
+		}
+	}
+	if len(got) != 4 {
+		t.Errorf("trigrams of cat returned wrongly: %v", got)
+	}
+
+	//as pg_trgm: similarity('gopher', 'gophers') = 6 shared / 9 in either
+	if got := similarity(trigrams("gopher"), trigrams("gophers")); math.Abs(got-6.0/9.0) > 1e-9 {
+		t.Errorf("similarity returned wrongly:\ngot: %v\nwant: %v", got, 6.0/9.0)
+	}
+	if got := similarity(trigrams(""), trigrams("gopher")); got != 0 {
+		t.Errorf("similarity to nothing returned wrongly: %v", got)
+	}
+}
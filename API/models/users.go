@@ -1,8 +1,10 @@
 package models
 
 import (
+	"strings"
 	"time"
 
+	"github.com/lib/pq"
 	uuid "github.com/satori/go.uuid"
 )
 
@@ -15,15 +17,63 @@ type User struct {
 	Avatar   string    `json:"avatar"`
 	Created  time.Time `json:"created,omitempty"`
 	Updated  time.Time `json:"updated,omitempty"`
+
+	//Version counts the saved edits of the profile, starting at 1. UpdateUserPhoto only applies an edit made to the current version.
+	Version int `json:"version,omitempty"`
+
+	//Roles are the roles the user holds, always including RoleUser. They are carried in the user's JWT.
+	Roles []string `json:"roles,omitempty"`
 }
 
 //Our selection of sample User methods to satisfy the Datastore interface:
 
-//SearchUsers takes a search query and limit and returns all posts in reverse chronological order or an error.
-func (db *DB) SearchUsers(query, prevDate string, limit int) ([]*User, error) {
+//UserResult type defined
+//UserResult is a user found by a search along with its ranking score.
+type UserResult struct {
+	User
+	Score float64 `json:"score"`
+}
+
+//boosts added to a user's name similarity when the name is the query exactly or starts with it
+const (
+	exactNameBoost  = 2
+	prefixNameBoost = 1
+)
+
+//SearchUsers takes a search query, cursor, and limit and returns the users whose names resemble the query, best match first, or an error.
+//Names are ranked by trigram similarity to the query, with exact and prefix matches boosted to the top; names containing the query are always found.
+//Ranked results page by (score, id): the cursor's Score and ID mark the last user of the previous page.
+func (db *DB) SearchUsers(query string, before Cursor, limit int) ([]*UserResult, error) {
+	users := []*UserResult{}
+
+	query = strings.ToLower(query)
+
+	rows, err := db.Query("SELECT id, name, avatar, created, score FROM (SELECT id, name, avatar, created, similarity(lower(name), $1) + CASE WHEN lower(name) = $1 THEN $2 WHEN lower(name) LIKE $3 || '%' THEN $4 ELSE 0 END AS score FROM users WHERE lower(name) % $1 OR lower(name) LIKE '%' || $3 || '%') AS matches WHERE $6::uuid = $8 OR (score, id) < ($5, $6) ORDER BY score DESC, id DESC LIMIT $7", query, exactNameBoost, escapeLike(query), prefixNameBoost, before.Score, before.ID, limit, uuid.Nil)
+	if err != nil {
+		return users, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		user := &UserResult{}
+		err := rows.Scan(&user.ID, &user.Name, &user.Avatar, &user.Created, &user.Score)
+		if err != nil {
+			return users, err
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return users, err
+	}
+
+	return users, nil
+}
+
+//AutocompleteUsers takes a name prefix and limit and returns the users whose names start with it, shortest name first, or an error.
+func (db *DB) AutocompleteUsers(prefix string, limit int) ([]*User, error) {
 	users := []*User{}
 
-	rows, err := db.Query("SELECT id, name, avatar, created FROM users WHERE name ILIKE '%' || $1 || '%' AND created < $2 ORDER BY created DESC LIMIT $3", query, prevDate, limit)
+	rows, err := db.Query("SELECT id, name, avatar FROM users WHERE lower(name) LIKE $1 || '%' ORDER BY length(name), lower(name) LIMIT $2", escapeLike(strings.ToLower(prefix)), limit)
 	if err != nil {
 		return users, err
 	}
@@ -31,7 +81,7 @@ func (db *DB) SearchUsers(query, prevDate string, limit int) ([]*User, error) {
 
 	for rows.Next() {
 		user := &User{}
-		err := rows.Scan(&user.ID, &user.Name, &user.Avatar, &user.Created)
+		err := rows.Scan(&user.ID, &user.Name, &user.Avatar)
 		if err != nil {
 			return users, err
 		}
@@ -44,15 +94,24 @@ func (db *DB) SearchUsers(query, prevDate string, limit int) ([]*User, error) {
 	return users, nil
 }
 
+//escapeLike escapes the Postgres LIKE wildcard characters in s
+func escapeLike(s string) string {
+	s = strings.Replace(s, "\\", "\\\\", -1)
+	s = strings.Replace(s, "%", "\\%", -1)
+	return strings.Replace(s, "_", "\\_", -1)
+}
+
 //CreateUser creates a new user and returns nil or an error
 //CreateUser expects user will come in with name string, email string, pwd []byte
 func (db *DB) CreateUser(user *User) error {
 
-	_, err := db.Exec("INSERT INTO users (id, name, email, password, avatar, created, updated) VALUES ($1, $2, $3, $4, $5, $6, $7)", user.ID, user.Name, user.Email, user.Password, user.Avatar, user.Created, user.Updated)
+	_, err := db.Exec("INSERT INTO users (id, name, email, password, avatar, created, updated, version, roles) VALUES ($1, $2, $3, $4, $5, $6, $7, 1, $8)", user.ID, user.Name, user.Email, user.Password, user.Avatar, user.Created, user.Updated, pq.Array([]string{RoleUser}))
 	if err != nil {
 		return err
 	}
 
+	user.Version = 1
+	user.Roles = []string{RoleUser}
 	return nil
 }
 
@@ -70,6 +129,63 @@ func (db *DB) EmailCheck(email string) (bool, error) {
 	return exists, err
 }
 
+//UserByEmail returns the user with the email, including the password hash and roles, for logging in, or an error.
+//It returns sql.ErrNoRows if no user has the email.
+func (db *DB) UserByEmail(email string) (*User, error) {
+
+	user := &User{}
+
+	row := db.QueryRow("SELECT id, name, email, password, roles FROM users WHERE email = $1;", email)
+	err := row.Scan(&user.ID, &user.Name, &user.Email, &user.Password, pq.Array(&user.Roles))
+	if err != nil {
+		return user, err
+	}
+
+	return user, nil
+}
+
+//UserByID returns the user with the id, including their email and roles, for finishing two-factor logins, or an error.
+//It returns sql.ErrNoRows if there is no such user.
+func (db *DB) UserByID(id uuid.UUID) (*User, error) {
+
+	user := &User{}
+
+	row := db.QueryRow("SELECT id, name, email, roles FROM users WHERE id = $1;", id)
+	err := row.Scan(&user.ID, &user.Name, &user.Email, pq.Array(&user.Roles))
+	if err != nil {
+		return user, err
+	}
+
+	return user, nil
+}
+
+//UserPassword returns a user's password hash, for checking their current password before changing it, or an error.
+//It returns sql.ErrNoRows if there is no such user.
+func (db *DB) UserPassword(id uuid.UUID) (string, error) {
+
+	var password string
+
+	row := db.QueryRow("SELECT password FROM users WHERE id = $1;", id)
+	err := row.Scan(&password)
+	if err != nil {
+		return password, err
+	}
+
+	return password, nil
+}
+
+//UpdatePassword replaces a user's password hash and returns nil or an error.
+//UpdatePassword expects id uuid.UUID and password string, the new hash
+func (db *DB) UpdatePassword(id uuid.UUID, password string) error {
+
+	_, err := db.Exec("UPDATE users SET password=$2 WHERE id=$1;", id, password)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
 //NameCheck checks if a name is already in use when a new user signs up
 func (db *DB) NameCheck(name string) (bool, error) {
 
@@ -84,31 +200,84 @@ func (db *DB) NameCheck(name string) (bool, error) {
 }
 
 //UpdateUserPhoto updates a user's profile photo and returns nil or an error.
-//UpdateUserPhoto expects user will come in with avatar string, updated time.Time
+//UpdateUserPhoto expects user will come in with avatar string, updated time.Time, version int
+//It returns ErrVersionConflict without changing anything if the profile is no longer at that version, and otherwise leaves user at its new version.
 func (db *DB) UpdateUserPhoto(user *User) error {
 
-	_, err := db.Exec("UPDATE users SET avatar=$2, updated=$3 WHERE id=$1;", user.ID, user.Avatar, user.Updated)
+	res, err := db.Exec("UPDATE users SET avatar=$2, updated=$3, version = version + 1 WHERE id=$1 AND version=$4;", user.ID, user.Avatar, user.Updated, user.Version)
+	if err != nil {
+		return err
+	}
+
+	updated, err := res.RowsAffected()
 	if err != nil {
 		return err
 	}
 
+	if updated == 0 {
+		return ErrVersionConflict
+	}
+
+	user.Version++
 	return nil
 
 }
 
-//DeleteUser deletes one specific user from DB, along with associated posts, and returns nil or an error.
+//DeleteUser deletes one specific user from DB, along with associated posts, comments, reactions, and follows, and returns nil or an error.
 //DeleteUser expects user will come in with id uuid.UUID
 func (db *DB) DeleteUser(user *User) error {
 
-	_, err := db.Exec("DELETE FROM posts WHERE uid=$1;", user.ID)
+	//delete in one transaction so reaction counts on other users' posts stay in step
+	tx, err := db.Begin()
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	_, err = db.Exec("DELETE FROM users WHERE id=$1;", user.ID)
+	err = deleteUserReactions(tx, user.ID)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	err = deleteUserFollows(tx, user.ID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM comments WHERE uid=$1 OR post_id IN (SELECT id FROM posts WHERE uid=$1);", user.ID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM post_reactions WHERE post_id IN (SELECT id FROM posts WHERE uid=$1);", user.ID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM reaction_counts WHERE post_id IN (SELECT id FROM posts WHERE uid=$1);", user.ID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM post_tags WHERE post_id IN (SELECT id FROM posts WHERE uid=$1);", user.ID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM post_revisions WHERE uid=$1 OR post_id IN (SELECT id FROM posts WHERE uid=$1);", user.ID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM posts WHERE uid=$1;", user.ID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM users WHERE id=$1;", user.ID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
@@ -0,0 +1,124 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"net/http"
+	"time"
+)
+
+//jwk is the part of a JSON Web Key read (RFC 7517): RSA keys and P-256 EC keys for signatures
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+//key returns the provider's signing key with the ID, or its only key for tokens naming none.
+//Keys are cached, and fetched again when a token names an unknown key, as providers rotate them.
+func (p *Provider) key(ctx context.Context, kid string) (interface{}, error) {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if k := p.cachedKey(kid); k != nil {
+		return k, nil
+	}
+
+	if time.Since(p.fetched) < keyRefresh {
+		return nil, ErrUnknownKey
+	}
+
+	keys, err := p.fetchKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p.keys, p.fetched = keys, time.Now()
+
+	if k := p.cachedKey(kid); k != nil {
+		return k, nil
+	}
+
+	return nil, ErrUnknownKey
+}
+
+//cachedKey returns the cached key with the ID, or the only cached key for an empty ID. The caller holds the lock.
+func (p *Provider) cachedKey(kid string) interface{} {
+
+	if kid == "" && len(p.keys) == 1 {
+		for _, k := range p.keys {
+			return k
+		}
+	}
+
+	return p.keys[kid]
+}
+
+//fetchKeys fetches the provider's signing keys by ID, skipping keys of kinds this package does not verify with
+func (p *Provider) fetchKeys(ctx context.Context) (map[string]interface{}, error) {
+
+	req, err := http.NewRequestWithContext(ctx, "GET", p.JWKSURI, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	set := struct {
+		Keys []jwk `json:"keys"`
+	}{}
+	if err := fetchJSON(req, &set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]interface{})
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if key := k.publicKey(); key != nil {
+			keys[k.Kid] = key
+		}
+	}
+
+	return keys, nil
+}
+
+//publicKey returns the key, or nil if it is malformed or of a kind this package does not verify with
+func (k *jwk) publicKey() interface{} {
+
+	decode := func(s string) []byte {
+		b, _ := base64.RawURLEncoding.DecodeString(s)
+		return b
+	}
+
+	switch k.Kty {
+	case "RSA":
+		n, e := new(big.Int).SetBytes(decode(k.N)), new(big.Int).SetBytes(decode(k.E))
+		if n.BitLen() < 2048 || !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}
+
+	case "EC":
+		x, y := decode(k.X), decode(k.Y)
+		if k.Crv != "P-256" || len(x) != 32 || len(y) != 32 {
+			return nil
+		}
+		//parsing the uncompressed point checks it is on the curve
+		key, err := ecdsa.ParseUncompressedPublicKey(elliptic.P256(), append(append([]byte{4}, x...), y...))
+		if err != nil {
+			return nil
+		}
+		return key
+	}
+
+	return nil
+}
@@ -0,0 +1,328 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+//Leeway is the clock skew allowed between the server and providers when checking when ID tokens were issued and expire
+const Leeway = time.Minute
+
+//DefaultScopes are the scopes asked for when a provider is configured without any: the user's ID, email, and name
+var DefaultScopes = []string{"openid", "email", "profile"}
+
+//Client makes the requests to providers. Its timeout bounds every discovery, token, and key request.
+var Client = &http.Client{Timeout: 10 * time.Second}
+
+//The errors returned for ID tokens that fail verification
+var (
+	ErrInvalidToken = errors.New("oidc: invalid ID token")
+	ErrIssuer       = errors.New("oidc: ID token from another issuer")
+	ErrAudience     = errors.New("oidc: ID token for another client")
+	ErrNonce        = errors.New("oidc: wrong nonce")
+	ErrExpired      = errors.New("oidc: ID token expired")
+	ErrUnknownKey   = errors.New("oidc: ID token signed with an unknown key")
+)
+
+//keyRefresh is how soon after fetching a provider's keys they may be fetched again for a token signed with an unknown key,
+//so tokens with made up key IDs cannot make the server hammer the provider
+const keyRefresh = time.Minute
+
+//Provider type defined
+//Provider is an OpenID Connect provider users log in with: its name in URLs, its issuer, the client credentials it issued this server,
+//the URL it redirects users back to, and the scopes asked for. NewProvider fills in the endpoints from the provider's discovery document.
+type Provider struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+
+	AuthorizationEndpoint string
+	TokenEndpoint         string
+	JWKSURI               string
+
+	mu      sync.Mutex
+	keys    map[string]interface{}
+	fetched time.Time
+}
+
+//Claims are the claims of a verified ID token identifying the user
+type Claims struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+//discovery is the part of a provider's discovery document read (OpenID Connect Discovery §3)
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+//tokenResponse is the part of a token endpoint's response read, or its error (RFC 6749 §5)
+type tokenResponse struct {
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+//NewProvider returns the provider at the issuer, with its endpoints from its discovery document.
+func NewProvider(ctx context.Context, name, issuer, clientID, clientSecret, redirectURL string) (*Provider, error) {
+
+	p := &Provider{Name: name, Issuer: strings.TrimSuffix(issuer, "/"), ClientID: clientID, ClientSecret: clientSecret, RedirectURL: redirectURL, Scopes: DefaultScopes}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", p.Issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	d := discovery{}
+	if err := fetchJSON(req, &d); err != nil {
+		return nil, fmt.Errorf("oidc: discovering %v: %v", name, err)
+	}
+
+	//a document naming another issuer could be an attacker's
+	if d.Issuer != p.Issuer {
+		return nil, fmt.Errorf("oidc: discovering %v: document for issuer %q", name, d.Issuer)
+	}
+
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, fmt.Errorf("oidc: discovering %v: missing endpoints", name)
+	}
+
+	p.AuthorizationEndpoint, p.TokenEndpoint, p.JWKSURI = d.AuthorizationEndpoint, d.TokenEndpoint, d.JWKSURI
+
+	return p, nil
+}
+
+//NewSecret returns a random value for a state, nonce, or PKCE code verifier, base64url encoded.
+func NewSecret() (string, error) {
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+//CodeChallenge returns the S256 PKCE code challenge for the code verifier (RFC 7636 §4.2)
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+//AuthCodeURL returns the URL to send the user to to log in with the provider. The provider sends them back to the redirect URL
+//with a code and the state; the ID token it then issues carries the nonce, and the code is only exchanged with the verifier.
+func (p *Provider) AuthCodeURL(state, nonce, verifier string) string {
+
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", p.ClientID)
+	v.Set("redirect_uri", p.RedirectURL)
+	v.Set("scope", strings.Join(p.Scopes, " "))
+	v.Set("state", state)
+	v.Set("nonce", nonce)
+	v.Set("code_challenge", CodeChallenge(verifier))
+	v.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(p.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+
+	return p.AuthorizationEndpoint + sep + v.Encode()
+}
+
+//Exchange exchanges the code the provider sent the user back with for their ID token, proving with the verifier
+//that this server asked for the code, and verifies the token.
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (*Claims, error) {
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.RedirectURL)
+	form.Set("code_verifier", verifier)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", p.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	//client credentials are form encoded before going in the basic auth header (RFC 6749 §2.3.1)
+	req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+
+	res, err := Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	token := tokenResponse{}
+	err = json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&token)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: token response: %v", err)
+	}
+
+	if res.StatusCode != http.StatusOK || token.Error != "" {
+		return nil, fmt.Errorf("oidc: token request refused: %v %v %v", res.StatusCode, token.Error, token.ErrorDescription)
+	}
+
+	if token.IDToken == "" {
+		return nil, errors.New("oidc: no ID token in token response")
+	}
+
+	return p.Verify(ctx, token.IDToken, nonce)
+}
+
+//idTokenClaims are the claims of an ID token checked (OpenID Connect Core §3.1.3.7). The audience may be a string or a list.
+type idTokenClaims struct {
+	Issuer        string       `json:"iss"`
+	Subject       string       `json:"sub"`
+	Audience      audience     `json:"aud"`
+	AuthorizedBy  string       `json:"azp"`
+	ExpiresAt     int64        `json:"exp"`
+	IssuedAt      int64        `json:"iat"`
+	Nonce         string       `json:"nonce"`
+	Email         string       `json:"email"`
+	EmailVerified flexibleBool `json:"email_verified"`
+	Name          string       `json:"name"`
+}
+
+//audience is an ID token's audience, sent as one string or a list of them
+type audience []string
+
+//UnmarshalJSON reads either form of audience
+func (a *audience) UnmarshalJSON(b []byte) error {
+
+	var one string
+	if err := json.Unmarshal(b, &one); err == nil {
+		*a = audience{one}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(b, &list); err != nil {
+		return err
+	}
+	*a = list
+
+	return nil
+}
+
+//flexibleBool is a boolean claim some providers send as the string "true" or "false"
+type flexibleBool bool
+
+//UnmarshalJSON reads a boolean or its string
+func (f *flexibleBool) UnmarshalJSON(b []byte) error {
+
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*f = s == "true"
+		return nil
+	}
+
+	var v bool
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	*f = flexibleBool(v)
+
+	return nil
+}
+
+//Valid checks when the token was issued and expires, allowing Leeway for clock skew
+func (c *idTokenClaims) Valid() error {
+
+	now := time.Now()
+
+	if c.ExpiresAt == 0 || now.After(time.Unix(c.ExpiresAt, 0).Add(Leeway)) {
+		return ErrExpired
+	}
+
+	if c.IssuedAt == 0 || now.Add(Leeway).Before(time.Unix(c.IssuedAt, 0)) {
+		return ErrInvalidToken
+	}
+
+	return nil
+}
+
+//Verify checks an ID token was signed by the provider for this client, has not expired, and carries the nonce, and returns its claims.
+//Only asymmetric algorithms are accepted: a token "signed" with none or with the client secret is refused.
+func (p *Provider) Verify(ctx context.Context, idToken, nonce string) (*Claims, error) {
+
+	parser := &jwt.Parser{ValidMethods: []string{"RS256", "ES256"}}
+
+	claims := &idTokenClaims{}
+	_, err := parser.ParseWithClaims(idToken, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return p.key(ctx, kid)
+	})
+	if err != nil {
+		//errors from the key lookup and claims checks come back wrapped
+		if v, ok := err.(*jwt.ValidationError); ok && v.Inner != nil {
+			switch v.Inner {
+			case ErrExpired, ErrUnknownKey:
+				return nil, v.Inner
+			}
+		}
+		return nil, ErrInvalidToken
+	}
+
+	if claims.Issuer != p.Issuer {
+		return nil, ErrIssuer
+	}
+
+	audienced := false
+	for _, aud := range claims.Audience {
+		audienced = audienced || aud == p.ClientID
+	}
+	if !audienced || (claims.AuthorizedBy != "" && claims.AuthorizedBy != p.ClientID) || (len(claims.Audience) > 1 && claims.AuthorizedBy == "") {
+		return nil, ErrAudience
+	}
+
+	if nonce == "" || subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1 {
+		return nil, ErrNonce
+	}
+
+	if claims.Subject == "" {
+		return nil, ErrInvalidToken
+	}
+
+	return &Claims{Subject: claims.Subject, Email: claims.Email, EmailVerified: bool(claims.EmailVerified), Name: claims.Name}, nil
+}
+
+//fetchJSON sends the request and decodes the JSON response into v
+func fetchJSON(req *http.Request, v interface{}) error {
+
+	res, err := Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %v", res.StatusCode)
+	}
+
+	return json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(v)
+}
@@ -0,0 +1,162 @@
+package oidc
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chiips/snippets/API/oidc/oidctest"
+	"github.com/dgrijalva/jwt-go"
+)
+
+//login runs the authorization code flow against the mock provider, returning the verified claims or the error
+func login(t *testing.T, p *Provider, mock *oidctest.Server) (*Claims, error) {
+
+	t.Helper()
+
+	state, _ := NewSecret()
+	nonce, _ := NewSecret()
+	verifier, _ := NewSecret()
+
+	back, err := mock.Authorize(p.AuthCodeURL(state, nonce, verifier))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if back.Query().Get("state") != state {
+		t.Fatalf("provider sent back wrong state: %v", back)
+	}
+
+	return p.Exchange(context.Background(), back.Query().Get("code"), verifier, nonce)
+}
+
+func TestProvider(t *testing.T) {
+
+	mock := oidctest.NewServer("client", "secret&more")
+	defer mock.Close()
+
+	p, err := NewProvider(context.Background(), "mock", mock.URL+"/", "client", "secret&more", "https://example.com/api/oidc/mock/callback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Issuer != mock.URL || p.TokenEndpoint != mock.URL+"/token" {
+		t.Fatalf("provider discovered wrongly: %+v", p)
+	}
+
+	u, err := url.Parse(p.AuthCodeURL("state", "nonce", "verifier"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("code_challenge") != CodeChallenge("verifier") || q.Get("code_challenge_method") != "S256" || q.Get("scope") != "openid email profile" || q.Get("nonce") != "nonce" {
+		t.Errorf("authorization URL wrong: %v", u)
+	}
+
+	claims, err := login(t, p, mock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "subject-1" || claims.Email != "user@provider.example" || !claims.EmailVerified || claims.Name != "Provider User" {
+		t.Errorf("claims wrong: %+v", claims)
+	}
+
+	//the provider's keys are fetched again once they rotate, but not more than once per keyRefresh
+	if err := mock.RotateKey("ES256"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := login(t, p, mock); err != ErrUnknownKey {
+		t.Errorf("token signed with new key soon after fetching returned wrong error:\ngot: %v\nwant: %v", err, ErrUnknownKey)
+	}
+	p.fetched = time.Now().Add(-keyRefresh)
+	if _, err := login(t, p, mock); err != nil {
+		t.Errorf("token signed with rotated ES256 key refused: %v", err)
+	}
+
+	//tokens that are not for this client, this login, or now are refused
+	for name, c := range map[string]struct {
+		claims map[string]interface{}
+		want   error
+	}{
+		"another audience":         {map[string]interface{}{"aud": "other"}, ErrAudience},
+		"several audiences":        {map[string]interface{}{"aud": []string{"client", "other"}}, ErrAudience},
+		"several audiences by azp": {map[string]interface{}{"aud": []string{"client", "other"}, "azp": "client"}, nil},
+		"another authorized party": {map[string]interface{}{"azp": "other"}, ErrAudience},
+		"another issuer":           {map[string]interface{}{"iss": "https://evil.example"}, ErrIssuer},
+		"another nonce":            {map[string]interface{}{"nonce": "other"}, ErrNonce},
+		"no nonce":                 {map[string]interface{}{"nonce": nil}, ErrNonce},
+		"expired":                  {map[string]interface{}{"exp": time.Now().Add(-2 * Leeway).Unix()}, ErrExpired},
+		"issued in the future":     {map[string]interface{}{"iat": time.Now().Add(2 * Leeway).Unix()}, ErrInvalidToken},
+		"no subject":               {map[string]interface{}{"sub": nil}, ErrInvalidToken},
+		"string email_verified":    {map[string]interface{}{"email_verified": "true"}, nil},
+	} {
+		mock.Claims = c.claims
+		if _, err := login(t, p, mock); err != c.want {
+			t.Errorf("token with %v returned wrong error:\ngot: %v\nwant: %v", name, err, c.want)
+		}
+	}
+	mock.Claims = nil
+
+	//codes are exchanged once, and only with their verifier
+	state, _ := NewSecret()
+	nonce, _ := NewSecret()
+	verifier, _ := NewSecret()
+	back, err := mock.Authorize(p.AuthCodeURL(state, nonce, verifier))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Exchange(context.Background(), back.Query().Get("code"), "wrong verifier", nonce); err == nil || !strings.Contains(err.Error(), "invalid_grant") {
+		t.Errorf("exchange with wrong verifier returned wrong error: %v", err)
+	}
+	if _, err := p.Exchange(context.Background(), back.Query().Get("code"), verifier, nonce); err == nil {
+		t.Errorf("code exchanged after a failed exchange")
+	}
+
+	wrongSecret, err := NewProvider(context.Background(), "mock", mock.URL, "client", "wrong", p.RedirectURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := login(t, wrongSecret, mock); err == nil || !strings.Contains(err.Error(), "invalid_client") {
+		t.Errorf("exchange with wrong client secret returned wrong error: %v", err)
+	}
+}
+
+func TestVerifyAlgorithms(t *testing.T) {
+
+	mock := oidctest.NewServer("client", "secret")
+	defer mock.Close()
+
+	p, err := NewProvider(context.Background(), "mock", mock.URL, "client", "secret", "https://example.com/callback")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims := jwt.MapClaims{"iss": mock.URL, "aud": "client", "sub": "subject-1", "nonce": "nonce", "iat": time.Now().Unix(), "exp": time.Now().Add(time.Minute).Unix()}
+
+	//tokens signed with no key, or with the client secret as an HMAC key, are refused
+	unsigned, err := jwt.NewWithClaims(jwt.SigningMethodNone, claims).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hmac, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, token := range map[string]string{"none": unsigned, "HS256": hmac, "garbage": "a.b.c"} {
+		if _, err := p.Verify(context.Background(), token, "nonce"); err != ErrInvalidToken {
+			t.Errorf("token signed with %v returned wrong error:\ngot: %v\nwant: %v", name, err, ErrInvalidToken)
+		}
+	}
+}
+
+func TestDiscovery(t *testing.T) {
+
+	mock := oidctest.NewServer("client", "secret")
+	defer mock.Close()
+
+	//a document naming another issuer is refused
+	if _, err := NewProvider(context.Background(), "mock", mock.URL+"/other", "client", "secret", ""); err == nil {
+		t.Errorf("provider with wrong issuer discovered")
+	}
+}
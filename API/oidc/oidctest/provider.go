@@ -0,0 +1,259 @@
+package oidctest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+//Server type defined
+//Server is an in-process OpenID Connect provider for testing relying parties. It serves discovery, authorization with PKCE,
+//token, and key endpoints at its URL, which is its issuer. Authorization answers at once for the user set in its fields,
+//as if they were logged in at the provider and consented.
+type Server struct {
+	*httptest.Server
+
+	ClientID     string
+	ClientSecret string
+
+	//the user logged in at the provider, whom codes are issued for
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+
+	//Claims are set in the ID tokens issued next, replacing the provider's; a nil value leaves the claim out.
+	//They make tokens that should be refused: e.g. for another audience, with another nonce, or expired.
+	Claims map[string]interface{}
+
+	mu     sync.Mutex
+	alg    string
+	key    interface{}
+	kid    string
+	public map[string]interface{}
+	codes  map[string]*grant
+}
+
+//grant is an authorization code and what it was issued for
+type grant struct {
+	redirectURI string
+	nonce       string
+	challenge   string
+	claims      jwt.MapClaims
+}
+
+//NewServer starts a provider for the client with the credentials, signing with an RS256 key. Close it when done.
+func NewServer(clientID, clientSecret string) *Server {
+
+	s := &Server{ClientID: clientID, ClientSecret: clientSecret, Subject: "subject-1", Email: "user@provider.example", EmailVerified: true, Name: "Provider User", codes: make(map[string]*grant)}
+
+	if err := s.RotateKey("RS256"); err != nil {
+		panic(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", s.discovery)
+	mux.HandleFunc("/authorize", s.authorize)
+	mux.HandleFunc("/token", s.token)
+	mux.HandleFunc("/jwks", s.jwks)
+	s.Server = httptest.NewServer(mux)
+
+	return s
+}
+
+//RotateKey replaces the provider's signing key with a new one for the algorithm, RS256 or ES256, published in place of the old.
+func (s *Server) RotateKey(alg string) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kid := strconv.FormatInt(time.Now().UnixNano(), 36)
+
+	switch alg {
+	case "RS256":
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return err
+		}
+		s.key = key
+		s.public = map[string]interface{}{"kty": "RSA", "kid": kid, "use": "sig", "alg": alg,
+			"n": encode(key.N.Bytes()), "e": encode(big.NewInt(int64(key.E)).Bytes())}
+	case "ES256":
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return err
+		}
+		point, err := key.PublicKey.Bytes()
+		if err != nil {
+			return err
+		}
+		s.key = key
+		s.public = map[string]interface{}{"kty": "EC", "kid": kid, "use": "sig", "alg": alg, "crv": "P-256",
+			"x": encode(point[1:33]), "y": encode(point[33:])}
+	default:
+		return errors.New("oidctest: unsupported algorithm")
+	}
+
+	s.alg, s.kid = alg, kid
+
+	return nil
+}
+
+//Authorize follows the authorization URL as the user's browser would, returning the URL the provider sends them back to
+func (s *Server) Authorize(authURL string) (*url.URL, error) {
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+
+	res, err := client.Get(authURL)
+	if err != nil {
+		return nil, err
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusFound {
+		return nil, errors.New("oidctest: authorization refused: " + res.Status)
+	}
+
+	return res.Location()
+}
+
+//encode base64url encodes JWK values
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+//writeJSON sends v as JSON with the status
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (s *Server) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                s.URL,
+		"authorization_endpoint":                s.URL + "/authorize",
+		"token_endpoint":                        s.URL + "/token",
+		"jwks_uri":                              s.URL + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"id_token_signing_alg_values_supported": []string{"RS256", "ES256"},
+	})
+}
+
+//authorize issues a code for the logged in user and sends them back to the client, requiring PKCE
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) {
+
+	q := r.URL.Query()
+
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || q.Get("client_id") != s.ClientID || q.Get("redirect_uri") == "" {
+		http.Error(w, "invalid client or redirect URI", http.StatusBadRequest)
+		return
+	}
+
+	back := redirect.Query()
+	back.Set("state", q.Get("state"))
+
+	if q.Get("response_type") != "code" || q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		back.Set("error", "invalid_request")
+		redirect.RawQuery = back.Encode()
+		http.Redirect(w, r, redirect.String(), http.StatusFound)
+		return
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	code := encode(b)
+
+	s.mu.Lock()
+	s.codes[code] = &grant{
+		redirectURI: q.Get("redirect_uri"),
+		nonce:       q.Get("nonce"),
+		challenge:   q.Get("code_challenge"),
+		claims:      jwt.MapClaims{"sub": s.Subject, "email": s.Email, "email_verified": s.EmailVerified, "name": s.Name},
+	}
+	s.mu.Unlock()
+
+	back.Set("code", code)
+	redirect.RawQuery = back.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+//token exchanges a code for an ID token, once, for the client that asked for it with the verifier of its code challenge
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+
+	id, secret, ok := r.BasicAuth()
+	id, _ = url.QueryUnescape(id)
+	secret, _ = url.QueryUnescape(secret)
+	if !ok || id != s.ClientID || secret != s.ClientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	g, ok := s.codes[r.PostFormValue("code")]
+	delete(s.codes, r.PostFormValue("code"))
+
+	sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if !ok || r.PostFormValue("grant_type") != "authorization_code" || r.PostFormValue("redirect_uri") != g.redirectURI || encode(sum[:]) != g.challenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{"iss": s.URL, "aud": s.ClientID, "iat": now.Unix(), "exp": now.Add(5 * time.Minute).Unix(), "nonce": g.nonce}
+	for k, v := range g.claims {
+		claims[k] = v
+	}
+	for k, v := range s.Claims {
+		if v == nil {
+			delete(claims, k)
+			continue
+		}
+		claims[k] = v
+	}
+
+	method := jwt.SigningMethod(jwt.SigningMethodRS256)
+	if s.alg == "ES256" {
+		method = jwt.SigningMethodES256
+	}
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = s.kid
+
+	idToken, err := token.SignedString(s.key)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"access_token": "access-" + r.PostFormValue("code"), "token_type": "Bearer", "expires_in": 300, "id_token": idToken})
+}
+
+//jwks publishes the current signing key
+func (s *Server) jwks(w http.ResponseWriter, r *http.Request) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"keys": []interface{}{s.public}})
+}
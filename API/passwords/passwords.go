@@ -0,0 +1,323 @@
+package passwords
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+//The algorithms a Hasher can hash new passwords with. Hashes of either are verified whatever the Hasher's algorithm.
+const (
+	Argon2id = "argon2id"
+	Bcrypt   = "bcrypt"
+)
+
+//ErrInvalidHash is returned when a stored hash is in no format this package reads
+var ErrInvalidHash = errors.New("passwords: invalid hash")
+
+//Argon2Params type defined
+//Argon2Params are the cost of an argon2id hash: memory in KiB, iterations, and parallelism, along with the salt and key lengths in bytes.
+type Argon2Params struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+//DefaultArgon2 follows the second recommended option of RFC 9106 for hardware without much memory to spare: 64 MiB, 3 iterations, 4 lanes.
+var DefaultArgon2 = Argon2Params{Memory: 64 * 1024, Iterations: 3, Parallelism: 4, SaltLength: 16, KeyLength: 32}
+
+//DefaultBcryptCost is the bcrypt cost used when bcrypt is chosen without one
+const DefaultBcryptCost = 12
+
+//DefaultMaxMemory is the memory in KiB a Hasher's argon2id hashes may take at once when it sets none: 512 MiB, eight hashes with the defaults.
+const DefaultMaxMemory = 512 * 1024
+
+//Hasher type defined
+//Hasher hashes new passwords with its algorithm and cost, in the PHC string format for argon2id
+//($argon2id$v=19$m=65536,t=3,p=4$salt$hash) and bcrypt's own format for bcrypt, and verifies hashes of either algorithm.
+//Hashes made with another algorithm or cost are reported by NeedsRehash so they can be replaced when the user next logs in.
+//Hash, Verify, and VerifyNone wait their turn so the hashes running at once fit in MaxMemory and the CPUs, see slots.
+type Hasher struct {
+	Algorithm  string
+	Argon2     Argon2Params
+	BcryptCost int
+	MaxMemory  uint32
+
+	//turns holds a token for each hash running, up to slots
+	turnsOnce sync.Once
+	turns     chan struct{}
+
+	//dummy is a hash made with the Hasher's settings, verified against when there is no stored hash
+	once  sync.Once
+	dummy string
+}
+
+//NewHasher returns a Hasher using argon2id with the default parameters.
+func NewHasher() *Hasher {
+	return &Hasher{Algorithm: Argon2id, Argon2: DefaultArgon2, BcryptCost: DefaultBcryptCost}
+}
+
+//ParseHasher returns a Hasher from a comma separated spec naming the algorithm and any parameters to change from the defaults,
+//e.g. "argon2id,m=65536,t=3,p=4" or "bcrypt,cost=12". An empty spec returns NewHasher().
+func ParseHasher(spec string) (*Hasher, error) {
+
+	h := NewHasher()
+
+	parts := strings.Split(spec, ",")
+	if algorithm := strings.TrimSpace(parts[0]); algorithm != "" {
+		h.Algorithm = algorithm
+	}
+	if h.Algorithm != Argon2id && h.Algorithm != Bcrypt {
+		return nil, fmt.Errorf("invalid password hash %q: unknown algorithm", spec)
+	}
+
+	for _, part := range parts[1:] {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid password hash %q: want name=value parameters", spec)
+		}
+
+		n, err := strconv.ParseUint(kv[1], 10, 32)
+		if err != nil || n == 0 {
+			return nil, fmt.Errorf("invalid password hash %q: %s must be a positive number", spec, kv[0])
+		}
+
+		switch {
+		case h.Algorithm == Argon2id && kv[0] == "m" && n >= 8:
+			h.Argon2.Memory = uint32(n)
+		case h.Algorithm == Argon2id && kv[0] == "t":
+			h.Argon2.Iterations = uint32(n)
+		case h.Algorithm == Argon2id && kv[0] == "p" && n <= 255:
+			h.Argon2.Parallelism = uint8(n)
+		case h.Algorithm == Bcrypt && kv[0] == "cost" && int(n) >= bcrypt.MinCost && int(n) <= bcrypt.MaxCost:
+			h.BcryptCost = int(n)
+		default:
+			return nil, fmt.Errorf("invalid password hash %q: bad parameter %s", spec, kv[0])
+		}
+	}
+
+	return h, nil
+}
+
+//String returns the Hasher's spec, as read by ParseHasher.
+func (h *Hasher) String() string {
+
+	if h.Algorithm == Bcrypt {
+		return fmt.Sprintf("%s,cost=%d", Bcrypt, h.BcryptCost)
+	}
+
+	return fmt.Sprintf("%s,m=%d,t=%d,p=%d", Argon2id, h.Argon2.Memory, h.Argon2.Iterations, h.Argon2.Parallelism)
+}
+
+//slots returns how many hashes the Hasher runs at once: as many argon2id hashes with its settings as fit in MaxMemory,
+//and no more than keep the CPUs busy with its parallelism, but at least one.
+func (h *Hasher) slots() int {
+
+	maxMemory := h.MaxMemory
+	if maxMemory == 0 {
+		maxMemory = DefaultMaxMemory
+	}
+
+	parallelism := int(h.Argon2.Parallelism)
+	if parallelism == 0 {
+		parallelism = 1
+	}
+
+	n := runtime.NumCPU() / parallelism
+	if h.Argon2.Memory > 0 && int(maxMemory/h.Argon2.Memory) < n {
+		n = int(maxMemory / h.Argon2.Memory)
+	}
+	if n < 1 {
+		n = 1
+	}
+
+	return n
+}
+
+//wait blocks until the Hasher may run another hash, and returns the func to call once it is done.
+func (h *Hasher) wait() func() {
+
+	h.turnsOnce.Do(func() {
+		h.turns = make(chan struct{}, h.slots())
+	})
+
+	h.turns <- struct{}{}
+
+	return func() { <-h.turns }
+}
+
+//Hash hashes the password with the Hasher's algorithm and cost and returns the encoded hash.
+func (h *Hasher) Hash(password string) (string, error) {
+
+	defer h.wait()()
+
+	if h.Algorithm == Bcrypt {
+		bs, err := bcrypt.GenerateFromPassword([]byte(password), h.BcryptCost)
+		return string(bs), err
+	}
+
+	p := h.Argon2
+
+	salt := make([]byte, p.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+
+	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s", Argon2id, argon2.Version, p.Memory, p.Iterations, p.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+//Verify reports whether the password matches the encoded hash, of either algorithm.
+//It returns ErrInvalidHash for a hash in no format it reads.
+func (h *Hasher) Verify(password, encoded string) (bool, error) {
+
+	defer h.wait()()
+
+	if isBcrypt(encoded) {
+		err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return false, nil
+		}
+		if err != nil {
+			return false, ErrInvalidHash
+		}
+		return true, nil
+	}
+
+	p, version, salt, key, err := decodeArgon2(encoded)
+	if err != nil {
+		return false, err
+	}
+	if version != argon2.Version {
+		return false, ErrInvalidHash
+	}
+
+	other := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, uint32(len(key)))
+
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+//VerifyNone takes as long as verifying a password against a hash made with the Hasher's settings, and fails.
+//Call it when there is no stored hash, e.g. no account has the email given to log in, so the response takes no less time.
+func (h *Hasher) VerifyNone(password string) {
+
+	h.once.Do(func() {
+		h.dummy, _ = h.Hash("no stored hash")
+	})
+
+	h.Verify(password, h.dummy)
+}
+
+//NeedsRehash reports whether the encoded hash was made with another algorithm or cost than the Hasher's,
+//so should be replaced by a new hash of the password once it is verified.
+func (h *Hasher) NeedsRehash(encoded string) bool {
+
+	if isBcrypt(encoded) {
+		cost, err := bcrypt.Cost([]byte(encoded))
+		return h.Algorithm != Bcrypt || err != nil || cost != h.BcryptCost
+	}
+
+	if h.Algorithm != Argon2id {
+		return true
+	}
+
+	p, version, salt, key, err := decodeArgon2(encoded)
+	if err != nil || version != argon2.Version {
+		return true
+	}
+
+	want := h.Argon2
+	return p.Memory != want.Memory || p.Iterations != want.Iterations || p.Parallelism != want.Parallelism ||
+		uint32(len(salt)) != want.SaltLength || uint32(len(key)) != want.KeyLength
+}
+
+//Calibrate finds the argon2id iterations that make one hash with the given memory and parallelism take at least target on this hardware,
+//doubling them from one and then narrowing down. It returns the parameters found and how long a hash with them took.
+func Calibrate(target time.Duration, memory uint32, parallelism uint8) (Argon2Params, time.Duration) {
+
+	p := Argon2Params{Memory: memory, Iterations: 1, Parallelism: parallelism, SaltLength: DefaultArgon2.SaltLength, KeyLength: DefaultArgon2.KeyLength}
+	salt := make([]byte, p.SaltLength)
+
+	measure := func(iterations uint32) time.Duration {
+		start := time.Now()
+		argon2.IDKey([]byte("calibrate"), salt, iterations, p.Memory, p.Parallelism, p.KeyLength)
+		return time.Since(start)
+	}
+
+	//double until a hash takes at least the target, or the iterations get absurd
+	low, high := uint32(0), uint32(1)
+	took := measure(high)
+	for took < target && high < 1<<10 {
+		low, high = high, high*2
+		took = measure(high)
+	}
+
+	//narrow down to the fewest iterations that still reach the target
+	for high-low > 1 {
+		mid := low + (high-low)/2
+		if t := measure(mid); t >= target {
+			high, took = mid, t
+		} else {
+			low = mid
+		}
+	}
+
+	p.Iterations = high
+	return p, took
+}
+
+//isBcrypt reports whether the hash is in bcrypt's format
+func isBcrypt(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+//decodeArgon2 reads an argon2id hash in the PHC string format
+func decodeArgon2(encoded string) (Argon2Params, int, []byte, []byte, error) {
+
+	p := Argon2Params{}
+
+	fields := strings.Split(encoded, "$")
+	if len(fields) != 6 || fields[0] != "" || fields[1] != Argon2id {
+		return p, 0, nil, nil, ErrInvalidHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(fields[2], "v=%d", &version); err != nil {
+		return p, 0, nil, nil, ErrInvalidHash
+	}
+
+	if _, err := fmt.Sscanf(fields[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Iterations, &p.Parallelism); err != nil {
+		return p, 0, nil, nil, ErrInvalidHash
+	}
+	if p.Memory == 0 || p.Iterations == 0 || p.Parallelism == 0 {
+		return p, 0, nil, nil, ErrInvalidHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(fields[4])
+	if err != nil || len(salt) == 0 {
+		return p, 0, nil, nil, ErrInvalidHash
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(fields[5])
+	if err != nil || len(key) == 0 {
+		return p, 0, nil, nil, ErrInvalidHash
+	}
+
+	p.SaltLength, p.KeyLength = uint32(len(salt)), uint32(len(key))
+
+	return p, version, salt, key, nil
+}
@@ -0,0 +1,209 @@
+package passwords
+
+import (
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+//cheap keeps the argon2id cost low so tests are fast
+var cheap = Argon2Params{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+func TestHash(t *testing.T) {
+
+	argon := &Hasher{Algorithm: Argon2id, Argon2: cheap}
+	legacy := &Hasher{Algorithm: Bcrypt, BcryptCost: bcrypt.MinCost}
+
+	for _, h := range []*Hasher{argon, legacy} {
+
+		hash, err := h.Hash("Password1!")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		other, err := h.Hash("Password1!")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hash == other {
+			t.Errorf("%v hashes are not salted: %q", h.Algorithm, hash)
+		}
+
+		//hashes of either algorithm verify whatever the hasher's algorithm
+		if ok, err := h.Verify("Password1!", hash); !ok || err != nil {
+			t.Errorf("%v hash did not verify: %v %v", h.Algorithm, ok, err)
+		}
+		if ok, err := h.Verify("Password2!", hash); ok || err != nil {
+			t.Errorf("%v hash verified the wrong password: %v %v", h.Algorithm, ok, err)
+		}
+
+		if h.NeedsRehash(hash) {
+			t.Errorf("%v hash needs rehash by its own hasher: %q", h.Algorithm, hash)
+		}
+	}
+
+	hash, err := argon.Hash("Password1!")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=1$") || strings.Count(hash, "$") != 5 {
+		t.Errorf("argon2id hash not in the PHC string format: %q", hash)
+	}
+
+	legacyHash, err := legacy.Hash("Password1!")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stronger := &Hasher{Algorithm: Argon2id, Argon2: cheap}
+	stronger.Argon2.Iterations = 2
+
+	for name, test := range map[string]struct {
+		h    *Hasher
+		hash string
+		want bool
+	}{
+		"bcrypt by argon2id":        {argon, legacyHash, true},
+		"argon2id by bcrypt":        {legacy, hash, true},
+		"bcrypt by higher cost":     {&Hasher{Algorithm: Bcrypt, BcryptCost: bcrypt.MinCost + 1}, legacyHash, true},
+		"argon2id by more rounds":   {stronger, hash, true},
+		"argon2id by same settings": {&Hasher{Algorithm: Argon2id, Argon2: cheap}, hash, false},
+		"unreadable":                {argon, "plaintext", true},
+	} {
+		if got := test.h.NeedsRehash(test.hash); got != test.want {
+			t.Errorf("NeedsRehash %v returned wrong result:\ngot: %v\nwant: %v", name, got, test.want)
+		}
+	}
+
+	//a hash with its key cut short or in no known format is invalid, not a mismatch
+	for _, bad := range []string{"", "plaintext", strings.TrimSuffix(hash, hash[strings.LastIndex(hash, "$"):]), "$argon2id$v=19$m=0,t=1,p=1$c2FsdA$a2V5", "$2a$04$short"} {
+		if ok, err := argon.Verify("Password1!", bad); ok || err != ErrInvalidHash {
+			t.Errorf("Verify of %q returned wrong result:\ngot: %v %v\nwant: false %v", bad, ok, err, ErrInvalidHash)
+		}
+	}
+
+	//verifying without a stored hash fails quietly
+	argon.VerifyNone("Password1!")
+}
+
+func TestParseHasher(t *testing.T) {
+
+	h, err := ParseHasher("")
+	if err != nil || h.Algorithm != Argon2id || h.Argon2 != DefaultArgon2 {
+		t.Errorf("ParseHasher of empty spec returned wrong hasher: %+v %v", h, err)
+	}
+
+	for spec, want := range map[string]string{
+		"argon2id":                  "argon2id,m=65536,t=3,p=4",
+		"argon2id,m=19456,t=2,p=1":  "argon2id,m=19456,t=2,p=1",
+		"argon2id, t=4":             "argon2id,m=65536,t=4,p=4",
+		"bcrypt":                    "bcrypt,cost=12",
+		"bcrypt,cost=14":            "bcrypt,cost=14",
+		"argon2id,m=65536,t=1,p=16": "argon2id,m=65536,t=1,p=16",
+	} {
+		h, err := ParseHasher(spec)
+		if err != nil {
+			t.Errorf("ParseHasher(%q) returned error: %v", spec, err)
+			continue
+		}
+		if h.String() != want {
+			t.Errorf("ParseHasher(%q) returned wrong hasher:\ngot: %v\nwant: %v", spec, h, want)
+		}
+	}
+
+	for _, spec := range []string{"md5", "argon2id,m=4", "argon2id,t=0", "argon2id,p=300", "argon2id,cost=12", "bcrypt,cost=2", "bcrypt,t=3", "argon2id,m"} {
+		if _, err := ParseHasher(spec); err == nil || !strings.Contains(err.Error(), "invalid password hash") {
+			t.Errorf("ParseHasher(%q) returned wrong error: %v", spec, err)
+		}
+	}
+}
+
+func TestSlots(t *testing.T) {
+
+	cpus := runtime.NumCPU()
+	atMost := func(n, max int) int {
+		if n > max {
+			return max
+		}
+		return n
+	}
+
+	for name, test := range map[string]struct {
+		h    *Hasher
+		want int
+	}{
+		"defaults":        {NewHasher(), atMost(DefaultMaxMemory/int(DefaultArgon2.Memory), cpus/4)},
+		"memory bound":    {&Hasher{Algorithm: Argon2id, Argon2: DefaultArgon2, MaxMemory: 2 * DefaultArgon2.Memory}, atMost(2, cpus/4)},
+		"below one hash":  {&Hasher{Algorithm: Argon2id, Argon2: DefaultArgon2, MaxMemory: 1024}, 1},
+		"cpu bound":       {&Hasher{Algorithm: Argon2id, Argon2: cheap}, cpus},
+		"bcrypt":          {&Hasher{Algorithm: Bcrypt, BcryptCost: bcrypt.MinCost}, cpus},
+		"more lanes than": {&Hasher{Algorithm: Argon2id, Argon2: Argon2Params{Memory: 64, Iterations: 1, Parallelism: 255}}, 1},
+	} {
+		if test.want < 1 {
+			test.want = 1
+		}
+		if got := test.h.slots(); got != test.want {
+			t.Errorf("slots of %v returned wrongly:\ngot: %v\nwant: %v", name, got, test.want)
+		}
+	}
+
+	//no more hashes run at once than there are slots
+	h := &Hasher{Algorithm: Argon2id, Argon2: cheap, MaxMemory: 2 * cheap.Memory}
+	var wg sync.WaitGroup
+	var running, most int32
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			done := h.wait()
+			n := atomic.AddInt32(&running, 1)
+			for m := atomic.LoadInt32(&most); n > m && !atomic.CompareAndSwapInt32(&most, m, n); m = atomic.LoadInt32(&most) {
+			}
+			time.Sleep(time.Millisecond)
+			atomic.AddInt32(&running, -1)
+			done()
+		}()
+	}
+	wg.Wait()
+	if most > 2 {
+		t.Errorf("%v hashes ran at once with 2 slots", most)
+	}
+}
+
+func TestCalibrate(t *testing.T) {
+
+	target := 5 * time.Millisecond
+
+	p, took := Calibrate(target, 1024, 1)
+	if p.Memory != 1024 || p.Parallelism != 1 || p.Iterations < 1 || p.SaltLength != DefaultArgon2.SaltLength || p.KeyLength != DefaultArgon2.KeyLength {
+		t.Errorf("Calibrate returned wrong parameters: %+v", p)
+	}
+	if took < target {
+		t.Errorf("Calibrate returned parameters below the target: %v < %v", took, target)
+	}
+
+	h := &Hasher{Algorithm: Argon2id, Argon2: p}
+	hash, err := h.Hash("Password1!")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := h.Verify("Password1!", hash); !ok || err != nil {
+		t.Errorf("hash with calibrated parameters did not verify: %v %v", ok, err)
+	}
+}
+
+func BenchmarkHash(b *testing.B) {
+
+	h := NewHasher()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := h.Hash("Password1!"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
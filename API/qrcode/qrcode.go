@@ -0,0 +1,551 @@
+package qrcode
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+)
+
+//ErrTooLong is returned for text too long to fit the largest QR code this package makes
+var ErrTooLong = errors.New("qrcode: text too long")
+
+//quietZone is the light border, in modules, readers need around a QR code
+const quietZone = 4
+
+//blocks type defined
+//blocks describes how a version's codewords split into error correction blocks at level M:
+//the error correction codewords per block, and the number of blocks with short data codewords and with one more.
+type blocks struct {
+	ec, short, shortData, long int
+}
+
+//levelM holds the blocks of versions 1 to 20 at error correction level M, which recovers about 15% of a damaged code.
+//Versions above 20 hold over 666 bytes, more than the otpauth URIs this package is for ever need.
+var levelM = []blocks{
+	{},
+	{10, 1, 16, 0}, {16, 1, 28, 0}, {26, 1, 44, 0}, {18, 2, 32, 0}, {24, 2, 43, 0},
+	{16, 4, 27, 0}, {18, 4, 31, 0}, {22, 2, 38, 2}, {22, 3, 36, 2}, {26, 4, 43, 1},
+	{30, 1, 50, 4}, {22, 6, 36, 2}, {22, 8, 37, 1}, {24, 4, 40, 5}, {24, 5, 41, 5},
+	{28, 7, 45, 3}, {28, 10, 46, 1}, {26, 9, 43, 4}, {26, 3, 44, 11}, {26, 3, 41, 13},
+}
+
+//Code type defined
+//Code is a QR code encoding text in byte mode at error correction level M, in the smallest version that holds it.
+type Code struct {
+	Version int
+	Size    int
+	Mask    int
+
+	modules  [][]bool //dark modules, by row then column
+	function [][]bool //modules of the finder, timing, alignment, format, and version patterns, which hold no data
+}
+
+//Encode returns the QR code for the text, or ErrTooLong.
+func Encode(text string) (*Code, error) {
+
+	data := []byte(text)
+
+	version := 0
+	for v := 1; v < len(levelM); v++ {
+		if 4+countBits(v)+8*len(data) <= 8*dataCodewords(v) {
+			version = v
+			break
+		}
+	}
+	if version == 0 {
+		return nil, ErrTooLong
+	}
+
+	c := newCode(version)
+	c.placeData(c.codewords(data))
+
+	//use the mask leaving the fewest patterns readers could confuse, as the standard requires
+	best, lowest := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		c.applyMask(mask)
+		c.drawFormat(mask)
+		if p := c.penalty(); lowest < 0 || p < lowest {
+			best, lowest = mask, p
+		}
+		c.applyMask(mask)
+	}
+
+	c.applyMask(best)
+	c.drawFormat(best)
+	c.Mask = best
+
+	return c, nil
+}
+
+//Dark reports whether the module at column x and row y is dark.
+func (c *Code) Dark(x, y int) bool {
+	return c.modules[y][x]
+}
+
+//PNG renders the code as a black and white PNG, scale pixels per module, with the quiet zone readers need around it.
+func (c *Code) PNG(scale int) ([]byte, error) {
+
+	if scale < 1 {
+		scale = 1
+	}
+
+	side := (c.Size + 2*quietZone) * scale
+	img := image.NewGray(image.Rect(0, 0, side, side))
+
+	for py := 0; py < side; py++ {
+		for px := 0; px < side; px++ {
+			x, y := px/scale-quietZone, py/scale-quietZone
+			shade := color.Gray{Y: 255}
+			if x >= 0 && y >= 0 && x < c.Size && y < c.Size && c.modules[y][x] {
+				shade = color.Gray{Y: 0}
+			}
+			img.SetGray(px, py, shade)
+		}
+	}
+
+	var buf bytes.Buffer
+	err := png.Encode(&buf, img)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+//countBits is the length of the byte mode character count in the version
+func countBits(version int) int {
+	if version < 10 {
+		return 8
+	}
+	return 16
+}
+
+//dataCodewords is the number of data codewords the version holds at level M
+func dataCodewords(version int) int {
+	b := levelM[version]
+	return b.short*b.shortData + b.long*(b.shortData+1)
+}
+
+//rawModules is the number of modules the version has for data and error correction, including the remainder bits
+func rawModules(version int) int {
+
+	n := (16*version+128)*version + 64
+	if version >= 2 {
+		align := version/7 + 2
+		n -= (25*align-10)*align - 55
+		if version >= 7 {
+			n -= 36
+		}
+	}
+
+	return n
+}
+
+//alignmentPositions returns the rows and columns alignment patterns are centered on in the version
+func alignmentPositions(version int) []int {
+
+	if version == 1 {
+		return nil
+	}
+
+	n := version/7 + 2
+	step := (version*4 + n*2 + 1) / (n*2 - 2) * 2
+
+	positions := make([]int, n)
+	positions[0] = 6
+	for i, pos := n-1, version*4+10; i >= 1; i, pos = i-1, pos-step {
+		positions[i] = pos
+	}
+
+	return positions
+}
+
+//newCode returns a code of the version with its function patterns drawn and its format and version areas reserved
+func newCode(version int) *Code {
+
+	size := version*4 + 17
+	c := &Code{Version: version, Size: size, modules: make([][]bool, size), function: make([][]bool, size)}
+	for y := range c.modules {
+		c.modules[y] = make([]bool, size)
+		c.function[y] = make([]bool, size)
+	}
+
+	//timing patterns
+	for i := 0; i < size; i++ {
+		c.set(6, i, i%2 == 0)
+		c.set(i, 6, i%2 == 0)
+	}
+
+	//finder patterns, with their light separators, in three corners
+	for _, corner := range [][2]int{{3, 3}, {size - 4, 3}, {3, size - 4}} {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				x, y := corner[0]+dx, corner[1]+dy
+				if x >= 0 && y >= 0 && x < size && y < size {
+					d := max(abs(dx), abs(dy))
+					c.set(x, y, d != 2 && d != 4)
+				}
+			}
+		}
+	}
+
+	//alignment patterns, except where they would overlap the finders
+	positions := alignmentPositions(version)
+	last := len(positions) - 1
+	for i, cy := range positions {
+		for j, cx := range positions {
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					c.set(cx+dx, cy+dy, max(abs(dx), abs(dy)) != 1)
+				}
+			}
+		}
+	}
+
+	//reserve the format areas until a mask is chosen
+	c.drawFormat(0)
+
+	//version information, from version 7
+	if version >= 7 {
+		bits := versionBits(version)
+		for i := 0; i < 18; i++ {
+			dark := (bits>>uint(i))&1 == 1
+			a, b := size-11+i%3, i/3
+			c.set(a, b, dark)
+			c.set(b, a, dark)
+		}
+	}
+
+	return c
+}
+
+//set sets the module at column x and row y as part of a function pattern
+func (c *Code) set(x, y int, dark bool) {
+	c.modules[y][x] = dark
+	c.function[y][x] = true
+}
+
+//versionBits returns the 18 version information bits, BCH protected, for versions 7 and up
+func versionBits(version int) int {
+
+	rem := version
+	for i := 0; i < 12; i++ {
+		rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+	}
+
+	return version<<12 | rem
+}
+
+//formatBits returns the 15 format bits for level M and the mask, BCH protected and masked as the standard requires
+func formatBits(mask int) int {
+
+	data := 0<<3 | mask //level M is 00
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+
+	return (data<<10 | rem) ^ 0x5412
+}
+
+//drawFormat draws both copies of the format bits for the mask, and the dark module beside them
+func (c *Code) drawFormat(mask int) {
+
+	bits := formatBits(mask)
+	bit := func(i int) bool { return (bits>>uint(i))&1 == 1 }
+
+	//around the top left finder
+	for i := 0; i <= 5; i++ {
+		c.set(8, i, bit(i))
+	}
+	c.set(8, 7, bit(6))
+	c.set(8, 8, bit(7))
+	c.set(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		c.set(14-i, 8, bit(i))
+	}
+
+	//beside the other two finders
+	for i := 0; i < 8; i++ {
+		c.set(c.Size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		c.set(8, c.Size-15+i, bit(i))
+	}
+	c.set(8, c.Size-8, true)
+}
+
+//codewords returns the data in byte mode, padded to the version's data codewords,
+//split into blocks with their error correction codewords added, and interleaved as they are placed
+func (c *Code) codewords(data []byte) []byte {
+
+	total := dataCodewords(c.Version)
+
+	//mode, count, and data bits, then a terminator and padding to whole codewords
+	bits := make([]bool, 0, total*8)
+	appendBits := func(value, n int) {
+		for i := n - 1; i >= 0; i-- {
+			bits = append(bits, (value>>uint(i))&1 == 1)
+		}
+	}
+	appendBits(0x4, 4)
+	appendBits(len(data), countBits(c.Version))
+	for _, b := range data {
+		appendBits(int(b), 8)
+	}
+	appendBits(0, min(4, total*8-len(bits)))
+	appendBits(0, (8-len(bits)%8)%8)
+
+	padded := make([]byte, 0, total)
+	for i := 0; i < len(bits); i += 8 {
+		var b byte
+		for j := 0; j < 8; j++ {
+			if bits[i+j] {
+				b |= 1 << uint(7-j)
+			}
+		}
+		padded = append(padded, b)
+	}
+	for pad := byte(0xEC); len(padded) < total; pad ^= 0xEC ^ 0x11 {
+		padded = append(padded, pad)
+	}
+
+	//split into blocks and add each block's error correction
+	spec := levelM[c.Version]
+	divisor := rsDivisor(spec.ec)
+	dataBlocks := [][]byte{}
+	ecBlocks := [][]byte{}
+	for i, offset := 0, 0; i < spec.short+spec.long; i++ {
+		n := spec.shortData
+		if i >= spec.short {
+			n++
+		}
+		block := padded[offset : offset+n]
+		offset += n
+		dataBlocks = append(dataBlocks, block)
+		ecBlocks = append(ecBlocks, rsRemainder(block, divisor))
+	}
+
+	//interleave the data codewords, then the error correction codewords
+	result := make([]byte, 0, rawModules(c.Version)/8)
+	for i := 0; i <= spec.shortData; i++ {
+		for _, block := range dataBlocks {
+			if i < len(block) {
+				result = append(result, block[i])
+			}
+		}
+	}
+	for i := 0; i < spec.ec; i++ {
+		for _, block := range ecBlocks {
+			result = append(result, block[i])
+		}
+	}
+
+	return result
+}
+
+//placeData places the codewords' bits in the modules outside the function patterns,
+//in two module wide columns zigzagging up and down from the bottom right. Modules left over are the light remainder bits.
+func (c *Code) placeData(codewords []byte) {
+
+	i := 0
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		upward := (right+1)&2 == 0
+		for vert := 0; vert < c.Size; vert++ {
+			y := vert
+			if upward {
+				y = c.Size - 1 - vert
+			}
+			for j := 0; j < 2; j++ {
+				x := right - j
+				if c.function[y][x] || i >= len(codewords)*8 {
+					continue
+				}
+				c.modules[y][x] = (codewords[i/8]>>uint(7-i%8))&1 == 1
+				i++
+			}
+		}
+	}
+}
+
+//masked reports whether the mask flips the module at column x and row y
+func masked(mask, x, y int) bool {
+	switch mask {
+	case 0:
+		return (x+y)%2 == 0
+	case 1:
+		return y%2 == 0
+	case 2:
+		return x%3 == 0
+	case 3:
+		return (x+y)%3 == 0
+	case 4:
+		return (x/3+y/2)%2 == 0
+	case 5:
+		return x*y%2+x*y%3 == 0
+	case 6:
+		return (x*y%2+x*y%3)%2 == 0
+	default:
+		return ((x+y)%2+x*y%3)%2 == 0
+	}
+}
+
+//applyMask flips the data modules the mask selects. Applying a mask twice undoes it.
+func (c *Code) applyMask(mask int) {
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if !c.function[y][x] && masked(mask, x, y) {
+				c.modules[y][x] = !c.modules[y][x]
+			}
+		}
+	}
+}
+
+//penalty scores the code by the standard's four rules: long runs of one color, 2x2 blocks of one color,
+//patterns that look like finders, and an imbalance of dark and light modules. Lower is better.
+func (c *Code) penalty() int {
+
+	score := 0
+	finder := []bool{true, false, true, true, true, false, true}
+
+	//line returns row i, or column i if vertical
+	line := func(i int, vertical bool) []bool {
+		l := make([]bool, c.Size)
+		for j := range l {
+			if vertical {
+				l[j] = c.modules[j][i]
+			} else {
+				l[j] = c.modules[i][j]
+			}
+		}
+		return l
+	}
+
+	for i := 0; i < c.Size; i++ {
+		for _, vertical := range []bool{false, true} {
+			l := line(i, vertical)
+
+			//runs of five or more
+			run := 1
+			for j := 1; j <= len(l); j++ {
+				if j < len(l) && l[j] == l[j-1] {
+					run++
+					continue
+				}
+				if run >= 5 {
+					score += 3 + run - 5
+				}
+				run = 1
+			}
+
+			//finder-like patterns with four light modules, or the edge, on either side
+			for j := 0; j+len(finder) <= len(l); j++ {
+				match := true
+				for k, dark := range finder {
+					if l[j+k] != dark {
+						match = false
+						break
+					}
+				}
+				if match && (lightRun(l, j-4, j) || lightRun(l, j+len(finder), j+len(finder)+4)) {
+					score += 40
+				}
+			}
+		}
+	}
+
+	//2x2 blocks
+	dark := 0
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if c.modules[y][x] {
+				dark++
+			}
+			if x > 0 && y > 0 {
+				m := c.modules[y][x]
+				if c.modules[y-1][x] == m && c.modules[y][x-1] == m && c.modules[y-1][x-1] == m {
+					score += 3
+				}
+			}
+		}
+	}
+
+	//10 points for every 5% the dark modules are away from half
+	total := c.Size * c.Size
+	score += abs(dark*20-total*10) / total * 10
+
+	return score
+}
+
+//lightRun reports whether the modules of the line from start up to end are light, counting modules beyond the edge as light
+func lightRun(l []bool, start, end int) bool {
+	for i := start; i < end; i++ {
+		if i >= 0 && i < len(l) && l[i] {
+			return false
+		}
+	}
+	return true
+}
+
+//rsDivisor returns the Reed-Solomon generator polynomial of the degree over GF(256), without its leading 1, highest powers first
+func rsDivisor(degree int) []byte {
+
+	divisor := make([]byte, degree)
+	divisor[degree-1] = 1
+
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range divisor {
+			divisor[j] = gfMul(divisor[j], root)
+			if j+1 < degree {
+				divisor[j] ^= divisor[j+1]
+			}
+		}
+		root = gfMul(root, 0x02)
+	}
+
+	return divisor
+}
+
+//rsRemainder returns the Reed-Solomon error correction codewords of the data for the divisor
+func rsRemainder(data, divisor []byte) []byte {
+
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i := range result {
+			result[i] ^= gfMul(divisor[i], factor)
+		}
+	}
+
+	return result
+}
+
+//gfMul multiplies in GF(256) modulo the QR code polynomial x^8 + x^4 + x^3 + x^2 + 1
+func gfMul(x, y byte) byte {
+
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11D)
+		z ^= int((y>>uint(i))&1) * int(x)
+	}
+
+	return byte(z)
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,213 @@
+package qrcode
+
+import (
+	"bytes"
+	"fmt"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestTables(t *testing.T) {
+
+	//every version's blocks fill exactly the codewords its modules hold
+	for v := 1; v < len(levelM); v++ {
+		b := levelM[v]
+		if got, want := dataCodewords(v)+(b.short+b.long)*b.ec, rawModules(v)/8; got != want {
+			t.Errorf("version %v blocks hold wrong number of codewords:\ngot: %v\nwant: %v", v, got, want)
+		}
+	}
+
+	//alignment pattern positions from the standard's table
+	for v, want := range map[int]string{1: "[]", 2: "[6 18]", 7: "[6 22 38]", 14: "[6 26 46 66]", 15: "[6 26 48 70]", 20: "[6 34 62 90]"} {
+		if got := fmt.Sprint(alignmentPositions(v)); got != want {
+			t.Errorf("version %v alignment positions wrong:\ngot: %v\nwant: %v", v, got, want)
+		}
+	}
+
+	//format bits for level M with mask 0, from the standard's table
+	if got := fmt.Sprintf("%015b", formatBits(0)); got != "101010000010010" {
+		t.Errorf("format bits wrong:\ngot: %v\nwant: 101010000010010", got)
+	}
+
+	//version information for version 7, from the standard's table
+	if got := fmt.Sprintf("%018b", versionBits(7)); got != "000111110010010100" {
+		t.Errorf("version bits wrong:\ngot: %v\nwant: 000111110010010100", got)
+	}
+
+	//error correction of the data codewords of HELLO WORLD in version 1 at level M
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	want := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+	if got := rsRemainder(data, rsDivisor(10)); !bytes.Equal(got, want) {
+		t.Errorf("error correction codewords wrong:\ngot: %v\nwant: %v", got, want)
+	}
+}
+
+func TestEncode(t *testing.T) {
+
+	for _, text := range []string{
+		"",
+		"hello",
+		"otpauth://totp/Snippets:User-1?secret=JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP&issuer=Snippets&algorithm=SHA1&digits=6&period=30",
+		strings.Repeat("long text needing several blocks ", 12),
+		strings.Repeat("x", 666),
+	} {
+		c, err := Encode(text)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := decode(c)
+		if err != nil {
+			t.Errorf("version %v code for %d bytes did not decode: %v", c.Version, len(text), err)
+			continue
+		}
+		if got != text {
+			t.Errorf("version %v code decoded wrongly:\ngot: %q\nwant: %q", c.Version, got, text)
+		}
+	}
+
+	if c, _ := Encode("hello"); c.Version != 1 || c.Size != 21 {
+		t.Errorf("short text not in version 1: version %v size %v", c.Version, c.Size)
+	}
+
+	if _, err := Encode(strings.Repeat("x", 667)); err != ErrTooLong {
+		t.Errorf("Encode of too long text returned wrong error:\ngot: %v\nwant: %v", err, ErrTooLong)
+	}
+}
+
+func TestPNG(t *testing.T) {
+
+	c, err := Encode("hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := c.PNG(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := png.Decode(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	side := (c.Size + 2*quietZone) * 4
+	if img.Bounds().Dx() != side || img.Bounds().Dy() != side {
+		t.Fatalf("PNG wrong size: %v", img.Bounds())
+	}
+
+	//the quiet zone is light and the top left finder's corner dark
+	for _, p := range []struct {
+		x, y int
+		dark bool
+	}{{0, 0, false}, {quietZone*4 - 1, quietZone * 4, false}, {quietZone * 4, quietZone * 4, true}, {quietZone*4 + 3, quietZone*4 + 3, true}} {
+		r, _, _, _ := img.At(p.x, p.y).RGBA()
+		if (r == 0) != p.dark {
+			t.Errorf("PNG pixel %v,%v wrong: dark %v", p.x, p.y, r == 0)
+		}
+	}
+}
+
+//decode reads the text back from a code as a reader would, checking its format bits and error correction
+func decode(c *Code) (string, error) {
+
+	//the format bits around the top left finder name the mask
+	format := 0
+	bit := func(x, y int) int {
+		if c.Dark(x, y) {
+			return 1
+		}
+		return 0
+	}
+	for i := 0; i <= 5; i++ {
+		format |= bit(8, i) << uint(i)
+	}
+	format |= bit(8, 7)<<6 | bit(8, 8)<<7 | bit(7, 8)<<8
+	for i := 9; i < 15; i++ {
+		format |= bit(14-i, 8) << uint(i)
+	}
+
+	mask := -1
+	for m := 0; m < 8; m++ {
+		if formatBits(m) == format {
+			mask = m
+		}
+	}
+	if mask < 0 {
+		return "", fmt.Errorf("unreadable format bits %015b", format)
+	}
+
+	//read the codewords in placement order, unmasking them
+	f := newCode(c.Version)
+	raw := make([]byte, rawModules(c.Version)/8)
+	i := 0
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		upward := (right+1)&2 == 0
+		for vert := 0; vert < c.Size; vert++ {
+			y := vert
+			if upward {
+				y = c.Size - 1 - vert
+			}
+			for j := 0; j < 2; j++ {
+				x := right - j
+				if f.function[y][x] || i >= len(raw)*8 {
+					continue
+				}
+				if c.Dark(x, y) != masked(mask, x, y) {
+					raw[i/8] |= 1 << uint(7-i%8)
+				}
+				i++
+			}
+		}
+	}
+
+	//deinterleave the blocks and check each block's error correction
+	spec := levelM[c.Version]
+	n := spec.short + spec.long
+	blocks := make([][]byte, n)
+	k := 0
+	for col := 0; col <= spec.shortData; col++ {
+		for b := 0; b < n; b++ {
+			if col < spec.shortData || b >= spec.short {
+				blocks[b] = append(blocks[b], raw[k])
+				k++
+			}
+		}
+	}
+	data := []byte{}
+	for b := range blocks {
+		ec := raw[k+b:]
+		want := make([]byte, 0, spec.ec)
+		for e := 0; e < spec.ec; e++ {
+			want = append(want, ec[e*n])
+		}
+		if got := rsRemainder(blocks[b], rsDivisor(spec.ec)); !bytes.Equal(got, want) {
+			return "", fmt.Errorf("block %v error correction wrong", b)
+		}
+		data = append(data, blocks[b]...)
+	}
+
+	//byte mode, then the count, then the bytes
+	read := func(offset, length int) int {
+		v := 0
+		for i := offset; i < offset+length; i++ {
+			v = v<<1 | int(data[i/8]>>uint(7-i%8)&1)
+		}
+		return v
+	}
+	if mode := read(0, 4); mode != 4 {
+		return "", fmt.Errorf("wrong mode %v", mode)
+	}
+	count := read(4, countBits(c.Version))
+	text := make([]byte, count)
+	for j := range text {
+		text[j] = byte(read(4+countBits(c.Version)+8*j, 8))
+	}
+
+	return string(text), nil
+}
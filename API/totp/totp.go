@@ -0,0 +1,107 @@
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+//The codes this package makes and checks are those authenticator apps default to: 6 digits from HMAC-SHA1 over 30 second steps (RFC 6238).
+const (
+	Digits = 6
+	Period = 30 * time.Second
+
+	//Skew is how many steps either side of now a code is accepted from, allowing for clock drift and slow typing
+	Skew = 1
+)
+
+//ErrInvalidSecret is returned for a secret that is not base32
+var ErrInvalidSecret = errors.New("totp: invalid secret")
+
+//encoding is the unpadded base32 authenticator apps read secrets in
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+//NewSecret returns a random 160 bit secret, base32 encoded.
+func NewSecret() (string, error) {
+
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return encoding.EncodeToString(b), nil
+}
+
+//URI returns the otpauth URI authenticator apps enroll the secret from, usually by scanning it as a QR code,
+//labelled with the issuer and the account.
+func URI(issuer, account, secret string) string {
+
+	label := url.PathEscape(issuer + ":" + account)
+
+	//the secret first, as the key URI format documents it
+	return fmt.Sprintf("otpauth://totp/%s?secret=%s&issuer=%s&algorithm=SHA1&digits=%d&period=%d",
+		label, url.QueryEscape(secret), url.QueryEscape(issuer), Digits, int(Period.Seconds()))
+}
+
+//Step returns the time step t falls in.
+func Step(t time.Time) int64 {
+	return t.Unix() / int64(Period.Seconds())
+}
+
+//Code returns the code for the secret at time step.
+func Code(secret string, step int64) (string, error) {
+
+	key, err := encoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil || len(key) == 0 {
+		return "", ErrInvalidSecret
+	}
+
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	//dynamic truncation (RFC 4226 section 5.3)
+	offset := sum[len(sum)-1] & 0xf
+	n := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < Digits; i++ {
+		mod *= 10
+	}
+
+	return fmt.Sprintf("%0*d", Digits, n%mod), nil
+}
+
+//Validate checks the code against the secret at time t, allowing Skew steps either side,
+//and returns the step it matched so callers can refuse a code used before. It returns false for any code of the wrong form.
+func Validate(secret, code string, t time.Time) (int64, bool, error) {
+
+	code = strings.Replace(strings.TrimSpace(code), " ", "", -1)
+	if len(code) != Digits {
+		return 0, false, nil
+	}
+
+	now := Step(t)
+	for step := now - Skew; step <= now+Skew; step++ {
+		want, err := Code(secret, step)
+		if err != nil {
+			return 0, false, err
+		}
+		if subtle.ConstantTimeCompare([]byte(want), []byte(code)) == 1 {
+			return step, true, nil
+		}
+	}
+
+	return 0, false, nil
+}
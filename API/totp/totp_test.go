@@ -0,0 +1,99 @@
+package totp
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+//rfcSecret is the SHA-1 test secret of RFC 6238, "12345678901234567890", base32 encoded
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCode(t *testing.T) {
+
+	//the RFC 6238 test vectors, cut to 6 digits
+	for unix, want := range map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1111111111: "050471",
+		1234567890: "005924",
+		2000000000: "279037",
+	} {
+		got, err := Code(rfcSecret, Step(time.Unix(unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("code at %v wrong:\ngot: %v\nwant: %v", unix, got, want)
+		}
+	}
+
+	if _, err := Code("not base32!", 1); err != ErrInvalidSecret {
+		t.Errorf("Code with invalid secret returned wrong error:\ngot: %v\nwant: %v", err, ErrInvalidSecret)
+	}
+}
+
+func TestValidate(t *testing.T) {
+
+	now := time.Unix(1111111111, 0)
+	step := Step(now)
+
+	for name, test := range map[string]struct {
+		at   int64
+		code string
+		ok   bool
+	}{
+		"current step":      {step, "050471", true},
+		"previous step":     {step - 1, "", true},
+		"next step":         {step + 1, "", true},
+		"two steps ago":     {step - 2, "", false},
+		"spaced code":       {step, "050 471", true},
+		"wrong code":        {step, "123456", false},
+		"short code":        {step, "50471", false},
+		"code with letters": {step, "05047a", false},
+	} {
+		code := test.code
+		if code == "" {
+			code, _ = Code(rfcSecret, test.at)
+		}
+
+		matched, ok, err := Validate(rfcSecret, code, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != test.ok || (ok && matched != test.at) {
+			t.Errorf("%v validated wrongly:\ngot: %v step %v\nwant: %v step %v", name, ok, matched, test.ok, test.at)
+		}
+	}
+}
+
+func TestSecretAndURI(t *testing.T) {
+
+	secret, err := NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _ := NewSecret()
+	if len(secret) != 32 || secret == other {
+		t.Errorf("secrets not random 160 bit base32: %q %q", secret, other)
+	}
+	if _, err := Code(secret, 1); err != nil {
+		t.Errorf("new secret unusable: %v", err)
+	}
+
+	uri := URI("Snippets", "User-1", secret)
+	u, err := url.Parse(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if u.Scheme != "otpauth" || u.Host != "totp" || u.Path != "/Snippets:User-1" || q.Get("secret") != secret || q.Get("issuer") != "Snippets" ||
+		q.Get("digits") != "6" || q.Get("period") != "30" || q.Get("algorithm") != "SHA1" {
+		t.Errorf("URI wrong: %v", uri)
+	}
+
+	if uri := URI("Snippets", "a b/c", secret); !strings.HasPrefix(uri, "otpauth://totp/Snippets:a%20b%2Fc?") {
+		t.Errorf("URI label not escaped: %v", uri)
+	}
+}
@@ -0,0 +1,123 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+//maxDepth bounds how deeply CBOR arrays and maps may nest, so crafted responses cannot exhaust the stack
+const maxDepth = 16
+
+//decodeCBOR decodes the first CBOR data item in b (RFC 8949), returning it and the bytes after it.
+//It reads the subset authenticators send: integers as int64, byte strings as []byte, text strings, arrays as []interface{},
+//maps as map[interface{}]interface{}, booleans, and null as nil. Indefinite lengths, tags, and floats are refused.
+func decodeCBOR(b []byte, depth int) (interface{}, []byte, error) {
+
+	if depth > maxDepth || len(b) == 0 {
+		return nil, nil, ErrMalformed
+	}
+
+	major, info := b[0]>>5, b[0]&0x1f
+	b = b[1:]
+
+	//the argument: the value itself for integers, or the length of strings, arrays, and maps
+	var arg uint64
+	switch {
+	case info < 24:
+		arg = uint64(info)
+	case info <= 27:
+		n := 1 << (info - 24)
+		if len(b) < n {
+			return nil, nil, ErrMalformed
+		}
+		switch n {
+		case 1:
+			arg = uint64(b[0])
+		case 2:
+			arg = uint64(binary.BigEndian.Uint16(b))
+		case 4:
+			arg = uint64(binary.BigEndian.Uint32(b))
+		case 8:
+			arg = binary.BigEndian.Uint64(b)
+		}
+		b = b[n:]
+	default:
+		return nil, nil, ErrMalformed
+	}
+
+	switch major {
+	case 0:
+		if arg > math.MaxInt64 {
+			return nil, nil, ErrMalformed
+		}
+		return int64(arg), b, nil
+
+	case 1:
+		if arg > math.MaxInt64 {
+			return nil, nil, ErrMalformed
+		}
+		return -1 - int64(arg), b, nil
+
+	case 2, 3:
+		if arg > uint64(len(b)) {
+			return nil, nil, ErrMalformed
+		}
+		s := b[:arg:arg]
+		if major == 3 {
+			return string(s), b[arg:], nil
+		}
+		return s, b[arg:], nil
+
+	case 4:
+		//every item takes at least a byte, which bounds the allocation by the input
+		if arg > uint64(len(b)) {
+			return nil, nil, ErrMalformed
+		}
+		items := make([]interface{}, arg)
+		for i := range items {
+			var err error
+			items[i], b, err = decodeCBOR(b, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		return items, b, nil
+
+	case 5:
+		if arg > uint64(len(b))/2 {
+			return nil, nil, ErrMalformed
+		}
+		m := make(map[interface{}]interface{}, arg)
+		for i := uint64(0); i < arg; i++ {
+			key, rest, err := decodeCBOR(b, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, nil, ErrMalformed
+			}
+			if _, dup := m[key]; dup {
+				return nil, nil, ErrMalformed
+			}
+			m[key], b, err = decodeCBOR(rest, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		return m, b, nil
+
+	case 7:
+		switch info {
+		case 20:
+			return false, b, nil
+		case 21:
+			return true, b, nil
+		case 22:
+			return nil, b, nil
+		}
+	}
+
+	return nil, nil, ErrMalformed
+}
@@ -0,0 +1,73 @@
+package webauthn
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDecodeCBOR(t *testing.T) {
+
+	//examples from RFC 8949 appendix A
+	for encoded, want := range map[string]string{
+		"00":                 "0",
+		"17":                 "23",
+		"1818":               "24",
+		"190100":             "256",
+		"1b000000e8d4a51000": "1000000000000",
+		"20":                 "-1",
+		"3863":               "-100",
+		"4401020304":         "[1 2 3 4]",
+		"6449455446":         "IETF",
+		"83010203":           "[1 2 3]",
+		"a201020304":         "map[1:2 3:4]",
+		"a26161016162820203": "map[a:1 b:[2 3]]",
+		"f4":                 "false",
+		"f5":                 "true",
+		"f6":                 "<nil>",
+	} {
+		b, err := hex.DecodeString(encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, rest, err := decodeCBOR(b, 0)
+		if err != nil {
+			t.Errorf("decoding %v failed: %v", encoded, err)
+			continue
+		}
+		if got := fmt.Sprint(v); got != want || len(rest) != 0 {
+			t.Errorf("decoding %v wrong:\ngot: %v, %d bytes left\nwant: %v", encoded, got, len(rest), want)
+		}
+	}
+
+	//the bytes after the first item are returned
+	if _, rest, _ := decodeCBOR([]byte{0x01, 0x02, 0x03}, 0); !bytes.Equal(rest, []byte{0x02, 0x03}) {
+		t.Errorf("bytes after item wrong: %x", rest)
+	}
+
+	for name, encoded := range map[string]string{
+		"empty":               "",
+		"indefinite length":   "9f01ff",
+		"tag":                 "c11a514b67b0",
+		"float":               "f97c00",
+		"truncated argument":  "1901",
+		"truncated string":    "4401",
+		"huge length":         "5bffffffffffffffff",
+		"integer overflow":    "1bffffffffffffffff",
+		"duplicate keys":      "a201010102",
+		"array key":           "a1800102",
+		"missing map value":   "a101",
+		"too deeply nested":   strings.Repeat("81", maxDepth+2) + "00",
+		"reserved additional": "1c",
+	} {
+		b, err := hex.DecodeString(encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := decodeCBOR(b, 0); err != ErrMalformed {
+			t.Errorf("decoding %v returned wrong error:\ngot: %v\nwant: %v", name, err, ErrMalformed)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"math/big"
+)
+
+//The COSE algorithms credentials may use, in the order registration asks authenticators to prefer them:
+//ECDSA on P-256, which nearly every authenticator supports, Ed25519, and RSA, which Windows Hello uses.
+const (
+	ES256 = -7
+	EdDSA = -8
+	RS256 = -257
+)
+
+//Algorithms lists the COSE algorithms credentials may use, in order of preference
+var Algorithms = []int{ES256, EdDSA, RS256}
+
+//the COSE_Key parameters read (RFC 9053)
+const (
+	coseKty = 1
+	coseAlg = 3
+	coseCrv = -1 //the curve for EC2 and OKP keys, or the modulus for RSA keys
+	coseX   = -2 //the x coordinate, or the RSA public exponent
+	coseY   = -3
+
+	ktyOKP = 1
+	ktyEC2 = 2
+	ktyRSA = 3
+
+	crvP256    = 1
+	crvEd25519 = 6
+)
+
+//minRSABits is the smallest RSA modulus accepted
+const minRSABits = 2048
+
+//parseKey parses a credential's public key from its COSE_Key encoding, returning its algorithm and the key.
+func parseKey(cose []byte) (int, crypto.PublicKey, error) {
+
+	v, rest, err := decodeCBOR(cose, 0)
+	if err != nil || len(rest) != 0 {
+		return 0, nil, ErrMalformed
+	}
+
+	m, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return 0, nil, ErrMalformed
+	}
+
+	integer := func(label int64) int64 {
+		n, _ := m[label].(int64)
+		return n
+	}
+	bytes := func(label int64) []byte {
+		b, _ := m[label].([]byte)
+		return b
+	}
+
+	kty, alg := integer(coseKty), integer(coseAlg)
+
+	switch {
+	case alg == ES256 && kty == ktyEC2 && integer(coseCrv) == crvP256:
+		x, y := bytes(coseX), bytes(coseY)
+		if len(x) != 32 || len(y) != 32 {
+			return 0, nil, ErrMalformed
+		}
+		//parsing the uncompressed point checks it is on the curve
+		key, err := ecdsa.ParseUncompressedPublicKey(elliptic.P256(), append(append([]byte{4}, x...), y...))
+		if err != nil {
+			return 0, nil, ErrMalformed
+		}
+		return ES256, key, nil
+
+	case alg == EdDSA && kty == ktyOKP && integer(coseCrv) == crvEd25519:
+		x := bytes(coseX)
+		if len(x) != ed25519.PublicKeySize {
+			return 0, nil, ErrMalformed
+		}
+		return EdDSA, ed25519.PublicKey(x), nil
+
+	case alg == RS256 && kty == ktyRSA:
+		n, e := new(big.Int).SetBytes(bytes(coseCrv)), new(big.Int).SetBytes(bytes(coseX))
+		if n.BitLen() < minRSABits || !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 || e.Bit(0) == 0 {
+			return 0, nil, ErrMalformed
+		}
+		return RS256, &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	}
+
+	return 0, nil, ErrUnsupportedKey
+}
+
+//verifySignature checks the signature over data by the credential with the COSE_Key.
+func verifySignature(cose, data, signature []byte) error {
+
+	alg, key, err := parseKey(cose)
+	if err != nil {
+		return err
+	}
+
+	valid := false
+	digest := sha256.Sum256(data)
+
+	switch alg {
+	case ES256:
+		valid = ecdsa.VerifyASN1(key.(*ecdsa.PublicKey), digest[:], signature)
+	case EdDSA:
+		valid = ed25519.Verify(key.(ed25519.PublicKey), data, signature)
+	case RS256:
+		valid = rsa.VerifyPKCS1v15(key.(*rsa.PublicKey), crypto.SHA256, digest[:], signature) == nil
+	}
+
+	if !valid {
+		return ErrSignature
+	}
+
+	return nil
+}
@@ -0,0 +1,488 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+//Timeout is how long browsers are given to complete a ceremony, and how long its challenge stays valid
+const Timeout = 5 * time.Minute
+
+//the authenticator data flags read (WebAuthn §6.1)
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttested     = 0x40
+	flagExtensions   = 0x80
+)
+
+//The errors returned for responses that fail a ceremony
+var (
+	ErrMalformed       = errors.New("webauthn: malformed response")
+	ErrUnsupportedKey  = errors.New("webauthn: unsupported public key algorithm")
+	ErrCeremony        = errors.New("webauthn: response for another ceremony")
+	ErrChallenge       = errors.New("webauthn: wrong challenge")
+	ErrOrigin          = errors.New("webauthn: wrong origin")
+	ErrRelyingParty    = errors.New("webauthn: credential scoped to another relying party")
+	ErrUserNotPresent  = errors.New("webauthn: user not present")
+	ErrUserNotVerified = errors.New("webauthn: user not verified")
+	ErrCredentialID    = errors.New("webauthn: credential ID does not match")
+	ErrSignature       = errors.New("webauthn: invalid signature")
+	ErrCloned          = errors.New("webauthn: signature counter did not increase, so the authenticator may have been cloned")
+)
+
+//RelyingParty type defined
+//RelyingParty is the site credentials are registered with and asserted to: the domain credentials are scoped to (e.g. example.com),
+//the name authenticators show users, and the origins the site's pages are served from (e.g. https://example.com).
+//Credentials are the only factor of a passkey login, so both ceremonies require the authenticator to verify the user, by PIN or biometrics.
+type RelyingParty struct {
+	ID      string
+	Name    string
+	Origins []string
+}
+
+//Entity names a relying party or user to authenticators
+type Entity struct {
+	ID          string `json:"id,omitempty"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName,omitempty"`
+}
+
+//CredentialParameter is an algorithm credentials may use
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+//CredentialDescriptor names a credential by its base64url ID
+type CredentialDescriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+//AuthenticatorSelection is the kind of authenticator registration asks for
+type AuthenticatorSelection struct {
+	ResidentKey        string `json:"residentKey"`
+	RequireResidentKey bool   `json:"requireResidentKey"`
+	UserVerification   string `json:"userVerification"`
+}
+
+//CreationOptions type defined
+//CreationOptions are the options for navigator.credentials.create, in the JSON form PublicKeyCredential.parseCreationOptionsFromJSON reads:
+//binary values are base64url strings.
+type CreationOptions struct {
+	Challenge              string                 `json:"challenge"`
+	RP                     Entity                 `json:"rp"`
+	User                   Entity                 `json:"user"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+//RequestOptions type defined
+//RequestOptions are the options for navigator.credentials.get, in the JSON form PublicKeyCredential.parseRequestOptionsFromJSON reads.
+//Without allowed credentials the browser offers the user the passkeys they have for the site.
+type RequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	Timeout          int64                  `json:"timeout"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+//AttestationResponse is the authenticator's response to navigator.credentials.create
+type AttestationResponse struct {
+	ClientDataJSON    string   `json:"clientDataJSON"`
+	AttestationObject string   `json:"attestationObject"`
+	Transports        []string `json:"transports,omitempty"`
+}
+
+//Registration type defined
+//Registration is a new credential as PublicKeyCredential.toJSON sends it, with binary values as base64url strings.
+type Registration struct {
+	ID       string              `json:"id"`
+	RawID    string              `json:"rawId"`
+	Type     string              `json:"type"`
+	Response AttestationResponse `json:"response"`
+}
+
+//AssertionResponse is the authenticator's response to navigator.credentials.get
+type AssertionResponse struct {
+	ClientDataJSON    string `json:"clientDataJSON"`
+	AuthenticatorData string `json:"authenticatorData"`
+	Signature         string `json:"signature"`
+	UserHandle        string `json:"userHandle,omitempty"`
+}
+
+//Assertion type defined
+//Assertion is a credential's signature over a challenge as PublicKeyCredential.toJSON sends it, with binary values as base64url strings.
+type Assertion struct {
+	ID       string            `json:"id"`
+	RawID    string            `json:"rawId"`
+	Type     string            `json:"type"`
+	Response AssertionResponse `json:"response"`
+}
+
+//Credential type defined
+//Credential is a registered credential: its ID, its public key in the COSE_Key encoding, its signature counter,
+//and the AAGUID naming the authenticator's model, all zeroes for authenticators that keep it private.
+type Credential struct {
+	ID        []byte
+	PublicKey []byte
+	SignCount uint32
+	AAGUID    []byte
+}
+
+//clientData is the part of the client data a relying party checks (WebAuthn §5.8.1)
+type clientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+//authenticatorData is the data an authenticator signs (WebAuthn §6.1), with the credential it attests to when registering
+type authenticatorData struct {
+	rpIDHash  []byte
+	flags     byte
+	signCount uint32
+	aaguid    []byte
+	id        []byte
+	publicKey []byte
+}
+
+//NewChallenge returns a random challenge for a ceremony, base64url encoded.
+func NewChallenge() (string, error) {
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+//EncodeID returns the base64url encoding credential and user IDs are sent in.
+func EncodeID(id []byte) string {
+	return base64.RawURLEncoding.EncodeToString(id)
+}
+
+//decode decodes a base64url value, padded or not.
+func decode(s string) ([]byte, error) {
+
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	return b, nil
+}
+
+//Challenge returns the challenge the client data of a response was made for, so the server can find the ceremony it answers.
+//It is checked along with the rest of the response by Register and Verify.
+func Challenge(clientDataJSON string) (string, error) {
+
+	_, c, err := parseClientData(clientDataJSON)
+	if err != nil {
+		return "", err
+	}
+
+	return c.Challenge, nil
+}
+
+//CredentialID returns the decoded ID of the credential that made the assertion.
+func (a *Assertion) CredentialID() ([]byte, error) {
+
+	if a.RawID != "" {
+		return decode(a.RawID)
+	}
+
+	return decode(a.ID)
+}
+
+//UserHandle returns the decoded user ID the credential was registered with, which discoverable credentials send, or nil.
+func (a *Assertion) UserHandle() ([]byte, error) {
+
+	if a.Response.UserHandle == "" {
+		return nil, nil
+	}
+
+	return decode(a.Response.UserHandle)
+}
+
+//CreationOptions returns the options for registering a new credential for the user, named by their ID, which authenticators
+//return as the user handle, and their name. The browser refuses to register a second credential on an authenticator
+//holding one of the excluded credentials. Registration asks for a discoverable credential, a passkey, and no attestation.
+func (rp *RelyingParty) CreationOptions(challenge string, userID []byte, userName string, exclude [][]byte) *CreationOptions {
+
+	options := &CreationOptions{
+		Challenge:          challenge,
+		RP:                 Entity{ID: rp.ID, Name: rp.Name},
+		User:               Entity{ID: EncodeID(userID), Name: userName, DisplayName: userName},
+		Timeout:            Timeout.Milliseconds(),
+		ExcludeCredentials: descriptors(exclude),
+		AuthenticatorSelection: AuthenticatorSelection{
+			ResidentKey:        "required",
+			RequireResidentKey: true,
+			UserVerification:   "required",
+		},
+		Attestation: "none",
+	}
+
+	for _, alg := range Algorithms {
+		options.PubKeyCredParams = append(options.PubKeyCredParams, CredentialParameter{Type: "public-key", Alg: alg})
+	}
+
+	return options
+}
+
+//RequestOptions returns the options for asserting one of the allowed credentials, or any of the user's passkeys for the site if none are.
+func (rp *RelyingParty) RequestOptions(challenge string, allow [][]byte) *RequestOptions {
+
+	return &RequestOptions{
+		Challenge:        challenge,
+		Timeout:          Timeout.Milliseconds(),
+		RPID:             rp.ID,
+		AllowCredentials: descriptors(allow),
+		UserVerification: "required",
+	}
+}
+
+//descriptors names the credentials with the IDs
+func descriptors(ids [][]byte) []CredentialDescriptor {
+
+	list := []CredentialDescriptor{}
+	for _, id := range ids {
+		list = append(list, CredentialDescriptor{Type: "public-key", ID: EncodeID(id)})
+	}
+
+	return list
+}
+
+//Register checks a new credential against the challenge it was created for, and returns it to be stored (WebAuthn §7.1).
+//The attestation statement is not verified: registration asks for none, as passkeys are trusted for the user verification
+//they perform rather than the make of authenticator they are on.
+func (rp *RelyingParty) Register(reg *Registration, challenge string) (*Credential, error) {
+
+	if reg.Type != "public-key" {
+		return nil, ErrMalformed
+	}
+
+	err := rp.checkClientData(reg.Response.ClientDataJSON, "webauthn.create", challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := decode(reg.Response.AttestationObject)
+	if err != nil {
+		return nil, err
+	}
+
+	v, rest, err := decodeCBOR(b, 0)
+	if err != nil || len(rest) != 0 {
+		return nil, ErrMalformed
+	}
+
+	attestation, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return nil, ErrMalformed
+	}
+
+	if _, ok := attestation["fmt"].(string); !ok {
+		return nil, ErrMalformed
+	}
+
+	raw, ok := attestation["authData"].([]byte)
+	if !ok {
+		return nil, ErrMalformed
+	}
+
+	data, err := rp.checkAuthenticatorData(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	if data.flags&flagAttested == 0 {
+		return nil, ErrMalformed
+	}
+
+	//the ID the browser reports must be the one the authenticator attested to
+	id, err := decode(reg.RawID)
+	if err != nil || !bytes.Equal(id, data.id) {
+		return nil, ErrCredentialID
+	}
+
+	_, _, err = parseKey(data.publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Credential{ID: data.id, PublicKey: data.publicKey, SignCount: data.signCount, AAGUID: data.aaguid}, nil
+}
+
+//Verify checks an assertion by the stored credential against the challenge it was made for, and returns the credential's new signature counter
+//to store (WebAuthn §7.2). Authenticators that count signatures report a higher count each time, so a count no higher than the stored one
+//means another copy of the credential has signed since: Verify then returns ErrCloned, with the count, once the signature is checked.
+//Authenticators that do not count, as most synced passkeys do not, always report zero.
+func (rp *RelyingParty) Verify(a *Assertion, challenge string, credential *Credential) (uint32, error) {
+
+	if a.Type != "public-key" {
+		return 0, ErrMalformed
+	}
+
+	id, err := a.CredentialID()
+	if err != nil || !bytes.Equal(id, credential.ID) {
+		return 0, ErrCredentialID
+	}
+
+	err = rp.checkClientData(a.Response.ClientDataJSON, "webauthn.get", challenge)
+	if err != nil {
+		return 0, err
+	}
+
+	raw, err := decode(a.Response.AuthenticatorData)
+	if err != nil {
+		return 0, err
+	}
+
+	data, err := rp.checkAuthenticatorData(raw)
+	if err != nil {
+		return 0, err
+	}
+
+	signature, err := decode(a.Response.Signature)
+	if err != nil {
+		return 0, err
+	}
+
+	clientDataJSON, err := decode(a.Response.ClientDataJSON)
+	if err != nil {
+		return 0, err
+	}
+
+	//the signature is over the authenticator data and the hash of the client data
+	hash := sha256.Sum256(clientDataJSON)
+	err = verifySignature(credential.PublicKey, append(raw[:len(raw):len(raw)], hash[:]...), signature)
+	if err != nil {
+		return 0, err
+	}
+
+	if (data.signCount != 0 || credential.SignCount != 0) && data.signCount <= credential.SignCount {
+		return data.signCount, ErrCloned
+	}
+
+	return data.signCount, nil
+}
+
+//parseClientData decodes the client data of a response
+func parseClientData(clientDataJSON string) ([]byte, *clientData, error) {
+
+	raw, err := decode(clientDataJSON)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c := &clientData{}
+	if err := json.Unmarshal(raw, c); err != nil {
+		return nil, nil, ErrMalformed
+	}
+
+	return raw, c, nil
+}
+
+//checkClientData checks the client data of a response was made for the ceremony and challenge, by one of the relying party's pages.
+func (rp *RelyingParty) checkClientData(clientDataJSON, ceremony, challenge string) error {
+
+	_, c, err := parseClientData(clientDataJSON)
+	if err != nil {
+		return err
+	}
+
+	if c.Type != ceremony {
+		return ErrCeremony
+	}
+
+	if challenge == "" || subtle.ConstantTimeCompare([]byte(c.Challenge), []byte(challenge)) != 1 {
+		return ErrChallenge
+	}
+
+	//pages of other sites framing ours could otherwise start ceremonies for our origin
+	if c.CrossOrigin {
+		return ErrOrigin
+	}
+
+	for _, origin := range rp.Origins {
+		if c.Origin == origin {
+			return nil
+		}
+	}
+
+	return ErrOrigin
+}
+
+//checkAuthenticatorData parses authenticator data, checking it is scoped to the relying party and the user was present and verified.
+func (rp *RelyingParty) checkAuthenticatorData(b []byte) (*authenticatorData, error) {
+
+	if len(b) < 37 {
+		return nil, ErrMalformed
+	}
+
+	data := &authenticatorData{rpIDHash: b[:32], flags: b[32], signCount: binary.BigEndian.Uint32(b[33:37])}
+	rest := b[37:]
+
+	if data.flags&flagAttested != 0 {
+		if len(rest) < 18 {
+			return nil, ErrMalformed
+		}
+		data.aaguid = rest[:16]
+		n := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if n == 0 || n > 1023 || len(rest) < n {
+			return nil, ErrMalformed
+		}
+		data.id, rest = rest[:n], rest[n:]
+
+		_, after, err := decodeCBOR(rest, 0)
+		if err != nil {
+			return nil, ErrMalformed
+		}
+		data.publicKey, rest = rest[:len(rest)-len(after)], after
+	}
+
+	if data.flags&flagExtensions != 0 {
+		v, after, err := decodeCBOR(rest, 0)
+		if _, ok := v.(map[interface{}]interface{}); err != nil || !ok {
+			return nil, ErrMalformed
+		}
+		rest = after
+	}
+
+	if len(rest) != 0 {
+		return nil, ErrMalformed
+	}
+
+	hash := sha256.Sum256([]byte(rp.ID))
+	if subtle.ConstantTimeCompare(data.rpIDHash, hash[:]) != 1 {
+		return nil, ErrRelyingParty
+	}
+
+	if data.flags&flagUserPresent == 0 {
+		return nil, ErrUserNotPresent
+	}
+
+	if data.flags&flagUserVerified == 0 {
+		return nil, ErrUserNotVerified
+	}
+
+	return data, nil
+}
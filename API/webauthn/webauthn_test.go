@@ -0,0 +1,236 @@
+package webauthn_test
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/chiips/snippets/API/webauthn"
+	"github.com/chiips/snippets/API/webauthn/webauthntest"
+)
+
+//the tests are outside the package so they can use webauthntest, which imports it
+
+var rp = &webauthn.RelyingParty{ID: "example.com", Name: "Snippets", Origins: []string{"https://example.com"}}
+
+//register registers a new credential on the authenticator, failing the test on error
+func register(t *testing.T, a *webauthntest.Authenticator) *webauthn.Credential {
+
+	t.Helper()
+
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reg, err := a.Create(rp.CreationOptions(challenge, []byte("user-1"), "User-1", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cred, err := rp.Register(reg, challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cred
+}
+
+//assert asserts a credential on the authenticator, returning the assertion and its challenge
+func assert(t *testing.T, a *webauthntest.Authenticator) (*webauthn.Assertion, string) {
+
+	t.Helper()
+
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertion, err := a.Get(rp.RequestOptions(challenge, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return assertion, challenge
+}
+
+func TestCeremonies(t *testing.T) {
+
+	for _, alg := range webauthn.Algorithms {
+
+		a := webauthntest.New("https://example.com")
+		a.Algorithm = alg
+
+		cred := register(t, a)
+		if len(cred.ID) != 32 || len(cred.AAGUID) != 16 || cred.SignCount != 0 {
+			t.Fatalf("algorithm %v credential registered wrongly: %+v", alg, cred)
+		}
+
+		//each assertion verifies and counts up
+		for want := uint32(1); want <= 2; want++ {
+			assertion, challenge := assert(t, a)
+
+			if got, err := webauthn.Challenge(assertion.Response.ClientDataJSON); got != challenge || err != nil {
+				t.Errorf("algorithm %v assertion challenge wrong: %v %v", alg, got, err)
+			}
+			if handle, err := assertion.UserHandle(); string(handle) != "user-1" || err != nil {
+				t.Errorf("algorithm %v assertion user handle wrong: %q %v", alg, handle, err)
+			}
+
+			count, err := rp.Verify(assertion, challenge, cred)
+			if err != nil {
+				t.Fatalf("algorithm %v assertion failed: %v", alg, err)
+			}
+			if count != want {
+				t.Errorf("algorithm %v signature counter wrong:\ngot: %v\nwant: %v", alg, count, want)
+			}
+			cred.SignCount = count
+		}
+
+		//a signature by another key is refused
+		other := register(t, webauthntest.New("https://example.com"))
+		assertion, challenge := assert(t, a)
+		other.ID = cred.ID
+		if _, err := rp.Verify(assertion, challenge, other); err != webauthn.ErrSignature {
+			t.Errorf("algorithm %v assertion with wrong key returned wrong error:\ngot: %v\nwant: %v", alg, err, webauthn.ErrSignature)
+		}
+	}
+}
+
+func TestClones(t *testing.T) {
+
+	a := webauthntest.New("https://example.com")
+	cred := register(t, a)
+
+	//the clone signs first, so the original's next count is no higher than the stored one
+	clone := a.Clone()
+	assertion, challenge := assert(t, clone)
+	count, err := rp.Verify(assertion, challenge, cred)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cred.SignCount = count
+
+	assertion, challenge = assert(t, a)
+	if _, err := rp.Verify(assertion, challenge, cred); err != webauthn.ErrCloned {
+		t.Errorf("assertion by cloned authenticator returned wrong error:\ngot: %v\nwant: %v", err, webauthn.ErrCloned)
+	}
+
+	//authenticators without counters always report zero, which is not a clone
+	synced := webauthntest.New("https://example.com")
+	synced.NoCounter = true
+	cred = register(t, synced)
+	for i := 0; i < 2; i++ {
+		assertion, challenge := assert(t, synced)
+		if count, err := rp.Verify(assertion, challenge, cred); count != 0 || err != nil {
+			t.Errorf("assertion without counter returned wrong result: %v %v", count, err)
+		}
+	}
+}
+
+func TestRefusals(t *testing.T) {
+
+	a := webauthntest.New("https://example.com")
+	cred := register(t, a)
+
+	refused := func(name string, want error, reg *webauthn.Registration, challenge string) {
+		t.Helper()
+		if _, err := rp.Register(reg, challenge); err != want {
+			t.Errorf("registration with %v returned wrong error:\ngot: %v\nwant: %v", name, err, want)
+		}
+	}
+
+	challenge, _ := webauthn.NewChallenge()
+	options := rp.CreationOptions(challenge, []byte("user-1"), "User-1", nil)
+
+	reg, err := webauthntest.New("https://example.com").Create(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	refused("wrong challenge", webauthn.ErrChallenge, reg, "other")
+	refused("no challenge", webauthn.ErrChallenge, reg, "")
+
+	mismatched := *reg
+	mismatched.RawID = webauthn.EncodeID([]byte("another credential"))
+	refused("mismatched ID", webauthn.ErrCredentialID, &mismatched, challenge)
+
+	truncated := *reg
+	truncated.Response.AttestationObject = reg.Response.AttestationObject[:len(reg.Response.AttestationObject)-8]
+	refused("truncated attestation", webauthn.ErrMalformed, &truncated, challenge)
+
+	if reg, err = webauthntest.New("https://evil.example").Create(options); err != nil {
+		t.Fatal(err)
+	}
+	refused("wrong origin", webauthn.ErrOrigin, reg, challenge)
+
+	unverified := webauthntest.New("https://example.com")
+	unverified.SkipVerification = true
+	if reg, err = unverified.Create(options); err != nil {
+		t.Fatal(err)
+	}
+	refused("unverified user", webauthn.ErrUserNotVerified, reg, challenge)
+
+	other := *options
+	other.RP.ID = "evil.example"
+	if reg, err = webauthntest.New("https://example.com").Create(&other); err != nil {
+		t.Fatal(err)
+	}
+	refused("another relying party", webauthn.ErrRelyingParty, reg, challenge)
+
+	//a registered authenticator is excluded from registering again
+	if _, err := a.Create(rp.CreationOptions(challenge, []byte("user-1"), "User-1", [][]byte{cred.ID})); err == nil {
+		t.Errorf("excluded authenticator registered again")
+	}
+
+	//assertions are checked the same way, and for their signature
+	assertion, challenge := assert(t, a)
+	if _, err := rp.Verify(assertion, "other", cred); err != webauthn.ErrChallenge {
+		t.Errorf("assertion with wrong challenge returned wrong error:\ngot: %v\nwant: %v", err, webauthn.ErrChallenge)
+	}
+
+	tampered := *assertion
+	signature, _ := base64.RawURLEncoding.DecodeString(assertion.Response.Signature)
+	signature[len(signature)-1] ^= 1
+	tampered.Response.Signature = base64.RawURLEncoding.EncodeToString(signature)
+	if _, err := rp.Verify(&tampered, challenge, cred); err != webauthn.ErrSignature {
+		t.Errorf("assertion with tampered signature returned wrong error:\ngot: %v\nwant: %v", err, webauthn.ErrSignature)
+	}
+
+	swapped := *assertion
+	swapped.Response.ClientDataJSON = reg.Response.ClientDataJSON
+	if _, err := rp.Verify(&swapped, challenge, cred); err != webauthn.ErrCeremony {
+		t.Errorf("assertion with registration client data returned wrong error:\ngot: %v\nwant: %v", err, webauthn.ErrCeremony)
+	}
+
+	if _, err := rp.Verify(assertion, challenge, &webauthn.Credential{ID: []byte("another credential"), PublicKey: cred.PublicKey}); err != webauthn.ErrCredentialID {
+		t.Errorf("assertion for another credential returned wrong error:\ngot: %v\nwant: %v", err, webauthn.ErrCredentialID)
+	}
+
+	if _, err := rp.Verify(assertion, challenge, cred); err != nil {
+		t.Errorf("untampered assertion refused: %v", err)
+	}
+}
+
+func TestOptions(t *testing.T) {
+
+	options := rp.CreationOptions("challenge", []byte{0xff, 0xfe}, "User-1", [][]byte{{1, 2, 3}})
+
+	if options.User.ID != "__4" || options.ExcludeCredentials[0].ID != "AQID" || options.RP.ID != "example.com" {
+		t.Errorf("creation options wrong: %+v", options)
+	}
+	if options.AuthenticatorSelection.UserVerification != "required" || options.AuthenticatorSelection.ResidentKey != "required" {
+		t.Errorf("creation options do not require a verified passkey: %+v", options.AuthenticatorSelection)
+	}
+	if len(options.PubKeyCredParams) != len(webauthn.Algorithms) || options.PubKeyCredParams[0].Alg != webauthn.ES256 {
+		t.Errorf("creation options algorithms wrong: %+v", options.PubKeyCredParams)
+	}
+
+	request := rp.RequestOptions("challenge", nil)
+	if request.AllowCredentials == nil || len(request.AllowCredentials) != 0 || request.UserVerification != "required" {
+		t.Errorf("request options wrong: %+v", request)
+	}
+
+	if _, err := webauthn.Challenge(strings.Repeat("!", 8)); err != webauthn.ErrMalformed {
+		t.Errorf("Challenge of invalid client data returned wrong error:\ngot: %v\nwant: %v", err, webauthn.ErrMalformed)
+	}
+}
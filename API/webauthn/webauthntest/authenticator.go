@@ -0,0 +1,299 @@
+package webauthntest
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math/big"
+
+	"github.com/chiips/snippets/API/webauthn"
+)
+
+//ErrNoCredential is returned by Get when the authenticator holds none of the credentials asked for
+var ErrNoCredential = errors.New("webauthntest: no credential")
+
+//Authenticator type defined
+//Authenticator is a software authenticator for testing relying parties without hardware. It answers options as a browser and a passkey
+//provider would: Create registers a new discoverable credential and Get asserts one, with client data from Origin.
+//The user is always present, and verified unless SkipVerification is set.
+type Authenticator struct {
+	Origin string
+
+	//Algorithm is the COSE algorithm new credentials use, webauthn.ES256 if unset
+	Algorithm int
+
+	//SkipVerification leaves the user verified flag unset, as for security keys without a PIN
+	SkipVerification bool
+
+	//NoCounter reports a signature counter of zero, as synced passkeys do
+	NoCounter bool
+
+	credentials []*credential
+}
+
+//credential is a credential the authenticator holds
+type credential struct {
+	id        []byte
+	rpID      string
+	user      []byte
+	alg       int
+	key       crypto.Signer
+	signCount uint32
+}
+
+//New returns an authenticator for pages served from the origin
+func New(origin string) *Authenticator {
+	return &Authenticator{Origin: origin}
+}
+
+//Clone returns an authenticator holding copies of the credentials, signature counters included, as if its keys had been extracted
+func (a *Authenticator) Clone() *Authenticator {
+
+	c := *a
+	c.credentials = nil
+	for _, cred := range a.credentials {
+		copied := *cred
+		c.credentials = append(c.credentials, &copied)
+	}
+
+	return &c
+}
+
+//Create registers a new credential with the options' relying party for their user, as navigator.credentials.create does
+func (a *Authenticator) Create(options *webauthn.CreationOptions) (*webauthn.Registration, error) {
+
+	for _, d := range options.ExcludeCredentials {
+		for _, cred := range a.credentials {
+			if cred.rpID == options.RP.ID && webauthn.EncodeID(cred.id) == d.ID {
+				return nil, errors.New("webauthntest: authenticator already registered")
+			}
+		}
+	}
+
+	user, err := base64.RawURLEncoding.DecodeString(options.User.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	cred := &credential{id: make([]byte, 32), rpID: options.RP.ID, user: user, alg: a.Algorithm}
+	if _, err := rand.Read(cred.id); err != nil {
+		return nil, err
+	}
+	if cred.alg == 0 {
+		cred.alg = webauthn.ES256
+	}
+
+	var cose []byte
+
+	switch cred.alg {
+	case webauthn.ES256:
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		point, err := key.PublicKey.Bytes()
+		if err != nil {
+			return nil, err
+		}
+		cred.key = key
+		cose = marshal(mapping{{1, 2}, {3, webauthn.ES256}, {-1, 1}, {-2, point[1:33]}, {-3, point[33:]}})
+	case webauthn.EdDSA:
+		public, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		cred.key = key
+		cose = marshal(mapping{{1, 1}, {3, webauthn.EdDSA}, {-1, 6}, {-2, []byte(public)}})
+	case webauthn.RS256:
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, err
+		}
+		cred.key = key
+		cose = marshal(mapping{{1, 3}, {3, webauthn.RS256}, {-1, key.N.Bytes()}, {-2, big.NewInt(int64(key.E)).Bytes()}})
+	default:
+		return nil, webauthn.ErrUnsupportedKey
+	}
+
+	//attested credential data: an all-zero AAGUID, the credential ID's length and the ID, then the public key
+	attested := make([]byte, 18, 18+len(cred.id)+len(cose))
+	binary.BigEndian.PutUint16(attested[16:], uint16(len(cred.id)))
+	attested = append(append(attested, cred.id...), cose...)
+
+	clientDataJSON, err := a.clientData("webauthn.create", options.Challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	authData := a.authenticatorData(cred, 0x40, attested)
+	attestation := marshal(mapping{{"fmt", "none"}, {"attStmt", mapping{}}, {"authData", authData}})
+
+	a.credentials = append(a.credentials, cred)
+
+	return &webauthn.Registration{
+		ID:    webauthn.EncodeID(cred.id),
+		RawID: webauthn.EncodeID(cred.id),
+		Type:  "public-key",
+		Response: webauthn.AttestationResponse{
+			ClientDataJSON:    base64.RawURLEncoding.EncodeToString(clientDataJSON),
+			AttestationObject: base64.RawURLEncoding.EncodeToString(attestation),
+			Transports:        []string{"internal"},
+		},
+	}, nil
+}
+
+//Get asserts the first credential for the options' relying party that they allow, as navigator.credentials.get does
+func (a *Authenticator) Get(options *webauthn.RequestOptions) (*webauthn.Assertion, error) {
+
+	var cred *credential
+	for _, c := range a.credentials {
+		if c.rpID != options.RPID {
+			continue
+		}
+		allowed := len(options.AllowCredentials) == 0
+		for _, d := range options.AllowCredentials {
+			allowed = allowed || d.ID == webauthn.EncodeID(c.id)
+		}
+		if allowed {
+			cred = c
+			break
+		}
+	}
+
+	if cred == nil {
+		return nil, ErrNoCredential
+	}
+
+	if !a.NoCounter {
+		cred.signCount++
+	}
+
+	clientDataJSON, err := a.clientData("webauthn.get", options.Challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	authData := a.authenticatorData(cred, 0, nil)
+	hash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte{}, authData...), hash[:]...)
+
+	var signature []byte
+	switch cred.alg {
+	case webauthn.EdDSA:
+		signature, err = cred.key.Sign(rand.Reader, signed, crypto.Hash(0))
+	default:
+		digest := sha256.Sum256(signed)
+		signature, err = cred.key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &webauthn.Assertion{
+		ID:    webauthn.EncodeID(cred.id),
+		RawID: webauthn.EncodeID(cred.id),
+		Type:  "public-key",
+		Response: webauthn.AssertionResponse{
+			ClientDataJSON:    base64.RawURLEncoding.EncodeToString(clientDataJSON),
+			AuthenticatorData: base64.RawURLEncoding.EncodeToString(authData),
+			Signature:         base64.RawURLEncoding.EncodeToString(signature),
+			UserHandle:        webauthn.EncodeID(cred.user),
+		},
+	}, nil
+}
+
+//clientData returns the client data a browser on the authenticator's origin would send for the ceremony
+func (a *Authenticator) clientData(ceremony, challenge string) ([]byte, error) {
+	return json.Marshal(map[string]interface{}{"type": ceremony, "challenge": challenge, "origin": a.Origin, "crossOrigin": false})
+}
+
+//authenticatorData returns the authenticator data for the credential, with the flags and the attested credential data given
+func (a *Authenticator) authenticatorData(cred *credential, flags byte, attested []byte) []byte {
+
+	flags |= 0x01
+	if !a.SkipVerification {
+		flags |= 0x04
+	}
+
+	hash := sha256.Sum256([]byte(cred.rpID))
+	data := append(hash[:], flags, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(data[33:], cred.signCount)
+
+	return append(data, attested...)
+}
+
+//pair is a CBOR map entry
+type pair struct {
+	key, value interface{}
+}
+
+//mapping is a CBOR map, encoded in the order of its entries
+type mapping []pair
+
+//marshal encodes the ints, byte strings, text strings, and mappings authenticators send as CBOR.
+func marshal(v interface{}) []byte {
+
+	var b bytes.Buffer
+	encode(&b, v)
+
+	return b.Bytes()
+}
+
+//encode writes v to b as CBOR
+func encode(b *bytes.Buffer, v interface{}) {
+
+	switch v := v.(type) {
+	case int:
+		if v < 0 {
+			head(b, 1, uint64(-1-v))
+		} else {
+			head(b, 0, uint64(v))
+		}
+	case []byte:
+		head(b, 2, uint64(len(v)))
+		b.Write(v)
+	case string:
+		head(b, 3, uint64(len(v)))
+		b.WriteString(v)
+	case mapping:
+		head(b, 5, uint64(len(v)))
+		for _, p := range v {
+			encode(b, p.key)
+			encode(b, p.value)
+		}
+	default:
+		panic("webauthntest: cannot encode value as CBOR")
+	}
+}
+
+//head writes the initial bytes of a CBOR data item of the major type, with the argument in the fewest bytes
+func head(b *bytes.Buffer, major byte, arg uint64) {
+
+	major <<= 5
+
+	switch {
+	case arg < 24:
+		b.WriteByte(major | byte(arg))
+	case arg <= 0xff:
+		b.WriteByte(major | 24)
+		b.WriteByte(byte(arg))
+	case arg <= 0xffff:
+		b.WriteByte(major | 25)
+		binary.Write(b, binary.BigEndian, uint16(arg))
+	case arg <= 0xffffffff:
+		b.WriteByte(major | 26)
+		binary.Write(b, binary.BigEndian, uint32(arg))
+	default:
+		b.WriteByte(major | 27)
+		binary.Write(b, binary.BigEndian, arg)
+	}
+}